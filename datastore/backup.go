@@ -0,0 +1,77 @@
+/*
+	This file supports online backups of an open datastore.
+*/
+
+package datastore
+
+import (
+	"fmt"
+
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+// Backup writes a consistent copy of the datastore, including its datasets, into a
+// new store at the given directory that can be opened with Open.  Reads and writes
+// may continue during the backup, although writes made after it starts are not
+// included.  Key spaces routed to additional stores are copied into the backup's
+// single store.
+func (s *Service) Backup(directory string) error {
+	// An engine that can checkpoint its own files is faster than copying pairs.
+	if checkpointer, ok := s.baseDB().(storage.Checkpointer); ok && s.routes == nil {
+		dvid.Log(dvid.Normal, "Checkpointing datastore to %s\n", directory)
+		return checkpointer.Checkpoint(directory)
+	}
+
+	dvid.Log(dvid.Normal, "Copying datastore snapshot to %s\n", directory)
+	backup, err := storage.NewStore(directory, true, s.StorageEngine().GetConfig())
+	if err != nil {
+		return fmt.Errorf("Error creating backup store (%s): %s", directory, err.Error())
+	}
+	defer backup.Close()
+
+	first, last := storage.RawKey{}, storage.RawKey(maxKey([]byte{}))
+	loader, err := storage.NewBulkLoader(backup, first, last)
+	if err != nil {
+		return err
+	}
+	defer loader.Close()
+
+	snapshot := storage.ReadSnapshot(s.StorageEngine())
+	defer snapshot.Close()
+	it, err := snapshot.NewIterator(first, last, nil)
+	if err != nil {
+		return err
+	}
+	defer it.Close()
+	var numKeys int
+	for ; it.Valid(); it.Next() {
+		key, err := it.Key()
+		if err != nil {
+			return err
+		}
+		kBytes := key.Bytes()
+
+		// The backup holds all key spaces so routes are not copied.
+		if len(kBytes) > 0 && KeyType(kBytes[0]) == KeyRoutes {
+			continue
+		}
+		k := make([]byte, len(kBytes))
+		copy(k, kBytes)
+		value := it.Value()
+		v := make([]byte, len(value))
+		copy(v, value)
+		if err = loader.Put(storage.RawKey(k), v); err != nil {
+			return err
+		}
+		numKeys++
+	}
+	if err = it.Error(); err != nil {
+		return err
+	}
+	if err = loader.Commit(); err != nil {
+		return err
+	}
+	dvid.Log(dvid.Normal, "Copied %d key-value pairs to backup %s\n", numKeys, directory)
+	return nil
+}
@@ -0,0 +1,40 @@
+package datastore
+
+import (
+	. "github.com/janelia-flyem/go/gocheck"
+
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+func (s *DataSuite) TestBackup(c *C) {
+	for _, config := range []dvid.Config{
+		{},
+		{"stores": "other:" + c.MkDir() + "/other", "routes": "keytype/dataset:other"},
+	} {
+		dir := c.MkDir()
+		c.Assert(Init(dir, true, config), IsNil)
+		service, openErr := Open(dir)
+		c.Assert(openErr, IsNil)
+		_, _, err := service.NewDataset()
+		c.Assert(err, IsNil)
+		oldJSON, err := service.DatasetsAllJSON()
+		c.Assert(err, IsNil)
+
+		backupDir := c.MkDir() + "/backup"
+		c.Assert(service.Backup(backupDir), IsNil)
+		c.Assert(service.Backup(backupDir), NotNil)
+
+		// Changes after the backup are not in it.
+		_, _, err = service.NewDataset()
+		c.Assert(err, IsNil)
+		service.Shutdown()
+
+		backup, openErr := Open(backupDir)
+		c.Assert(openErr, IsNil)
+		c.Assert(backup.routes, IsNil)
+		newJSON, err := backup.DatasetsAllJSON()
+		c.Assert(err, IsNil)
+		c.Assert(newJSON, Equals, oldJSON)
+		backup.Shutdown()
+	}
+}
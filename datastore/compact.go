@@ -0,0 +1,69 @@
+/*
+	This file supports storage engine maintenance, e.g., compacting stores to reclaim
+	space after large deletions and reporting engine statistics.
+*/
+
+package datastore
+
+import (
+	"fmt"
+
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+// baseDB returns the engine of the datastore without wrappers like a read-through
+// cache, which hide optional interfaces like storage.Compacter.
+func (s *Service) baseDB() storage.Engine {
+	return storage.BaseEngine(s.StorageEngine())
+}
+
+// compactRange compacts the stores holding keys in the inclusive range [first, last].
+func (s *Service) compactRange(first, last []byte) error {
+	compacter, ok := s.baseDB().(storage.Compacter)
+	if !ok {
+		return fmt.Errorf("Storage engine %s cannot be compacted", storage.Version)
+	}
+	return compacter.CompactRange(storage.RawKey(first), storage.RawKey(last))
+}
+
+// Compact compacts all stores of the datastore, reclaiming space held by deleted or
+// overwritten data.  This can take a long time for large datastores.
+func (s *Service) Compact() error {
+	dvid.Log(dvid.Normal, "Compacting datastore...\n")
+	return s.compactRange([]byte{}, maxKey([]byte{}))
+}
+
+// CompactData compacts the key-value pairs of a data instance across all versions.
+func (s *Service) CompactData(u dvid.UUID, name dvid.DataString) error {
+	dset, err := s.datasets.DatasetFromUUID(u)
+	if err != nil {
+		return err
+	}
+	dataservice, err := dset.DataService(name)
+	if err != nil {
+		return err
+	}
+	dvid.Log(dvid.Normal, "Compacting data %q of dataset %s...\n", name, dset.Root)
+	prefix := dataPrefix(dset.DatasetID, dataservice.LocalID())
+	return s.compactRange(prefix, maxKey(prefix))
+}
+
+// StorageStats returns the statistics of each store by store name, e.g., the number
+// of files and bytes at each level of a leveldb.  Stores whose engines cannot report
+// statistics are given empty statistics.
+func (s *Service) StorageStats() map[string]map[string]string {
+	stores := map[string]storage.Engine{defaultStoreName: s.baseDB()}
+	if s.routes != nil {
+		stores = s.routes.engines
+	}
+	stats := make(map[string]map[string]string, len(stores))
+	for name, db := range stores {
+		if reporter, ok := db.(storage.StatsReporter); ok {
+			stats[name] = reporter.Stats()
+		} else {
+			stats[name] = map[string]string{}
+		}
+	}
+	return stats
+}
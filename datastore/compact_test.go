@@ -0,0 +1,45 @@
+package datastore
+
+import (
+	"fmt"
+
+	. "github.com/janelia-flyem/go/gocheck"
+
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+func (s *DataSuite) TestCompact(c *C) {
+	dir := c.MkDir()
+	config := dvid.Config{"stores": "other:" + c.MkDir() + "/other", "routes": "keytype/sync:other"}
+	c.Assert(Init(dir, true, config), IsNil)
+	service, openErr := Open(dir)
+	c.Assert(openErr, IsNil)
+	defer service.Shutdown()
+
+	stats := service.StorageStats()
+	c.Assert(stats, HasLen, 2)
+	_, found := stats["other"]
+	c.Assert(found, Equals, true)
+
+	_, datasetID, err := service.NewDataset()
+	c.Assert(err, IsNil)
+	for i := 0; i < 10; i++ {
+		key := &DataKey{datasetID, 1, 0, dvid.IndexString(fmt.Sprintf("deleted %d", i))}
+		c.Assert(service.db.Put(key, []byte("deleted value")), IsNil)
+		c.Assert(service.db.Delete(key), IsNil)
+	}
+	oldJSON, err := service.DatasetsAllJSON()
+	c.Assert(err, IsNil)
+
+	_, canCompact := service.baseDB().(*storage.Router).Engines()[0].(storage.Compacter)
+	err = service.Compact()
+	if canCompact {
+		c.Assert(err, IsNil)
+	} else {
+		c.Assert(err, NotNil)
+	}
+	newJSON, err := service.DatasetsAllJSON()
+	c.Assert(err, IsNil)
+	c.Assert(newJSON, Equals, oldJSON)
+}
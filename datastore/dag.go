@@ -0,0 +1,193 @@
+/*
+	This file supports queries of the version DAG of a dataset: the ancestors and
+	descendants of a node, the lowest common ancestor of nodes, and the heads of the
+	DAG, i.e., nodes without children that can still be modified or branched.
+*/
+
+package datastore
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+// DAGNode gives the position and state of a node in a version DAG.
+type DAGNode struct {
+	UUID     dvid.UUID
+	Parents  []dvid.UUID
+	Children []dvid.UUID
+	Locked   bool
+	Pruned   bool
+	Refs     []string // Tags and branches naming the node, e.g., "tag: v1"
+	Created  time.Time
+	Updated  time.Time
+}
+
+// dagNode returns the DAG state of a node.  The caller must hold the dag's mapLock.
+func (dag *VersionDAG) dagNode(node *Node) DAGNode {
+	node.writeLock.Lock()
+	defer node.writeLock.Unlock()
+	return DAGNode{
+		UUID:     node.GlobalID,
+		Parents:  append([]dvid.UUID{}, node.Parents...),
+		Children: append([]dvid.UUID{}, node.Children...),
+		Locked:   node.Locked,
+		Pruned:   node.Pruned,
+		Refs:     dag.refNames(node.GlobalID),
+		Created:  node.Created,
+		Updated:  node.Updated,
+	}
+}
+
+// descendants returns the set of the given node and all its descendants.  The caller
+// must hold the dag's mapLock.
+func (dag *VersionDAG) descendants(u dvid.UUID) map[dvid.UUID]bool {
+	set := make(map[dvid.UUID]bool)
+	stack := []dvid.UUID{u}
+	for len(stack) > 0 {
+		u, stack = stack[len(stack)-1], stack[:len(stack)-1]
+		if set[u] {
+			continue
+		}
+		set[u] = true
+		if node, found := dag.Nodes[u]; found {
+			stack = append(stack, node.Children...)
+		}
+	}
+	return set
+}
+
+// dagNodes returns the DAG state of the nodes accepted by a filter, ordered by
+// creation time with the oldest first.
+func (dag *VersionDAG) dagNodes(accept func(node *Node) bool) []DAGNode {
+	dag.mapLock.Lock()
+	defer dag.mapLock.Unlock()
+
+	nodes := []DAGNode{}
+	for _, node := range dag.Nodes {
+		if accept(node) {
+			nodes = append(nodes, dag.dagNode(node))
+		}
+	}
+	sort.Sort(dagNodesByCreation(nodes))
+	return nodes
+}
+
+type dagNodesByCreation []DAGNode
+
+func (nodes dagNodesByCreation) Len() int      { return len(nodes) }
+func (nodes dagNodesByCreation) Swap(i, j int) { nodes[i], nodes[j] = nodes[j], nodes[i] }
+func (nodes dagNodesByCreation) Less(i, j int) bool {
+	return nodes[i].Created.Before(nodes[j].Created)
+}
+
+// dagFromUUID returns the version DAG holding the node with the given UUID.
+func (s *Service) dagFromUUID(u dvid.UUID) (*VersionDAG, error) {
+	if s.datasets == nil {
+		return nil, fmt.Errorf("Datastore service has no datasets available")
+	}
+	dataset, err := s.datasets.DatasetFromUUID(u)
+	if err != nil {
+		return nil, err
+	}
+	return dataset.VersionDAG, nil
+}
+
+// DAGNodes returns all nodes in the version DAG of the dataset holding the given UUID,
+// oldest first.
+func (s *Service) DAGNodes(u dvid.UUID) ([]DAGNode, error) {
+	dag, err := s.dagFromUUID(u)
+	if err != nil {
+		return nil, err
+	}
+	return dag.dagNodes(func(node *Node) bool { return true }), nil
+}
+
+// DAGNode returns the position and state of the node with the given UUID.
+func (s *Service) DAGNode(u dvid.UUID) (DAGNode, error) {
+	dag, err := s.dagFromUUID(u)
+	if err != nil {
+		return DAGNode{}, err
+	}
+	dag.mapLock.Lock()
+	defer dag.mapLock.Unlock()
+	node, found := dag.Nodes[u]
+	if !found {
+		return DAGNode{}, fmt.Errorf("No node found with UUID %s", u)
+	}
+	return dag.dagNode(node), nil
+}
+
+// Ancestors returns all ancestors of the node with the given UUID through any of its
+// parents, oldest first.
+func (s *Service) Ancestors(u dvid.UUID) ([]DAGNode, error) {
+	dag, err := s.dagFromUUID(u)
+	if err != nil {
+		return nil, err
+	}
+	dag.mapLock.Lock()
+	ancestors := dag.ancestors(u)
+	dag.mapLock.Unlock()
+	return dag.dagNodes(func(node *Node) bool {
+		return ancestors[node.GlobalID] && node.GlobalID != u
+	}), nil
+}
+
+// Descendants returns all descendants of the node with the given UUID, oldest first.
+func (s *Service) Descendants(u dvid.UUID) ([]DAGNode, error) {
+	dag, err := s.dagFromUUID(u)
+	if err != nil {
+		return nil, err
+	}
+	dag.mapLock.Lock()
+	descendants := dag.descendants(u)
+	dag.mapLock.Unlock()
+	return dag.dagNodes(func(node *Node) bool {
+		return descendants[node.GlobalID] && node.GlobalID != u
+	}), nil
+}
+
+// Heads returns the nodes of the dataset holding the given UUID that have no children
+// other than pruned nodes, oldest first.  Pruned nodes are not heads.
+func (s *Service) Heads(u dvid.UUID) ([]DAGNode, error) {
+	dag, err := s.dagFromUUID(u)
+	if err != nil {
+		return nil, err
+	}
+	return dag.dagNodes(func(node *Node) bool {
+		if node.Pruned {
+			return false
+		}
+		for _, childUUID := range node.Children {
+			if child, found := dag.Nodes[childUUID]; found && !child.Pruned {
+				return false
+			}
+		}
+		return true
+	}), nil
+}
+
+// CommonAncestor returns the lowest common ancestor of the given nodes, i.e., the
+// nearest node to the first given node that is, or is an ancestor of, all the nodes.
+func (s *Service) CommonAncestor(nodes []dvid.UUID) (DAGNode, error) {
+	if len(nodes) == 0 {
+		return DAGNode{}, fmt.Errorf("Common ancestor requires at least one node")
+	}
+	dag, err := s.dagFromUUID(nodes[0])
+	if err != nil {
+		return DAGNode{}, err
+	}
+	for _, u := range nodes[1:] {
+		if other, err := s.dagFromUUID(u); err != nil || other != dag {
+			return DAGNode{}, fmt.Errorf("Node %s is not in the same dataset as %s", u, nodes[0])
+		}
+	}
+	ancestor, err := dag.commonAncestor(nodes)
+	if err != nil {
+		return DAGNode{}, err
+	}
+	return s.DAGNode(ancestor)
+}
@@ -0,0 +1,71 @@
+package datastore
+
+import (
+	. "github.com/janelia-flyem/go/gocheck"
+
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+func (s *DataSuite) TestDAGQueries(c *C) {
+	service, _ := newTestService(c)
+	defer service.Shutdown()
+
+	branch := func(u dvid.UUID) dvid.UUID {
+		child, err := service.NewVersion(u)
+		c.Assert(err, IsNil)
+		return child
+	}
+	uuids := func(nodes []DAGNode, err error) map[dvid.UUID]bool {
+		c.Assert(err, IsNil)
+		set := make(map[dvid.UUID]bool)
+		for _, node := range nodes {
+			set[node.UUID] = true
+		}
+		return set
+	}
+
+	// root -> a -> a1
+	//      -> b
+	// a, b -> merged
+	root, _, err := service.NewDataset()
+	c.Assert(err, IsNil)
+	c.Assert(service.Lock(root), IsNil)
+	a := branch(root)
+	b := branch(root)
+	c.Assert(service.Lock(a), IsNil)
+	c.Assert(service.Lock(b), IsNil)
+	a1 := branch(a)
+	merged, err := service.Merge([]dvid.UUID{a, b}, MergeStrategy{})
+	c.Assert(err, IsNil)
+
+	c.Assert(uuids(service.DAGNodes(b)), DeepEquals,
+		map[dvid.UUID]bool{root: true, a: true, b: true, a1: true, merged: true})
+	c.Assert(uuids(service.Ancestors(merged)), DeepEquals,
+		map[dvid.UUID]bool{root: true, a: true, b: true})
+	c.Assert(uuids(service.Descendants(a)), DeepEquals,
+		map[dvid.UUID]bool{a1: true, merged: true})
+	c.Assert(uuids(service.Heads(root)), DeepEquals,
+		map[dvid.UUID]bool{a1: true, merged: true})
+
+	node, err := service.DAGNode(merged)
+	c.Assert(err, IsNil)
+	c.Assert(node.Parents, DeepEquals, []dvid.UUID{a, b})
+	c.Assert(node.Locked, Equals, false)
+	node, err = service.DAGNode(a)
+	c.Assert(err, IsNil)
+	c.Assert(node.Children, DeepEquals, []dvid.UUID{a1, merged})
+	c.Assert(node.Locked, Equals, true)
+
+	ancestor, err := service.CommonAncestor([]dvid.UUID{a1, b})
+	c.Assert(err, IsNil)
+	c.Assert(ancestor.UUID, Equals, root)
+	ancestor, err = service.CommonAncestor([]dvid.UUID{merged, a1})
+	c.Assert(err, IsNil)
+	c.Assert(ancestor.UUID, Equals, a)
+
+	// Nodes must be in the same dataset.
+	other, _, err := service.NewDataset()
+	c.Assert(err, IsNil)
+	_, err = service.CommonAncestor([]dvid.UUID{a, other})
+	c.Assert(err, NotNil)
+}
@@ -129,22 +129,40 @@ func (dsets *Datasets) newDataset() (dset *Dataset, err error) {
 	return
 }
 
-// newChild creates a new child node off a LOCKED parent node.  Will return
-// an error if the parent node has not been locked.
-func (dsets *Datasets) newChild(parent dvid.UUID) (dset *Dataset, u dvid.UUID, err error) {
-	// Find the Dataset with this UUID
-	var found bool
-	dset, found = dsets.mapUUID[parent]
-	if !found {
-		err = fmt.Errorf("No node found with UUID %s", parent)
+// newChild creates a new child node off LOCKED parent nodes, which must be in
+// the same dataset.  Will return an error if a parent node has not been locked.
+func (dsets *Datasets) newChild(parents ...dvid.UUID) (dset *Dataset, u dvid.UUID, err error) {
+	// Find the Dataset with these UUIDs
+	if len(parents) == 0 {
+		err = fmt.Errorf("Cannot create a child without a parent node")
 		return
 	}
+	for _, parent := range parents {
+		parentDset, found := dsets.mapUUID[parent]
+		if !found {
+			err = fmt.Errorf("No node found with UUID %s", parent)
+			return
+		}
+		if dset != nil && parentDset != dset {
+			err = fmt.Errorf("Nodes %s and %s are not in the same dataset", parents[0], parent)
+			return
+		}
+		dset = parentDset
+	}
 
 	// Create the child in this Dataset's DAG
-	u, err = dset.VersionDAG.newChild(parent)
+	u, err = dset.VersionDAG.newChild(parents...)
 	if err != nil {
 		return
 	}
+
+	// The child inherits versioned data from its parent until it is written.
+	child := dset.Nodes[u]
+	for name, dataservice := range dset.DataMap {
+		if dataservice.IsVersioned() {
+			child.setAvail(name, DataDelta)
+		}
+	}
 	dsets.mapUUID[u] = dset
 	return
 }
@@ -403,6 +421,9 @@ type NodeVersion struct {
 	// Locked nodes are read-only and can be branched.
 	Locked bool
 
+	// Pruned nodes hold no data and can't be branched.  Their VersionID may be reused.
+	Pruned bool
+
 	// Parents is an ordered list of parent nodes.
 	Parents []dvid.UUID
 
@@ -422,6 +443,10 @@ type NodeText struct {
 	// Provenance describes the operations performed between the locking of
 	// this node's parents and its current state.
 	Provenance string
+
+	// Author is the user who locked this node, with the lock message held in Note.
+	// The requests that modified data at this node are stored under OperationKeys.
+	Author string
 }
 
 // Node contains all information needed at each node of the version DAG
@@ -437,6 +462,12 @@ type Node struct {
 	Avail map[dvid.DataString]DataAvail
 
 	writeLock sync.Mutex
+
+	// numOperations is the number of operations stored under the node's
+	// OperationKeys, which is only known once operationsCounted is set.  Guarded
+	// by writeLock.
+	numOperations     uint64
+	operationsCounted bool
 }
 
 // VersionDAG is the directed acyclic graph of NodeVersion and an index by UUID into
@@ -452,6 +483,12 @@ type VersionDAG struct {
 	NewVersionID dvid.VersionLocalID
 	NewDataID    dvid.DataLocalID
 
+	// FreeVersionIDs holds the VersionLocalIDs of pruned nodes available for reuse.
+	FreeVersionIDs []dvid.VersionLocalID
+
+	// Refs holds the tags and branches naming nodes, keyed by name.
+	Refs map[string]*Ref
+
 	mapLock sync.Mutex // guards the VersionDAG maps
 }
 
@@ -487,47 +524,92 @@ func (dag *VersionDAG) Lock(u dvid.UUID) error {
 	return nil
 }
 
-// newChild creates a new child node off a LOCKED parent node.  Will return
-// an error if the parent node has not been locked.
-func (dag *VersionDAG) newChild(parent dvid.UUID) (u dvid.UUID, err error) {
-	node, found := dag.Nodes[parent]
-	if !found {
-		err = fmt.Errorf("No node found with UUID %s", parent)
+// newChild creates a new child node off LOCKED parent nodes.  Will return
+// an error if a parent node has not been locked.  A child with more than one
+// parent merges its parents, with the first parent as its primary ancestor.
+func (dag *VersionDAG) newChild(parents ...dvid.UUID) (u dvid.UUID, err error) {
+	if len(parents) == 0 {
+		err = fmt.Errorf("Cannot create a child without a parent node")
 		return
 	}
-	if !node.Locked {
-		err = fmt.Errorf("Cannot create a child of an unlocked node %s", parent)
-		return
+	nodes := make([]*Node, len(parents))
+	for i, parent := range parents {
+		node, found := dag.Nodes[parent]
+		if !found {
+			err = fmt.Errorf("No node found with UUID %s", parent)
+			return
+		}
+		if !node.Locked {
+			err = fmt.Errorf("Cannot create a child of an unlocked node %s", parent)
+			return
+		}
+		if node.Pruned {
+			err = fmt.Errorf("Cannot create a child of a pruned node %s", parent)
+			return
+		}
+		for _, other := range parents[:i] {
+			if other == parent {
+				err = fmt.Errorf("Node %s given more than once as a parent", parent)
+				return
+			}
+		}
+		nodes[i] = node
 	}
 
+	dag.mapLock.Lock()
+	versionID, err := dag.newVersionID()
+	if err != nil {
+		dag.mapLock.Unlock()
+		return
+	}
 	u = dvid.NewUUID()
 	t := time.Now()
-
-	node.writeLock.Lock()
-	node.Children = append(node.Children, u)
-	node.Updated = t
-	node.writeLock.Unlock()
-
-	dag.mapLock.Lock()
 	version := &NodeVersion{
 		GlobalID:  u,
-		VersionID: dag.NewVersionID,
+		VersionID: versionID,
 		Created:   t,
 		Updated:   t,
-		Parents:   []dvid.UUID{parent},
+		Parents:   append([]dvid.UUID{}, parents...),
 	}
 	dag.Nodes[u] = &Node{NodeVersion: version}
 	dag.VersionMap[u] = version.VersionID
-	dag.NewVersionID++
 	dag.mapLock.Unlock()
+
+	for _, node := range nodes {
+		node.writeLock.Lock()
+		node.Children = append(node.Children, u)
+		node.Updated = t
+		node.writeLock.Unlock()
+	}
 	return
 }
 
+// newVersionID returns an unused VersionLocalID, reusing those released by pruned
+// nodes before allocating new ones.  The caller must hold the mapLock.
+func (dag *VersionDAG) newVersionID() (dvid.VersionLocalID, error) {
+	if n := len(dag.FreeVersionIDs); n > 0 {
+		versionID := dag.FreeVersionIDs[n-1]
+		dag.FreeVersionIDs = dag.FreeVersionIDs[:n-1]
+		return versionID, nil
+	}
+	if dag.NewVersionID == ^dvid.VersionLocalID(0) {
+		return 0, fmt.Errorf("All version IDs of dataset with root %s are used.  "+
+			"Prune unneeded nodes to make more available.", dag.Root)
+	}
+	versionID := dag.NewVersionID
+	dag.NewVersionID++
+	return versionID, nil
+}
+
 // LogInfo returns provenance information for all the version nodes.
 func (dag *VersionDAG) LogInfo() string {
 	text := "Versions:\n"
 	for _, node := range dag.Nodes {
-		text += fmt.Sprintf("%s  (%d)\n", node.GlobalID, node.VersionID)
+		text += fmt.Sprintf("%s  (%d)", node.GlobalID, node.VersionID)
+		if node.NodeText != nil && node.Note != "" {
+			text += fmt.Sprintf("  %s: %s", node.Author, strings.SplitN(node.Note, "\n", 2)[0])
+		}
+		text += "\n"
 	}
 	return text
 }
@@ -7,6 +7,7 @@ package datastore
 import (
 	"encoding/json"
 	"fmt"
+	"sync"
 
 	"github.com/janelia-flyem/dvid/dvid"
 	"github.com/janelia-flyem/dvid/storage"
@@ -34,20 +35,53 @@ func Versions() string {
 
 // Init creates a key-value datastore using default arguments.  Datastore
 // configuration is stored as key/values in the datastore and also in a
-// human-readable config file in the datastore directory.
+// human-readable config file in the datastore directory.  Key spaces may be routed
+// to additional stores using "stores" and "routes" settings in the config, and a
+// read-through cache can be sized using the "cache" setting.
 func Init(directory string, create bool, config dvid.Config) error {
 	fmt.Println("\nInitializing datastore at", directory)
 
+	settings, err := parseSettings(config)
+	if err != nil {
+		return err
+	}
+
 	// Initialize the backend database
 	db, err := storage.NewStore(directory, create, config)
 	if err != nil {
 		return fmt.Errorf("Error initializing datastore (%s): %s\n", directory, err.Error())
 	}
-	defer db.Close()
+
+	if err = settings.put(db); err != nil {
+		db.Close()
+		return err
+	}
+
+	// Route key spaces to any additional stores.
+	routes, err := parseRoutes(config)
+	if err != nil {
+		db.Close()
+		return err
+	}
+	var engine storage.Engine = db
+	if routes == nil {
+		defer db.Close()
+	} else {
+		router, err := routes.open(create, config, db)
+		if err != nil {
+			db.Close()
+			return err
+		}
+		defer routes.close()
+		if err = routes.put(db); err != nil {
+			return err
+		}
+		engine = router
+	}
 
 	// Put empty Datasets
 	datasets := new(Datasets)
-	err = datasets.Put(db)
+	err = datasets.Put(engine)
 	return err
 }
 
@@ -57,7 +91,15 @@ type Service struct {
 
 	// The backend storage which is private since we want to create an object
 	// interface (e.g., cache object or UUID map) and hide DVID-specific keys.
-	db storage.Engine
+	// It is guarded by dbLock since tests may replace it.
+	db     storage.Engine
+	dbLock sync.RWMutex
+
+	// Routes of key spaces to additional stores or nil if only one store is used.
+	routes *storageRoutes
+
+	// Background deletions of deleted data.
+	reclaims dataReclaims
 }
 
 type OpenErrorType int
@@ -87,9 +129,45 @@ func Open(path string) (s *Service, openErr *OpenError) {
 		return
 	}
 
+	// Route key spaces to any additional stores.
+	routes, err := loadRoutes(db)
+	if err != nil {
+		db.Close()
+		openErr = &OpenError{err, ErrorOpening}
+		return
+	}
+	settings, err := loadSettings(db)
+	if err != nil {
+		db.Close()
+		openErr = &OpenError{err, ErrorOpening}
+		return
+	}
+	var engine storage.Engine = db
+	if routes != nil {
+		engine, err = routes.open(create, dvid.Config{}, db)
+		if err != nil {
+			db.Close()
+			openErr = &OpenError{err, ErrorOpening}
+			return
+		}
+	}
+	engine = settings.wrap(engine)
+
+	// Close all stores if the datastore can't be used.
+	defer func() {
+		if openErr == nil {
+			return
+		}
+		if routes != nil {
+			routes.close()
+		} else {
+			db.Close()
+		}
+	}()
+
 	// Read this datastore's configuration
 	datasets := new(Datasets)
-	err = datasets.Load(db)
+	err = datasets.Load(engine)
 	if err != nil {
 		openErr = &OpenError{
 			fmt.Errorf("Error reading datasets: %s", err.Error()),
@@ -97,6 +175,12 @@ func Open(path string) (s *Service, openErr *OpenError) {
 		}
 		return
 	}
+	if routes != nil {
+		if err = routes.addDataRoutes(datasets); err != nil {
+			openErr = &OpenError{err, ErrorDatasets}
+			return
+		}
+	}
 
 	// Verify that the runtime configuration can be supported by this DVID's
 	// compiled-in data types.
@@ -111,13 +195,18 @@ func Open(path string) (s *Service, openErr *OpenError) {
 	}
 
 	fmt.Printf("\nDatastoreService successfully opened: %s\n", path)
-	s = &Service{datasets, db}
+	s = &Service{datasets: datasets, db: engine, routes: routes}
 	return
 }
 
 // Shutdown closes a DVID datastore.
 func (s *Service) Shutdown() {
-	s.db.Close()
+	s.reclaims.stopAll()
+	if s.routes != nil {
+		s.routes.close()
+	} else {
+		s.StorageEngine().Close()
+	}
 }
 
 // DatasetsListJSON returns JSON of a list of datasets.
@@ -177,11 +266,11 @@ func (s *Service) NewDataset() (root dvid.UUID, datasetID dvid.DatasetLocalID, e
 	if err != nil {
 		return
 	}
-	err = s.datasets.Put(s.db) // Need to persist change to list of Dataset
+	err = s.datasets.Put(s.StorageEngine()) // Need to persist change to list of Dataset
 	if err != nil {
 		return
 	}
-	err = dataset.Put(s.db)
+	err = dataset.Put(s.StorageEngine())
 	root = dataset.Root
 	datasetID = dataset.DatasetID
 	return
@@ -199,7 +288,7 @@ func (s *Service) NewVersion(parent dvid.UUID) (u dvid.UUID, err error) {
 	if err != nil {
 		return
 	}
-	err = dataset.Put(s.db)
+	err = dataset.Put(s.StorageEngine())
 	return
 }
 
@@ -216,23 +305,17 @@ func (s *Service) NewData(u dvid.UUID, typename, dataname string, config dvid.Co
 	if err != nil {
 		return err
 	}
-	return dataset.Put(s.db)
+	if s.routes != nil {
+		if err = s.routes.addDataRoute(dataset, dvid.DataString(dataname)); err != nil {
+			return err
+		}
+	}
+	return dataset.Put(s.StorageEngine())
 }
 
 // Locks the node with the given UUID.
 func (s *Service) Lock(u dvid.UUID) error {
-	if s.datasets == nil {
-		return fmt.Errorf("Datastore service has no datasets available")
-	}
-	dataset, err := s.datasets.DatasetFromUUID(u)
-	if err != nil {
-		return err
-	}
-	err = dataset.Lock(u)
-	if err != nil {
-		return err
-	}
-	return dataset.Put(s.db)
+	return s.LockWithMessage(u, "", "")
 }
 
 // SaveDataset forces this service to persist the dataset with given UUID.
@@ -245,7 +328,7 @@ func (s *Service) SaveDataset(u dvid.UUID) error {
 	if err != nil {
 		return err
 	}
-	return dataset.Put(s.db)
+	return dataset.Put(s.StorageEngine())
 }
 
 // LocalIDFromUUID when supplied a UUID string, returns smaller sized local IDs that identify a
@@ -269,9 +352,56 @@ func (s *Service) LocalIDFromUUID(u dvid.UUID) (dID dvid.DatasetLocalID, vID dvi
 	return
 }
 
+// LockedError is returned when data would be modified at a locked version node.
+type LockedError struct {
+	UUID dvid.UUID
+}
+
+func (e *LockedError) Error() string {
+	return fmt.Sprintf("Version node %s is locked and read-only.  Branch it to make changes.", e.UUID)
+}
+
+// IsLockedError returns true if the error is due to modification of a locked node.
+func IsLockedError(err error) bool {
+	_, locked := err.(*LockedError)
+	return locked
+}
+
+// WritableVersion returns the local IDs for the node with the given UUID if data can
+// be modified at that node.  Data types should call it before any mutation, passing
+// the token of the request making the change.  If the node is locked, a *LockedError
+// is returned.
+func (s *Service) WritableVersion(u dvid.UUID, token *WriteToken) (dID dvid.DatasetLocalID,
+	vID dvid.VersionLocalID, err error) {
+
+	if s.datasets == nil {
+		err = fmt.Errorf("Datastore service has no datasets available")
+		return
+	}
+	var dataset *Dataset
+	dataset, err = s.datasets.DatasetFromUUID(u)
+	if err != nil {
+		return
+	}
+	dataset.mapLock.Lock()
+	node, found := dataset.Nodes[u]
+	dataset.mapLock.Unlock()
+	if !found {
+		err = fmt.Errorf("UUID (%s) not found in dataset", u)
+		return
+	}
+	if node.Locked {
+		err = &LockedError{u}
+		return
+	}
+	token.add()
+	return dataset.DatasetID, node.VersionID, nil
+}
+
 // NodeIDFromString when supplied a UUID string, returns the matched UUID as well as
 // more compact local IDs that identify the dataset and a version.  Partial matches
-// are allowed, similar to DatasetFromString.
+// are allowed, similar to DatasetFromString.  The name of a tag or branch can also
+// be given if it is unique across datasets.
 func (s *Service) NodeIDFromString(str string) (u dvid.UUID, dID dvid.DatasetLocalID,
 	vID dvid.VersionLocalID, err error) {
 
@@ -280,12 +410,20 @@ func (s *Service) NodeIDFromString(str string) (u dvid.UUID, dID dvid.DatasetLoc
 		return
 	}
 	var dataset *Dataset
-	dataset, u, err = s.datasets.DatasetFromString(str)
+	if validRefName(str) == nil {
+		dataset, u, err = s.datasets.refNode(str)
+	} else {
+		dataset, u, err = s.datasets.DatasetFromString(str)
+	}
 	if err != nil {
 		return
 	}
 	dID = dataset.DatasetID
-	vID = dataset.VersionMap[u]
+	var found bool
+	vID, found = dataset.VersionMap[u]
+	if !found {
+		err = fmt.Errorf("UUID (%s) not found in dataset", u)
+	}
 	return
 }
 
@@ -300,14 +438,28 @@ func (s *Service) DataService(u dvid.UUID, name dvid.DataString) (dataservice Da
 
 // StorageEngine returns a a key-value database interface.
 func (s *Service) StorageEngine() storage.Engine {
+	s.dbLock.RLock()
+	defer s.dbLock.RUnlock()
 	return s.db
 }
 
+// SetStorageEngine replaces the key-value database used by the datastore and its
+// data and returns the replaced database.  It is a testing hook, e.g., to wrap the
+// database with a storage.FaultyEngine, and requests already using the replaced
+// database continue to use it.
+func (s *Service) SetStorageEngine(db storage.Engine) storage.Engine {
+	s.dbLock.Lock()
+	defer s.dbLock.Unlock()
+	old := s.db
+	s.db = db
+	return old
+}
+
 // Batcher returns an interface that can create a new batch write.
 func (s *Service) Batcher() (db storage.Batcher, err error) {
-	if s.db.IsBatcher() {
+	if s.StorageEngine().IsBatcher() {
 		var ok bool
-		db, ok = s.db.(storage.Batcher)
+		db, ok = s.StorageEngine().(storage.Batcher)
 		if !ok {
 			err = fmt.Errorf("DVID backend says it supports batch write but does not!")
 		}
@@ -326,7 +478,7 @@ func (s *Service) SupportedDataChart() string {
 }
 
 // About returns a chart of the code versions of compile-time DVID datastore
-// and the runtime data types.
+// and the runtime data types, followed by the progress of any deleted data reclaims.
 func (s *Service) About() string {
 	var text string
 	writeLine := func(name, version string) {
@@ -340,6 +492,9 @@ func (s *Service) About() string {
 			writeLine(dtype.DatatypeName(), dtype.DatatypeVersion())
 		}
 	}
+	for _, r := range s.Reclaims() {
+		text += fmt.Sprintf("\n%s", r)
+	}
 	return text
 }
 
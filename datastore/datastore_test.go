@@ -32,3 +32,13 @@ func (suite *DataSuite) SetUpSuite(c *C) {
 	suite.service, err = Open(suite.dir)
 	c.Assert(err, IsNil)
 }
+
+// newTestService creates and opens a datastore in a new temporary directory, for
+// tests that need a datastore of their own.  The caller must shut down the service.
+func newTestService(c *C) (service *Service, dir string) {
+	dir = c.MkDir()
+	c.Assert(Init(dir, true, dvid.Config{}), IsNil)
+	service, openErr := Open(dir)
+	c.Assert(openErr, IsNil)
+	return service, dir
+}
@@ -75,6 +75,9 @@ type DataService interface {
 	// DataName returns the name of the data (e.g., grayscale data that is grayscale8 data type).
 	DataName() dvid.DataString
 
+	// LocalID returns the server-specific ID of the data, which is unique per dataset.
+	LocalID() dvid.DataLocalID
+
 	// IsVersioned returns true if this data can be mutated across versions.  If the data is
 	// not versioned, only one copy of data is kept across all versions nodes in a dataset.
 	IsVersioned() bool
@@ -93,6 +96,13 @@ type DataService interface {
 type Request struct {
 	dvid.Command
 	Input []byte
+
+	// User is the name of the user making the request, recorded in node provenance.
+	User string
+
+	// Writes counts the writes allowed for the request.  Data types pass it to
+	// WritableVersion so the request is recorded in the provenance of nodes it modifies.
+	Writes *WriteToken
 }
 
 var (
@@ -241,9 +251,16 @@ type Data struct {
 
 	// If false (default), we allow changes along nodes.
 	Unversioned bool
+
+	// Compression of stored values.  If nil (default), values use Snappy compression.
+	// This is the only record of the level, which applies to values written after it
+	// is set and is not stored with each value.
+	Compression *dvid.Compressor
 }
 
-// NewDataService returns a base data struct and sets the versioning depending on config.
+// NewDataService returns a base data struct and sets the versioning and compression
+// depending on config.  Compression is given as <format>[:<level>], e.g.,
+// "Compression=gzip:6".
 func NewDataService(id *DataID, t TypeService, config dvid.Config) (data *Data, err error) {
 	data = &Data{DataID: id, TypeService: t}
 	var versioned bool
@@ -252,6 +269,19 @@ func NewDataService(id *DataID, t TypeService, config dvid.Config) (data *Data,
 		return
 	}
 	data.Unversioned = !versioned
+
+	var s string
+	var found bool
+	s, found, err = config.GetString("Compression")
+	if err != nil || !found {
+		return
+	}
+	var compressor dvid.Compressor
+	compressor, err = dvid.ParseCompressor(s)
+	if err != nil {
+		return
+	}
+	data.Compression = &compressor
 	return
 }
 
@@ -259,11 +289,31 @@ func (d *Data) IsVersioned() bool {
 	return !d.Unversioned
 }
 
+// Compressor returns the compression used for this data's stored values.
+func (d *Data) Compressor() dvid.Compressor {
+	if d.Compression == nil {
+		return dvid.Compressor{Format: dvid.Snappy}
+	}
+	return *d.Compression
+}
+
 func (d *Data) UnknownCommand(request Request) error {
 	return fmt.Errorf("Unknown command.  Data type '%s' [%s] does not support '%s' command.",
 		d.Name, d.DatatypeName(), request.TypeCommand())
 }
 
+// DeleteVersion removes all key-value pairs for this data at the given version.
+func (d *Data) DeleteVersion(db storage.KeyValueSetter, versionID dvid.VersionLocalID) error {
+	first, last := d.VersionKeyRange(versionID)
+	return db.DeleteRange(first, last)
+}
+
+// DeleteAll removes all key-value pairs for this data across all versions.
+func (d *Data) DeleteAll(db storage.KeyValueSetter) error {
+	first, last := d.KeyRange()
+	return db.DeleteRange(first, last)
+}
+
 // --- Handle version-specific data mutexes -----
 
 type nodeID struct {
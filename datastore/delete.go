@@ -0,0 +1,284 @@
+/*
+	This file supports deletion of data instances.  Deleted data is removed from its
+	dataset immediately, and its key-value pairs across all versions are then deleted
+	in the background.  Shutdown stops reclaims in progress before closing the stores.
+	If the server stops before the deletion completes, remaining pairs are reported as
+	orphans by Verify and can be quarantined with repair.
+*/
+
+package datastore
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+// reclaimBatchSize is the number of key-value pairs deleted between progress reports.
+const reclaimBatchSize = 10000
+
+// DataReferrer is implemented by data that depend on other data in the same dataset,
+// e.g., tiles generated from a source of voxels.  Referenced data cannot be deleted.
+type DataReferrer interface {
+	// References returns the names of data this data depends on.
+	References() []dvid.DataString
+}
+
+// deleteData removes the named data from the dataset, returning the removed data.
+// Will return an error if other data in the dataset reference it.
+func (dset *Dataset) deleteData(name dvid.DataString) (DataService, error) {
+	dset.mapLock.Lock()
+	defer dset.mapLock.Unlock()
+
+	dataservice, found := dset.DataMap[name]
+	if !found {
+		return nil, fmt.Errorf("No data named '%s' in dataset %s", name, dset.Root)
+	}
+	for othername, other := range dset.DataMap {
+		referrer, ok := other.(DataReferrer)
+		if !ok {
+			continue
+		}
+		for _, ref := range referrer.References() {
+			if ref == name {
+				return nil, fmt.Errorf("Cannot delete data '%s' since it is used by data '%s'",
+					name, othername)
+			}
+		}
+	}
+	delete(dset.DataMap, name)
+	return dataservice, nil
+}
+
+// DataReclaim tracks the background deletion of a deleted data instance's key-value pairs.
+type DataReclaim struct {
+	Data    dvid.DataString
+	Dataset dvid.UUID // Root of the dataset that held the data.
+
+	deleted uint64 // accessed atomically
+	done    chan struct{}
+	err     error
+}
+
+// Deleted returns the number of key-value pairs deleted so far.
+func (r *DataReclaim) Deleted() uint64 {
+	return atomic.LoadUint64(&r.deleted)
+}
+
+// Wait blocks until all key-value pairs have been deleted and returns any error.
+func (r *DataReclaim) Wait() error {
+	<-r.done
+	return r.err
+}
+
+func (r *DataReclaim) String() string {
+	select {
+	case <-r.done:
+		if r.err != nil {
+			return fmt.Sprintf("Reclaim of data '%s' failed after %d key-value pairs: %s",
+				r.Data, r.Deleted(), r.err.Error())
+		}
+		return fmt.Sprintf("Reclaimed %d key-value pairs of data '%s'", r.Deleted(), r.Data)
+	default:
+		return fmt.Sprintf("Reclaiming data '%s': %d key-value pairs deleted", r.Data, r.Deleted())
+	}
+}
+
+// reclaim deletes all key-value pairs in the inclusive range [first, last], logging
+// progress after each batch of deletions.  Deletion ends early if stop is closed.
+func (r *DataReclaim) reclaim(db storage.Engine, first, last []byte, stop <-chan struct{}) error {
+	return deleteRange(db, first, last, func(n int) error {
+		atomic.AddUint64(&r.deleted, uint64(n))
+		dvid.Log(dvid.Normal, "%s\n", r)
+		select {
+		case <-stop:
+			return fmt.Errorf("Stopped by datastore shutdown")
+		default:
+			return nil
+		}
+	})
+}
+
+// deleteRange deletes all key-value pairs in the inclusive range [first, last] in
+// batches, calling progress with the number of pairs deleted in each batch.  Deletion
+// stops if progress returns an error.
+func deleteRange(db storage.Engine, first, last []byte, progress func(n int) error) error {
+	batcher, canBatch := db.(storage.Batcher)
+	canBatch = canBatch && db.IsBatcher()
+	for {
+		keys, err := firstKeys(db, first, last, reclaimBatchSize)
+		if err != nil {
+			return err
+		}
+		if len(keys) == 0 {
+			return nil
+		}
+		if canBatch {
+			batch := batcher.NewBatch()
+			for _, key := range keys {
+				batch.Delete(key)
+			}
+			err = batch.Commit()
+			batch.Close()
+		} else {
+			for _, key := range keys {
+				if err = db.Delete(key); err != nil {
+					break
+				}
+			}
+		}
+		if err != nil {
+			return err
+		}
+		if progress != nil {
+			if err = progress(len(keys)); err != nil {
+				return err
+			}
+		}
+
+		// Resume just after the last deleted key so the next batch doesn't have to
+		// step over the deletions again.
+		lastDeleted := keys[len(keys)-1].Bytes()
+		first = make([]byte, len(lastDeleted)+1)
+		copy(first, lastDeleted)
+	}
+}
+
+// firstKeys returns up to n of the first keys in the inclusive range [first, last].
+func firstKeys(db storage.Engine, first, last []byte, n int) ([]storage.Key, error) {
+	snapshot := storage.ReadSnapshot(db)
+	defer snapshot.Close()
+	it, err := snapshot.NewIterator(storage.RawKey(first), storage.RawKey(last), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer it.Close()
+	keys := []storage.Key{}
+	for ; it.Valid() && len(keys) < n; it.Next() {
+		key, err := it.Key()
+		if err != nil {
+			return nil, err
+		}
+		kBytes := make([]byte, len(key.Bytes()))
+		copy(kBytes, key.Bytes())
+		keys = append(keys, storage.RawKey(kBytes))
+	}
+	return keys, it.Error()
+}
+
+// DeleteData removes the named data from the dataset with the given UUID and persists
+// the dataset.  The data's key-value pairs across all versions are deleted in the
+// background, which can be tracked through the returned DataReclaim.  Will return an
+// error if other data in the dataset reference the named data.
+func (s *Service) DeleteData(u dvid.UUID, name dvid.DataString) (*DataReclaim, error) {
+	if s.datasets == nil {
+		return nil, fmt.Errorf("Datastore service has no datasets available")
+	}
+	dataset, err := s.datasets.DatasetFromUUID(u)
+	if err != nil {
+		return nil, err
+	}
+
+	// Register the reclaim first so a shutdown can't close the stores under it.
+	r := &DataReclaim{Data: name, Dataset: dataset.Root, done: make(chan struct{})}
+	stop, err := s.reclaims.add(r)
+	if err != nil {
+		return nil, err
+	}
+	dataservice, err := dataset.deleteData(name)
+	if err == nil {
+		err = dataset.Put(s.StorageEngine())
+	}
+	if err != nil {
+		s.reclaims.finish(r)
+		return nil, err
+	}
+
+	prefix := dataPrefix(dataset.DatasetID, dataservice.LocalID())
+	go func() {
+		startTime := time.Now()
+		err := r.reclaim(s.StorageEngine(), prefix, maxKey(prefix), stop)
+		if err == nil {
+			// Compaction can take a while, so it's left for later if shutting down.
+			select {
+			case <-stop:
+			default:
+				if _, ok := s.baseDB().(storage.Compacter); ok {
+					err = s.compactRange(prefix, maxKey(prefix))
+				}
+			}
+		}
+		r.err = err
+		s.reclaims.finish(r)
+		if err != nil {
+			dvid.Error("%s\n", r)
+		} else {
+			dvid.Log(dvid.Normal, "%s in %s\n", r, time.Since(startTime))
+		}
+	}()
+	return r, nil
+}
+
+// dataReclaims is the set of data reclaims in progress.  Closing stop asks reclaims
+// to end after their current batch of deletions.
+type dataReclaims struct {
+	sync.Mutex
+	active  []*DataReclaim
+	running sync.WaitGroup
+	stop    chan struct{}
+	stopped bool
+}
+
+// add registers a reclaim that must be removed when done, returning the channel that
+// is closed when reclaims should stop.  Reclaims can't be added once stopped.
+func (reclaims *dataReclaims) add(r *DataReclaim) (stop <-chan struct{}, err error) {
+	reclaims.Lock()
+	defer reclaims.Unlock()
+	if reclaims.stopped {
+		return nil, fmt.Errorf("Cannot delete data: datastore is shutting down")
+	}
+	if reclaims.stop == nil {
+		reclaims.stop = make(chan struct{})
+	}
+	reclaims.active = append(reclaims.active, r)
+	reclaims.running.Add(1)
+	return reclaims.stop, nil
+}
+
+// finish removes a reclaim and marks it done.
+func (reclaims *dataReclaims) finish(r *DataReclaim) {
+	reclaims.Lock()
+	defer reclaims.Unlock()
+	for i, active := range reclaims.active {
+		if active == r {
+			reclaims.active = append(reclaims.active[:i], reclaims.active[i+1:]...)
+			close(r.done)
+			reclaims.running.Done()
+			return
+		}
+	}
+}
+
+// stopAll stops all reclaims in progress and waits for them to end.
+func (reclaims *dataReclaims) stopAll() {
+	reclaims.Lock()
+	if !reclaims.stopped {
+		reclaims.stopped = true
+		if reclaims.stop != nil {
+			close(reclaims.stop)
+		}
+	}
+	reclaims.Unlock()
+	reclaims.running.Wait()
+}
+
+// Reclaims returns the reclaims of deleted data still in progress.
+func (s *Service) Reclaims() []*DataReclaim {
+	s.reclaims.Lock()
+	defer s.reclaims.Unlock()
+	return append([]*DataReclaim{}, s.reclaims.active...)
+}
@@ -0,0 +1,135 @@
+package datastore
+
+import (
+	"strings"
+
+	. "github.com/janelia-flyem/go/gocheck"
+
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+// referrerData is test data that depends on other data.
+type referrerData struct {
+	usageData
+	refs []dvid.DataString
+}
+
+func (d referrerData) References() []dvid.DataString {
+	return d.refs
+}
+
+func (s *DataSuite) TestDeleteData(c *C) {
+	service, _ := newTestService(c)
+	defer service.Shutdown()
+
+	root, datasetID, err := service.NewDataset()
+	c.Assert(err, IsNil)
+	dset, err := service.datasets.DatasetFromUUID(root)
+	c.Assert(err, IsNil)
+	data := usageData{&Data{DataID: &DataID{"mydata", 1, datasetID}}}
+	other := usageData{&Data{DataID: &DataID{"other", 2, datasetID}}}
+	dset.DataMap = map[dvid.DataString]DataService{"mydata": data, "other": other}
+
+	c.Assert(service.Lock(root), IsNil)
+	child, err := service.NewVersion(root)
+	c.Assert(err, IsNil)
+	for _, u := range []dvid.UUID{root, child} {
+		for _, index := range []string{"a", "b", "c"} {
+			c.Assert(service.db.Put(data.DataKey(dset.VersionMap[u], dvid.IndexString(index)),
+				[]byte("value")), IsNil)
+			c.Assert(service.db.Put(other.DataKey(dset.VersionMap[u], dvid.IndexString(index)),
+				[]byte("value")), IsNil)
+		}
+	}
+
+	// Data referenced by other data can't be deleted.
+	dset.DataMap["tiles"] = referrerData{
+		usageData{&Data{DataID: &DataID{"tiles", 3, datasetID}}},
+		[]dvid.DataString{"mydata"},
+	}
+	_, err = service.DeleteData(child, "mydata")
+	c.Assert(err, NotNil)
+	c.Assert(strings.Contains(err.Error(), "tiles"), Equals, true)
+	delete(dset.DataMap, "tiles")
+
+	_, err = service.DeleteData(child, "unknown")
+	c.Assert(err, NotNil)
+
+	reclaim, err := service.DeleteData(child, "mydata")
+	c.Assert(err, IsNil)
+	_, err = service.DataService(root, "mydata")
+	c.Assert(err, NotNil)
+	c.Assert(reclaim.Wait(), IsNil)
+	c.Assert(reclaim.Deleted(), Equals, uint64(6))
+	c.Assert(service.Reclaims(), HasLen, 0)
+
+	// Only the deleted data's key-value pairs are removed.
+	first, last := data.VersionKeyRange(0)
+	keys, err := service.db.KeysInRange(first, data.DataKey(^dvid.VersionLocalID(0), last.Index))
+	c.Assert(err, IsNil)
+	c.Assert(keys, HasLen, 0)
+	first, last = other.VersionKeyRange(0)
+	keys, err = service.db.KeysInRange(first, other.DataKey(^dvid.VersionLocalID(0), last.Index))
+	c.Assert(err, IsNil)
+	c.Assert(keys, HasLen, 6)
+}
+
+func (s *DataSuite) TestDeleteDataShutdown(c *C) {
+	service, _ := newTestService(c)
+
+	root, datasetID, err := service.NewDataset()
+	c.Assert(err, IsNil)
+	dset, err := service.datasets.DatasetFromUUID(root)
+	c.Assert(err, IsNil)
+	data := usageData{&Data{DataID: &DataID{"mydata", 1, datasetID}}}
+	other := usageData{&Data{DataID: &DataID{"other", 2, datasetID}}}
+	dset.DataMap = map[dvid.DataString]DataService{"mydata": data, "other": other}
+	for i := 0; i < 100; i++ {
+		index := dvid.IndexString(string([]byte{'a', byte(i)}))
+		c.Assert(service.db.Put(data.DataKey(dset.VersionMap[root], index), []byte("value")), IsNil)
+	}
+
+	// Shutdown stops reclaims in progress before closing the stores.
+	reclaim, err := service.DeleteData(root, "mydata")
+	c.Assert(err, IsNil)
+	service.Shutdown()
+	select {
+	case <-reclaim.done:
+	default:
+		c.Fatalf("Shutdown returned before reclaim of deleted data ended")
+	}
+	c.Assert(service.Reclaims(), HasLen, 0)
+
+	_, err = service.DeleteData(root, "other")
+	c.Assert(err, NotNil)
+}
+
+func (s *DataSuite) TestDeleteRangeBatches(c *C) {
+	service, _ := newTestService(c)
+	defer service.Shutdown()
+
+	// Fill more than one batch of keys, with a key past the range that must survive.
+	prefix := []byte{byte(KeyData), 0xF0}
+	for i := 0; i <= reclaimBatchSize; i++ {
+		key := append(append([]byte{}, prefix...), byte(i>>8), byte(i))
+		c.Assert(service.db.Put(storage.RawKey(key), []byte{}), IsNil)
+	}
+	outside := storage.RawKey([]byte{byte(KeyData), 0xF1})
+	c.Assert(service.db.Put(outside, []byte("keep")), IsNil)
+
+	var batches []int
+	err := deleteRange(service.db, prefix, maxKey(prefix), func(n int) error {
+		batches = append(batches, n)
+		return nil
+	})
+	c.Assert(err, IsNil)
+	c.Assert(batches, DeepEquals, []int{reclaimBatchSize, 1})
+
+	keys, err := service.db.KeysInRange(storage.RawKey(prefix), storage.RawKey(maxKey(prefix)))
+	c.Assert(err, IsNil)
+	c.Assert(keys, HasLen, 0)
+	value, err := service.db.Get(outside)
+	c.Assert(err, IsNil)
+	c.Assert(string(value), Equals, "keep")
+}
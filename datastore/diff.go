@@ -0,0 +1,159 @@
+/*
+	This file supports comparison of data between two versions.  Only indices written
+	at versions that are not read by both versions can differ, so the comparison scans
+	just those versions' keys and then compares the values visible at each version.
+*/
+
+package datastore
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+// DiffType describes how the value of an index changed between two versions.
+type DiffType uint8
+
+const (
+	// The index is only present in the second version.
+	IndexAdded DiffType = iota
+
+	// The index is only present in the first version.
+	IndexDeleted
+
+	// The index is present in both versions with different values.
+	IndexModified
+)
+
+func (t DiffType) String() string {
+	switch t {
+	case IndexAdded:
+		return "added"
+	case IndexDeleted:
+		return "deleted"
+	case IndexModified:
+		return "modified"
+	}
+	return fmt.Sprintf("unknown diff type %d", t)
+}
+
+// IndexDiff gives an index whose value differs between two versions.
+type IndexDiff struct {
+	Index dvid.IndexBytes
+	Diff  DiffType
+}
+
+// diffVersions returns the versions whose keys must be scanned to find differences
+// between reads along two ancestries.  If the versions common to both are read in the
+// same order, an index held only by common versions is read from the same version by
+// both, so only the versions read by one ancestry need to be scanned.
+func diffVersions(a, b []dvid.VersionLocalID) []dvid.VersionLocalID {
+	inA := make(map[dvid.VersionLocalID]bool, len(a))
+	for _, versionID := range a {
+		inA[versionID] = true
+	}
+	inB := make(map[dvid.VersionLocalID]bool, len(b))
+	for _, versionID := range b {
+		inB[versionID] = true
+	}
+	common := func(versions []dvid.VersionLocalID, in map[dvid.VersionLocalID]bool) (
+		shared, unshared []dvid.VersionLocalID) {
+		for _, versionID := range versions {
+			if in[versionID] {
+				shared = append(shared, versionID)
+			} else {
+				unshared = append(unshared, versionID)
+			}
+		}
+		return
+	}
+	sharedA, onlyA := common(a, inB)
+	sharedB, onlyB := common(b, inA)
+	for i := range sharedA {
+		if sharedA[i] != sharedB[i] {
+			return append(append([]dvid.VersionLocalID{}, a...), onlyB...)
+		}
+	}
+	return append(onlyA, onlyB...)
+}
+
+// DiffVersions returns the indices spanning (begIndex, endIndex) whose values differ
+// between data at the version with UUID u and the version with UUID other.  Changes
+// are given going from u to other and are in ascending index order.
+func (s *Service) DiffVersions(u, other dvid.UUID, name dvid.DataString,
+	begIndex, endIndex dvid.Index) ([]IndexDiff, error) {
+
+	snapshot := storage.ReadSnapshot(s.StorageEngine())
+	defer snapshot.Close()
+	return s.diffSnapshot(snapshot, u, other, name, begIndex, endIndex)
+}
+
+// diffSnapshot is DiffVersions reading from the given snapshot.
+func (s *Service) diffSnapshot(snapshot storage.Snapshot, u, other dvid.UUID,
+	name dvid.DataString, begIndex, endIndex dvid.Index) ([]IndexDiff, error) {
+
+	reader, err := s.VersionedReader(u, name, snapshot)
+	if err != nil {
+		return nil, err
+	}
+	otherReader, err := s.VersionedReader(other, name, snapshot)
+	if err != nil {
+		return nil, err
+	}
+	if reader.data.DsetID != otherReader.data.DsetID {
+		return nil, fmt.Errorf("Nodes %s and %s are not in the same dataset", u, other)
+	}
+
+	// Collect the indices written at versions not read by both.
+	data := reader.data
+	found := make(map[string]bool)
+	indices := []string{}
+	for _, versionID := range diffVersions(reader.versions, otherReader.versions) {
+		startKey := &DataKey{data.DsetID, data.ID, versionID, begIndex}
+		endKey := &DataKey{data.DsetID, data.ID, versionID, endIndex}
+		keys, err := snapshot.KeysInRange(startKey, endKey)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range keys {
+			dataKey, ok := key.(*DataKey)
+			if !ok {
+				return nil, fmt.Errorf("Can't convert Key (%s) to DataKey", key)
+			}
+			indexStr := string(dataKey.Index.Bytes())
+			if !found[indexStr] {
+				found[indexStr] = true
+				indices = append(indices, indexStr)
+			}
+		}
+	}
+	sort.Strings(indices)
+
+	// Compare the values visible at each version.
+	diffs := []IndexDiff{}
+	for _, indexStr := range indices {
+		index := dvid.IndexBytes(indexStr)
+		value, err := reader.Get(index)
+		if err != nil {
+			return nil, err
+		}
+		otherValue, err := otherReader.Get(index)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case value == nil && otherValue == nil:
+		case value == nil:
+			diffs = append(diffs, IndexDiff{index, IndexAdded})
+		case otherValue == nil:
+			diffs = append(diffs, IndexDiff{index, IndexDeleted})
+		case !bytes.Equal(value, otherValue):
+			diffs = append(diffs, IndexDiff{index, IndexModified})
+		}
+	}
+	return diffs, nil
+}
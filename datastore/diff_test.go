@@ -0,0 +1,20 @@
+package datastore
+
+import (
+	. "github.com/janelia-flyem/go/gocheck"
+
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+func (s *DataSuite) TestDiffVersions(c *C) {
+	// Versions read by both ancestries in the same order don't need scanning.
+	versions := diffVersions([]dvid.VersionLocalID{3, 1, 0}, []dvid.VersionLocalID{2, 1, 0})
+	c.Assert(versions, DeepEquals, []dvid.VersionLocalID{3, 2})
+
+	versions = diffVersions([]dvid.VersionLocalID{0}, []dvid.VersionLocalID{0})
+	c.Assert(versions, HasLen, 0)
+
+	// If common versions are read in different orders, all versions are scanned.
+	versions = diffVersions([]dvid.VersionLocalID{3, 1, 2}, []dvid.VersionLocalID{4, 2, 1})
+	c.Assert(versions, DeepEquals, []dvid.VersionLocalID{3, 1, 2, 4})
+}
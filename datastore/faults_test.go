@@ -0,0 +1,37 @@
+package datastore
+
+import (
+	. "github.com/janelia-flyem/go/gocheck"
+
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+func (s *DataSuite) TestStorageFaults(c *C) {
+	service, _ := newTestService(c)
+	defer service.Shutdown()
+
+	root, _, err := service.NewDataset()
+	c.Assert(err, IsNil)
+
+	db := storage.NewFaultyEngine(service.StorageEngine(), storage.Faults{PutErrorEvery: 1})
+	service.SetStorageEngine(db)
+
+	// Failed writes of dataset metadata are returned.
+	_, _, err = service.NewDataset()
+	c.Assert(err, NotNil)
+	_, err = service.NewVersion(root)
+	c.Assert(err, NotNil)
+	c.Assert(service.SaveDataset(root), NotNil)
+
+	// Corrupt values are found by verification.
+	db.SetFaults(storage.Faults{CorruptEvery: 1})
+	report, err := service.Verify(false)
+	c.Assert(err, IsNil)
+	c.Assert(report.NumCorrupt > 0, Equals, true)
+
+	db.SetFaults(storage.Faults{})
+	c.Assert(service.SaveDataset(root), IsNil)
+	report, err = service.Verify(false)
+	c.Assert(err, IsNil)
+	c.Assert(report.NumCorrupt, Equals, 0)
+}
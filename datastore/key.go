@@ -6,6 +6,7 @@
 package datastore
 
 import (
+	"encoding/binary"
 	"fmt"
 	"reflect"
 
@@ -32,6 +33,22 @@ const (
 	// Key group that holds Sync links between Data.  Sync key/value pairs designate
 	// what values need to be updated when its linked data changes.
 	KeySync
+
+	// Key group that holds the routing of key spaces to storage engines.  It is
+	// always kept in the default storage engine.
+	KeyRoutes
+
+	// Key group that holds datastore-wide settings like the cache size.  It is
+	// always kept in the default storage engine.
+	KeySettings
+
+	// Key group that holds values set aside by an integrity check.  Each key is the
+	// KeyQuarantine byte followed by the bytes of the original key.
+	KeyQuarantine
+
+	// Key group that holds the operations recorded in the provenance of version
+	// nodes, appended under each node's UUID in the order they were recorded.
+	KeyOperation
 )
 
 type KeyType storage.KeyType
@@ -46,6 +63,14 @@ func (t KeyType) String() string {
 		return "Data Key Type"
 	case KeySync:
 		return "Data Sync Key Type"
+	case KeyRoutes:
+		return "Storage Routes Key Type"
+	case KeySettings:
+		return "Datastore Settings Key Type"
+	case KeyQuarantine:
+		return "Quarantine Key Type"
+	case KeyOperation:
+		return "Node Operation Key Type"
 	default:
 		return "Unknown Key Type"
 	}
@@ -82,6 +107,64 @@ func (k DatasetsKey) String() string {
 	return fmt.Sprintf("%x", k.Bytes())
 }
 
+// RoutesKey is an implementation of storage.Key for storage routes persistence.
+type RoutesKey struct{}
+
+func (k RoutesKey) KeyType() storage.KeyType {
+	return storage.KeyType(KeyRoutes)
+}
+
+func (k RoutesKey) BytesToKey(b []byte) (storage.Key, error) {
+	if len(b) < 1 {
+		return nil, fmt.Errorf("Malformed RoutesKey bytes (too few): %x", b)
+	}
+	if b[0] != byte(KeyRoutes) {
+		return nil, fmt.Errorf("Cannot convert %s Key Type into RoutesKey", KeyType(b[0]))
+	}
+	return &RoutesKey{}, nil
+}
+
+func (k RoutesKey) Bytes() []byte {
+	return []byte{byte(KeyRoutes)}
+}
+
+func (k RoutesKey) BytesString() string {
+	return string(k.Bytes())
+}
+
+func (k RoutesKey) String() string {
+	return fmt.Sprintf("%x", k.Bytes())
+}
+
+// SettingsKey is an implementation of storage.Key for datastore settings persistence.
+type SettingsKey struct{}
+
+func (k SettingsKey) KeyType() storage.KeyType {
+	return storage.KeyType(KeySettings)
+}
+
+func (k SettingsKey) BytesToKey(b []byte) (storage.Key, error) {
+	if len(b) < 1 {
+		return nil, fmt.Errorf("Malformed SettingsKey bytes (too few): %x", b)
+	}
+	if b[0] != byte(KeySettings) {
+		return nil, fmt.Errorf("Cannot convert %s Key Type into SettingsKey", KeyType(b[0]))
+	}
+	return &SettingsKey{}, nil
+}
+
+func (k SettingsKey) Bytes() []byte {
+	return []byte{byte(KeySettings)}
+}
+
+func (k SettingsKey) BytesString() string {
+	return string(k.Bytes())
+}
+
+func (k SettingsKey) String() string {
+	return fmt.Sprintf("%x", k.Bytes())
+}
+
 // DatasetKey is an implementation of storage.Key for Dataset persistence.
 type DatasetKey struct {
 	Dataset dvid.DatasetLocalID
@@ -124,6 +207,52 @@ func MaxDatasetKey() storage.Key {
 	return &DatasetKey{maxDatasetLocalID}
 }
 
+// OperationKey is an implementation of storage.Key for the operations in the
+// provenance of a version node.  Keys are ordered by node and then by sequence, so a
+// node's operations can be read in the order they were recorded.
+type OperationKey struct {
+	Dataset  dvid.DatasetLocalID
+	UUID     dvid.UUID
+	Sequence uint64
+}
+
+func (k OperationKey) KeyType() storage.KeyType {
+	return storage.KeyType(KeyOperation)
+}
+
+func (k OperationKey) BytesToKey(b []byte) (storage.Key, error) {
+	if len(b) < 1+dvid.LocalID32Size+8 {
+		return nil, fmt.Errorf("Malformed OperationKey bytes (too few): %x", b)
+	}
+	if b[0] != byte(KeyOperation) {
+		return nil, fmt.Errorf("Cannot convert %s Key Type into OperationKey", KeyType(b[0]))
+	}
+	dataset, _ := dvid.LocalID32FromBytes(b[1:])
+	uuidEnd := len(b) - 8
+	return &OperationKey{
+		Dataset:  dvid.DatasetLocalID(dataset),
+		UUID:     dvid.UUID(b[1+dvid.LocalID32Size : uuidEnd]),
+		Sequence: binary.BigEndian.Uint64(b[uuidEnd:]),
+	}, nil
+}
+
+func (k OperationKey) Bytes() (b []byte) {
+	b = []byte{byte(KeyOperation)}
+	b = append(b, dvid.LocalID32(k.Dataset).Bytes()...)
+	b = append(b, []byte(k.UUID)...)
+	seq := make([]byte, 8)
+	binary.BigEndian.PutUint64(seq, k.Sequence)
+	return append(b, seq...)
+}
+
+func (k OperationKey) BytesString() string {
+	return string(k.Bytes())
+}
+
+func (k OperationKey) String() string {
+	return fmt.Sprintf("%x", k.Bytes())
+}
+
 /*
 	DataKey holds DVID-centric data like shortened version/UUID, data set, and
 	index identifiers and that follow a convention of how to collapse those
@@ -159,6 +288,34 @@ func (d *Data) DataKey(versionID dvid.VersionLocalID, index dvid.Index) *DataKey
 	return &DataKey{d.DsetID, d.ID, versionID, index}
 }
 
+// maxIndexBytes exceeds the size of any datatype-specific index, so an Index of that
+// many 0xFF bytes follows every valid Index under the same data and version.
+const maxIndexBytes = 256
+
+// maxIndex returns an Index that follows every valid Index.
+func maxIndex() dvid.IndexBytes {
+	index := make(dvid.IndexBytes, maxIndexBytes)
+	for i := range index {
+		index[i] = 0xFF
+	}
+	return index
+}
+
+// VersionKeyRange returns the first and last possible keys for this data at a given
+// version.  They are useful for range operations over all of a version's indices.
+func (d *Data) VersionKeyRange(versionID dvid.VersionLocalID) (first, last *DataKey) {
+	first = &DataKey{d.DsetID, d.ID, versionID, dvid.IndexBytes{}}
+	last = &DataKey{d.DsetID, d.ID, versionID, maxIndex()}
+	return
+}
+
+// KeyRange returns the first and last possible keys for this data across all versions.
+func (d *Data) KeyRange() (first, last *DataKey) {
+	first, _ = d.VersionKeyRange(0)
+	_, last = d.VersionKeyRange(dvid.MaxLocalID)
+	return
+}
+
 // KeyToPointIndexer takes a Key and returns an implementation of a PointIndexer if possible.
 func KeyToPointIndexer(key storage.Key) (dvid.PointIndexer, error) {
 	datakey, ok := key.(*DataKey)
@@ -0,0 +1,389 @@
+/*
+	This file supports merging version nodes.  A merged node has two or more locked
+	parents.  Since reads at the merged node follow its first parent, values changed in
+	the other parents since their nearest common ancestor are written into the merged
+	node.  An index changed to different values in more than one parent is a conflict,
+	which is resolved according to a MergeStrategy.
+*/
+
+package datastore
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+// MergeType gives the way conflicts are resolved when merging nodes.
+type MergeType uint8
+
+const (
+	// Conflicts abort the merge.
+	MergeFail MergeType = iota
+
+	// Conflicts take the value of a preferred parent.
+	MergePrefer
+
+	// Conflicts are resolved by data implementing Merger.
+	MergeHook
+)
+
+// MergeStrategy determines how conflicts are resolved when merging nodes.
+type MergeStrategy struct {
+	Type MergeType
+
+	// Preferred is the position of the preferred parent, starting at 0, for MergePrefer.
+	Preferred int
+}
+
+// ParseMergeStrategy returns a MergeStrategy given "fail", "hook", or "prefer-<N>"
+// where N is the position of the preferred parent starting at 0.  An empty string
+// is the same as "fail".
+func ParseMergeStrategy(s string) (strategy MergeStrategy, err error) {
+	switch {
+	case s == "" || s == "fail":
+		strategy.Type = MergeFail
+	case s == "hook":
+		strategy.Type = MergeHook
+	case strings.HasPrefix(s, "prefer-"):
+		strategy.Type = MergePrefer
+		strategy.Preferred, err = strconv.Atoi(strings.TrimPrefix(s, "prefer-"))
+		if err != nil || strategy.Preferred < 0 {
+			err = fmt.Errorf("Bad preferred parent in merge strategy %q", s)
+		}
+	default:
+		err = fmt.Errorf("Unknown merge strategy %q: use fail, prefer-<N>, or hook", s)
+	}
+	return
+}
+
+func (strategy MergeStrategy) String() string {
+	switch strategy.Type {
+	case MergeFail:
+		return "fail"
+	case MergePrefer:
+		return fmt.Sprintf("prefer-%d", strategy.Preferred)
+	case MergeHook:
+		return "hook"
+	}
+	return fmt.Sprintf("unknown merge type %d", strategy.Type)
+}
+
+// Merger is implemented by data that can resolve conflicting changes to an index made
+// in different parents of a merged node.
+type Merger interface {
+	// MergeValues returns the merged value of an index given its value at the parents'
+	// nearest common ancestor and at each parent.  Values are nil if the index is not
+	// present, and a nil merged value deletes the index.  An error is returned if the
+	// changes can't be merged.
+	MergeValues(index dvid.IndexBytes, ancestor []byte, values [][]byte) ([]byte, error)
+}
+
+// MergeConflict gives an index changed to different values in more than one parent.
+type MergeConflict struct {
+	Data    dvid.DataString
+	Index   dvid.IndexBytes
+	Parents []dvid.UUID // The parents that changed the index.
+}
+
+// MergeConflictError is returned when a merge has conflicts that can't be resolved.
+type MergeConflictError struct {
+	Conflicts []MergeConflict
+}
+
+func (e *MergeConflictError) Error() string {
+	text := fmt.Sprintf("Merge has %d unresolved conflicts:", len(e.Conflicts))
+	for i, conflict := range e.Conflicts {
+		if i == maxReportedKeys {
+			text += fmt.Sprintf("\n  ... and %d more", len(e.Conflicts)-i)
+			break
+		}
+		text += fmt.Sprintf("\n  data %s, index %x changed in %v", conflict.Data,
+			[]byte(conflict.Index), conflict.Parents)
+	}
+	return text
+}
+
+// IsMergeConflictError returns true if the error is due to unresolved merge conflicts.
+func IsMergeConflictError(err error) bool {
+	_, conflict := err.(*MergeConflictError)
+	return conflict
+}
+
+// sameValue returns true if two values read from a VersionedReader are the same,
+// where nil values are not present.
+func sameValue(a, b []byte) bool {
+	return (a == nil) == (b == nil) && bytes.Equal(a, b)
+}
+
+// ancestors returns the set of the given node and all its ancestors.
+func (dag *VersionDAG) ancestors(u dvid.UUID) map[dvid.UUID]bool {
+	set := make(map[dvid.UUID]bool)
+	stack := []dvid.UUID{u}
+	for len(stack) > 0 {
+		u, stack = stack[len(stack)-1], stack[:len(stack)-1]
+		if set[u] {
+			continue
+		}
+		set[u] = true
+		if node, found := dag.Nodes[u]; found {
+			stack = append(stack, node.Parents...)
+		}
+	}
+	return set
+}
+
+// commonAncestor returns the nearest node to the first given node that is, or is an
+// ancestor of, all the given nodes.
+func (dag *VersionDAG) commonAncestor(nodes []dvid.UUID) (dvid.UUID, error) {
+	dag.mapLock.Lock()
+	defer dag.mapLock.Unlock()
+
+	sets := make([]map[dvid.UUID]bool, len(nodes))
+	for i, u := range nodes {
+		sets[i] = dag.ancestors(u)
+	}
+	visited := make(map[dvid.UUID]bool)
+	queue := []dvid.UUID{nodes[0]}
+	for len(queue) > 0 {
+		u := queue[0]
+		queue = queue[1:]
+		if visited[u] {
+			continue
+		}
+		visited[u] = true
+		common := true
+		for _, set := range sets[1:] {
+			if !set[u] {
+				common = false
+				break
+			}
+		}
+		if common {
+			return u, nil
+		}
+		if node, found := dag.Nodes[u]; found {
+			queue = append(queue, node.Parents...)
+		}
+	}
+	return "", fmt.Errorf("Nodes %v have no common ancestor", nodes)
+}
+
+// mergeWrite gives the value written to a merged node for an index.
+type mergeWrite struct {
+	index  dvid.IndexBytes
+	parent int    // Position of the parent holding the value or -1 if value is set.
+	value  []byte // Value from a Merger, where nil deletes the index.
+}
+
+// dataMerge holds the writes needed to merge one data instance.
+type dataMerge struct {
+	data    *DataID
+	readers []*VersionedReader // readers for each parent
+	writes  []mergeWrite
+}
+
+// mergeData determines the writes needed to merge data changed in parents since their
+// common ancestor, returning any conflicts that the strategy can't resolve.
+func (s *Service) mergeData(snapshot storage.Snapshot, ancestor dvid.UUID, parents []dvid.UUID,
+	dataservice DataService, strategy MergeStrategy) (*dataMerge, []MergeConflict, error) {
+
+	name := dataservice.DataName()
+	ancestorReader, err := s.VersionedReader(ancestor, name, snapshot)
+	if err != nil {
+		return nil, nil, err
+	}
+	merge := &dataMerge{
+		data:    ancestorReader.data,
+		readers: make([]*VersionedReader, len(parents)),
+	}
+	for i, parent := range parents {
+		if merge.readers[i], err = s.VersionedReader(parent, name, snapshot); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// Find the parents that changed each index since the common ancestor.
+	changed := make(map[string][]int)
+	indices := []string{}
+	for i, parent := range parents {
+		diffs, err := s.diffSnapshot(snapshot, ancestor, parent, name, dvid.IndexBytes{}, maxIndex())
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, diff := range diffs {
+			indexStr := string(diff.Index)
+			if _, found := changed[indexStr]; !found {
+				indices = append(indices, indexStr)
+			}
+			changed[indexStr] = append(changed[indexStr], i)
+		}
+	}
+	sort.Strings(indices)
+
+	conflicts := []MergeConflict{}
+	for _, indexStr := range indices {
+		index := dvid.IndexBytes(indexStr)
+		values := make([][]byte, len(parents))
+		for i, reader := range merge.readers {
+			if values[i], err = reader.Get(index); err != nil {
+				return nil, nil, err
+			}
+		}
+
+		// Changes agree if all parents changing the index have the same value.
+		changers := changed[indexStr]
+		write := mergeWrite{index: index, parent: changers[0]}
+		agree := true
+		for _, i := range changers[1:] {
+			if !sameValue(values[i], values[write.parent]) {
+				agree = false
+			}
+		}
+		if !agree {
+			resolved := false
+			switch strategy.Type {
+			case MergePrefer:
+				write.parent = strategy.Preferred
+				resolved = true
+			case MergeHook:
+				if merger, ok := dataservice.(Merger); ok {
+					ancestorValue, err := ancestorReader.Get(index)
+					if err != nil {
+						return nil, nil, err
+					}
+					write.value, err = merger.MergeValues(index, ancestorValue, values)
+					if err != nil {
+						dvid.Log(dvid.Debug, "Unable to merge %s index %x: %s\n", name,
+							[]byte(index), err.Error())
+					} else {
+						write.parent = -1
+						resolved = true
+					}
+				}
+			}
+			if !resolved {
+				conflict := MergeConflict{Data: name, Index: index}
+				for _, changer := range changers {
+					conflict.Parents = append(conflict.Parents, parents[changer])
+				}
+				conflicts = append(conflicts, conflict)
+				continue
+			}
+		}
+
+		// The first parent's values are read through the merged node.
+		value := write.value
+		if write.parent >= 0 {
+			value = values[write.parent]
+		}
+		if !sameValue(value, values[0]) {
+			merge.writes = append(merge.writes, write)
+		}
+	}
+	return merge, conflicts, nil
+}
+
+// write stores the merged values at the merged node's version.
+func (merge *dataMerge) write(db storage.KeyValueSetter, versionID dvid.VersionLocalID) error {
+	for _, write := range merge.writes {
+		value := write.value
+		if write.parent >= 0 {
+			var err error
+			if value, err = merge.readers[write.parent].Get(write.index); err != nil {
+				return err
+			}
+		}
+		if value == nil {
+			value = tombstone
+		}
+		key := &DataKey{merge.data.DsetID, merge.data.ID, versionID, write.index}
+		if err := db.Put(key, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Merge creates a child of the given locked parents that combines the changes made in
+// each parent since their nearest common ancestor.  Indices changed to different
+// values in more than one parent are resolved using the given strategy.  If conflicts
+// remain, no node is created and a *MergeConflictError is returned.
+func (s *Service) Merge(parents []dvid.UUID, strategy MergeStrategy) (u dvid.UUID, err error) {
+	if s.datasets == nil {
+		err = fmt.Errorf("Datastore service has no datasets available")
+		return
+	}
+	if len(parents) < 2 {
+		err = fmt.Errorf("Merge requires at least two parent nodes")
+		return
+	}
+	if strategy.Type == MergePrefer && strategy.Preferred >= len(parents) {
+		err = fmt.Errorf("Merge of %d parents can't prefer parent %d", len(parents),
+			strategy.Preferred)
+		return
+	}
+	dataset, err := s.datasets.DatasetFromUUID(parents[0])
+	if err != nil {
+		return
+	}
+	dataset.mapLock.Lock()
+	for _, parent := range parents {
+		node, found := dataset.Nodes[parent]
+		if !found {
+			err = fmt.Errorf("Node %s is not in the same dataset as %s", parent, parents[0])
+		} else if !node.Locked {
+			err = fmt.Errorf("Cannot merge unlocked node %s", parent)
+		}
+		if err != nil {
+			break
+		}
+	}
+	dataset.mapLock.Unlock()
+	if err != nil {
+		return
+	}
+	ancestor, err := dataset.commonAncestor(parents)
+	if err != nil {
+		return
+	}
+
+	// Determine all writes before creating the merged node.
+	snapshot := storage.ReadSnapshot(s.StorageEngine())
+	defer snapshot.Close()
+	merges := []*dataMerge{}
+	conflicts := []MergeConflict{}
+	for _, dataservice := range dataset.DataMap {
+		if !dataservice.IsVersioned() {
+			continue
+		}
+		merge, dataConflicts, err := s.mergeData(snapshot, ancestor, parents, dataservice, strategy)
+		if err != nil {
+			return "", err
+		}
+		merges = append(merges, merge)
+		conflicts = append(conflicts, dataConflicts...)
+	}
+	if len(conflicts) > 0 {
+		err = &MergeConflictError{conflicts}
+		return
+	}
+
+	dataset, u, err = s.datasets.newChild(parents...)
+	if err != nil {
+		return
+	}
+	versionID := dataset.VersionMap[u]
+	for _, merge := range merges {
+		if err = merge.write(s.StorageEngine(), versionID); err != nil {
+			return
+		}
+	}
+	err = dataset.Put(s.StorageEngine())
+	return
+}
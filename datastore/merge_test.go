@@ -0,0 +1,121 @@
+package datastore
+
+import (
+	. "github.com/janelia-flyem/go/gocheck"
+
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+func (s *DataSuite) TestParseMergeStrategy(c *C) {
+	for _, text := range []string{"fail", "hook", "prefer-0", "prefer-2"} {
+		strategy, err := ParseMergeStrategy(text)
+		c.Assert(err, IsNil)
+		c.Assert(strategy.String(), Equals, text)
+	}
+	strategy, err := ParseMergeStrategy("")
+	c.Assert(err, IsNil)
+	c.Assert(strategy.Type, Equals, MergeFail)
+
+	for _, text := range []string{"prefer", "prefer-x", "prefer--1", "newest"} {
+		_, err = ParseMergeStrategy(text)
+		c.Assert(err, NotNil, Commentf("strategy %q", text))
+	}
+}
+
+func (s *DataSuite) TestMerge(c *C) {
+	service, _ := newTestService(c)
+	defer service.Shutdown()
+
+	root, datasetID, err := service.NewDataset()
+	c.Assert(err, IsNil)
+	dset, err := service.datasets.DatasetFromUUID(root)
+	c.Assert(err, IsNil)
+	id := &DataID{"mydata", 1, datasetID}
+	dset.DataMap = map[dvid.DataString]DataService{"mydata": usageData{&Data{DataID: id}}}
+
+	put := func(u dvid.UUID, index, value string) {
+		key := &DataKey{datasetID, 1, dset.VersionMap[u], dvid.IndexString(index)}
+		c.Assert(service.db.Put(key, []byte(value)), IsNil)
+	}
+	get := func(u dvid.UUID, index string) string {
+		reader, err := service.VersionedReader(u, "mydata", service.db)
+		c.Assert(err, IsNil)
+		value, err := reader.Get(dvid.IndexString(index))
+		c.Assert(err, IsNil)
+		if value == nil {
+			return "<none>"
+		}
+		return string(value)
+	}
+	branch := func(u dvid.UUID) dvid.UUID {
+		child, err := service.NewVersion(u)
+		c.Assert(err, IsNil)
+		return child
+	}
+
+	put(root, "a", "root a")
+	put(root, "b", "root b")
+	put(root, "c", "root c")
+	put(root, "e", "root e")
+	c.Assert(service.Lock(root), IsNil)
+
+	team1 := branch(root)
+	put(team1, "a", "team1 a")
+	put(team1, "c", "same c")
+	team2 := branch(root)
+	put(team2, "b", "team2 b")
+	put(team2, "c", "same c")
+	put(team2, "d", "team2 d")
+
+	// Parents must be locked.
+	_, err = service.Merge([]dvid.UUID{team1, team2}, MergeStrategy{})
+	c.Assert(err, NotNil)
+	c.Assert(service.Lock(team1), IsNil)
+	c.Assert(service.Lock(team2), IsNil)
+
+	// Changes to different indices, or to the same value, merge cleanly.
+	merged, err := service.Merge([]dvid.UUID{team1, team2}, MergeStrategy{})
+	c.Assert(err, IsNil)
+	c.Assert(get(merged, "a"), Equals, "team1 a")
+	c.Assert(get(merged, "b"), Equals, "team2 b")
+	c.Assert(get(merged, "c"), Equals, "same c")
+	c.Assert(get(merged, "d"), Equals, "team2 d")
+
+	dset, err = service.datasets.DatasetFromUUID(merged)
+	c.Assert(err, IsNil)
+	c.Assert(dset.Nodes[merged].Parents, DeepEquals, []dvid.UUID{team1, team2})
+
+	// Deletions in a later parent are carried into the merged node.
+	team3 := branch(root)
+	reader, err := service.VersionedReader(team3, "mydata", service.db)
+	c.Assert(err, IsNil)
+	c.Assert(reader.Delete(service.db, dvid.IndexString("e")), IsNil)
+	put(team3, "b", "team3 b")
+	c.Assert(service.Lock(team3), IsNil)
+
+	merged, err = service.Merge([]dvid.UUID{team1, team3}, MergeStrategy{})
+	c.Assert(err, IsNil)
+	c.Assert(get(merged, "b"), Equals, "team3 b")
+	c.Assert(get(merged, "c"), Equals, "same c")
+	c.Assert(get(merged, "e"), Equals, "<none>")
+
+	// Two parents changing an index differently conflict unless a parent is preferred.
+	_, err = service.Merge([]dvid.UUID{team2, team3}, MergeStrategy{})
+	c.Assert(IsMergeConflictError(err), Equals, true)
+	conflicts := err.(*MergeConflictError).Conflicts
+	c.Assert(conflicts, HasLen, 1)
+	c.Assert(string(conflicts[0].Index), Equals, "b")
+	c.Assert(conflicts[0].Parents, DeepEquals, []dvid.UUID{team2, team3})
+
+	// Data without a Merger can't resolve conflicts with a hook.
+	_, err = service.Merge([]dvid.UUID{team2, team3}, MergeStrategy{Type: MergeHook})
+	c.Assert(IsMergeConflictError(err), Equals, true)
+
+	_, err = service.Merge([]dvid.UUID{team2, team3}, MergeStrategy{MergePrefer, 2})
+	c.Assert(err, NotNil)
+	merged, err = service.Merge([]dvid.UUID{team2, team3}, MergeStrategy{MergePrefer, 1})
+	c.Assert(err, IsNil)
+	c.Assert(get(merged, "b"), Equals, "team3 b")
+	c.Assert(get(merged, "d"), Equals, "team2 d")
+	c.Assert(get(merged, "e"), Equals, "<none>")
+}
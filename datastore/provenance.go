@@ -0,0 +1,331 @@
+/*
+	This file supports the provenance of version nodes: the message and author given
+	when a node is locked, and the requests that modified data or refs at each node.
+	A node's log walks its ancestors, most recently created first, similar to "git log".
+
+	Operations are appended under each node's own keys rather than held in the
+	Dataset, so recording one costs a single put however long the log grows.  Requests
+	carry a WriteToken that counts the writes WritableVersion allows for that request
+	alone, so the server records only requests that modified data.
+*/
+
+package datastore
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+// Operation records a request that modified data at a node or changed a ref naming
+// the node, in which case Data is empty.
+type Operation struct {
+	Time    time.Time
+	User    string
+	Data    dvid.DataString
+	Command string
+}
+
+func (op Operation) String() string {
+	if op.Data == "" {
+		return fmt.Sprintf("%s  %s  %s", op.Time.Format(time.RFC3339), op.User, op.Command)
+	}
+	return fmt.Sprintf("%s  %s  %s: %s", op.Time.Format(time.RFC3339), op.User, op.Data,
+		op.Command)
+}
+
+// WriteToken counts the times a single request was allowed to modify data.  Each
+// request gets its own token, so concurrent requests to a node aren't credited with
+// each other's writes.  A nil token counts nothing.
+type WriteToken struct {
+	writes uint64
+}
+
+// NewWriteToken returns a token for counting the writes of a request.
+func NewWriteToken() *WriteToken {
+	return new(WriteToken)
+}
+
+// Writes returns the number of times the request was allowed to modify data.
+func (token *WriteToken) Writes() uint64 {
+	if token == nil {
+		return 0
+	}
+	return atomic.LoadUint64(&token.writes)
+}
+
+func (token *WriteToken) add() {
+	if token != nil {
+		atomic.AddUint64(&token.writes, 1)
+	}
+}
+
+type writeTokenKey struct{}
+
+// WithWriteToken returns a copy of an HTTP request carrying a token, so data types
+// handling the request can pass it to WritableVersion.
+func WithWriteToken(r *http.Request, token *WriteToken) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), writeTokenKey{}, token))
+}
+
+// HTTPWriteToken returns the token carried by an HTTP request or nil if it has none.
+func HTTPWriteToken(r *http.Request) *WriteToken {
+	token, _ := r.Context().Value(writeTokenKey{}).(*WriteToken)
+	return token
+}
+
+// operationRange returns the first and last possible keys of the operations of a node.
+func operationRange(datasetID dvid.DatasetLocalID, u dvid.UUID) (first, last *OperationKey) {
+	first = &OperationKey{datasetID, u, 0}
+	last = &OperationKey{datasetID, u, ^uint64(0)}
+	return
+}
+
+// putOperation appends an operation to the provenance of a node.  Each operation is
+// stored under its own key, so recording one doesn't rewrite the dataset.
+func (s *Service) putOperation(dataset *Dataset, node *Node, op Operation) error {
+	node.writeLock.Lock()
+	defer node.writeLock.Unlock()
+	if !node.operationsCounted {
+		first, last := operationRange(dataset.DatasetID, node.GlobalID)
+		keys, err := s.StorageEngine().KeysInRange(first, last)
+		if err != nil {
+			return err
+		}
+		if len(keys) > 0 {
+			key, ok := keys[len(keys)-1].(*OperationKey)
+			if !ok {
+				return fmt.Errorf("Bad operation key %s for node %s", keys[len(keys)-1], node.GlobalID)
+			}
+			node.numOperations = key.Sequence + 1
+		}
+		node.operationsCounted = true
+	}
+	serialization, err := dvid.Serialize(&op, dvid.Snappy, dvid.CRC32)
+	if err != nil {
+		return err
+	}
+	key := &OperationKey{dataset.DatasetID, node.GlobalID, node.numOperations}
+	if err = s.StorageEngine().Put(key, serialization); err != nil {
+		return err
+	}
+	node.numOperations++
+	node.Updated = op.Time
+	return nil
+}
+
+// getOperations returns the operations in the provenance of a node in the order they
+// were recorded.
+func (s *Service) getOperations(datasetID dvid.DatasetLocalID, u dvid.UUID) ([]Operation, error) {
+	first, last := operationRange(datasetID, u)
+	keyvalues, err := s.StorageEngine().GetRange(first, last)
+	if err != nil {
+		return nil, err
+	}
+	operations := make([]Operation, len(keyvalues))
+	for i, kv := range keyvalues {
+		if err = dvid.Deserialize(kv.V, &operations[i]); err != nil {
+			return nil, fmt.Errorf("Bad operation %s for node %s: %s", kv.K, u, err.Error())
+		}
+	}
+	return operations, nil
+}
+
+// NodeLog gives the provenance of a version node.
+type NodeLog struct {
+	UUID       dvid.UUID
+	Parents    []dvid.UUID
+	Locked     bool
+	Pruned     bool
+	Refs       []string // Tags and branches naming the node, e.g., "tag: v1"
+	Author     string
+	Message    string
+	Created    time.Time
+	Updated    time.Time
+	Operations []Operation
+}
+
+// nodeLog returns the provenance of a node.  The caller must hold the dag's mapLock.
+func (dag *VersionDAG) nodeLog(node *Node) NodeLog {
+	node.writeLock.Lock()
+	defer node.writeLock.Unlock()
+	log := NodeLog{
+		UUID:    node.GlobalID,
+		Parents: append([]dvid.UUID{}, node.Parents...),
+		Locked:  node.Locked,
+		Pruned:  node.Pruned,
+		Refs:    dag.refNames(node.GlobalID),
+		Created: node.Created,
+		Updated: node.Updated,
+	}
+	if node.NodeText != nil {
+		log.Author = node.Author
+		log.Message = node.Note
+	}
+	return log
+}
+
+// nodeLogs returns the provenance of a node and all its ancestors, most recently
+// created first.
+func (dag *VersionDAG) nodeLogs(u dvid.UUID) ([]NodeLog, error) {
+	dag.mapLock.Lock()
+	defer dag.mapLock.Unlock()
+
+	if _, found := dag.Nodes[u]; !found {
+		return nil, fmt.Errorf("No node found with UUID %s", u)
+	}
+	logs := []NodeLog{}
+	for ancestor := range dag.ancestors(u) {
+		if node, found := dag.Nodes[ancestor]; found {
+			logs = append(logs, dag.nodeLog(node))
+		}
+	}
+	sort.Sort(nodeLogsByCreation(logs))
+	return logs, nil
+}
+
+type nodeLogsByCreation []NodeLog
+
+func (logs nodeLogsByCreation) Len() int      { return len(logs) }
+func (logs nodeLogsByCreation) Swap(i, j int) { logs[i], logs[j] = logs[j], logs[i] }
+func (logs nodeLogsByCreation) Less(i, j int) bool {
+	return logs[i].Created.After(logs[j].Created)
+}
+
+// LockWithMessage locks the node with the given UUID, recording a message describing
+// the changes made at the node and its author.  Nodes that are already locked can't
+// be given a message.
+func (s *Service) LockWithMessage(u dvid.UUID, message, author string) error {
+	if s.datasets == nil {
+		return fmt.Errorf("Datastore service has no datasets available")
+	}
+	dataset, err := s.datasets.DatasetFromUUID(u)
+	if err != nil {
+		return err
+	}
+	dataset.mapLock.Lock()
+	node, found := dataset.Nodes[u]
+	dataset.mapLock.Unlock()
+	if !found {
+		return fmt.Errorf("No node found with UUID %s", u)
+	}
+	if node.Locked && (message != "" || author != "") {
+		return fmt.Errorf("Node %s is already locked", u)
+	}
+	if err = dataset.Lock(u); err != nil {
+		return err
+	}
+	node.writeLock.Lock()
+	if node.NodeText == nil {
+		node.NodeText = new(NodeText)
+	}
+	if message != "" {
+		node.Note = message
+	}
+	if author != "" {
+		node.Author = author
+	}
+	node.Updated = time.Now()
+	node.writeLock.Unlock()
+	return dataset.Put(s.StorageEngine())
+}
+
+// RecordOperation adds an operation that modified data to the provenance of a node.
+func (s *Service) RecordOperation(u dvid.UUID, op Operation) error {
+	if s.datasets == nil {
+		return fmt.Errorf("Datastore service has no datasets available")
+	}
+	dataset, err := s.datasets.DatasetFromUUID(u)
+	if err != nil {
+		return err
+	}
+	dataset.mapLock.Lock()
+	node, found := dataset.Nodes[u]
+	dataset.mapLock.Unlock()
+	if !found {
+		return fmt.Errorf("No node found with UUID %s", u)
+	}
+	return s.putOperation(dataset, node, op)
+}
+
+// NodeLogs returns the provenance of a node and all its ancestors, most recently
+// created first.
+func (s *Service) NodeLogs(u dvid.UUID) ([]NodeLog, error) {
+	if s.datasets == nil {
+		return nil, fmt.Errorf("Datastore service has no datasets available")
+	}
+	dataset, err := s.datasets.DatasetFromUUID(u)
+	if err != nil {
+		return nil, err
+	}
+	logs, err := dataset.nodeLogs(u)
+	if err != nil {
+		return nil, err
+	}
+	for i := range logs {
+		logs[i].Operations, err = s.getOperations(dataset.DatasetID, logs[i].UUID)
+		if err != nil {
+			return nil, err
+		}
+		if n := len(logs[i].Operations); n > 0 && logs[i].Operations[n-1].Time.After(logs[i].Updated) {
+			logs[i].Updated = logs[i].Operations[n-1].Time
+		}
+	}
+	return logs, nil
+}
+
+// NodeLogText returns the provenance of a node and all its ancestors formatted like
+// "git log".
+func (s *Service) NodeLogText(u dvid.UUID) (string, error) {
+	logs, err := s.NodeLogs(u)
+	if err != nil {
+		return "", err
+	}
+	var text string
+	for _, log := range logs {
+		text += fmt.Sprintf("node %s", log.UUID)
+		decorations := []string{}
+		switch {
+		case log.Pruned:
+			decorations = append(decorations, "pruned")
+		case !log.Locked:
+			decorations = append(decorations, "unlocked")
+		}
+		decorations = append(decorations, log.Refs...)
+		if len(decorations) > 0 {
+			text += fmt.Sprintf(" (%s)", strings.Join(decorations, ", "))
+		}
+		text += "\n"
+		if len(log.Parents) > 1 {
+			parents := make([]string, len(log.Parents))
+			for i, parent := range log.Parents {
+				parents[i] = string(parent)
+			}
+			text += fmt.Sprintf("Merge:  %s\n", strings.Join(parents, " "))
+		}
+		if log.Author != "" {
+			text += fmt.Sprintf("Author: %s\n", log.Author)
+		}
+		text += fmt.Sprintf("Date:   %s\n", log.Updated.Format(time.RFC1123Z))
+		if log.Message != "" {
+			text += "\n"
+			for _, line := range strings.Split(log.Message, "\n") {
+				text += fmt.Sprintf("    %s\n", line)
+			}
+		}
+		if len(log.Operations) > 0 {
+			text += "\n"
+			for _, op := range log.Operations {
+				text += fmt.Sprintf("    %s\n", op)
+			}
+		}
+		text += "\n"
+	}
+	return text, nil
+}
@@ -0,0 +1,71 @@
+package datastore
+
+import (
+	"strings"
+	"time"
+
+	. "github.com/janelia-flyem/go/gocheck"
+
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+func (s *DataSuite) TestNodeProvenance(c *C) {
+	service, dir := newTestService(c)
+
+	root, _, err := service.NewDataset()
+	c.Assert(err, IsNil)
+	c.Assert(service.LockWithMessage(root, "Initial grayscale", "alice"), IsNil)
+	c.Assert(service.LockWithMessage(root, "Another message", ""), NotNil)
+
+	child, err := service.NewVersion(root)
+	c.Assert(err, IsNil)
+
+	// Writes are only counted for the request allowed to make them.
+	token, other := NewWriteToken(), NewWriteToken()
+	_, _, err = service.WritableVersion(child, token)
+	c.Assert(err, IsNil)
+	c.Assert(token.Writes(), Equals, uint64(1))
+	c.Assert(other.Writes(), Equals, uint64(0))
+	_, _, err = service.WritableVersion(root, other)
+	c.Assert(err, NotNil)
+	c.Assert(other.Writes(), Equals, uint64(0))
+
+	op := Operation{Time: time.Now(), User: "bob", Data: "bodies", Command: "apply labels"}
+	c.Assert(service.RecordOperation(child, op), IsNil)
+	c.Assert(service.LockWithMessage(child, "Split body 17\nMerged bodies 3 and 5", "bob"), IsNil)
+
+	logs, err := service.NodeLogs(child)
+	c.Assert(err, IsNil)
+	c.Assert(logs, HasLen, 2)
+	c.Assert(logs[0].UUID, Equals, child)
+	c.Assert(logs[0].Parents, DeepEquals, []dvid.UUID{root})
+	c.Assert(logs[0].Author, Equals, "bob")
+	c.Assert(logs[0].Operations, HasLen, 1)
+	c.Assert(logs[0].Operations[0].Command, Equals, "apply labels")
+	c.Assert(logs[1].UUID, Equals, root)
+	c.Assert(logs[1].Message, Equals, "Initial grayscale")
+	c.Assert(logs[1].Operations, HasLen, 0)
+
+	text, err := service.NodeLogText(child)
+	c.Assert(err, IsNil)
+	c.Assert(strings.Index(text, string(child)) < strings.Index(text, string(root)), Equals, true)
+	c.Assert(strings.Contains(text, "Author: bob"), Equals, true)
+	c.Assert(strings.Contains(text, "    Merged bodies 3 and 5\n"), Equals, true)
+	c.Assert(strings.Contains(text, "bob  bodies: apply labels"), Equals, true)
+
+	_, err = service.NodeLogs(dvid.UUID("unknown"))
+	c.Assert(err, NotNil)
+
+	// Operations are appended after those stored before the datastore was reopened.
+	service.Shutdown()
+	service, err = Open(dir)
+	c.Assert(err, IsNil)
+	defer service.Shutdown()
+	op.Command = "split body 17"
+	c.Assert(service.RecordOperation(child, op), IsNil)
+	logs, err = service.NodeLogs(child)
+	c.Assert(err, IsNil)
+	c.Assert(logs[0].Operations, HasLen, 2)
+	c.Assert(logs[0].Operations[0].Command, Equals, "apply labels")
+	c.Assert(logs[0].Operations[1].Command, Equals, "split body 17")
+}
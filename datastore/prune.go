@@ -0,0 +1,343 @@
+/*
+	This file supports pruning and archiving of version nodes so long version histories
+	don't exhaust disk space or the VersionLocalIDs of a dataset.
+
+		flatten   Copies values a node reads from its ancestors into the node, so its
+		          data is DataComplete and no longer depends on its ancestors.
+		archive   Removes values a node shares with its first parent, leaving a DataDelta
+		          against the parent.
+		prune     Deletes a node's key-value pairs for all data, marks it DataDeleted and
+		          releases its VersionLocalID for reuse.  Children that read through the
+		          node must be flattened first.
+*/
+
+package datastore
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+// versionRange returns the first and last possible keys of data at a version.
+func versionRange(data *DataID, versionID dvid.VersionLocalID) (first, last *DataKey) {
+	first = &DataKey{data.DsetID, data.ID, versionID, dvid.IndexBytes{}}
+	last = &DataKey{data.DsetID, data.ID, versionID, maxIndex()}
+	return
+}
+
+// versionIndices returns the indices of data stored at a version and the subset of
+// those indices whose values are tombstones.
+func versionIndices(snapshot storage.Snapshot, data *DataID, versionID dvid.VersionLocalID) (
+	indices []string, tombstones map[string]bool, err error) {
+
+	first, last := versionRange(data, versionID)
+	it, err := snapshot.NewIterator(first, last, nil)
+	if err != nil {
+		return
+	}
+	defer it.Close()
+	tombstones = make(map[string]bool)
+	for ; it.Valid(); it.Next() {
+		var key storage.Key
+		if key, err = it.Key(); err != nil {
+			return
+		}
+		kBytes := key.Bytes()
+		if len(kBytes) < DataKeyIndexOffset {
+			err = fmt.Errorf("Malformed data key %x", kBytes)
+			return
+		}
+		indexStr := string(kBytes[DataKeyIndexOffset:])
+		indices = append(indices, indexStr)
+		if IsTombstone(it.Value()) {
+			tombstones[indexStr] = true
+		}
+	}
+	err = it.Error()
+	return
+}
+
+// unsharedIndices returns the sorted indices of data stored at the given versions that
+// are not in the given set of indices.
+func unsharedIndices(snapshot storage.Snapshot, data *DataID, versions []dvid.VersionLocalID,
+	exclude map[string]bool) ([]string, error) {
+
+	found := make(map[string]bool)
+	indices := []string{}
+	for _, versionID := range versions {
+		versionIndices, _, err := versionIndices(snapshot, data, versionID)
+		if err != nil {
+			return nil, err
+		}
+		for _, indexStr := range versionIndices {
+			if !exclude[indexStr] && !found[indexStr] {
+				found[indexStr] = true
+				indices = append(indices, indexStr)
+			}
+		}
+	}
+	sort.Strings(indices)
+	return indices, nil
+}
+
+// nodeForUpdate returns the dataset and node with the given UUID, which must not be
+// pruned.
+func (s *Service) nodeForUpdate(u dvid.UUID) (*Dataset, *Node, error) {
+	if s.datasets == nil {
+		return nil, nil, fmt.Errorf("Datastore service has no datasets available")
+	}
+	dataset, err := s.datasets.DatasetFromUUID(u)
+	if err != nil {
+		return nil, nil, err
+	}
+	dataset.mapLock.Lock()
+	node, found := dataset.Nodes[u]
+	dataset.mapLock.Unlock()
+	if !found {
+		return nil, nil, fmt.Errorf("No node found with UUID %s", u)
+	}
+	if node.Pruned {
+		return nil, nil, fmt.Errorf("Node %s has been pruned", u)
+	}
+	return dataset, node, nil
+}
+
+// flattenData copies values data reads from ancestors of a node into the node.
+// Tombstones in the node are no longer needed and are removed.
+func (s *Service) flattenData(u dvid.UUID, name dvid.DataString) error {
+	snapshot := storage.ReadSnapshot(s.StorageEngine())
+	defer snapshot.Close()
+
+	reader, err := s.VersionedReader(u, name, snapshot)
+	if err != nil {
+		return err
+	}
+	own, tombstones, err := versionIndices(snapshot, reader.data, reader.version)
+	if err != nil {
+		return err
+	}
+	exclude := make(map[string]bool, len(own))
+	for _, indexStr := range own {
+		exclude[indexStr] = true
+	}
+	indices, err := unsharedIndices(snapshot, reader.data, reader.versions[1:], exclude)
+	if err != nil {
+		return err
+	}
+	for _, indexStr := range indices {
+		index := dvid.IndexBytes(indexStr)
+		value, err := reader.Get(index)
+		if err != nil {
+			return err
+		}
+		if value != nil {
+			key := &DataKey{reader.data.DsetID, reader.data.ID, reader.version, index}
+			if err = s.StorageEngine().Put(key, value); err != nil {
+				return err
+			}
+		}
+	}
+	for indexStr := range tombstones {
+		key := &DataKey{reader.data.DsetID, reader.data.ID, reader.version, dvid.IndexBytes(indexStr)}
+		if err = s.StorageEngine().Delete(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Flatten copies all values a node reads from its ancestors into the node, so the
+// node's data no longer depends on its ancestors.  Reads at the node are unchanged.
+// Flattening should not be done while data at the node is being modified.
+func (s *Service) Flatten(u dvid.UUID) error {
+	dataset, node, err := s.nodeForUpdate(u)
+	if err != nil {
+		return err
+	}
+	for name, dataservice := range dataset.DataMap {
+		if node.avail(dataservice) != DataDelta {
+			continue
+		}
+		if err = s.flattenData(u, name); err != nil {
+			return err
+		}
+		node.setAvail(name, DataComplete)
+	}
+	return dataset.Put(s.StorageEngine())
+}
+
+// archiveData removes values of data at a node that are the same as those read at
+// the node's parent.  If the node's data is complete, tombstones are added for values
+// read at the parent that are not in the node.
+func (s *Service) archiveData(u, parent dvid.UUID, name dvid.DataString, complete bool) error {
+	snapshot := storage.ReadSnapshot(s.StorageEngine())
+	defer snapshot.Close()
+
+	reader, err := s.VersionedReader(u, name, snapshot)
+	if err != nil {
+		return err
+	}
+	parentReader, err := s.VersionedReader(parent, name, snapshot)
+	if err != nil {
+		return err
+	}
+	own, _, err := versionIndices(snapshot, reader.data, reader.version)
+	if err != nil {
+		return err
+	}
+	exclude := make(map[string]bool, len(own))
+	for _, indexStr := range own {
+		exclude[indexStr] = true
+		index := dvid.IndexBytes(indexStr)
+		key := &DataKey{reader.data.DsetID, reader.data.ID, reader.version, index}
+		value, err := snapshot.Get(key)
+		if err != nil {
+			return err
+		}
+		parentValue, err := parentReader.Get(index)
+		if err != nil {
+			return err
+		}
+		if IsTombstone(value) && parentValue == nil ||
+			parentValue != nil && bytes.Equal(value, parentValue) {
+			if err = s.StorageEngine().Delete(key); err != nil {
+				return err
+			}
+		}
+	}
+	if !complete {
+		return nil
+	}
+
+	// Values read at the parent must be hidden if the node doesn't have them.
+	indices, err := unsharedIndices(snapshot, reader.data, parentReader.versions, exclude)
+	if err != nil {
+		return err
+	}
+	for _, indexStr := range indices {
+		index := dvid.IndexBytes(indexStr)
+		value, err := parentReader.Get(index)
+		if err != nil {
+			return err
+		}
+		if value != nil {
+			key := &DataKey{reader.data.DsetID, reader.data.ID, reader.version, index}
+			if err = s.StorageEngine().Put(key, tombstone); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Archive collapses the versioned data of a locked node into a delta against its
+// first parent, removing values that are the same as those read at the parent.
+// Reads at the node are unchanged.
+func (s *Service) Archive(u dvid.UUID) error {
+	dataset, node, err := s.nodeForUpdate(u)
+	if err != nil {
+		return err
+	}
+	if !node.Locked {
+		return fmt.Errorf("Cannot archive unlocked node %s", u)
+	}
+	if len(node.Parents) == 0 {
+		return fmt.Errorf("Cannot archive node %s without a parent", u)
+	}
+	parent := node.Parents[0]
+	if _, _, err = s.nodeForUpdate(parent); err != nil {
+		return err
+	}
+	for name, dataservice := range dataset.DataMap {
+		if !dataservice.IsVersioned() {
+			continue
+		}
+		avail := node.avail(dataservice)
+		if avail != DataComplete && avail != DataDelta {
+			continue
+		}
+		if err = s.archiveData(u, parent, name, avail == DataComplete); err != nil {
+			return err
+		}
+		node.setAvail(name, DataDelta)
+	}
+	return dataset.Put(s.StorageEngine())
+}
+
+// Prune deletes the key-value pairs of all data at a node, marks the node deleted,
+// and makes its VersionLocalID available for reuse.  Will return an error if the node
+// is the root, is named by a tag or branch, or has children that read data through
+// it, which must be flattened first.  The number of key-value pairs deleted is returned.
+func (s *Service) Prune(u dvid.UUID) (deleted uint64, err error) {
+	dataset, node, err := s.nodeForUpdate(u)
+	if err != nil {
+		return
+	}
+	if u == dataset.Root {
+		err = fmt.Errorf("Cannot prune the root node %s", u)
+		return
+	}
+	dataset.mapLock.Lock()
+	refs := dataset.refNames(u)
+	dataset.mapLock.Unlock()
+	if len(refs) > 0 {
+		err = fmt.Errorf("Node %s is named by %s.  Delete its refs before pruning.",
+			u, strings.Join(refs, ", "))
+		return
+	}
+	dataset.mapLock.Lock()
+	for _, childUUID := range node.Children {
+		child, found := dataset.Nodes[childUUID]
+		if !found || child.Pruned || child.Parents[0] != u {
+			continue
+		}
+		for name, dataservice := range dataset.DataMap {
+			if child.avail(dataservice) == DataDelta {
+				err = fmt.Errorf("Child %s reads data '%s' through node %s.  "+
+					"Flatten the child before pruning.", childUUID, name, u)
+				break
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+	if err == nil {
+		node.writeLock.Lock()
+		node.Locked = true
+		node.Pruned = true
+		node.writeLock.Unlock()
+		delete(dataset.VersionMap, u)
+	}
+	dataset.mapLock.Unlock()
+	if err != nil {
+		return
+	}
+	if err = dataset.Put(s.StorageEngine()); err != nil {
+		return
+	}
+
+	// The version is only reused once its key-value pairs are deleted.
+	for _, dataservice := range dataset.DataMap {
+		data := &DataID{dataservice.DataName(), dataservice.LocalID(), dataset.DatasetID}
+		first, last := versionRange(data, node.VersionID)
+		err = deleteRange(s.StorageEngine(), first.Bytes(), last.Bytes(), func(n int) error {
+			deleted += uint64(n)
+			return nil
+		})
+		if err != nil {
+			return
+		}
+	}
+	dataset.mapLock.Lock()
+	dataset.FreeVersionIDs = append(dataset.FreeVersionIDs, node.VersionID)
+	dataset.mapLock.Unlock()
+	dvid.Log(dvid.Normal, "Pruned node %s, deleting %d key-value pairs\n", u, deleted)
+	err = dataset.Put(s.StorageEngine())
+	return
+}
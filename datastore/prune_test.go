@@ -0,0 +1,113 @@
+package datastore
+
+import (
+	. "github.com/janelia-flyem/go/gocheck"
+
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+func (s *DataSuite) TestPruneAndArchive(c *C) {
+	service, _ := newTestService(c)
+	defer service.Shutdown()
+
+	root, datasetID, err := service.NewDataset()
+	c.Assert(err, IsNil)
+	dset, err := service.datasets.DatasetFromUUID(root)
+	c.Assert(err, IsNil)
+	data := usageData{&Data{DataID: &DataID{"mydata", 1, datasetID}}}
+	dset.DataMap = map[dvid.DataString]DataService{"mydata": data}
+
+	put := func(u dvid.UUID, index, value string) {
+		key := &DataKey{datasetID, 1, dset.VersionMap[u], dvid.IndexString(index)}
+		c.Assert(service.db.Put(key, []byte(value)), IsNil)
+	}
+	del := func(u dvid.UUID, index string) {
+		reader, err := service.VersionedReader(u, "mydata", service.db)
+		c.Assert(err, IsNil)
+		c.Assert(reader.Delete(service.db, dvid.IndexString(index)), IsNil)
+	}
+	// contents returns all values read at a node.
+	contents := func(u dvid.UUID) map[string]string {
+		reader, err := service.VersionedReader(u, "mydata", service.db)
+		c.Assert(err, IsNil)
+		values, err := reader.GetRange(dvid.IndexBytes{}, maxIndex())
+		c.Assert(err, IsNil)
+		m := make(map[string]string)
+		for _, kv := range values {
+			m[string(kv.K.(*DataKey).Index.Bytes())] = string(kv.V)
+		}
+		return m
+	}
+	// ownKeys returns the number of key-value pairs stored at a node.
+	ownKeys := func(u dvid.UUID) int {
+		first, last := data.VersionKeyRange(dset.VersionMap[u])
+		keys, err := service.db.KeysInRange(first, last)
+		c.Assert(err, IsNil)
+		return len(keys)
+	}
+	branch := func(u dvid.UUID) dvid.UUID {
+		child, err := service.NewVersion(u)
+		c.Assert(err, IsNil)
+		return child
+	}
+
+	put(root, "a", "root a")
+	put(root, "b", "root b")
+	put(root, "c", "root c")
+	c.Assert(service.Lock(root), IsNil)
+	rootContents := contents(root)
+
+	child := branch(root)
+	put(child, "b", "child b")
+	del(child, "c")
+	c.Assert(service.Lock(child), IsNil)
+	grandchild := branch(child)
+	put(grandchild, "d", "grandchild d")
+	expected := map[string]string{"a": "root a", "b": "child b", "d": "grandchild d"}
+	c.Assert(contents(grandchild), DeepEquals, expected)
+
+	// Nodes can't be pruned while children read through them.
+	_, err = service.Prune(root)
+	c.Assert(err, NotNil)
+	_, err = service.Prune(child)
+	c.Assert(err, NotNil)
+
+	c.Assert(service.Flatten(grandchild), IsNil)
+	c.Assert(contents(grandchild), DeepEquals, expected)
+	c.Assert(ownKeys(grandchild), Equals, 3)
+
+	childVersion := dset.VersionMap[child]
+	deleted, err := service.Prune(child)
+	c.Assert(err, IsNil)
+	c.Assert(deleted, Equals, uint64(2))
+	c.Assert(contents(grandchild), DeepEquals, expected)
+	c.Assert(contents(root), DeepEquals, rootContents)
+
+	// Pruned nodes can't be modified or branched, and their version ID is reused.
+	_, _, err = service.WritableVersion(child, nil)
+	c.Assert(err, NotNil)
+	_, err = service.NewVersion(child)
+	c.Assert(err, NotNil)
+	_, err = service.Prune(child)
+	c.Assert(err, NotNil)
+	c.Assert(dset.FreeVersionIDs, DeepEquals, []dvid.VersionLocalID{childVersion})
+	reused := branch(root)
+	c.Assert(dset.VersionMap[reused], Equals, childVersion)
+	c.Assert(contents(reused), DeepEquals, rootContents)
+	c.Assert(dset.FreeVersionIDs, HasLen, 0)
+
+	// Archived nodes only hold changes from their parent.
+	c.Assert(service.Archive(grandchild), NotNil) // parent is pruned
+
+	changed := branch(root)
+	del(changed, "a")
+	put(changed, "e", "changed e")
+	c.Assert(service.Flatten(changed), IsNil)
+	c.Assert(ownKeys(changed), Equals, 3)
+	c.Assert(service.Archive(changed), NotNil) // not locked
+	c.Assert(service.Lock(changed), IsNil)
+	c.Assert(service.Archive(changed), IsNil)
+	c.Assert(ownKeys(changed), Equals, 2)
+	c.Assert(contents(changed), DeepEquals,
+		map[string]string{"b": "root b", "c": "root c", "e": "changed e"})
+}
@@ -0,0 +1,272 @@
+/*
+	This file supports named refs for version nodes within a dataset.  A tag names a
+	locked node and never moves.  A branch names the head of a line of development and
+	can be moved to other nodes as development proceeds.  Ref names can be used wherever
+	a UUID string is accepted, so they must include a character that is not hexadecimal
+	to avoid confusion with partial UUIDs.
+*/
+
+package datastore
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+// RefType distinguishes tags from branches.
+type RefType uint8
+
+const (
+	// A tag names a locked node and cannot be moved.
+	RefTag RefType = iota
+
+	// A branch names the head of a line of development and can be moved.
+	RefBranch
+)
+
+// ParseRefType returns a RefType given "tag" or "branch".
+func ParseRefType(s string) (RefType, error) {
+	switch s {
+	case "tag":
+		return RefTag, nil
+	case "branch":
+		return RefBranch, nil
+	}
+	return RefTag, fmt.Errorf("Unknown ref type %q: use tag or branch", s)
+}
+
+func (t RefType) String() string {
+	switch t {
+	case RefTag:
+		return "tag"
+	case RefBranch:
+		return "branch"
+	}
+	return fmt.Sprintf("unknown ref type %d", t)
+}
+
+// MarshalJSON returns the name of the ref type.
+func (t RefType) MarshalJSON() ([]byte, error) {
+	return json.Marshal(t.String())
+}
+
+// Ref is a name for a version node within a dataset.
+type Ref struct {
+	Name    string
+	Type    RefType
+	UUID    dvid.UUID
+	Updated time.Time
+}
+
+func (ref Ref) String() string {
+	return fmt.Sprintf("%s: %s", ref.Type, ref.Name)
+}
+
+// validRefName returns an error if a name can't be used for a ref.
+func validRefName(name string) error {
+	if name == "" {
+		return fmt.Errorf("Ref names cannot be empty")
+	}
+	hex := true
+	for _, r := range name {
+		switch {
+		case '0' <= r && r <= '9', 'a' <= r && r <= 'f', 'A' <= r && r <= 'F':
+		case 'g' <= r && r <= 'z', 'G' <= r && r <= 'Z', r == '-', r == '_', r == '.':
+			hex = false
+		default:
+			return fmt.Errorf("Ref name %q can only use letters, digits, '-', '_' and '.'", name)
+		}
+	}
+	if hex {
+		return fmt.Errorf("Ref name %q could be a partial UUID: use a non-hexadecimal letter", name)
+	}
+	return nil
+}
+
+// refNames returns the refs naming a node, e.g., "tag: v1", in sorted order.  The
+// caller must hold the dag's mapLock.
+func (dag *VersionDAG) refNames(u dvid.UUID) []string {
+	names := []string{}
+	for _, ref := range dag.Refs {
+		if ref.UUID == u {
+			names = append(names, ref.String())
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// refTarget returns the node a ref can name, checking that it is in the dag and not
+// pruned, and that tagged nodes are locked.  The caller must hold the dag's mapLock.
+func (dag *VersionDAG) refTarget(u dvid.UUID, refType RefType) (*Node, error) {
+	node, found := dag.Nodes[u]
+	if !found {
+		return nil, fmt.Errorf("No node found with UUID %s", u)
+	}
+	if node.Pruned {
+		return nil, fmt.Errorf("Node %s has been pruned", u)
+	}
+	if refType == RefTag && !node.Locked {
+		return nil, fmt.Errorf("Cannot tag unlocked node %s", u)
+	}
+	return node, nil
+}
+
+// putRefOperation stores a dataset after a change to its refs and records the change
+// in the provenance of the given node, if any.
+func (s *Service) putRefOperation(dataset *Dataset, node *Node, user, command string) error {
+	if err := dataset.Put(s.StorageEngine()); err != nil {
+		return err
+	}
+	if node == nil {
+		return nil
+	}
+	return s.putOperation(dataset, node, Operation{Time: time.Now(), User: user, Command: command})
+}
+
+// refNode returns the dataset and node named by a ref in any dataset.  A name used in
+// more than one dataset is an error.
+func (dsets *Datasets) refNode(name string) (dataset *Dataset, u dvid.UUID, err error) {
+	numMatches := 0
+	for _, dset := range dsets.list {
+		dset.mapLock.Lock()
+		ref, found := dset.Refs[name]
+		if found {
+			numMatches++
+			dataset = dset
+			u = ref.UUID
+		}
+		dset.mapLock.Unlock()
+	}
+	if numMatches > 1 {
+		err = fmt.Errorf("Ref %q names nodes in more than one dataset: use a UUID", name)
+	} else if numMatches == 0 {
+		err = fmt.Errorf("Could not find UUID or ref matching %s!", name)
+	}
+	return
+}
+
+// NewRef names the node with the given UUID by a new tag or branch in its dataset.
+// The change is recorded in the node's log as made by the given user.
+func (s *Service) NewRef(u dvid.UUID, name string, refType RefType, user string) error {
+	if err := validRefName(name); err != nil {
+		return err
+	}
+	if s.datasets == nil {
+		return fmt.Errorf("Datastore service has no datasets available")
+	}
+	dataset, err := s.datasets.DatasetFromUUID(u)
+	if err != nil {
+		return err
+	}
+	dataset.mapLock.Lock()
+	node, err := dataset.refTarget(u, refType)
+	if err == nil {
+		if ref, found := dataset.Refs[name]; found {
+			err = fmt.Errorf("Dataset %s already has %s", dataset.Root, ref)
+		}
+	}
+	if err == nil {
+		if dataset.Refs == nil {
+			dataset.Refs = make(map[string]*Ref)
+		}
+		ref := &Ref{Name: name, Type: refType, UUID: u, Updated: time.Now()}
+		dataset.Refs[name] = ref
+	}
+	dataset.mapLock.Unlock()
+	if err != nil {
+		return err
+	}
+	return s.putRefOperation(dataset, node, user, fmt.Sprintf("new %s: %s", refType, name))
+}
+
+// MoveRef moves a branch in the dataset of the given UUID to that node.  Tags cannot
+// be moved.  The change is recorded in the log of the branch's new node.
+func (s *Service) MoveRef(u dvid.UUID, name string, user string) error {
+	if s.datasets == nil {
+		return fmt.Errorf("Datastore service has no datasets available")
+	}
+	dataset, err := s.datasets.DatasetFromUUID(u)
+	if err != nil {
+		return err
+	}
+	dataset.mapLock.Lock()
+	ref, found := dataset.Refs[name]
+	var node *Node
+	var command string
+	switch {
+	case !found:
+		err = fmt.Errorf("Dataset %s has no ref %q", dataset.Root, name)
+	case ref.Type != RefBranch:
+		err = fmt.Errorf("Cannot move %s: only branches can be moved", ref)
+	default:
+		node, err = dataset.refTarget(u, ref.Type)
+	}
+	if err == nil && ref.UUID == u {
+		node = nil // The branch already names the node, so there's nothing to record.
+	} else if err == nil {
+		command = fmt.Sprintf("move %s from %s", ref, ref.UUID)
+		ref.UUID = u
+		ref.Updated = time.Now()
+	}
+	dataset.mapLock.Unlock()
+	if err != nil {
+		return err
+	}
+	return s.putRefOperation(dataset, node, user, command)
+}
+
+// DeleteRef removes a tag or branch from the dataset of the given UUID.  The change is
+// recorded in the log of the node the ref named.
+func (s *Service) DeleteRef(u dvid.UUID, name string, user string) error {
+	if s.datasets == nil {
+		return fmt.Errorf("Datastore service has no datasets available")
+	}
+	dataset, err := s.datasets.DatasetFromUUID(u)
+	if err != nil {
+		return err
+	}
+	dataset.mapLock.Lock()
+	ref, found := dataset.Refs[name]
+	var node *Node
+	if !found {
+		err = fmt.Errorf("Dataset %s has no ref %q", dataset.Root, name)
+	} else {
+		delete(dataset.Refs, name)
+		node = dataset.Nodes[ref.UUID]
+	}
+	dataset.mapLock.Unlock()
+	if err != nil {
+		return err
+	}
+	return s.putRefOperation(dataset, node, user, fmt.Sprintf("delete %s", ref))
+}
+
+// Refs returns the tags and branches of the dataset with the given UUID, sorted by name.
+func (s *Service) Refs(u dvid.UUID) ([]Ref, error) {
+	if s.datasets == nil {
+		return nil, fmt.Errorf("Datastore service has no datasets available")
+	}
+	dataset, err := s.datasets.DatasetFromUUID(u)
+	if err != nil {
+		return nil, err
+	}
+	dataset.mapLock.Lock()
+	defer dataset.mapLock.Unlock()
+	refs := []Ref{}
+	for _, ref := range dataset.Refs {
+		refs = append(refs, *ref)
+	}
+	sort.Sort(refsByName(refs))
+	return refs, nil
+}
+
+type refsByName []Ref
+
+func (refs refsByName) Len() int           { return len(refs) }
+func (refs refsByName) Swap(i, j int)      { refs[i], refs[j] = refs[j], refs[i] }
+func (refs refsByName) Less(i, j int) bool { return refs[i].Name < refs[j].Name }
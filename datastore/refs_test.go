@@ -0,0 +1,92 @@
+package datastore
+
+import (
+	"strings"
+
+	. "github.com/janelia-flyem/go/gocheck"
+)
+
+func (s *DataSuite) TestRefNames(c *C) {
+	for _, name := range []string{"v1", "master", "release-2.0", "proofread_roi3"} {
+		c.Assert(validRefName(name), IsNil, Commentf("name %q", name))
+	}
+	for _, name := range []string{"", "3fa2", "beef", "v 1", "team/alice"} {
+		c.Assert(validRefName(name), NotNil, Commentf("name %q", name))
+	}
+}
+
+func (s *DataSuite) TestRefs(c *C) {
+	service, dir := newTestService(c)
+
+	root, _, err := service.NewDataset()
+	c.Assert(err, IsNil)
+
+	// Tags can only name locked nodes and names are unique in a dataset.
+	c.Assert(service.NewRef(root, "v1", RefTag, "alice"), NotNil)
+	c.Assert(service.Lock(root), IsNil)
+	child, err := service.NewVersion(root)
+	c.Assert(err, IsNil)
+	c.Assert(service.NewRef(child, "v1", RefTag, "alice"), NotNil)
+	c.Assert(service.NewRef(root, "v1", RefTag, "alice"), IsNil)
+	c.Assert(service.NewRef(child, "v1", RefBranch, "alice"), NotNil)
+	c.Assert(service.NewRef(root, "master", RefBranch, "alice"), IsNil)
+	c.Assert(service.NewRef(root, "abc", RefBranch, "alice"), NotNil)
+
+	// Refs can be used in place of UUID strings.
+	u, _, _, err := service.NodeIDFromString("v1")
+	c.Assert(err, IsNil)
+	c.Assert(u, Equals, root)
+	_, _, _, err = service.NodeIDFromString("v2")
+	c.Assert(err, NotNil)
+
+	// Only branches can move.
+	c.Assert(service.MoveRef(child, "v1", "bob"), NotNil)
+	c.Assert(service.MoveRef(child, "master", "bob"), IsNil)
+	u, _, _, err = service.NodeIDFromString("master")
+	c.Assert(err, IsNil)
+	c.Assert(u, Equals, child)
+
+	// Nodes named by refs can't be pruned.
+	_, err = service.Prune(child)
+	c.Assert(err, NotNil)
+
+	refs, err := service.Refs(child)
+	c.Assert(err, IsNil)
+	c.Assert(refs, HasLen, 2)
+	c.Assert(refs[0].Name, Equals, "master")
+	c.Assert(refs[0].Type, Equals, RefBranch)
+	c.Assert(refs[0].UUID, Equals, child)
+	c.Assert(refs[1].Name, Equals, "v1")
+	c.Assert(refs[1].UUID, Equals, root)
+
+	// Ref changes are in the log of the nodes they name.
+	logs, err := service.NodeLogs(child)
+	c.Assert(err, IsNil)
+	c.Assert(logs[0].Refs, DeepEquals, []string{"branch: master"})
+	c.Assert(logs[0].Operations, HasLen, 1)
+	c.Assert(logs[0].Operations[0].User, Equals, "bob")
+	c.Assert(logs[1].Refs, DeepEquals, []string{"tag: v1"})
+	c.Assert(logs[1].Operations, HasLen, 2)
+	text, err := service.NodeLogText(child)
+	c.Assert(err, IsNil)
+	c.Assert(strings.Contains(text, "(unlocked, branch: master)"), Equals, true)
+	c.Assert(strings.Contains(text, "move branch: master from "+string(root)), Equals, true)
+
+	// A ref name used in more than one dataset is ambiguous.
+	root2, _, err := service.NewDataset()
+	c.Assert(err, IsNil)
+	c.Assert(service.NewRef(root2, "master", RefBranch, "carol"), IsNil)
+	_, _, _, err = service.NodeIDFromString("master")
+	c.Assert(err, NotNil)
+	c.Assert(service.DeleteRef(root2, "master", "carol"), IsNil)
+	c.Assert(service.DeleteRef(root2, "master", "carol"), NotNil)
+
+	// Refs are persisted with the dataset.
+	service.Shutdown()
+	service, err = Open(dir)
+	c.Assert(err, IsNil)
+	defer service.Shutdown()
+	u, _, _, err = service.NodeIDFromString("master")
+	c.Assert(err, IsNil)
+	c.Assert(u, Equals, child)
+}
@@ -0,0 +1,268 @@
+/*
+	This file supports routing DVID key spaces to additional storage engines, e.g.,
+	placing grayscale blocks on a large spinning disk while label indices and
+	metadata stay on SSD.
+
+	Routes are specified when the datastore is created via "stores" and "routes"
+	settings:
+
+		stores=<name>:<directory>[,<name>:<directory>...]
+		routes=<selector>:<store name>[,<selector>:<store name>...]
+
+	where a selector is one of:
+
+		keytype/<datasets|dataset|data|sync>   All keys of a datastore key type.
+		dataset/<dataset local ID>             All data within a dataset.
+		data/<data name>                       All data with the given name in any dataset.
+
+	The store name "default" refers to the store in the datastore directory.  For
+	example:
+
+		dvid init stores=hdd:/mnt/hdd/dvid routes=data/grayscale:hdd
+
+	A store may be sharded across several directories, e.g., one per disk, by joining
+	the directories with "+".  Data keys are spread among the shards by a hash of their
+	index, so blocks of a data instance are written to all disks in parallel:
+
+		dvid init stores=blocks:/disk1/dvid+/disk2/dvid+/disk3/dvid routes=data/grayscale:blocks
+*/
+
+package datastore
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+// Name of the store held in the datastore directory.
+const defaultStoreName = "default"
+
+// storageRoutes maps key spaces to named stores.  Only the exported fields are
+// persisted.
+type storageRoutes struct {
+	// Stores maps a store name to its directory.
+	Stores map[string]string
+
+	// The following map selectors to store names.
+	KeyTypes map[KeyType]string
+	Datasets map[dvid.DatasetLocalID]string
+	Data     map[dvid.DataString]string
+
+	router  *storage.Router
+	engines map[string]storage.Engine
+}
+
+// keyTypeNames are the key types that may be routed.
+var keyTypeNames = map[string]KeyType{
+	"datasets": KeyDatasets,
+	"dataset":  KeyDataset,
+	"data":     KeyData,
+	"sync":     KeySync,
+}
+
+// parseRoutes returns storage routes from a configuration or nil if no routing was
+// requested.
+func parseRoutes(config dvid.Config) (*storageRoutes, error) {
+	storesStr, storesFound, err := config.GetString("stores")
+	if err != nil {
+		return nil, err
+	}
+	routesStr, routesFound, err := config.GetString("routes")
+	if err != nil {
+		return nil, err
+	}
+	if !storesFound && !routesFound {
+		return nil, nil
+	}
+
+	routes := &storageRoutes{
+		Stores:   make(map[string]string),
+		KeyTypes: make(map[KeyType]string),
+		Datasets: make(map[dvid.DatasetLocalID]string),
+		Data:     make(map[dvid.DataString]string),
+	}
+	if storesFound && storesStr != "" {
+		for _, spec := range strings.Split(storesStr, ",") {
+			elems := strings.SplitN(spec, ":", 2)
+			if len(elems) != 2 || elems[0] == "" || elems[1] == "" {
+				return nil, fmt.Errorf("Bad store specification '%s': expected <name>:<directory>", spec)
+			}
+			if elems[0] == defaultStoreName {
+				return nil, fmt.Errorf("Store name '%s' is reserved for the datastore directory",
+					defaultStoreName)
+			}
+			if _, found := routes.Stores[elems[0]]; found {
+				return nil, fmt.Errorf("Store '%s' specified more than once", elems[0])
+			}
+			routes.Stores[elems[0]] = elems[1]
+		}
+	}
+	if routesFound && routesStr != "" {
+		for _, spec := range strings.Split(routesStr, ",") {
+			if err := routes.parseRoute(spec); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return routes, nil
+}
+
+// parseRoute adds a route of form <kind>/<id>:<store name>.
+func (routes *storageRoutes) parseRoute(spec string) error {
+	colon := strings.LastIndex(spec, ":")
+	slash := strings.Index(spec, "/")
+	if colon < 0 || slash < 0 || slash > colon {
+		return fmt.Errorf("Bad route specification '%s': expected <selector>:<store name>", spec)
+	}
+	kind, id, store := spec[:slash], spec[slash+1:colon], spec[colon+1:]
+	if _, found := routes.Stores[store]; !found && store != defaultStoreName {
+		return fmt.Errorf("Route '%s' uses unknown store '%s'", spec, store)
+	}
+	switch kind {
+	case "keytype":
+		t, found := keyTypeNames[id]
+		if !found {
+			return fmt.Errorf("Route '%s' has unknown key type '%s'", spec, id)
+		}
+		routes.KeyTypes[t] = store
+	case "dataset":
+		datasetID, err := strconv.ParseUint(id, 10, 32)
+		if err != nil {
+			return fmt.Errorf("Route '%s' has bad dataset local ID '%s'", spec, id)
+		}
+		routes.Datasets[dvid.DatasetLocalID(datasetID)] = store
+	case "data":
+		if id == "" {
+			return fmt.Errorf("Route '%s' has no data name", spec)
+		}
+		routes.Data[dvid.DataString(id)] = store
+	default:
+		return fmt.Errorf("Route '%s' has unknown selector '%s'", spec, kind)
+	}
+	return nil
+}
+
+// loadRoutes returns the storage routes persisted in a store or nil if there are none.
+func loadRoutes(db storage.Engine) (*storageRoutes, error) {
+	data, err := db.Get(&RoutesKey{})
+	if err != nil || data == nil {
+		return nil, err
+	}
+	routes := new(storageRoutes)
+	if err = dvid.Deserialize(data, routes); err != nil {
+		return nil, fmt.Errorf("Error in deserializing storage routes: %s", err.Error())
+	}
+	return routes, nil
+}
+
+// put stores the routes, overwriting whatever was there before.
+func (routes *storageRoutes) put(db storage.Engine) error {
+	serialization, err := dvid.Serialize(routes, dvid.Snappy, dvid.CRC32)
+	if err != nil {
+		return err
+	}
+	return db.Put(&RoutesKey{}, serialization)
+}
+
+// open creates or opens the additional stores and returns a Router that directs the
+// key type and dataset key spaces to them.  Data routes are added as data are loaded
+// or created.
+func (routes *storageRoutes) open(create bool, config dvid.Config, defaultDB storage.Engine) (
+	*storage.Router, error) {
+
+	routes.engines = map[string]storage.Engine{defaultStoreName: defaultDB}
+	routes.router = storage.NewRouter(defaultDB)
+	for name, directory := range routes.Stores {
+		db, err := openStore(directory, create, config)
+		if err != nil {
+			routes.close()
+			return nil, fmt.Errorf("Error opening store '%s' (%s): %s", name, directory, err.Error())
+		}
+		routes.engines[name] = db
+	}
+	for t, name := range routes.KeyTypes {
+		if err := routes.router.AddRoute([]byte{byte(t)}, routes.engines[name]); err != nil {
+			routes.close()
+			return nil, err
+		}
+	}
+	for datasetID, name := range routes.Datasets {
+		if err := routes.router.AddRoute(datasetPrefix(datasetID), routes.engines[name]); err != nil {
+			routes.close()
+			return nil, err
+		}
+	}
+	return routes.router, nil
+}
+
+// openStore creates or opens the store for a directory specification, which may
+// join the directories of shards with "+".
+func openStore(directory string, create bool, config dvid.Config) (storage.Engine, error) {
+	if !strings.Contains(directory, "+") {
+		return storage.NewStore(directory, create, config)
+	}
+	directories := strings.Split(directory, "+")
+	for _, dir := range directories {
+		if dir == "" {
+			return nil, fmt.Errorf("Bad sharded store directories '%s'", directory)
+		}
+	}
+	return storage.NewShardedStore(directories, create, config, shardByIndex)
+}
+
+// shardByIndex is a storage.ShardFunc that spreads data keys among shards using the
+// hash of their index.  All other keys, e.g., metadata, are held by the first shard.
+func shardByIndex(kBytes []byte, n int) int {
+	start := DataKeyIndexOffset
+	if len(kBytes) <= start || kBytes[0] != byte(KeyData) {
+		return 0
+	}
+	return dvid.IndexBytes(kBytes[start:]).Hash(n)
+}
+
+// close closes all stores, including those not yet used by a route.
+func (routes *storageRoutes) close() {
+	for _, db := range routes.engines {
+		db.Close()
+	}
+}
+
+// addDataRoute routes the given data if its name has a route.
+func (routes *storageRoutes) addDataRoute(dset *Dataset, name dvid.DataString) error {
+	store, found := routes.Data[name]
+	if !found {
+		return nil
+	}
+	dataservice, found := dset.DataMap[name]
+	if !found {
+		return fmt.Errorf("Cannot route unknown data '%s' in dataset %s", name, dset.Root)
+	}
+	prefix := dataPrefix(dset.DatasetID, dataservice.LocalID())
+	return routes.router.AddRoute(prefix, routes.engines[store])
+}
+
+// addDataRoutes routes all existing data with routed names.
+func (routes *storageRoutes) addDataRoutes(dsets *Datasets) error {
+	for _, dset := range dsets.list {
+		for name := range dset.DataMap {
+			if err := routes.addDataRoute(dset, name); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// datasetPrefix returns the key prefix for all data in a dataset.
+func datasetPrefix(datasetID dvid.DatasetLocalID) []byte {
+	return append([]byte{byte(KeyData)}, dvid.LocalID32(datasetID).Bytes()...)
+}
+
+// dataPrefix returns the key prefix for all key-value pairs of a data instance.
+func dataPrefix(datasetID dvid.DatasetLocalID, dataID dvid.DataLocalID) []byte {
+	return append(datasetPrefix(datasetID), dvid.LocalID(dataID).Bytes()...)
+}
@@ -0,0 +1,112 @@
+package datastore
+
+import (
+	"fmt"
+
+	. "github.com/janelia-flyem/go/gocheck"
+
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+func (s *DataSuite) TestParseRoutes(c *C) {
+	routes, err := parseRoutes(dvid.Config{})
+	c.Assert(err, IsNil)
+	c.Assert(routes, IsNil)
+
+	config := dvid.Config{
+		"stores": "hdd:/mnt/hdd/dvid",
+		"routes": "data/grayscale:hdd,keytype/sync:hdd,dataset/2:default",
+	}
+	routes, err = parseRoutes(config)
+	c.Assert(err, IsNil)
+	c.Assert(routes.Stores["hdd"], Equals, "/mnt/hdd/dvid")
+	c.Assert(routes.Data[dvid.DataString("grayscale")], Equals, "hdd")
+	c.Assert(routes.KeyTypes[KeySync], Equals, "hdd")
+	c.Assert(routes.Datasets[dvid.DatasetLocalID(2)], Equals, "default")
+
+	badRoutes := []string{"data/grayscale:ssd", "keytype/foo:hdd", "dataset/x:hdd", "grayscale:hdd"}
+	for _, route := range badRoutes {
+		_, err = parseRoutes(dvid.Config{"stores": "hdd:/mnt/hdd/dvid", "routes": route})
+		c.Assert(err, NotNil)
+	}
+}
+
+func (s *DataSuite) TestRoutedDatastore(c *C) {
+	dir := c.MkDir()
+	otherDir := c.MkDir() + "/other"
+	config := dvid.Config{
+		"stores": "other:" + otherDir,
+		"routes": "keytype/dataset:other",
+	}
+	c.Assert(Init(dir, true, config), IsNil)
+
+	service, openErr := Open(dir)
+	c.Assert(openErr, IsNil)
+	root, _, err := service.NewDataset()
+	c.Assert(err, IsNil)
+	oldJSON, err := service.DatasetsAllJSON()
+	c.Assert(err, IsNil)
+
+	// The dataset is held by the other store and not the default.
+	dset, err := service.datasets.DatasetFromUUID(root)
+	c.Assert(err, IsNil)
+	value, err := service.routes.engines["other"].Get(dset.Key())
+	c.Assert(err, IsNil)
+	c.Assert(value, NotNil)
+	value, err = service.routes.engines[defaultStoreName].Get(dset.Key())
+	c.Assert(err, IsNil)
+	c.Assert(value, IsNil)
+	service.Shutdown()
+
+	service, openErr = Open(dir)
+	c.Assert(openErr, IsNil)
+	newJSON, err := service.DatasetsAllJSON()
+	c.Assert(err, IsNil)
+	c.Assert(newJSON, Equals, oldJSON)
+	service.Shutdown()
+}
+
+func (s *DataSuite) TestShardedDatastore(c *C) {
+	dir := c.MkDir()
+	config := dvid.Config{
+		"stores": "blocks:" + c.MkDir() + "+" + c.MkDir(),
+		"routes": "keytype/data:blocks",
+	}
+	c.Assert(Init(dir, true, config), IsNil)
+
+	service, openErr := Open(dir)
+	c.Assert(openErr, IsNil)
+	_, datasetID, err := service.NewDataset()
+	c.Assert(err, IsNil)
+	for i := 0; i < 20; i++ {
+		key := &DataKey{datasetID, 1, 1, dvid.IndexString(fmt.Sprintf("block %02d", i))}
+		c.Assert(service.db.Put(key, []byte{byte(i)}), IsNil)
+	}
+
+	// Data keys are spread among the shards.
+	sharded, ok := service.routes.engines["blocks"].(*storage.ShardedEngine)
+	c.Assert(ok, Equals, true)
+	prefix := dataPrefix(datasetID, 1)
+	for _, db := range sharded.Shards() {
+		keys, err := db.KeysInRange(storage.RawKey(prefix), storage.RawKey(maxKey(prefix)))
+		c.Assert(err, IsNil)
+		c.Assert(len(keys) > 0, Equals, true)
+	}
+	service.Shutdown()
+
+	// The shards are merged in key order after reopening.
+	service, openErr = Open(dir)
+	c.Assert(openErr, IsNil)
+	defer service.Shutdown()
+	values, err := service.db.GetRange(storage.RawKey(prefix), storage.RawKey(maxKey(prefix)))
+	c.Assert(err, IsNil)
+	c.Assert(values, HasLen, 20)
+	for i, kv := range values {
+		c.Assert(kv.V, DeepEquals, []byte{byte(i)})
+	}
+	key := &DataKey{datasetID, 1, 1, dvid.IndexString("block 07")}
+	value, err := service.db.Get(key)
+	c.Assert(err, IsNil)
+	c.Assert(value, DeepEquals, []byte{7})
+}
@@ -0,0 +1,74 @@
+/*
+	This file supports datastore-wide settings given when the datastore is created
+	and applied each time it is opened:
+
+		cache=<megabytes>    Size of the read-through cache over all stores.  The
+		                     default of 0 disables caching.
+
+	For example:
+
+		dvid init cache=1024
+*/
+
+package datastore
+
+import (
+	"fmt"
+
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+// settings are persisted in the default store.
+type settings struct {
+	// CacheMB is the size of the read-through cache in megabytes or 0 for no cache.
+	CacheMB int
+}
+
+// parseSettings returns the datastore settings from a configuration.
+func parseSettings(config dvid.Config) (*settings, error) {
+	s := new(settings)
+	cacheMB, found, err := config.GetInt("cache")
+	if err != nil {
+		return nil, err
+	}
+	if found {
+		if cacheMB < 0 {
+			return nil, fmt.Errorf("Cache size must be non-negative, not %d MB", cacheMB)
+		}
+		s.CacheMB = cacheMB
+	}
+	return s, nil
+}
+
+// loadSettings returns the settings persisted in a store.  Datastores created
+// before settings were persisted use the default settings.
+func loadSettings(db storage.Engine) (*settings, error) {
+	s := new(settings)
+	data, err := db.Get(&SettingsKey{})
+	if err != nil || data == nil {
+		return s, err
+	}
+	if err = dvid.Deserialize(data, s); err != nil {
+		return nil, fmt.Errorf("Error in deserializing datastore settings: %s", err.Error())
+	}
+	return s, nil
+}
+
+// put stores the settings, overwriting whatever was there before.
+func (s *settings) put(db storage.Engine) error {
+	serialization, err := dvid.Serialize(s, dvid.Snappy, dvid.CRC32)
+	if err != nil {
+		return err
+	}
+	return db.Put(&SettingsKey{}, serialization)
+}
+
+// wrap returns the engine used by a Service given the settings.
+func (s *settings) wrap(db storage.Engine) storage.Engine {
+	if s.CacheMB == 0 {
+		return db
+	}
+	dvid.Log(dvid.Normal, "Using %d MB read-through cache for datastore\n", s.CacheMB)
+	return storage.NewCachedEngine(db, int64(s.CacheMB)*dvid.Mega)
+}
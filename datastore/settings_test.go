@@ -0,0 +1,35 @@
+package datastore
+
+import (
+	. "github.com/janelia-flyem/go/gocheck"
+
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+func (s *DataSuite) TestCachedDatastore(c *C) {
+	_, err := parseSettings(dvid.Config{"cache": "-1"})
+	c.Assert(err, NotNil)
+
+	dir := c.MkDir()
+	c.Assert(Init(dir, true, dvid.Config{"cache": "16"}), IsNil)
+
+	service, openErr := Open(dir)
+	c.Assert(openErr, IsNil)
+	_, isCached := service.db.(*storage.CachedEngine)
+	c.Assert(isCached, Equals, true)
+	root, _, err := service.NewDataset()
+	c.Assert(err, IsNil)
+	oldJSON, err := service.DatasetsAllJSON()
+	c.Assert(err, IsNil)
+	service.Shutdown()
+
+	service, openErr = Open(dir)
+	c.Assert(openErr, IsNil)
+	newJSON, err := service.DatasetsAllJSON()
+	c.Assert(err, IsNil)
+	c.Assert(newJSON, Equals, oldJSON)
+	_, err = service.datasets.DatasetFromUUID(root)
+	c.Assert(err, IsNil)
+	service.Shutdown()
+}
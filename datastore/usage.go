@@ -0,0 +1,167 @@
+/*
+	This file supports reporting the storage used by data instances.  Sizes are
+	estimated by the storage engine when possible and can be counted exactly by
+	scanning keys.
+*/
+
+package datastore
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+// VersionUsage gives the storage used by a data instance at one version.
+type VersionUsage struct {
+	UUID      dvid.UUID
+	VersionID dvid.VersionLocalID
+
+	// Bytes estimated by the storage engine, which may include overhead and reflect
+	// compression by the engine.
+	ApproximateBytes uint64
+
+	// Exact counts from a scan of the version's keys.
+	Keys       int
+	KeyBytes   uint64
+	ValueBytes uint64
+}
+
+// DataUsage gives the storage used by a data instance broken down by version.
+type DataUsage struct {
+	Name dvid.DataString
+
+	// Estimated is true if the storage engine gave approximate sizes.
+	Estimated bool
+
+	// Scanned is true if keys were scanned for exact counts.
+	Scanned bool
+
+	Versions []VersionUsage
+}
+
+func (usage *DataUsage) String() string {
+	text := fmt.Sprintf("%s:\n", usage.Name)
+	for _, v := range usage.Versions {
+		text += fmt.Sprintf("  %s", v.UUID)
+		if usage.Estimated {
+			text += fmt.Sprintf("  ~%d bytes", v.ApproximateBytes)
+		}
+		if usage.Scanned {
+			text += fmt.Sprintf("  %d keys, %d key bytes, %d value bytes", v.Keys,
+				v.KeyBytes, v.ValueBytes)
+		}
+		text += "\n"
+	}
+	return text
+}
+
+type versionUsages []VersionUsage
+
+func (v versionUsages) Len() int           { return len(v) }
+func (v versionUsages) Less(i, j int) bool { return v[i].VersionID < v[j].VersionID }
+func (v versionUsages) Swap(i, j int)      { v[i], v[j] = v[j], v[i] }
+
+// dataUsage returns the usage for each version of a data instance.  Keys are scanned
+// if exact counts are requested or the storage engine cannot estimate sizes.
+func (s *Service) dataUsage(dset *Dataset, dataservice DataService, exact bool) (*DataUsage, error) {
+	db := s.baseDB()
+	estimator, estimated := db.(storage.SizeEstimator)
+	estimated = estimated && db.IsSizeEstimator()
+	usage := &DataUsage{
+		Name:      dataservice.DataName(),
+		Estimated: estimated,
+		Scanned:   exact || !estimated,
+	}
+
+	dset.mapLock.Lock()
+	for u, versionID := range dset.VersionMap {
+		usage.Versions = append(usage.Versions, VersionUsage{UUID: u, VersionID: versionID})
+	}
+	dset.mapLock.Unlock()
+	sort.Sort(versionUsages(usage.Versions))
+
+	var snapshot storage.Snapshot
+	if usage.Scanned {
+		snapshot = storage.ReadSnapshot(db)
+		defer snapshot.Close()
+	}
+	for i := range usage.Versions {
+		v := &usage.Versions[i]
+		prefix := (&DataKey{dset.DatasetID, dataservice.LocalID(), v.VersionID, nil}).Bytes()
+		first, last := storage.RawKey(prefix), storage.RawKey(maxKey(prefix))
+		if estimated {
+			size, err := estimator.ApproximateSize(first, last)
+			if err != nil {
+				return nil, err
+			}
+			v.ApproximateBytes = size
+		}
+		if usage.Scanned {
+			if err := v.scan(snapshot, first, last); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return usage, nil
+}
+
+// scan counts the key-value pairs in the inclusive range [first, last].
+func (v *VersionUsage) scan(snapshot storage.Snapshot, first, last storage.Key) error {
+	it, err := snapshot.NewIterator(first, last, nil)
+	if err != nil {
+		return err
+	}
+	defer it.Close()
+	for ; it.Valid(); it.Next() {
+		key, err := it.Key()
+		if err != nil {
+			return err
+		}
+		v.Keys++
+		v.KeyBytes += uint64(len(key.Bytes()))
+		v.ValueBytes += uint64(len(it.Value()))
+	}
+	return it.Error()
+}
+
+// DataUsage returns the storage used by a data instance for each version of its
+// dataset.  If exact is true, keys are scanned to count pairs and bytes.
+func (s *Service) DataUsage(u dvid.UUID, name dvid.DataString, exact bool) (*DataUsage, error) {
+	dset, err := s.datasets.DatasetFromUUID(u)
+	if err != nil {
+		return nil, err
+	}
+	dataservice, err := dset.DataService(name)
+	if err != nil {
+		return nil, err
+	}
+	return s.dataUsage(dset, dataservice, exact)
+}
+
+// UsageText returns a chart of the approximate storage used by each data instance
+// in each dataset, broken down by version.
+func (s *Service) UsageText() (string, error) {
+	if s.datasets == nil {
+		return "", nil
+	}
+	var text string
+	for _, dset := range s.datasets.list {
+		names := []string{}
+		for name := range dset.DataMap {
+			names = append(names, string(name))
+		}
+		sort.Strings(names)
+		text += fmt.Sprintf("Dataset %s\n", dset.Root)
+		for _, name := range names {
+			usage, err := s.dataUsage(dset, dset.DataMap[dvid.DataString(name)], false)
+			if err != nil {
+				return "", err
+			}
+			text += usage.String()
+		}
+	}
+	return text, nil
+}
@@ -0,0 +1,72 @@
+package datastore
+
+import (
+	"net/http"
+	"strings"
+
+	. "github.com/janelia-flyem/go/gocheck"
+
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+// usageData is a minimal DataService for checking usage without a compiled datatype.
+type usageData struct {
+	*Data
+}
+
+func (d usageData) DoRPC(request Request, reply *Response) error {
+	return d.UnknownCommand(request)
+}
+
+func (d usageData) DoHTTP(uuid dvid.UUID, w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+func (s *DataSuite) TestDataUsage(c *C) {
+	service, _ := newTestService(c)
+	defer service.Shutdown()
+
+	root, datasetID, err := service.NewDataset()
+	c.Assert(err, IsNil)
+	c.Assert(service.Lock(root), IsNil)
+	child, err := service.NewVersion(root)
+	c.Assert(err, IsNil)
+
+	dset, err := service.datasets.DatasetFromUUID(root)
+	c.Assert(err, IsNil)
+	id := &DataID{"mydata", 1, datasetID}
+	dset.DataMap = map[dvid.DataString]DataService{"mydata": usageData{&Data{DataID: id}}}
+
+	// Store two values in the child version.
+	for _, index := range []string{"a", "b"} {
+		key := &DataKey{datasetID, 1, dset.VersionMap[child], dvid.IndexString(index)}
+		c.Assert(service.db.Put(key, []byte("0123456789")), IsNil)
+	}
+
+	usage, err := service.DataUsage(child, "mydata", true)
+	c.Assert(err, IsNil)
+	c.Assert(usage.Scanned, Equals, true)
+	c.Assert(usage.Versions, HasLen, 2)
+	c.Assert(usage.Versions[0].UUID, Equals, root)
+	c.Assert(usage.Versions[0].Keys, Equals, 0)
+	c.Assert(usage.Versions[1].UUID, Equals, child)
+	c.Assert(usage.Versions[1].Keys, Equals, 2)
+	c.Assert(usage.Versions[1].ValueBytes, Equals, uint64(20))
+	c.Assert(usage.Versions[1].KeyBytes, Equals, uint64(2*(DataKeyIndexOffset+1)))
+
+	// Without exact counts, keys are only scanned if sizes can't be estimated.
+	usage, err = service.DataUsage(child, "mydata", false)
+	c.Assert(err, IsNil)
+	c.Assert(usage.Estimated, Equals, service.baseDB().IsSizeEstimator())
+	c.Assert(usage.Scanned, Equals, !usage.Estimated)
+	if usage.Scanned {
+		c.Assert(usage.Versions[1].Keys, Equals, 2)
+	}
+
+	_, err = service.DataUsage(child, "unknown", false)
+	c.Assert(err, NotNil)
+
+	text, err := service.UsageText()
+	c.Assert(err, IsNil)
+	c.Assert(strings.Contains(text, "mydata"), Equals, true)
+}
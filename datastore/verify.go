@@ -0,0 +1,306 @@
+/*
+	This file supports integrity checks of a datastore.  Every key is checked to see
+	that its value can be deserialized with a valid checksum and, for data keys, that
+	it refers to an existing dataset, data instance, and version.  Bad key-value pairs
+	can be quarantined, i.e., moved into a separate key space for later inspection.
+*/
+
+package datastore
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+// Maximum number of bad keys of each kind listed in a VerifyReport.
+const maxReportedKeys = 100
+
+// ValueVerifier is implemented by data that store values not serialized using
+// dvid.SerializeData, e.g., empty values for indices.  Other data are checked by
+// deserializing their values.
+type ValueVerifier interface {
+	// VerifyValue returns an error if the value is not valid for the key.
+	VerifyValue(key *DataKey, value []byte) error
+}
+
+// BadKey describes a key that failed verification.
+type BadKey struct {
+	Key     string // Hexadecimal representation of the key bytes.
+	Problem string
+}
+
+// VerifyReport summarizes an integrity check.
+type VerifyReport struct {
+	Scanned     int
+	NumOrphaned int // Keys referring to missing datasets, data, or versions.
+	NumCorrupt  int // Keys with values that fail checksums or cannot be deserialized.
+	Quarantined int // Bad keys moved to the quarantine key space by this check.
+
+	// Previously quarantined keys are skipped.
+	Skipped int
+
+	// The first bad keys found.
+	Orphaned []BadKey
+	Corrupt  []BadKey
+}
+
+func (r *VerifyReport) String() string {
+	text := fmt.Sprintf("Scanned %d keys: %d orphaned, %d corrupt, %d quarantined",
+		r.Scanned, r.NumOrphaned, r.NumCorrupt, r.Quarantined)
+	if r.Skipped > 0 {
+		text += fmt.Sprintf(" (skipped %d previously quarantined)", r.Skipped)
+	}
+	text += "\n"
+	list := func(title string, total int, badKeys []BadKey) {
+		if total == 0 {
+			return
+		}
+		text += title + ":\n"
+		for _, bad := range badKeys {
+			text += fmt.Sprintf("  %s: %s\n", bad.Key, bad.Problem)
+		}
+		if total > len(badKeys) {
+			text += fmt.Sprintf("  ... and %d more\n", total-len(badKeys))
+		}
+	}
+	list("Orphaned keys", r.NumOrphaned, r.Orphaned)
+	list("Corrupt keys", r.NumCorrupt, r.Corrupt)
+	return text
+}
+
+// verifier checks keys against the datasets known when it was created.
+type verifier struct {
+	datasets map[dvid.DatasetLocalID]*Dataset
+	data     map[dvid.DatasetLocalID]map[dvid.DataLocalID]DataService
+	versions map[dvid.DatasetLocalID]map[dvid.VersionLocalID]bool
+
+	report *VerifyReport
+	bad    []badPair // pairs to quarantine
+}
+
+// badPair is a key-value pair that failed verification, with the value as scanned.
+type badPair struct {
+	key   []byte
+	value []byte
+}
+
+func newVerifier(dsets *Datasets) *verifier {
+	v := &verifier{
+		datasets: make(map[dvid.DatasetLocalID]*Dataset),
+		data:     make(map[dvid.DatasetLocalID]map[dvid.DataLocalID]DataService),
+		versions: make(map[dvid.DatasetLocalID]map[dvid.VersionLocalID]bool),
+		report:   new(VerifyReport),
+	}
+	for _, dset := range dsets.list {
+		v.datasets[dset.DatasetID] = dset
+		v.data[dset.DatasetID] = make(map[dvid.DataLocalID]DataService)
+		for _, dataservice := range dset.DataMap {
+			v.data[dset.DatasetID][dataservice.LocalID()] = dataservice
+		}
+		v.versions[dset.DatasetID] = make(map[dvid.VersionLocalID]bool)
+		for _, versionID := range dset.VersionMap {
+			v.versions[dset.DatasetID][versionID] = true
+		}
+	}
+	return v
+}
+
+// verifySerialized checks that a value was written by dvid.SerializeData.
+func verifySerialized(value []byte) error {
+	_, _, err := dvid.DeserializeData(value, true)
+	return err
+}
+
+// check verifies a key-value pair and records any problem.
+func (v *verifier) check(kBytes, value []byte) {
+	v.report.Scanned++
+	if len(kBytes) == 0 {
+		v.orphaned(kBytes, value, fmt.Errorf("Empty key"))
+		return
+	}
+	switch KeyType(kBytes[0]) {
+	case KeyDatasets, KeySync, KeyRoutes, KeySettings:
+		v.corrupt(kBytes, value, verifySerialized(value))
+	case KeyDataset, KeyOperation:
+		if len(kBytes) < 1+dvid.LocalID32Size {
+			v.orphaned(kBytes, value, fmt.Errorf("Malformed %s", KeyType(kBytes[0])))
+			return
+		}
+		datasetID, _ := dvid.LocalID32FromBytes(kBytes[1:])
+		if _, found := v.datasets[dvid.DatasetLocalID(datasetID)]; !found {
+			v.orphaned(kBytes, value, fmt.Errorf("Unknown dataset %d", datasetID))
+			return
+		}
+		v.corrupt(kBytes, value, verifySerialized(value))
+	case KeyData:
+		v.checkData(kBytes, value)
+	case KeyQuarantine:
+		v.report.Scanned--
+		v.report.Skipped++
+	default:
+		v.orphaned(kBytes, value, fmt.Errorf("Unknown key type %d", kBytes[0]))
+	}
+}
+
+func (v *verifier) checkData(kBytes, value []byte) {
+	template := &DataKey{Index: dvid.IndexBytes{}}
+	if len(kBytes) < DataKeyIndexOffset {
+		v.orphaned(kBytes, value, fmt.Errorf("Malformed data key"))
+		return
+	}
+	key, err := template.BytesToKey(kBytes)
+	if err != nil {
+		v.orphaned(kBytes, value, err)
+		return
+	}
+	dataKey := key.(*DataKey)
+	if _, found := v.datasets[dataKey.Dataset]; !found {
+		v.orphaned(kBytes, value, fmt.Errorf("Unknown dataset %d", dataKey.Dataset))
+		return
+	}
+	dataservice, found := v.data[dataKey.Dataset][dataKey.Data]
+	if !found {
+		v.orphaned(kBytes, value, fmt.Errorf("Unknown data %d in dataset %d", dataKey.Data,
+			dataKey.Dataset))
+		return
+	}
+	if !v.versions[dataKey.Dataset][dataKey.Version] {
+		v.orphaned(kBytes, value, fmt.Errorf("Unknown version %d in dataset %d", dataKey.Version,
+			dataKey.Dataset))
+		return
+	}
+	if IsTombstone(value) {
+		return
+	}
+	if valueVerifier, ok := dataservice.(ValueVerifier); ok {
+		v.corrupt(kBytes, value, valueVerifier.VerifyValue(dataKey, value))
+	} else {
+		v.corrupt(kBytes, value, verifySerialized(value))
+	}
+}
+
+func (v *verifier) orphaned(kBytes, value []byte, err error) {
+	v.report.NumOrphaned++
+	if len(v.report.Orphaned) < maxReportedKeys {
+		v.report.Orphaned = append(v.report.Orphaned, BadKey{fmt.Sprintf("%x", kBytes), err.Error()})
+	}
+	v.bad = append(v.bad, badPair{kBytes, value})
+}
+
+func (v *verifier) corrupt(kBytes, value []byte, err error) {
+	if err == nil {
+		return
+	}
+	v.report.NumCorrupt++
+	if len(v.report.Corrupt) < maxReportedKeys {
+		v.report.Corrupt = append(v.report.Corrupt, BadKey{fmt.Sprintf("%x", kBytes), err.Error()})
+	}
+	v.bad = append(v.bad, badPair{kBytes, value})
+}
+
+// scan checks all key-value pairs in the inclusive range [start, end] using a
+// consistent view of the store.
+func (v *verifier) scan(db storage.Engine, start, end []byte) error {
+	snapshot := storage.ReadSnapshot(db)
+	defer snapshot.Close()
+	it, err := snapshot.NewIterator(storage.RawKey(start), storage.RawKey(end), nil)
+	if err != nil {
+		return err
+	}
+	defer it.Close()
+	for ; it.Valid(); it.Next() {
+		key, err := it.Key()
+		if err != nil {
+			return err
+		}
+		kBytes := make([]byte, len(key.Bytes()))
+		copy(kBytes, key.Bytes())
+		value := make([]byte, len(it.Value()))
+		copy(value, it.Value())
+		v.check(kBytes, value)
+	}
+	return it.Error()
+}
+
+// quarantine moves bad key-value pairs into the quarantine key space.  A pair is only
+// moved if it still holds the value seen by the scan, so values rewritten since then
+// are left in place.  Each pair is checked and moved in a single transaction.
+func (v *verifier) quarantine(db storage.Engine) error {
+	for _, bad := range v.bad {
+		moved := false
+		err := storage.UpdateTxn(db, func(txn *storage.Txn) error {
+			moved = false
+			value, err := txn.Get(storage.RawKey(bad.key))
+			if err != nil {
+				return err
+			}
+			if value == nil || !bytes.Equal(value, bad.value) {
+				return nil
+			}
+			qKey := append([]byte{byte(KeyQuarantine)}, bad.key...)
+			txn.Put(storage.RawKey(qKey), value)
+			txn.Delete(storage.RawKey(bad.key))
+			moved = true
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if moved {
+			v.report.Quarantined++
+		}
+	}
+	return nil
+}
+
+// maxKey returns a key that follows every key starting with the given prefix.
+func maxKey(prefix []byte) []byte {
+	end := make([]byte, len(prefix), len(prefix)+DataKeyIndexOffset+maxIndexBytes)
+	copy(end, prefix)
+	for i := 0; i < DataKeyIndexOffset+maxIndexBytes; i++ {
+		end = append(end, 0xFF)
+	}
+	return end
+}
+
+// Verify checks every key-value pair in the datastore.  If repair is true, orphaned
+// and corrupt pairs are quarantined.
+func (s *Service) Verify(repair bool) (*VerifyReport, error) {
+	v := newVerifier(s.datasets)
+	if err := v.scan(s.StorageEngine(), []byte{}, maxKey([]byte{})); err != nil {
+		return nil, err
+	}
+	if repair {
+		if err := v.quarantine(s.StorageEngine()); err != nil {
+			return v.report, err
+		}
+	}
+	return v.report, nil
+}
+
+// VerifyData checks the key-value pairs of a data instance across all versions.  If
+// repair is true, orphaned and corrupt pairs are quarantined.
+func (s *Service) VerifyData(u dvid.UUID, name dvid.DataString, repair bool) (*VerifyReport, error) {
+	dset, err := s.datasets.DatasetFromUUID(u)
+	if err != nil {
+		return nil, err
+	}
+	dataservice, err := dset.DataService(name)
+	if err != nil {
+		return nil, err
+	}
+	prefix := dataPrefix(dset.DatasetID, dataservice.LocalID())
+	v := newVerifier(s.datasets)
+	if err := v.scan(s.StorageEngine(), prefix, maxKey(prefix)); err != nil {
+		return nil, err
+	}
+	if repair {
+		if err := v.quarantine(s.StorageEngine()); err != nil {
+			return v.report, err
+		}
+	}
+	return v.report, nil
+}
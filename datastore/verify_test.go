@@ -0,0 +1,81 @@
+package datastore
+
+import (
+	. "github.com/janelia-flyem/go/gocheck"
+
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+func (s *DataSuite) TestVerify(c *C) {
+	service, _ := newTestService(c)
+	defer service.Shutdown()
+
+	_, datasetID, err := service.NewDataset()
+	c.Assert(err, IsNil)
+
+	report, err := service.Verify(false)
+	c.Assert(err, IsNil)
+	c.Assert(report.NumOrphaned, Equals, 0)
+	c.Assert(report.NumCorrupt, Equals, 0)
+	numKeys := report.Scanned
+
+	// Add a key for missing data and a value with a bad checksum.
+	orphanKey := &DataKey{datasetID, 23, 0, dvid.IndexString("orphan")}
+	value, err := dvid.SerializeData([]byte("orphaned value"), dvid.Snappy, dvid.CRC32)
+	c.Assert(err, IsNil)
+	c.Assert(service.db.Put(orphanKey, value), IsNil)
+	corruptKey := storage.RawKey([]byte{byte(KeySync), 1})
+	value, err = dvid.SerializeData([]byte("corrupt value"), dvid.Uncompressed, dvid.CRC32)
+	c.Assert(err, IsNil)
+	value[len(value)-1] ^= 0x01
+	c.Assert(service.db.Put(corruptKey, value), IsNil)
+
+	report, err = service.Verify(true)
+	c.Assert(err, IsNil)
+	c.Assert(report.Scanned, Equals, numKeys+2)
+	c.Assert(report.NumOrphaned, Equals, 1)
+	c.Assert(report.Orphaned[0].Key, Equals, orphanKey.String())
+	c.Assert(report.NumCorrupt, Equals, 1)
+	c.Assert(report.Quarantined, Equals, 2)
+
+	value, err = service.db.Get(orphanKey)
+	c.Assert(err, IsNil)
+	c.Assert(value, IsNil)
+	value, err = service.db.Get(storage.RawKey(append([]byte{byte(KeyQuarantine)},
+		orphanKey.Bytes()...)))
+	c.Assert(err, IsNil)
+	c.Assert(value, NotNil)
+
+	report, err = service.Verify(false)
+	c.Assert(err, IsNil)
+	c.Assert(report.Scanned, Equals, numKeys)
+	c.Assert(report.Skipped, Equals, 2)
+	c.Assert(report.NumOrphaned+report.NumCorrupt, Equals, 0)
+}
+
+func (s *DataSuite) TestQuarantineRewritten(c *C) {
+	service, _ := newTestService(c)
+	defer service.Shutdown()
+
+	corruptKey := storage.RawKey([]byte{byte(KeySync), 1})
+	value, err := dvid.SerializeData([]byte("corrupt value"), dvid.Uncompressed, dvid.CRC32)
+	c.Assert(err, IsNil)
+	value[len(value)-1] ^= 0x01
+	c.Assert(service.db.Put(corruptKey, value), IsNil)
+
+	v := newVerifier(service.datasets)
+	c.Assert(v.scan(service.db, []byte{}, maxKey([]byte{})), IsNil)
+	c.Assert(v.report.NumCorrupt, Equals, 1)
+
+	// Rewrite the pair with good data before quarantine runs.
+	good, err := dvid.SerializeData([]byte("good value"), dvid.Uncompressed, dvid.CRC32)
+	c.Assert(err, IsNil)
+	c.Assert(service.db.Put(corruptKey, good), IsNil)
+
+	c.Assert(v.quarantine(service.db), IsNil)
+	c.Assert(v.report.Quarantined, Equals, 0)
+	value, err = service.db.Get(corruptKey)
+	c.Assert(err, IsNil)
+	c.Assert(value, DeepEquals, good)
+}
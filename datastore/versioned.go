@@ -0,0 +1,233 @@
+/*
+	This file supports copy-on-write reads of versioned data.  A child node starts
+	with no key-value pairs of its own, so reads at a node also search its ancestors
+	in the version DAG, using the nearest version holding a key.  Deletes at a node
+	whose ancestors may hold the key write a tombstone that hides the ancestor's value.
+
+	How far reads traverse the DAG is given by each node's availability of the data:
+
+		DataComplete   Only this node is read.
+		DataDelta      This node and then its first parent's ancestry are read.
+		DataRoot       This node and then the root are read.
+		DataDeleted    No key-value pairs are available.
+
+	Nodes created by branching are DataDelta for all versioned data.  A merged node
+	reads through its first parent and holds any changes made in its other parents.
+*/
+
+package datastore
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+// tombstone is the value stored to delete a key hidden in an ancestor.  It can't be
+// produced by dvid.SerializeData, whose values start with a format byte below 0xFF.
+var tombstone = []byte("\xffdvid tombstone")
+
+// IsTombstone returns true if a stored value marks a deleted key.
+func IsTombstone(value []byte) bool {
+	return bytes.Equal(value, tombstone)
+}
+
+// avail returns the availability of data at a node, using the default if it has
+// not been set.  No data is available at pruned nodes.
+func (node *Node) avail(data DataService) DataAvail {
+	node.writeLock.Lock()
+	defer node.writeLock.Unlock()
+	if node.Pruned {
+		return DataDeleted
+	}
+	if avail, found := node.Avail[data.DataName()]; found {
+		return avail
+	}
+	if data.IsVersioned() {
+		return DataComplete
+	}
+	return DataRoot
+}
+
+// setAvail sets the availability of data at a node.
+func (node *Node) setAvail(name dvid.DataString, avail DataAvail) {
+	node.writeLock.Lock()
+	if node.Avail == nil {
+		node.Avail = make(map[dvid.DataString]DataAvail)
+	}
+	node.Avail[name] = avail
+	node.writeLock.Unlock()
+}
+
+// ancestry returns the versions that are read, nearest first, for data at a node.
+func (dag *VersionDAG) ancestry(u dvid.UUID, data DataService) ([]dvid.VersionLocalID, error) {
+	dag.mapLock.Lock()
+	defer dag.mapLock.Unlock()
+
+	node, found := dag.Nodes[u]
+	if !found {
+		return nil, fmt.Errorf("No node found with UUID %s", u)
+	}
+	versions := []dvid.VersionLocalID{}
+	visited := make(map[dvid.UUID]bool)
+	queue := []*Node{node}
+	for len(queue) > 0 {
+		node, queue = queue[0], queue[1:]
+		if visited[node.GlobalID] {
+			continue
+		}
+		visited[node.GlobalID] = true
+		// A node without data can't be read, nor can its ancestors through it.
+		avail := node.avail(data)
+		if avail == DataDeleted {
+			continue
+		}
+		versions = append(versions, node.VersionID)
+		switch avail {
+		case DataDelta:
+			if len(node.Parents) > 0 {
+				if parentNode, found := dag.Nodes[node.Parents[0]]; found {
+					queue = append(queue, parentNode)
+				}
+			}
+		case DataRoot:
+			if root, found := dag.Nodes[dag.Root]; found && !visited[dag.Root] {
+				versions = append(versions, root.VersionID)
+			}
+			return versions, nil
+		}
+	}
+	return versions, nil
+}
+
+// VersionedReader reads the key-value pairs of data visible at a version, whether
+// written at that version or inherited from an ancestor.  Keys returned are those of
+// the read version, so values read can be modified and written back.
+type VersionedReader struct {
+	reader   storage.KeyValueGetter
+	data     *DataID
+	version  dvid.VersionLocalID
+	versions []dvid.VersionLocalID // versions read, nearest first
+}
+
+// VersionedReader returns a reader for data at the version with the given UUID.
+// Reads use the given storage reader, e.g., a snapshot.
+func (s *Service) VersionedReader(u dvid.UUID, name dvid.DataString,
+	reader storage.KeyValueGetter) (*VersionedReader, error) {
+
+	dset, err := s.datasets.DatasetFromUUID(u)
+	if err != nil {
+		return nil, err
+	}
+	dataservice, err := dset.DataService(name)
+	if err != nil {
+		return nil, err
+	}
+	versions, err := dset.ancestry(u, dataservice)
+	if err != nil {
+		return nil, err
+	}
+	dset.mapLock.Lock()
+	versionID := dset.VersionMap[u]
+	dset.mapLock.Unlock()
+	data := &DataID{dataservice.DataName(), dataservice.LocalID(), dset.DatasetID}
+	return &VersionedReader{reader, data, versionID, versions}, nil
+}
+
+// Version returns the version read.
+func (vr *VersionedReader) Version() dvid.VersionLocalID {
+	return vr.version
+}
+
+// Key returns the key of an index at the read version.
+func (vr *VersionedReader) Key(index dvid.Index) *DataKey {
+	return &DataKey{vr.data.DsetID, vr.data.ID, vr.version, index}
+}
+
+// Inherits returns true if reads may return values written at ancestors.
+func (vr *VersionedReader) Inherits() bool {
+	return len(vr.versions) > 1
+}
+
+// Get returns the value of an index at the nearest version holding it or nil if the
+// index is not present or was deleted.
+func (vr *VersionedReader) Get(index dvid.Index) ([]byte, error) {
+	for _, versionID := range vr.versions {
+		value, err := vr.reader.Get(&DataKey{vr.data.DsetID, vr.data.ID, versionID, index})
+		if err != nil {
+			return nil, err
+		}
+		if value != nil {
+			if IsTombstone(value) {
+				return nil, nil
+			}
+			return value, nil
+		}
+	}
+	return nil, nil
+}
+
+// GetRange returns the key-value pairs for indices spanning (begIndex, endIndex) in
+// ascending index order, each taken from the nearest version holding the index.
+func (vr *VersionedReader) GetRange(begIndex, endIndex dvid.Index) ([]storage.KeyValue, error) {
+	if len(vr.versions) == 0 {
+		return []storage.KeyValue{}, nil
+	}
+	readVersion := vr.version
+	startKey := &DataKey{vr.data.DsetID, vr.data.ID, readVersion, begIndex}
+	endKey := &DataKey{vr.data.DsetID, vr.data.ID, readVersion, endIndex}
+	if len(vr.versions) == 1 {
+		keyvalues, err := vr.reader.GetRange(startKey, endKey)
+		if err != nil {
+			return nil, err
+		}
+		values := keyvalues[:0]
+		for _, kv := range keyvalues {
+			if !IsTombstone(kv.V) {
+				values = append(values, kv)
+			}
+		}
+		return values, nil
+	}
+
+	found := make(map[string]bool)
+	values := []storage.KeyValue{}
+	for _, versionID := range vr.versions {
+		startKey.Version, endKey.Version = versionID, versionID
+		keyvalues, err := vr.reader.GetRange(startKey, endKey)
+		if err != nil {
+			return nil, err
+		}
+		for _, kv := range keyvalues {
+			dataKey, ok := kv.K.(*DataKey)
+			if !ok {
+				return nil, fmt.Errorf("Can't convert Key (%s) to DataKey", kv.K)
+			}
+			indexStr := string(dataKey.Index.Bytes())
+			if found[indexStr] {
+				continue
+			}
+			found[indexStr] = true
+			if IsTombstone(kv.V) {
+				continue
+			}
+			key := &DataKey{dataKey.Dataset, dataKey.Data, readVersion, dataKey.Index}
+			values = append(values, storage.KeyValue{key, kv.V})
+		}
+	}
+	sort.Sort(storage.KeyValues(values))
+	return values, nil
+}
+
+// Delete removes an index at the read version.  If the index may be inherited from
+// an ancestor, a tombstone is written instead so the ancestor's value is hidden.
+func (vr *VersionedReader) Delete(db storage.KeyValueSetter, index dvid.Index) error {
+	key := &DataKey{vr.data.DsetID, vr.data.ID, vr.version, index}
+	if vr.Inherits() {
+		return db.Put(key, tombstone)
+	}
+	return db.Delete(key)
+}
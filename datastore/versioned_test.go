@@ -0,0 +1,95 @@
+package datastore
+
+import (
+	"encoding/gob"
+
+	. "github.com/janelia-flyem/go/gocheck"
+
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+func init() {
+	// Allow datasets holding test data to be persisted.
+	gob.Register(usageData{})
+}
+
+func (s *DataSuite) TestVersionedReader(c *C) {
+	service, _ := newTestService(c)
+	defer service.Shutdown()
+
+	root, datasetID, err := service.NewDataset()
+	c.Assert(err, IsNil)
+	dset, err := service.datasets.DatasetFromUUID(root)
+	c.Assert(err, IsNil)
+	id := &DataID{"mydata", 1, datasetID}
+	data := usageData{&Data{DataID: id}}
+	dset.DataMap = map[dvid.DataString]DataService{"mydata": data}
+
+	put := func(u dvid.UUID, index, value string) {
+		key := &DataKey{datasetID, 1, dset.VersionMap[u], dvid.IndexString(index)}
+		serialization, err := dvid.SerializeData([]byte(value), dvid.Snappy, dvid.CRC32)
+		c.Assert(err, IsNil)
+		c.Assert(service.db.Put(key, serialization), IsNil)
+	}
+	str := func(value []byte) string {
+		data, _, err := dvid.DeserializeData(value, true)
+		c.Assert(err, IsNil)
+		return string(data)
+	}
+	put(root, "a", "root a")
+	put(root, "b", "root b")
+	c.Assert(service.Lock(root), IsNil)
+	child, err := service.NewVersion(root)
+	c.Assert(err, IsNil)
+	put(child, "b", "child b")
+	put(child, "c", "child c")
+
+	// The child inherits keys it has not written.
+	reader, err := service.VersionedReader(child, "mydata", service.db)
+	c.Assert(err, IsNil)
+	c.Assert(reader.Inherits(), Equals, true)
+	value, err := reader.Get(dvid.IndexString("a"))
+	c.Assert(err, IsNil)
+	c.Assert(str(value), Equals, "root a")
+	value, err = reader.Get(dvid.IndexString("b"))
+	c.Assert(err, IsNil)
+	c.Assert(str(value), Equals, "child b")
+
+	values, err := reader.GetRange(dvid.IndexString("a"), dvid.IndexString("z"))
+	c.Assert(err, IsNil)
+	c.Assert(values, HasLen, 3)
+	expected := []string{"root a", "child b", "child c"}
+	for i, kv := range values {
+		c.Assert(str(kv.V), Equals, expected[i])
+		c.Assert(kv.K.(*DataKey).Version, Equals, dset.VersionMap[child])
+	}
+
+	// Deleting an inherited key in the child hides it without changing the root.
+	c.Assert(reader.Delete(service.db, dvid.IndexString("a")), IsNil)
+	value, err = reader.Get(dvid.IndexString("a"))
+	c.Assert(err, IsNil)
+	c.Assert(value, IsNil)
+	values, err = reader.GetRange(dvid.IndexString("a"), dvid.IndexString("z"))
+	c.Assert(err, IsNil)
+	c.Assert(values, HasLen, 2)
+
+	rootReader, err := service.VersionedReader(root, "mydata", service.db)
+	c.Assert(err, IsNil)
+	c.Assert(rootReader.Inherits(), Equals, false)
+	value, err = rootReader.Get(dvid.IndexString("a"))
+	c.Assert(err, IsNil)
+	c.Assert(str(value), Equals, "root a")
+
+	// Tombstones stored at a version without ancestors aren't returned either.
+	key := &DataKey{datasetID, 1, dset.VersionMap[root], dvid.IndexString("b")}
+	c.Assert(service.db.Put(key, tombstone), IsNil)
+	values, err = rootReader.GetRange(dvid.IndexString("a"), dvid.IndexString("z"))
+	c.Assert(err, IsNil)
+	c.Assert(values, HasLen, 1)
+	c.Assert(str(values[0].V), Equals, "root a")
+
+	// Tombstones are not reported as corrupt.
+	report, err := service.Verify(false)
+	c.Assert(err, IsNil)
+	c.Assert(report.NumCorrupt, Equals, 0)
+}
@@ -135,14 +135,14 @@ func (f File) Write(req *fuse.WriteRequest, resp *fuse.WriteResponse, intr fs.In
 		data = []byte{}
 	}
 	data = append(data, req.Data...)
-	err = f.Dir.Data.PutData(f.Dir.GetUUID(), f.keyStr, data)
+	err = f.Dir.Data.PutData(f.Dir.GetUUID(), nil, f.keyStr, data)
 	resp.Size = len(req.Data)
 	return nil
 }
 
 // Replace data
 func (f File) WriteAll(b []byte, intr fs.Intr) fuse.Error {
-	err := f.Dir.Data.PutData(f.Dir.GetUUID(), f.keyStr, b)
+	err := f.Dir.Data.PutData(f.Dir.GetUUID(), nil, f.keyStr, b)
 	if err != nil {
 		return fuse.EIO
 	}
@@ -48,6 +48,7 @@ $ dvid dataset <UUID> new keyvalue <data name> <settings...>
     Configuration Settings (case-insensitive keys)
 
     Versioned      "true" or "false" (default)
+    Compression    "none", "snappy" (default), "lz4", or "gzip[:<level 1-9>]"
 
 $ dvid node <UUID> <data name> get <key>
 
@@ -121,9 +122,11 @@ POST /api/node/<UUID>/<data name>/info
 
 GET  /api/node/<UUID>/<data name>/<key>[/<format>]
 POST /api/node/<UUID>/<data name>/<key>
-DEL  /api/node/<UUID>/<data name>/<key>  (TO DO)
+DELETE /api/node/<UUID>/<data name>/<key>
 
-    Retrieves or puts values given a key. 
+    Retrieves, puts, or deletes values given a key.  Values not written at a version
+    node are read from its nearest ancestor, and a delete hides any ancestor's value
+    from the node and its descendants.
 
     Example: 
 
@@ -140,6 +143,19 @@ DEL  /api/node/<UUID>/<data name>/<key>  (TO DO)
     UUID          Hexidecimal string with enough characters to uniquely identify a version node.
     data name     Name of data to add/retrieve.
     key           An alphanumeric key.
+
+
+GET  /api/node/<UUID>/<data name>/diff/<other UUID>
+
+    Returns JSON lists of the keys added, deleted, and modified going from the
+    version node UUID to the version node other UUID, e.g.,
+    {"Added": ["key1"], "Deleted": [], "Modified": ["key2"]}
+
+    Arguments:
+
+    UUID          Hexidecimal string with enough characters to uniquely identify a version node.
+    data name     Name of data to compare.
+    other UUID    Hexidecimal string identifying the version node to compare.
 `
 
 func init() {
@@ -194,21 +210,26 @@ type Data struct {
 	*datastore.Data
 }
 
-// GetData gets a value using a key at a given uuid
+// GetData gets a value using a key at a given uuid.  If the key was not written at
+// that version, the value is inherited from the nearest ancestor version holding it.
 func (d *Data) GetData(uuid dvid.UUID, keyStr string) ([]byte, error) {
-	// Compute the key
-	versionID, err := server.VersionLocalID(uuid)
+	db := server.StorageEngine()
+	if db == nil {
+		return nil, fmt.Errorf("Did not find a working key-value datastore to get data!")
+	}
+	reader, err := server.DatastoreService().VersionedReader(uuid, d.DataName(), db)
 	if err != nil {
 		return nil, err
 	}
-	key := d.DataKey(versionID, dvid.IndexString(keyStr))
 
 	// Get the data
-	db := server.StorageEngine()
-	data, err := db.Get(key)
+	data, err := reader.Get(dvid.IndexString(keyStr))
 	if err != nil {
 		return nil, fmt.Errorf("Key '%s' not present: %s\n", keyStr, err.Error())
 	}
+	if data == nil {
+		return nil, fmt.Errorf("Key '%s' not present\n", keyStr)
+	}
 	uncompress := true
 	value, _, err := dvid.DeserializeData(data, uncompress)
 	if err != nil {
@@ -217,10 +238,11 @@ func (d *Data) GetData(uuid dvid.UUID, keyStr string) ([]byte, error) {
 	return value, nil
 }
 
-// PutData puts a key/value at a given uuid
-func (d *Data) PutData(uuid dvid.UUID, keyStr string, value []byte) error {
+// PutData puts a key/value at a given uuid, which must not be locked.  The write is
+// counted by the token of the request making it.
+func (d *Data) PutData(uuid dvid.UUID, token *datastore.WriteToken, keyStr string, value []byte) error {
 	// Compute the key
-	versionID, err := server.VersionLocalID(uuid)
+	versionID, err := server.WritableVersion(uuid, token)
 	if err != nil {
 		return err
 	}
@@ -228,13 +250,65 @@ func (d *Data) PutData(uuid dvid.UUID, keyStr string, value []byte) error {
 
 	// PUT the file
 	db := server.StorageEngine()
-	serialization, err := dvid.SerializeData(value, dvid.Snappy, dvid.CRC32)
+	serialization, err := dvid.SerializeDataWith(value, d.Compressor(), dvid.CRC32)
 	if err != nil {
 		return fmt.Errorf("Unable to serialize data: %s\n", err.Error())
 	}
 	return db.Put(key, serialization)
 }
 
+// DeleteData deletes a key at a given uuid.  If an ancestor version holds the key, it
+// remains in the ancestor but is no longer visible at this version or its descendants.
+func (d *Data) DeleteData(uuid dvid.UUID, token *datastore.WriteToken, keyStr string) error {
+	db := server.StorageEngine()
+	if db == nil {
+		return fmt.Errorf("Did not find a working key-value datastore to delete data!")
+	}
+	if _, err := server.WritableVersion(uuid, token); err != nil {
+		return err
+	}
+	reader, err := server.DatastoreService().VersionedReader(uuid, d.DataName(), db)
+	if err != nil {
+		return err
+	}
+	return reader.Delete(db, dvid.IndexString(keyStr))
+}
+
+// KeyDiff lists the keys that differ between two versions.
+type KeyDiff struct {
+	Added    []string
+	Deleted  []string
+	Modified []string
+}
+
+// GetDiff returns JSON giving the keys added, deleted, and modified going from the
+// version with the given uuid to the other version.
+func (d *Data) GetDiff(uuid, other dvid.UUID) (string, error) {
+	firstKey, lastKey := d.VersionKeyRange(0)
+	diffs, err := server.DatastoreService().DiffVersions(uuid, other, d.DataName(),
+		firstKey.Index, lastKey.Index)
+	if err != nil {
+		return "", err
+	}
+	keyDiff := KeyDiff{[]string{}, []string{}, []string{}}
+	for _, diff := range diffs {
+		keyStr := string(diff.Index)
+		switch diff.Diff {
+		case datastore.IndexAdded:
+			keyDiff.Added = append(keyDiff.Added, keyStr)
+		case datastore.IndexDeleted:
+			keyDiff.Deleted = append(keyDiff.Deleted, keyStr)
+		case datastore.IndexModified:
+			keyDiff.Modified = append(keyDiff.Modified, keyStr)
+		}
+	}
+	m, err := json.Marshal(keyDiff)
+	if err != nil {
+		return "", err
+	}
+	return string(m), nil
+}
+
 // JSONString returns the JSON for this Data's configuration
 func (d *Data) JSONString() (jsonStr string, err error) {
 	m, err := json.Marshal(d)
@@ -287,6 +361,22 @@ func (d *Data) DoHTTP(uuid dvid.UUID, w http.ResponseWriter, r *http.Request) er
 		w.Header().Set("Content-Type", "application/json")
 		fmt.Fprintf(w, jsonStr)
 		return nil
+	case "diff":
+		if len(parts) < 5 || strings.ToLower(r.Method) != "get" {
+			break
+		}
+		other, err := server.MatchingUUID(parts[4])
+		if err != nil {
+			return err
+		}
+		jsonStr, err := d.GetDiff(uuid, other)
+		if err != nil {
+			return err
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, jsonStr)
+		dvid.ElapsedTime(dvid.Debug, startTime, "HTTP %s: diff with %s (%s)", r.Method, other, r.URL)
+		return nil
 	default:
 	}
 
@@ -311,14 +401,19 @@ func (d *Data) DoHTTP(uuid dvid.UUID, w http.ResponseWriter, r *http.Request) er
 		if err != nil {
 			return err
 		}
-		err = d.PutData(uuid, keyStr, data)
+		err = d.PutData(uuid, datastore.HTTPWriteToken(r), keyStr, data)
 		if err != nil {
 			return err
 		}
 		comment = fmt.Sprintf("POST %d bytes for data %s: key '%s', uuid %s\n",
 			len(data), d.DataName(), keyStr, uuid)
+	case "delete":
+		if err := d.DeleteData(uuid, datastore.HTTPWriteToken(r), keyStr); err != nil {
+			return err
+		}
+		comment = fmt.Sprintf("DELETE data %s: key '%s', uuid %s\n", d.DataName(), keyStr, uuid)
 	default:
-		return fmt.Errorf("Can only handle GET or POST HTTP verbs")
+		return fmt.Errorf("Can only handle GET, POST or DELETE HTTP verbs")
 	}
 
 	dvid.ElapsedTime(dvid.Debug, startTime, comment)
@@ -382,7 +477,7 @@ func (d *Data) Put(request datastore.Request, reply *datastore.Response) error {
 	if err != nil {
 		return err
 	}
-	err = d.PutData(uuid, keyStr, data)
+	err = d.PutData(uuid, request.Writes, keyStr, data)
 	dvid.ElapsedTime(dvid.Debug, startTime, "RPC put %d bytes -> key (%s) completed",
 		len(data), keyStr)
 	return err
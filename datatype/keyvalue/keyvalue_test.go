@@ -92,7 +92,7 @@ func (suite *DataSuite) TestRoundTrip(c *C) {
 	keyStr := "testkey"
 	value := []byte("I like Japan and this is some unicode: \u65e5\u672c\u8a9e")
 
-	err = kvdata.PutData(root, keyStr, value)
+	err = kvdata.PutData(root, nil, keyStr, value)
 	c.Assert(err, IsNil)
 
 	retrieved, err := kvdata.GetData(root, keyStr)
@@ -100,3 +100,82 @@ func (suite *DataSuite) TestRoundTrip(c *C) {
 
 	c.Assert(retrieved, DeepEquals, value)
 }
+
+func (suite *DataSuite) TestBranchInheritance(c *C) {
+	root, _, err := suite.service.NewDataset()
+	c.Assert(err, IsNil)
+
+	config := dvid.NewConfig()
+	config.SetVersioned(true)
+	err = suite.service.NewData(root, "keyvalue", "inherited", config)
+	c.Assert(err, IsNil)
+	kvservice, err := suite.service.DataService(root, "inherited")
+	c.Assert(err, IsNil)
+	kvdata, ok := kvservice.(*Data)
+	c.Assert(ok, Equals, true)
+
+	c.Assert(kvdata.PutData(root, nil, "shared", []byte("from root")), IsNil)
+	c.Assert(kvdata.PutData(root, nil, "changed", []byte("old value")), IsNil)
+	c.Assert(suite.service.Lock(root), IsNil)
+	child, err := suite.service.NewVersion(root)
+	c.Assert(err, IsNil)
+
+	// A freshly branched child sees all of its parent's values.
+	value, err := kvdata.GetData(child, "shared")
+	c.Assert(err, IsNil)
+	c.Assert(string(value), Equals, "from root")
+
+	c.Assert(kvdata.PutData(child, nil, "changed", []byte("new value")), IsNil)
+	c.Assert(kvdata.DeleteData(child, nil, "shared"), IsNil)
+
+	value, err = kvdata.GetData(child, "changed")
+	c.Assert(err, IsNil)
+	c.Assert(string(value), Equals, "new value")
+	_, err = kvdata.GetData(child, "shared")
+	c.Assert(err, NotNil)
+
+	// The parent is unchanged.
+	value, err = kvdata.GetData(root, "changed")
+	c.Assert(err, IsNil)
+	c.Assert(string(value), Equals, "old value")
+	value, err = kvdata.GetData(root, "shared")
+	c.Assert(err, IsNil)
+	c.Assert(string(value), Equals, "from root")
+}
+
+func (suite *DataSuite) TestDiff(c *C) {
+	root, _, err := suite.service.NewDataset()
+	c.Assert(err, IsNil)
+
+	config := dvid.NewConfig()
+	config.SetVersioned(true)
+	err = suite.service.NewData(root, "keyvalue", "diffed", config)
+	c.Assert(err, IsNil)
+	kvservice, err := suite.service.DataService(root, "diffed")
+	c.Assert(err, IsNil)
+	kvdata, ok := kvservice.(*Data)
+	c.Assert(ok, Equals, true)
+
+	c.Assert(kvdata.PutData(root, nil, "a", []byte("same")), IsNil)
+	c.Assert(kvdata.PutData(root, nil, "b", []byte("old value")), IsNil)
+	c.Assert(kvdata.PutData(root, nil, "c", []byte("deleted")), IsNil)
+	c.Assert(suite.service.Lock(root), IsNil)
+	child, err := suite.service.NewVersion(root)
+	c.Assert(err, IsNil)
+
+	c.Assert(kvdata.PutData(child, nil, "b", []byte("new value")), IsNil)
+	c.Assert(kvdata.DeleteData(child, nil, "c"), IsNil)
+	c.Assert(kvdata.PutData(child, nil, "d", []byte("added")), IsNil)
+
+	jsonStr, err := kvdata.GetDiff(root, child)
+	c.Assert(err, IsNil)
+	c.Assert(jsonStr, Equals, `{"Added":["d"],"Deleted":["c"],"Modified":["b"]}`)
+
+	jsonStr, err = kvdata.GetDiff(child, root)
+	c.Assert(err, IsNil)
+	c.Assert(jsonStr, Equals, `{"Added":["c"],"Deleted":["d"],"Modified":["b"]}`)
+
+	jsonStr, err = kvdata.GetDiff(child, child)
+	c.Assert(err, IsNil)
+	c.Assert(jsonStr, Equals, `{"Added":[],"Deleted":[],"Modified":[]}`)
+}
@@ -14,6 +14,7 @@ import (
 	"io"
 	"net/http"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -55,6 +56,7 @@ $ dvid dataset <UUID> new labelmap <data name> <settings...>
 
     Labels           Name of labels64 data for which this is a label mapping. (required)
     Versioned        "true" or "false" (default)
+    Compression      "none", "snappy" (default), "lz4", or "gzip[:<level 1-9>]"
 
 $ dvid node <UUID> <data name> load raveler <superpixel-to-segment filename> <segment-to-body filename>
 
@@ -158,6 +160,19 @@ GET /api/node/<UUID>/<data name>/sizerange/<min size>/<max size>
     max size      Maximum # of voxels.
 
 
+GET /api/node/<UUID>/<data name>/diff/<other UUID>
+
+    Returns JSON list of labels whose forward mapping differs between the two versions,
+    e.g., [{"Label": 23, "From": 7, "To": 9}].  "From" is the mapping at UUID and "To"
+    is the mapping at other UUID.  A null mapping means the label is unmapped.
+	
+    Arguments:
+
+    UUID          Hexidecimal string with enough characters to uniquely identify a version node.
+    data name     Name of mapping data.
+    other UUID    Hexidecimal string identifying the version node to compare.
+
+
 TODO:
 
 GET /api/node/<UUID>/<data name>/mapped/<min bound>/<max bound>
@@ -358,6 +373,11 @@ func (d *Data) JSONString() (jsonStr string, err error) {
 	return string(m), nil
 }
 
+// References returns the mapped labels, which can't be deleted while this data exists.
+func (d *Data) References() []dvid.DataString {
+	return []dvid.DataString{d.Labels}
+}
+
 // --- DataService interface ---
 
 // DoRPC acts as a switchboard for RPC commands.
@@ -491,6 +511,26 @@ func (d *Data) DoHTTP(uuid dvid.UUID, w http.ResponseWriter, r *http.Request) er
 		dvid.ElapsedTime(dvid.Debug, startTime, "HTTP %s: get labels with volume > %d and < %d (%s)",
 			r.Method, minSize, maxSize, r.URL)
 
+	case "diff":
+		// GET /api/node/<UUID>/<data name>/diff/<other UUID>
+		if len(parts) < 5 {
+			err := fmt.Errorf("ERROR: DVID requires other UUID to follow 'diff' command")
+			server.BadRequest(w, r, err.Error())
+			return err
+		}
+		other, err := server.MatchingUUID(parts[4])
+		if err != nil {
+			server.BadRequest(w, r, err.Error())
+			return err
+		}
+		jsonStr, err := d.GetMappingDiff(uuid, other)
+		if err != nil {
+			return err
+		}
+		w.Header().Set("Content-type", "application/json")
+		fmt.Fprintf(w, jsonStr)
+		dvid.ElapsedTime(dvid.Debug, startTime, "HTTP %s: diff with %s (%s)", r.Method, other, r.URL)
+
 	default:
 	}
 
@@ -606,6 +646,39 @@ func (d *Data) NewLabelSizesKey(vID dvid.VersionLocalID, size, label uint64) *da
 	return d.DataKey(vID, dvid.IndexBytes(index))
 }
 
+// deleteKeyType removes all key-value pairs of a given labelmap KeyType for a version.
+func (d *Data) deleteKeyType(db storage.KeyValueSetter, vID dvid.VersionLocalID, t KeyType) error {
+	firstKey := d.DataKey(vID, dvid.IndexBytes{byte(t)})
+	lastKey := d.DataKey(vID, dvid.IndexBytes{byte(t) + 1})
+	return db.DeleteRange(firstKey, lastKey)
+}
+
+// VerifyValue checks a stored value during datastore verification.  Label maps and
+// size indices have empty values while label spatial maps hold encoded runs.
+func (d *Data) VerifyValue(key *datastore.DataKey, value []byte) error {
+	index := key.Index.Bytes()
+	if len(index) == 0 {
+		return fmt.Errorf("Labelmap key has no index")
+	}
+	t := KeyType(index[0])
+	switch t {
+	case KeyInverseMap, KeyForwardMap, KeySpatialMap, KeyLabelSizes:
+		if len(value) != 0 {
+			return fmt.Errorf("%s key has %d byte value, expected none", t, len(value))
+		}
+	case KeyLabelSpatialMap:
+		if len(value) == 0 {
+			return fmt.Errorf("%s key has no runs", t)
+		}
+		if _, _, err := statsRuns(value); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("Unknown labelmap key type %d", index[0])
+	}
+	return nil
+}
+
 type sparseOp struct {
 	versionID dvid.VersionLocalID
 	encoding  []byte
@@ -674,51 +747,50 @@ func statsRuns(encoding []byte) (numVoxels, numRuns int32, err error) {
 	return
 }
 
-// Runs asynchronously and assumes that sparse volumes per spatial indices are ordered
-// by mapped label, i.e., we will get all data for body N before body N+1.  Exits when
-// receives a nil in channel.
-func (d *Data) computeSizes(sizeCh chan *storage.Chunk, db storage.Engine,
-	versionID dvid.VersionLocalID, wg *sync.WaitGroup) {
-
+// computeSizes iterates through the sparse volumes of all mapped labels and stores
+// the size index.  Since spatial indices are ordered by mapped label, we get all data
+// for body N before body N+1 and only need to track the current label.
+func (d *Data) computeSizes(db storage.Engine, versionID dvid.VersionLocalID) error {
 	const BATCH_SIZE = 10000
 	batcher, ok := db.(storage.Batcher)
 	if !ok {
-		dvid.Log(dvid.Normal, "Storage engine does not support Batch PUT.  Aborting\n")
-		return
+		return fmt.Errorf("Storage engine does not support Batch PUT")
 	}
 	batch := batcher.NewBatch()
+	defer batch.Close()
 
-	defer func() {
-		wg.Done()
-		batch.Close()
-	}()
+	// Remove any sizes from a previous computation.
+	if err := d.deleteKeyType(db, versionID, KeyLabelSizes); err != nil {
+		return err
+	}
+
+	startKey := d.NewLabelSpatialMapKey(versionID, 0, dvid.MinIndexZYX)
+	endKey := d.NewLabelSpatialMapKey(versionID, MaxLabel, dvid.MaxIndexZYX)
+	it, err := db.NewIterator(startKey, endKey, nil)
+	if err != nil {
+		return err
+	}
+	defer it.Close()
 
 	// Sequentially process all the sparse volume data for each label
 	var curLabel, curSize uint64
 	putsInBatch := 0
-	notFirst := true
-	for {
-		chunk := <-sizeCh
-		if chunk == nil {
-			key := d.NewLabelSizesKey(versionID, curSize, curLabel)
-			batch.Put(key, emptyValue)
-			if err := batch.Commit(); err != nil {
-				dvid.Log(dvid.Normal, "Error on batch PUT of label sizes for %s: %s\n",
-					d.DataName(), err.Error())
-			}
-			return
+	notFirst := false
+	for ; it.Valid(); it.Next() {
+		key, err := it.Key()
+		if err != nil {
+			return err
 		}
 
 		// Get label associated with this sparse volume.
-		dataKey := chunk.K.(*datastore.DataKey)
+		dataKey := key.(*datastore.DataKey)
 		indexBytes := dataKey.Index.Bytes()
-		label := binary.LittleEndian.Uint64(indexBytes[1:9])
+		label := binary.BigEndian.Uint64(indexBytes[1:9])
 
 		// Compute the size
-		numVoxels, _, err := statsRuns(chunk.V)
+		numVoxels, _, err := statsRuns(it.Value())
 		if err != nil {
-			dvid.Log(dvid.Normal, "Error on computing label sizes: %s\n", err.Error())
-			return
+			return fmt.Errorf("Error on computing label sizes: %s", err.Error())
 		}
 
 		// If we are a new label, store size
@@ -729,16 +801,28 @@ func (d *Data) computeSizes(sizeCh chan *storage.Chunk, db storage.Engine,
 			putsInBatch++
 			if putsInBatch%BATCH_SIZE == 0 {
 				if err := batch.Commit(); err != nil {
-					dvid.Log(dvid.Normal, "Error on batch PUT of label sizes for %s: %s\n",
+					return fmt.Errorf("Error on batch PUT of label sizes for %s: %s",
 						d.DataName(), err.Error())
-					return
 				}
+				batch.Clear()
 			}
 		}
 		curLabel = label
 		curSize += uint64(numVoxels)
 		notFirst = true
 	}
+	if err := it.Error(); err != nil {
+		return err
+	}
+	if notFirst {
+		key := d.NewLabelSizesKey(versionID, curSize, curLabel)
+		batch.Put(key, emptyValue)
+	}
+	if err := batch.Commit(); err != nil {
+		return fmt.Errorf("Error on batch PUT of label sizes for %s: %s",
+			d.DataName(), err.Error())
+	}
+	return nil
 }
 
 // GetSizeRange returns a JSON list of mapped labels that have volumes within the given range.
@@ -772,6 +856,55 @@ func (d *Data) GetSizeRange(uuid dvid.UUID, minSize, maxSize uint64) (string, er
 	return string(m), nil
 }
 
+// MappingChange gives the forward mapping of a label at two versions.  A nil mapping
+// means the label is not mapped at that version.
+type MappingChange struct {
+	Label uint64
+	From  *uint64
+	To    *uint64
+}
+
+// GetMappingDiff returns a JSON list of labels whose forward mapping differs between
+// the versions with the given UUIDs.
+func (d *Data) GetMappingDiff(uuid, other dvid.UUID) (string, error) {
+	firstIndex := dvid.IndexBytes{byte(KeyForwardMap)}
+	lastIndex := dvid.IndexBytes{byte(KeyForwardMap) + 1}
+	diffs, err := server.DatastoreService().DiffVersions(uuid, other, d.DataName(),
+		firstIndex, lastIndex)
+	if err != nil {
+		return "", err
+	}
+
+	// Since a label has one mapping per version, a changed mapping is a deleted
+	// forward map key followed by an added one.
+	changes := []MappingChange{}
+	changeIndex := make(map[uint64]int)
+	for _, diff := range diffs {
+		if len(diff.Index) != 17 {
+			return "", fmt.Errorf("Bad forward map index: %x", []byte(diff.Index))
+		}
+		label := binary.BigEndian.Uint64(diff.Index[1:9])
+		mapping := binary.BigEndian.Uint64(diff.Index[9:17])
+		i, found := changeIndex[label]
+		if !found {
+			i = len(changes)
+			changeIndex[label] = i
+			changes = append(changes, MappingChange{Label: label})
+		}
+		switch diff.Diff {
+		case datastore.IndexAdded:
+			changes[i].To = &mapping
+		case datastore.IndexDeleted:
+			changes[i].From = &mapping
+		}
+	}
+	m, err := json.Marshal(changes)
+	if err != nil {
+		return "", err
+	}
+	return string(m), nil
+}
+
 // GetLabelAtPoint returns a mapped label for a given point.
 func (d *Data) GetLabelAtPoint(uuid dvid.UUID, pt dvid.Point) (uint64, error) {
 	db, versionID, _, err := d.getHooks(uuid)
@@ -881,11 +1014,15 @@ func (d *Data) LoadRavelerMaps(request datastore.Request, reply *datastore.Respo
 	var uuidStr, dataName, cmdStr, fileTypeStr, spsegStr, segbodyStr string
 	request.CommandArgs(1, &uuidStr, &dataName, &cmdStr, &fileTypeStr, &spsegStr, &segbodyStr)
 
-	// Get the version
+	// Get the version, which must be writable.
 	uuid, err := server.MatchingUUID(uuidStr)
 	if err != nil {
 		return err
 	}
+	versionID, err := server.WritableVersion(uuid, request.Writes)
+	if err != nil {
+		return err
+	}
 
 	startTime := time.Now()
 
@@ -912,18 +1049,24 @@ func (d *Data) LoadRavelerMaps(request datastore.Request, reply *datastore.Respo
 	}
 
 	// Prepare for datastore access
-	versionID, err := server.VersionLocalID(uuid)
-	if err != nil {
+	db := server.StorageEngine()
+
+	// Replace any mappings from a previous load.
+	if err := d.deleteKeyType(db, versionID, KeyForwardMap); err != nil {
 		return err
 	}
-	db := server.StorageEngine()
+	if err := d.deleteKeyType(db, versionID, KeyInverseMap); err != nil {
+		return err
+	}
+
+	// Collect a bounded chunk of mappings at a time.  If the engine supports bulk
+	// loading, each chunk is written in sorted order, else it is written in a
+	// transaction so forward and inverse mappings are always written together.
+	bulkLoad := db.IsBulkIniter() || db.IsBulkWriter()
+	var mappings storage.KeyValues
 
 	var slice, superpixel32 uint32
 	var segment, body uint64
-	forwardIndex := make([]byte, 17)
-	forwardIndex[0] = byte(KeyForwardMap)
-	inverseIndex := make([]byte, 17)
-	inverseIndex[0] = byte(KeyInverseMap)
 
 	// Get the sp->seg map, persisting each computed sp->body.
 	dvid.Log(dvid.Normal, "Processing superpixel->segment map (Z %d-%d): %s\n",
@@ -964,31 +1107,50 @@ func (d *Data) LoadRavelerMaps(request datastore.Request, reply *datastore.Respo
 			return fmt.Errorf("Segment (%d) in %s not found in %s", segment, spsegStr, segbodyStr)
 		}
 
-		// PUT the forward label pair without compression.
+		// Key for the forward label pair, stored without compression.
+		forwardIndex := make([]byte, 17)
+		forwardIndex[0] = byte(KeyForwardMap)
 		copy(forwardIndex[1:9], superpixelBytes)
 		binary.BigEndian.PutUint64(forwardIndex[9:17], body)
-		key := d.DataKey(versionID, dvid.IndexBytes(forwardIndex))
-		err = db.Put(key, emptyValue)
-		if err != nil {
-			return fmt.Errorf("ERROR on PUT of forward label mapping (%x -> %d): %s\n",
-				superpixelBytes, body, err.Error())
-		}
+		forwardKey := d.DataKey(versionID, dvid.IndexBytes(forwardIndex))
 
-		// PUT the inverse label pair without compression.
+		// Key for the inverse label pair, stored without compression.
+		inverseIndex := make([]byte, 17)
+		inverseIndex[0] = byte(KeyInverseMap)
 		binary.BigEndian.PutUint64(inverseIndex[1:9], body)
 		copy(inverseIndex[9:17], superpixelBytes)
-		key = d.DataKey(versionID, dvid.IndexBytes(inverseIndex))
-		err = db.Put(key, emptyValue)
-		if err != nil {
-			return fmt.Errorf("ERROR on PUT of inverse label mapping (%d -> %x): %s\n",
-				body, superpixelBytes, err.Error())
-		}
+		inverseKey := d.DataKey(versionID, dvid.IndexBytes(inverseIndex))
+
+		mappings = append(mappings, storage.KeyValue{forwardKey, emptyValue},
+			storage.KeyValue{inverseKey, emptyValue})
 
 		linenum++
+		if bulkLoad && len(mappings) >= 2*mappingsPerTxn {
+			if err := bulkLoadMappings(db, mappings); err != nil {
+				return fmt.Errorf("ERROR on bulk load of label mappings: %s\n", err.Error())
+			}
+			mappings = mappings[:0]
+		}
+		if !bulkLoad && len(mappings) >= 2*mappingsPerTxn {
+			if err := putMappings(db, mappings); err != nil {
+				return fmt.Errorf("ERROR on PUT of label mappings: %s\n", err.Error())
+			}
+			mappings = mappings[:0]
+		}
 		if linenum%1000000 == 0 {
 			fmt.Printf("Added %d forward and inverse mappings\n", linenum)
 		}
 	}
+	if bulkLoad && len(mappings) > 0 {
+		if err := bulkLoadMappings(db, mappings); err != nil {
+			return fmt.Errorf("ERROR on bulk load of label mappings: %s\n", err.Error())
+		}
+	}
+	if !bulkLoad && len(mappings) > 0 {
+		if err := putMappings(db, mappings); err != nil {
+			return fmt.Errorf("ERROR on PUT of label mappings: %s\n", err.Error())
+		}
+	}
 	dvid.Log(dvid.Normal, "Added %d forward and inverse mappings\n", linenum)
 	dvid.ElapsedTime(dvid.Normal, startTime, "Processed Raveler superpixel->body files")
 
@@ -998,6 +1160,51 @@ func (d *Data) LoadRavelerMaps(request datastore.Request, reply *datastore.Respo
 	return nil
 }
 
+// Number of forward and inverse mapping pairs written per transaction, or per
+// sorted chunk when the engine can bulk load.
+const mappingsPerTxn = 10000
+
+// putMappings writes pairs of forward and inverse mappings in one transaction.  Each
+// pair is only written if the forward mapping is not already stored, and the pairs are
+// rewritten if another transaction changes any of the mappings before commit.
+func putMappings(db storage.Engine, mappings storage.KeyValues) error {
+	return storage.UpdateTxn(db, func(txn *storage.Txn) error {
+		for i := 0; i+1 < len(mappings); i += 2 {
+			forward, inverse := mappings[i], mappings[i+1]
+			prior, err := txn.Get(forward.K)
+			if err != nil {
+				return err
+			}
+			if prior != nil {
+				continue
+			}
+			priorInverse, err := txn.Get(inverse.K)
+			if err != nil {
+				return err
+			}
+			txn.PutIf(forward.K, nil, forward.V)
+			txn.PutIf(inverse.K, priorInverse, inverse.V)
+		}
+		return nil
+	})
+}
+
+// bulkLoadMappings sorts label mappings and writes them with the engine's bulk loader.
+func bulkLoadMappings(db storage.Engine, mappings storage.KeyValues) error {
+	sort.Sort(mappings)
+	loader, err := storage.NewBulkLoader(db, mappings[0].K, mappings[len(mappings)-1].K)
+	if err != nil {
+		return err
+	}
+	defer loader.Close()
+	for _, kv := range mappings {
+		if err := loader.Put(kv.K, kv.V); err != nil {
+			return err
+		}
+	}
+	return loader.Commit()
+}
+
 // ApplyLabelMap creates a new labels64 by applying a label map to existing labels64 data.
 func (d *Data) ApplyLabelMap(request datastore.Request, reply *datastore.Response) error {
 
@@ -1007,11 +1214,15 @@ func (d *Data) ApplyLabelMap(request datastore.Request, reply *datastore.Respons
 	var uuidStr, dataName, cmdStr, sourceName, destName string
 	request.CommandArgs(1, &uuidStr, &dataName, &cmdStr, &sourceName, &destName)
 
-	// Get the version
+	// Get the version, which must be writable.
 	uuid, err := server.MatchingUUID(uuidStr)
 	if err != nil {
 		return err
 	}
+	versionID, err := server.WritableVersion(uuid, request.Writes)
+	if err != nil {
+		return err
+	}
 
 	// Get the source labels64 data.
 	labels, err := labels64.Get(uuid, dvid.DataString(sourceName))
@@ -1036,16 +1247,17 @@ func (d *Data) ApplyLabelMap(request datastore.Request, reply *datastore.Respons
 	}
 
 	// Prepare for datastore access
-	versionID, err := server.VersionLocalID(uuid)
-	if err != nil {
-		return err
-	}
 	db := server.StorageEngine()
 
+	// Read the source labels and mappings from one point in time so concurrent writes
+	// don't give a partially mapped result.
+	snapshot := storage.ReadSnapshot(db)
+	defer snapshot.Close()
+
 	// Iterate through all labels chunks incrementally in Z, loading and then using the maps
 	// for all blocks in that layer.
 	wg := new(sync.WaitGroup)
-	op := &blockOp{labels, dest, versionID, nil}
+	op := &blockOp{source: labels, mapped: dest, versionID: versionID, reader: snapshot}
 
 	dataID := labels.DataID()
 	extents := labels.Extents()
@@ -1068,8 +1280,14 @@ func (d *Data) ApplyLabelMap(request datastore.Request, reply *datastore.Respons
 			startKey := &datastore.DataKey{dataID.DsetID, dataID.ID, versionID, minIndex}
 			endKey := &datastore.DataKey{dataID.DsetID, dataID.ID, versionID, maxIndex}
 			chunkOp := &storage.ChunkOp{op, wg}
-			err = db.ProcessRange(startKey, endKey, chunkOp, d.ChunkApplyMap)
+			err = snapshot.ProcessRange(startKey, endKey, chunkOp, d.ChunkApplyMap)
 			wg.Wait()
+			if err == nil {
+				err = op.Err()
+			}
+			if err != nil {
+				return fmt.Errorf("Error mapping %s blocks for block Z %d: %s", sourceName, z, err.Error())
+			}
 		}
 
 		dvid.ElapsedTime(dvid.Debug, t, "Processed all %s blocks for layer %d/%d",
@@ -1168,13 +1386,8 @@ func (d *Data) GetBlockLayerMapping(blockZ uint32, op *blockOp) (
 	// Get all forward mappings from the key-value store.
 	op.mapping = nil
 
-	db := server.StorageEngine()
-	if db == nil {
-		err = fmt.Errorf("Did not find a working key-value datastore to get image!")
-		return
-	}
 	var keys []storage.Key
-	keys, err = db.KeysInRange(firstKey, lastKey)
+	keys, err = op.reader.KeysInRange(firstKey, lastKey)
 	if err != nil {
 		err = fmt.Errorf("Could not find mapping with slice between %d and %d: %s",
 			minZ, maxZ, err.Error())
@@ -1201,6 +1414,12 @@ type blockOp struct {
 	mapped    *labels64.Data
 	versionID dvid.VersionLocalID
 	mapping   map[string]uint64
+
+	// reader is used for all reads of mappings and source blocks.
+	reader storage.KeyValueGetter
+
+	// First error encountered by any chunk handler for this operation.
+	storage.OpError
 }
 
 // Iterate through all blocks in the associated label volume, computing the spatial indices
@@ -1219,7 +1438,7 @@ func (d *Data) ProcessSpatially(uuid dvid.UUID) {
 	// for all blocks in that layer.
 	startTime := time.Now()
 	wg := new(sync.WaitGroup)
-	op := &blockOp{labels, nil, versionID, nil}
+	op := &blockOp{source: labels, versionID: versionID, reader: db}
 
 	dataID := labels.DataID()
 	extents := labels.Extents()
@@ -1245,6 +1464,14 @@ func (d *Data) ProcessSpatially(uuid dvid.UUID) {
 			chunkOp := &storage.ChunkOp{op, wg}
 			err = db.ProcessRange(startKey, endKey, chunkOp, d.ProcessChunk)
 			wg.Wait()
+			if err == nil {
+				err = op.Err()
+			}
+			if err != nil {
+				dvid.Log(dvid.Normal, "Error indexing %s blocks for block Z %d: %s\n",
+					d.Labels, z, err.Error())
+				return
+			}
 		}
 
 		dvid.ElapsedTime(dvid.Debug, t, "Processed all %s blocks for layer %d/%d",
@@ -1255,20 +1482,10 @@ func (d *Data) ProcessSpatially(uuid dvid.UUID) {
 
 	// Iterate through all mapped labels and determine the size in voxels.
 	startTime = time.Now()
-	startKey := d.NewLabelSpatialMapKey(versionID, 0, dvid.MinIndexZYX)
-	endKey := d.NewLabelSpatialMapKey(versionID, MaxLabel, dvid.MaxIndexZYX)
-	sizeCh := make(chan *storage.Chunk, 10000)
-	wg.Add(1)
-	go d.computeSizes(sizeCh, db, versionID, wg)
-	err = db.ProcessRange(startKey, endKey, &storage.ChunkOp{}, func(chunk *storage.Chunk) {
-		sizeCh <- chunk
-	})
-	if err != nil {
+	if err := d.computeSizes(db, versionID); err != nil {
 		dvid.Log(dvid.Normal, "Error indexing sizes for %s: %s\n", d.DataName(), err.Error())
 		return
 	}
-	sizeCh <- nil
-	wg.Wait()
 	dvid.ElapsedTime(dvid.Debug, startTime,
 		"Created size index for mapping '%s' applied to labels '%s'",
 		d.DataName(), d.Labels)
@@ -1303,7 +1520,7 @@ func (d *Data) chunkApplyMap(chunk *storage.Chunk) {
 	op := chunk.Op.(*blockOp)
 	db := server.StorageEngine()
 	if db == nil {
-		dvid.Log(dvid.Normal, "Did not find a working key-value datastore to get image!")
+		op.SetError(fmt.Errorf("Did not find a working key-value datastore to get image!"))
 		return
 	}
 
@@ -1314,13 +1531,13 @@ func (d *Data) chunkApplyMap(chunk *storage.Chunk) {
 	// Initialize the label buffers.  For voxels, this data needs to be uncompressed and deserialized.
 	blockData, _, err := dvid.DeserializeData(chunk.V, true)
 	if err != nil {
-		dvid.Log(dvid.Normal, "Unable to deserialize block in '%s': %s\n",
-			d.DataID.DataName(), err.Error())
+		op.SetError(fmt.Errorf("Unable to deserialize block in '%s': %s",
+			d.DataID.DataName(), err.Error()))
 		return
 	}
 	blockBytes := len(blockData)
 	if blockBytes%8 != 0 {
-		dvid.Log(dvid.Normal, "Retrieved, deserialized block is wrong size: %d bytes\n", blockBytes)
+		op.SetError(fmt.Errorf("Retrieved, deserialized block is wrong size: %d bytes", blockBytes))
 		return
 	}
 	mappedData := make([]byte, blockBytes, blockBytes)
@@ -1356,12 +1573,14 @@ func (d *Data) chunkApplyMap(chunk *storage.Chunk) {
 		Version: op.versionID,
 		Index:   dataKey.Index,
 	}
-	serialization, err := dvid.SerializeData(mappedData, dvid.Snappy, dvid.CRC32)
+	serialization, err := dvid.SerializeDataWith(mappedData, op.mapped.Compressor(), dvid.CRC32)
 	if err != nil {
-		dvid.Log(dvid.Normal, "Unable to serialize block: %s\n", err.Error())
+		op.SetError(fmt.Errorf("Unable to serialize block: %s", err.Error()))
 		return
 	}
-	db.Put(mappedKey, serialization)
+	if err := db.Put(mappedKey, serialization); err != nil {
+		op.SetError(err)
+	}
 }
 
 // ProcessChunk processes a chunk of data as part of a mapped operation.
@@ -1386,12 +1605,9 @@ func (d *Data) processChunk(chunk *storage.Chunk) {
 	op := chunk.Op.(*blockOp)
 	db := server.StorageEngine()
 	if db == nil {
-		dvid.Log(dvid.Normal, "Did not find a working key-value datastore to get image!")
+		op.SetError(fmt.Errorf("Did not find a working key-value datastore to get image!"))
 		return
 	}
-	batcher, ok := db.(storage.Batcher)
-	batch := batcher.NewBatch()
-
 	// Get the spatial index associated with this chunk.
 	dataKey := chunk.K.(*datastore.DataKey)
 	zyx := dataKey.Index.(*dvid.IndexZYX)
@@ -1400,8 +1616,8 @@ func (d *Data) processChunk(chunk *storage.Chunk) {
 	// Initialize the label buffer.  For voxels, this data needs to be uncompressed and deserialized.
 	blockData, _, err := dvid.DeserializeData(chunk.V, true)
 	if err != nil {
-		dvid.Log(dvid.Normal, "Unable to deserialize block in '%s': %s\n",
-			d.DataID.DataName(), err.Error())
+		op.SetError(fmt.Errorf("Unable to deserialize block in '%s': %s",
+			d.DataID.DataName(), err.Error()))
 		return
 	}
 
@@ -1415,10 +1631,12 @@ func (d *Data) processChunk(chunk *storage.Chunk) {
 	// Iterate through this block of labels.
 	blockBytes := len(blockData)
 	if blockBytes%8 != 0 {
-		dvid.Log(dvid.Normal, "Retrieved, deserialized block is wrong size: %d bytes\n", blockBytes)
+		op.SetError(fmt.Errorf("Retrieved, deserialized block is wrong size: %d bytes", blockBytes))
 		return
 	}
 	written := make(map[string]bool, blockBytes/10)
+	var spatialKeys []storage.Key
+	mapped := make(map[string]uint64, 10)
 	runStarts := make(map[uint64]([]dvid.Point3d), 10)
 	runLengths := make(map[uint64]([]int32), 10)
 
@@ -1426,6 +1644,7 @@ func (d *Data) processChunk(chunk *storage.Chunk) {
 	lastPt := zyx.LastPoint(op.source.BlockSize()).(dvid.Point3d)
 	var curPt dvid.Point3d
 	var b, curLabel uint64
+	var ok bool
 	var z, y, x, curRun int32
 	start := 0
 	for z = firstPt.Value(2); z <= lastPt.Value(2); z++ {
@@ -1474,9 +1693,13 @@ func (d *Data) processChunk(chunk *storage.Chunk) {
 				binary.BigEndian.PutUint64(sabIndex[offsetSAB+8:offsetSAB+16], b)
 				_, found := written[string(sabIndex)]
 				if !found {
-					key := d.DataKey(op.versionID, dvid.IndexBytes(sabIndex))
-					batch.Put(key, emptyValue)
+					index := make([]byte, len(sabIndex))
+					copy(index, sabIndex)
+					spatialKeys = append(spatialKeys, d.DataKey(op.versionID, dvid.IndexBytes(index)))
 					written[string(sabIndex)] = true
+					if _, ok := op.mapping[string(a)]; ok && !zeroToken {
+						mapped[string(a)] = b
+					}
 				}
 
 				start += 8
@@ -1488,32 +1711,45 @@ func (d *Data) processChunk(chunk *storage.Chunk) {
 			}
 		}
 	}
-	if err := batch.Commit(); err != nil {
-		dvid.Log(dvid.Normal, "Error on batch PUT of KeySpatialMap on %s: %s\n",
-			dataKey.Index, err.Error())
-		return
-	}
-	batch.Clear()
-
-	// Store the KeyLabelSpatialMap keys (index = b + s) with slice of runs for value.
-	bsIndex := make([]byte, 1+8+dvid.IndexZYXSize)
-	bsIndex[0] = byte(KeyLabelSpatialMap)
-	copy(bsIndex[9:9+dvid.IndexZYXSize], zyxBytes)
+	// Construct the KeyLabelSpatialMap keys (index = b + s) with slice of runs for value.
+	var runs storage.KeyValues
 	for b, coords := range runStarts {
+		bsIndex := make([]byte, 1+8+dvid.IndexZYXSize)
+		bsIndex[0] = byte(KeyLabelSpatialMap)
 		binary.BigEndian.PutUint64(bsIndex[1:9], b)
-		key := d.DataKey(op.versionID, dvid.IndexBytes(bsIndex))
+		copy(bsIndex[9:9+dvid.IndexZYXSize], zyxBytes)
 		runsBytes, err := encodeRuns(coords, runLengths[b])
 		if err != nil {
-			dvid.Log(dvid.Normal, "Error encoding KeyLabelSpatialMap keys for mapped label %d: %s\n",
-				b, err.Error())
+			op.SetError(fmt.Errorf("Error encoding KeyLabelSpatialMap keys for mapped label %d: %s",
+				b, err.Error()))
 			return
 		}
-		batch.Put(key, runsBytes)
+		runs = append(runs, storage.KeyValue{d.DataKey(op.versionID, dvid.IndexBytes(bsIndex)), runsBytes})
 	}
-	if err := batch.Commit(); err != nil {
-		dvid.Log(dvid.Normal, "Error on batch PUT of KeyLabelSpatialMap on %s: %s\n",
-			dataKey.Index, err.Error())
-		return
+
+	// The spatial index keys for this block are written in one transaction so the
+	// KeySpatialMap and KeyLabelSpatialMap key spaces never diverge, and only if the
+	// forward mappings used to index the block are still stored.
+	err = storage.UpdateTxn(db, func(txn *storage.Txn) error {
+		for a, b := range mapped {
+			value, err := txn.Get(d.NewForwardMapKey(op.versionID, []byte(a), b))
+			if err != nil {
+				return err
+			}
+			if value == nil {
+				return fmt.Errorf("Mapping of label %x to %d changed during indexing", a, b)
+			}
+		}
+		for _, key := range spatialKeys {
+			txn.Put(key, emptyValue)
+		}
+		for _, kv := range runs {
+			txn.Put(kv.K, kv.V)
+		}
+		return nil
+	})
+	if err != nil {
+		op.SetError(fmt.Errorf("Error on PUT of spatial index keys on %s: %s",
+			dataKey.Index, err.Error()))
 	}
-	batch.Close()
 }
@@ -1,12 +1,16 @@
 package labelmap
 
 import (
+	"bytes"
+	"encoding/binary"
 	. "github.com/janelia-flyem/go/gocheck"
 	"testing"
 
 	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/datatype/labels64"
 	"github.com/janelia-flyem/dvid/dvid"
 	"github.com/janelia-flyem/dvid/server"
+	"github.com/janelia-flyem/dvid/storage"
 )
 
 // Hook up gocheck into the "go test" runner.
@@ -39,6 +43,108 @@ func (suite *DataSuite) TearDownSuite(c *C) {
 	suite.service.Shutdown()
 }
 
+// Storage failures while mapping blocks should be returned from ApplyLabelMap.
+func (suite *DataSuite) TestApplyLabelMapFaults(c *C) {
+	root, _, err := suite.service.NewDataset()
+	c.Assert(err, IsNil)
+	config := dvid.NewConfig()
+	c.Assert(suite.service.NewData(root, "labels64", "labels", config), IsNil)
+	c.Assert(suite.service.NewData(root, "labels64", "mapped", config), IsNil)
+	config["labels"] = "labels"
+	c.Assert(suite.service.NewData(root, "labelmap", "bodies", config), IsNil)
+
+	labels, err := labels64.Get(root, "labels")
+	c.Assert(err, IsNil)
+	dataservice, err := suite.service.DataService(root, "bodies")
+	c.Assert(err, IsNil)
+	labelmap, ok := dataservice.(*Data)
+	c.Assert(ok, Equals, true)
+	versionID, err := server.WritableVersion(root, nil)
+	c.Assert(err, IsNil)
+
+	// Write the block holding voxel (0,0,0) with superpixel 1 in slice 0 and map it
+	// to body 7.
+	db := suite.service.StorageEngine()
+	index := dvid.IndexZYX(dvid.Point3d{0, 0, 0}.Chunk(labels.BlockSize()).(dvid.ChunkPoint3d))
+	superpixel := labels64.RavelerSuperpixelBytes(0, 1)
+	block := bytes.Repeat(superpixel, int(labels.BlockSize().Prod()))
+	serialization, err := dvid.SerializeDataWith(block, labels.Compressor(), dvid.CRC32)
+	c.Assert(err, IsNil)
+	c.Assert(db.Put(labels.DataKey(versionID, index), serialization), IsNil)
+	labels.Extents().AdjustIndices(index, index)
+	c.Assert(db.Put(labelmap.NewRavelerForwardMapKey(versionID, 0, 1, 7), []byte{}), IsNil)
+
+	request := datastore.Request{
+		Command: dvid.Command{"node", string(root), "bodies", "apply", "labels", "mapped"},
+	}
+	faulty := storage.NewFaultyEngine(db, storage.Faults{PutErrorEvery: 1})
+	suite.service.SetStorageEngine(faulty)
+	defer suite.service.SetStorageEngine(db)
+	c.Assert(labelmap.ApplyLabelMap(request, &datastore.Response{}), NotNil)
+
+	faulty.SetFaults(storage.Faults{CorruptEvery: 1})
+	c.Assert(labelmap.ApplyLabelMap(request, &datastore.Response{}), NotNil)
+
+	faulty.SetFaults(storage.Faults{})
+	c.Assert(labelmap.ApplyLabelMap(request, &datastore.Response{}), IsNil)
+	mapped, err := labels64.Get(root, "mapped")
+	c.Assert(err, IsNil)
+	value, err := db.Get(mapped.DataKey(versionID, index))
+	c.Assert(err, IsNil)
+	mappedBlock, _, err := dvid.DeserializeData(value, true)
+	c.Assert(err, IsNil)
+	c.Assert(mappedBlock, HasLen, len(block))
+	c.Assert(binary.LittleEndian.Uint64(mappedBlock[0:8]), Equals, uint64(7))
+}
+
+func (suite *DataSuite) TestMappingDiff(c *C) {
+	root, _, err := suite.service.NewDataset()
+	c.Assert(err, IsNil)
+	config := dvid.NewConfig()
+	c.Assert(suite.service.NewData(root, "labels64", "labels", config), IsNil)
+	config.SetVersioned(true)
+	config["labels"] = "labels"
+	c.Assert(suite.service.NewData(root, "labelmap", "bodies", config), IsNil)
+	dataservice, err := suite.service.DataService(root, "bodies")
+	c.Assert(err, IsNil)
+	labelmap, ok := dataservice.(*Data)
+	c.Assert(ok, Equals, true)
+
+	db := suite.service.StorageEngine()
+	rootVersion, err := server.WritableVersion(root, nil)
+	c.Assert(err, IsNil)
+	c.Assert(db.Put(labelmap.NewRavelerForwardMapKey(rootVersion, 0, 1, 7), []byte{}), IsNil)
+	c.Assert(db.Put(labelmap.NewRavelerForwardMapKey(rootVersion, 0, 3, 5), []byte{}), IsNil)
+	c.Assert(db.Put(labelmap.NewRavelerForwardMapKey(rootVersion, 0, 4, 6), []byte{}), IsNil)
+	c.Assert(suite.service.Lock(root), IsNil)
+	child, err := suite.service.NewVersion(root)
+	c.Assert(err, IsNil)
+
+	// In the child, superpixel 1 is remapped, 2 is added and 3 is no longer mapped.
+	childVersion, err := server.WritableVersion(child, nil)
+	c.Assert(err, IsNil)
+	reader, err := suite.service.VersionedReader(child, "bodies", db)
+	c.Assert(err, IsNil)
+	c.Assert(reader.Delete(db, labelmap.NewRavelerForwardMapKey(childVersion, 0, 1, 7).Index), IsNil)
+	c.Assert(db.Put(labelmap.NewRavelerForwardMapKey(childVersion, 0, 1, 9), []byte{}), IsNil)
+	c.Assert(db.Put(labelmap.NewRavelerForwardMapKey(childVersion, 0, 2, 3), []byte{}), IsNil)
+	c.Assert(reader.Delete(db, labelmap.NewRavelerForwardMapKey(childVersion, 0, 3, 5).Index), IsNil)
+
+	jsonStr, err := labelmap.GetMappingDiff(root, child)
+	c.Assert(err, IsNil)
+	c.Assert(jsonStr, Equals, `[{"Label":1,"From":7,"To":9},{"Label":2,"From":null,"To":3},`+
+		`{"Label":3,"From":5,"To":null}]`)
+
+	jsonStr, err = labelmap.GetMappingDiff(child, root)
+	c.Assert(err, IsNil)
+	c.Assert(jsonStr, Equals, `[{"Label":1,"From":9,"To":7},{"Label":2,"From":3,"To":null},`+
+		`{"Label":3,"From":null,"To":5}]`)
+
+	jsonStr, err = labelmap.GetMappingDiff(child, child)
+	c.Assert(err, IsNil)
+	c.Assert(jsonStr, Equals, `[]`)
+}
+
 /*
 // Make sure new keyvalue data have different IDs.
 func (suite *DataSuite) TestNewDataDifferent(c *C) {
@@ -92,7 +198,7 @@ func (suite *DataSuite) TestRoundTrip(c *C) {
 		keyStr := "testkey"
 		value := []byte("I like Japan and this is some unicode: \u65e5\u672c\u8a9e")
 
-		err = kvdata.PutData(root, keyStr, value)
+		err = kvdata.PutData(root, nil, keyStr, value)
 		c.Assert(err, IsNil)
 
 		retrieved, err := kvdata.GetData(root, keyStr)
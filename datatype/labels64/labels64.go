@@ -52,6 +52,7 @@ $ dvid dataset <UUID> new labels64 <data name> <settings...>
     Configuration Settings (case-insensitive keys)
 
     Versioned      "true" or "false" (default)
+    Compression    "none", "snappy" (default), "lz4", or "gzip[:<level 1-9>]"
     BlockSize      Size in pixels  (default: %s)
     Res       Resolution of voxels (default: 1.0, 1.0, 1.0)
     Units  String of units (default: "nanometers")
@@ -122,6 +123,18 @@ GET  /api/node/<UUID>/<data name>/schema
 	of bytes returned for n-d images.
 
 
+GET  /api/node/<UUID>/<data name>/diff/<other UUID>
+
+    Returns JSON with the blocks that differ between the two version nodes, as
+    described in the help for voxels data like grayscale8.
+
+    Arguments:
+
+    UUID          Hexidecimal string with enough characters to uniquely identify a version node.
+    data name     Name of data to compare.
+    other UUID    Hexidecimal string identifying the version node to compare.
+
+
 GET  /api/node/<UUID>/<data name>/<dims>/<size>/<offset>[/<format>]
 POST /api/node/<UUID>/<data name>/<dims>/<size>/<offset>[/<format>]
 
@@ -386,7 +399,7 @@ func (d *Data) DoRPC(request datastore.Request, reply *datastore.Response) error
 			return err
 		}
 		if formatStr == "raveler" {
-			return voxels.LoadXY(d, uuid, offset, filenames)
+			return voxels.LoadXY(d, uuid, request.Writes, offset, filenames)
 		} else {
 			return fmt.Errorf("Currently, only Raveler loading is supported for 64-bit labels.")
 		}
@@ -450,6 +463,8 @@ func (d *Data) DoHTTP(uuid dvid.UUID, w http.ResponseWriter, r *http.Request) er
 		w.Header().Set("Content-Type", "application/json")
 		fmt.Fprintf(w, jsonStr)
 		return nil
+	case "diff":
+		return d.ServeDiff(uuid, w, r, parts)
 	default:
 	}
 
@@ -477,7 +492,7 @@ func (d *Data) DoHTTP(uuid dvid.UUID, w http.ResponseWriter, r *http.Request) er
 			if err != nil {
 				return err
 			}
-			err = voxels.PutImage(uuid, d, e)
+			err = voxels.PutImage(uuid, datastore.HTTPWriteToken(r), d, e)
 			if err != nil {
 				return err
 			}
@@ -535,6 +550,9 @@ type blockOp struct {
 	grayscale *voxels.Data
 	composite *voxels.Data
 	versionID dvid.VersionLocalID
+
+	// First error encountered by any chunk handler for this operation.
+	storage.OpError
 }
 
 // CreateComposite creates a new rgba8 image by combining hash of labels + the grayscale
@@ -546,11 +564,15 @@ func (d *Data) CreateComposite(request datastore.Request, reply *datastore.Respo
 	var uuidStr, dataName, cmdStr, grayscaleName, destName string
 	request.CommandArgs(1, &uuidStr, &dataName, &cmdStr, &grayscaleName, &destName)
 
-	// Get the version
+	// Get the version, which must be writable.
 	uuid, err := server.MatchingUUID(uuidStr)
 	if err != nil {
 		return err
 	}
+	versionID, err := server.WritableVersion(uuid, request.Writes)
+	if err != nil {
+		return err
+	}
 
 	// Get the grayscale data.
 	service := server.DatastoreService()
@@ -583,15 +605,14 @@ func (d *Data) CreateComposite(request datastore.Request, reply *datastore.Respo
 	}
 
 	// Prepare for datastore access
-	versionID, err := server.VersionLocalID(uuid)
-	if err != nil {
-		return err
-	}
 	db := server.StorageEngine()
+	if db == nil {
+		return fmt.Errorf("Did not find a working key-value datastore to create composite!")
+	}
 
 	// Iterate through all labels and grayscale chunks incrementally in Z, a layer at a time.
 	wg := new(sync.WaitGroup)
-	op := &blockOp{grayscale, composite, versionID}
+	op := &blockOp{grayscale: grayscale, composite: composite, versionID: versionID}
 
 	extents := d.Extents()
 	startKey := d.DataKey(versionID, extents.MinIndex)
@@ -600,6 +621,12 @@ func (d *Data) CreateComposite(request datastore.Request, reply *datastore.Respo
 	chunkOp := &storage.ChunkOp{op, wg}
 	err = db.ProcessRange(startKey, endKey, chunkOp, d.CreateCompositeChunk)
 	wg.Wait()
+	if err == nil {
+		err = op.Err()
+	}
+	if err != nil {
+		return fmt.Errorf("Error creating composite %s: %s", destName, err.Error())
+	}
 
 	dvid.ElapsedTime(dvid.Debug, startTime, "Created composite of %s and %s",
 		grayscaleName, destName)
@@ -639,7 +666,7 @@ func (d *Data) createCompositeChunk(chunk *storage.Chunk) {
 	op := chunk.Op.(*blockOp)
 	db := server.StorageEngine()
 	if db == nil {
-		dvid.Log(dvid.Normal, "Did not find a working key-value datastore to get image!")
+		op.SetError(fmt.Errorf("Did not find a working key-value datastore to get image!"))
 		return
 	}
 
@@ -658,13 +685,13 @@ func (d *Data) createCompositeChunk(chunk *storage.Chunk) {
 
 	labelData, _, err := dvid.DeserializeData(chunk.V, true)
 	if err != nil {
-		dvid.Log(dvid.Normal, "Unable to deserialize block in '%s': %s\n",
-			d.DataName(), err.Error())
+		op.SetError(fmt.Errorf("Unable to deserialize block in '%s': %s",
+			d.DataName(), err.Error()))
 		return
 	}
 	blockBytes := len(labelData)
 	if blockBytes%8 != 0 {
-		dvid.Log(dvid.Normal, "Retrieved, deserialized block is wrong size: %d bytes\n", blockBytes)
+		op.SetError(fmt.Errorf("Retrieved, deserialized block is wrong size: %d bytes", blockBytes))
 		return
 	}
 
@@ -672,13 +699,14 @@ func (d *Data) createCompositeChunk(chunk *storage.Chunk) {
 	grayscaleKey := op.grayscale.DataKey(op.versionID, labelKey.Index)
 	blockData, err := db.Get(grayscaleKey)
 	if err != nil {
-		dvid.Log(dvid.Normal, "Error getting grayscale block for index %s\n", labelKey.Index)
+		op.SetError(fmt.Errorf("Error getting grayscale block for index %s: %s",
+			labelKey.Index, err.Error()))
 		return
 	}
 	grayscaleData, _, err := dvid.DeserializeData(blockData, true)
 	if err != nil {
-		dvid.Log(dvid.Normal, "Unable to deserialize block in '%s': %s\n",
-			op.grayscale.DataName(), err.Error())
+		op.SetError(fmt.Errorf("Unable to deserialize block in '%s': %s",
+			op.grayscale.DataName(), err.Error()))
 		return
 	}
 
@@ -699,16 +727,16 @@ func (d *Data) createCompositeChunk(chunk *storage.Chunk) {
 
 	// Store the composite block into the rgba8 data.
 	compositeKey := op.composite.DataKey(op.versionID, labelKey.Index)
-	serialization, err := dvid.SerializeData(compositeData, dvid.Snappy, dvid.CRC32)
+	serialization, err := dvid.SerializeDataWith(compositeData, op.composite.Compressor(), dvid.CRC32)
 	if err != nil {
-		dvid.Log(dvid.Normal, "Unable to serialize composite block at %s: %s\n",
-			labelKey.Index, err.Error())
+		op.SetError(fmt.Errorf("Unable to serialize composite block at %s: %s",
+			labelKey.Index, err.Error()))
 		return
 	}
 	err = db.Put(compositeKey, serialization)
 	if err != nil {
-		dvid.Log(dvid.Normal, "Unable to PUT composite block at %s: %s\n",
-			labelKey.Index, err.Error())
+		op.SetError(fmt.Errorf("Unable to PUT composite block at %s: %s",
+			labelKey.Index, err.Error()))
 		return
 	}
 }
@@ -83,6 +83,18 @@ POST /api/node/<UUID>/<data name>/info
     data name     Name of multichan16 data.
 
 
+GET  /api/node/<UUID>/<data name>/diff/<other UUID>
+
+    Returns JSON with the blocks that differ between the two version nodes, as
+    described in the help for voxels data like grayscale8.
+
+    Arguments:
+
+    UUID          Hexidecimal string with enough characters to uniquely identify a version node.
+    data name     Name of data to compare.
+    other UUID    Hexidecimal string identifying the version node to compare.
+
+
 GET  /api/node/<UUID>/<data name>/<dims>/<size>/<offset>[/<format>]
 POST /api/node/<UUID>/<data name>/<dims>/<size>/<offset>[/<format>]
 
@@ -283,6 +295,8 @@ func (d *Data) DoHTTP(uuid dvid.UUID, w http.ResponseWriter, r *http.Request) er
 		w.Header().Set("Content-Type", "application/json")
 		fmt.Fprintf(w, jsonStr)
 		return nil
+	case "diff":
+		return d.ServeDiff(uuid, w, r, parts)
 	default:
 	}
 
@@ -385,6 +399,9 @@ func (d *Data) LoadLocal(request datastore.Request, reply *datastore.Response) e
 	if err != nil {
 		return fmt.Errorf("Could not find node with UUID %s: %s", uuidStr, err.Error())
 	}
+	if _, _, err := service.WritableVersion(uuid, nil); err != nil {
+		return err
+	}
 
 	// Load the V3D Raw file.
 	ext := filepath.Ext(filename)
@@ -425,7 +442,7 @@ func (d *Data) LoadLocal(request datastore.Request, reply *datastore.Response) e
 	// PUT each channel of the file into the datastore using a separate data name.
 	for _, channel := range channels {
 		dvid.Fmt(dvid.Debug, "Processing channel %d... \n", channel.channelNum)
-		err = voxels.PutImage(uuid, d, channel)
+		err = voxels.PutImage(uuid, request.Writes, d, channel)
 		if err != nil {
 			return err
 		}
@@ -434,7 +451,7 @@ func (d *Data) LoadLocal(request datastore.Request, reply *datastore.Response) e
 	// Create a RGB composite from the first 3 channels.  This is considered to be channel 0
 	// or can be accessed with the base data name.
 	dvid.Fmt(dvid.Debug, "Creating composite image from channels...\n")
-	err = d.storeComposite(uuid, channels)
+	err = d.storeComposite(uuid, request.Writes, channels)
 	if err != nil {
 		return err
 	}
@@ -444,7 +461,7 @@ func (d *Data) LoadLocal(request datastore.Request, reply *datastore.Response) e
 }
 
 // Create a RGB interleaved volume.
-func (d *Data) storeComposite(uuid dvid.UUID, channels []*Channel) error {
+func (d *Data) storeComposite(uuid dvid.UUID, token *datastore.WriteToken, channels []*Channel) error {
 	// Setup the composite Channel
 	geom := channels[0].Geometry
 	pixels := int(geom.NumVoxels())
@@ -510,5 +527,5 @@ func (d *Data) storeComposite(uuid dvid.UUID, channels []*Channel) error {
 	}
 
 	// Store the result
-	return voxels.PutImage(uuid, d, composite)
+	return voxels.PutImage(uuid, token, d, composite)
 }
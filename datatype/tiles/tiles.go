@@ -53,6 +53,7 @@ $ dvid dataset <UUID> new tiles <data name> <settings...>
     Configuration Settings (case-insensitive keys)
 
     Versioned      "true" or "false" (default)
+    Compression    "none", "snappy" (default), "lz4", or "gzip[:<level 1-9>]"
     Source         Name of data source (required)
     TileSize       Size in pixels  (default: %s)
     Placeholder    Bool ("false", "true", "0", or "1").  Return placeholder tile if missing.
@@ -285,6 +286,11 @@ func (d *Data) JSONString() (jsonStr string, err error) {
 	return string(m), nil
 }
 
+// References returns the source of the tiles, which can't be deleted while this data exists.
+func (d *Data) References() []dvid.DataString {
+	return []dvid.DataString{d.Source}
+}
+
 // --- DataService interface ---
 
 // DoRPC handles the 'generate' command.
@@ -295,7 +301,7 @@ func (d *Data) DoRPC(request datastore.Request, reply *datastore.Response) error
 	var uuidStr string
 	request.Command.CommandArgs(1, &uuidStr)
 	config := request.Settings()
-	return d.ConstructTiles(uuidStr, config)
+	return d.ConstructTiles(uuidStr, config, request.Writes)
 }
 
 // DoHTTP handles all incoming HTTP requests for this data.
@@ -585,7 +591,7 @@ func (d *Data) extractTiles(img image.Image, interp resize.InterpolationFunction
 			if err != nil {
 				return err
 			}
-			serialization, err := tile.Serialize(dvid.Snappy, dvid.CRC32)
+			serialization, err := tile.SerializeWith(d.Compressor(), dvid.CRC32)
 			if err != nil {
 				return err
 			}
@@ -626,9 +632,15 @@ func (d *Data) getYZKeyFunc(versionID dvid.VersionLocalID, x int32) keyFunc {
 	}
 }
 
-func (d *Data) ConstructTiles(uuidStr string, config dvid.Config) error {
+// ConstructTiles generates tiles from the source data at a version node, counting the
+// write by the token of the request making it.
+func (d *Data) ConstructTiles(uuidStr string, config dvid.Config, token *datastore.WriteToken) error {
 	service := server.DatastoreService()
-	uuid, _, versionID, err := service.NodeIDFromString(uuidStr)
+	uuid, _, _, err := service.NodeIDFromString(uuidStr)
+	if err != nil {
+		return err
+	}
+	_, versionID, err := service.WritableVersion(uuid, token)
 	if err != nil {
 		return err
 	}
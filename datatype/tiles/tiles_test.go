@@ -0,0 +1,93 @@
+package tiles
+
+import (
+	"testing"
+
+	. "github.com/janelia-flyem/go/gocheck"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/datatype/voxels"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/server"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+// Hook up gocheck into the "go test" runner.
+func Test(t *testing.T) { TestingT(t) }
+
+type DataSuite struct {
+	dir     string
+	service *server.Service
+}
+
+var _ = Suite(&DataSuite{})
+
+// This will setup a new datastore and open it up, keeping the service pointer in
+// the DataSuite.
+func (suite *DataSuite) SetUpSuite(c *C) {
+	// Make a temporary testing directory that will be auto-deleted after testing.
+	suite.dir = c.MkDir()
+
+	// Create a new datastore.
+	err := datastore.Init(suite.dir, true, dvid.Config{})
+	c.Assert(err, IsNil)
+
+	// Open the datastore
+	suite.service, err = server.OpenDatastore(suite.dir)
+	c.Assert(err, IsNil)
+}
+
+func (suite *DataSuite) TearDownSuite(c *C) {
+	suite.service.Shutdown()
+}
+
+// Storage failures while generating tiles should be returned to the caller.
+func (suite *DataSuite) TestGenerateFaults(c *C) {
+	root, _, err := suite.service.NewDataset()
+	c.Assert(err, IsNil)
+	config := dvid.NewConfig()
+	c.Assert(suite.service.NewData(root, "grayscale8", "grayscale", config), IsNil)
+	config["source"] = "grayscale"
+	config["tilesize"] = "32"
+	c.Assert(suite.service.NewData(root, "tiles", "tiles", config), IsNil)
+
+	dataservice, err := suite.service.DataService(root, "grayscale")
+	c.Assert(err, IsNil)
+	grayscale, ok := dataservice.(*voxels.Data)
+	c.Assert(ok, Equals, true)
+	dataservice, err = suite.service.DataService(root, "tiles")
+	c.Assert(err, IsNil)
+	tiles, ok := dataservice.(*Data)
+	c.Assert(ok, Equals, true)
+
+	offset := dvid.Point3d{0, 0, 0}
+	size := dvid.Point2d{32, 32}
+	slice, err := dvid.NewOrthogSlice(dvid.XY, offset, size)
+	c.Assert(err, IsNil)
+	data := make([]byte, 32*32)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	v, err := grayscale.NewExtHandler(slice, dvid.ImageGrayFromData(data, 32, 32))
+	c.Assert(err, IsNil)
+	c.Assert(voxels.PutImage(root, nil, grayscale, v), IsNil)
+
+	db := suite.service.StorageEngine()
+	faulty := storage.NewFaultyEngine(db, storage.Faults{PutErrorEvery: 1})
+	suite.service.SetStorageEngine(faulty)
+	defer suite.service.SetStorageEngine(db)
+
+	generate := dvid.Config{"planes": "xy"}
+	c.Assert(tiles.ConstructTiles(string(root), generate, nil), NotNil)
+
+	faulty.SetFaults(storage.Faults{CorruptEvery: 1})
+	c.Assert(tiles.ConstructTiles(string(root), generate, nil), NotNil)
+
+	faulty.SetFaults(storage.Faults{})
+	c.Assert(tiles.ConstructTiles(string(root), generate, nil), IsNil)
+	_, versionID, err := suite.service.WritableVersion(root, nil)
+	c.Assert(err, IsNil)
+	tile, err := tiles.GetTile(versionID, "xy", "0", "0_0_0")
+	c.Assert(err, IsNil)
+	c.Assert(tile, NotNil)
+}
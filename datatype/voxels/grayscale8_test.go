@@ -1,12 +1,17 @@
 package voxels
 
 import (
-	. "github.com/janelia-flyem/go/gocheck"
+	"image/png"
+	"os"
+	"path/filepath"
 	"testing"
 
+	. "github.com/janelia-flyem/go/gocheck"
+
 	"github.com/janelia-flyem/dvid/datastore"
 	"github.com/janelia-flyem/dvid/dvid"
 	"github.com/janelia-flyem/dvid/server"
+	"github.com/janelia-flyem/dvid/storage"
 )
 
 // Hook up gocheck into the "go test" runner.
@@ -141,7 +146,7 @@ func (suite *TestSuite) sliceTest(c *C, slice dvid.Geometry) {
 	v, err := grayscale.NewExtHandler(slice, img)
 	c.Assert(err, IsNil)
 
-	err = PutImage(root, grayscale, v)
+	err = PutImage(root, nil, grayscale, v)
 	c.Assert(err, IsNil)
 
 	// Read the stored image
@@ -179,3 +184,330 @@ func (suite *TestSuite) TestYZSliceGrayscale8(c *C) {
 	c.Assert(err, IsNil)
 	suite.sliceTest(c, slice)
 }
+
+// Storage failures during chunk handling should be returned to the caller.
+func (suite *TestSuite) TestStorageFaults(c *C) {
+	root, _, err := suite.service.NewDataset()
+	c.Assert(err, IsNil)
+
+	config := dvid.NewConfig()
+	config.SetVersioned(true)
+	err = suite.service.NewData(root, "grayscale8", "faulty", config)
+	c.Assert(err, IsNil)
+
+	dataservice, err := suite.service.DataService(root, "faulty")
+	c.Assert(err, IsNil)
+	grayscale, ok := dataservice.(*Data)
+	c.Assert(ok, Equals, true)
+
+	offset := dvid.Point3d{3, 13, 24}
+	size := dvid.Point2d{100, 100}
+	slice, err := dvid.NewOrthogSlice(dvid.XY, offset, size)
+	c.Assert(err, IsNil)
+	img := dvid.ImageGrayFromData(MakeSlice(offset, size), 100, 100)
+	v, err := grayscale.NewExtHandler(slice, img)
+	c.Assert(err, IsNil)
+
+	db := storage.NewFaultyEngine(suite.service.StorageEngine(), storage.Faults{PutErrorEvery: 1})
+	suite.service.SetStorageEngine(db)
+	defer suite.service.SetStorageEngine(db.Engine)
+
+	err = PutImage(root, nil, grayscale, v)
+	c.Assert(err, NotNil)
+
+	filename := filepath.Join(c.MkDir(), "slice.png")
+	f, err := os.Create(filename)
+	c.Assert(err, IsNil)
+	c.Assert(png.Encode(f, img), IsNil)
+	c.Assert(f.Close(), IsNil)
+	err = LoadXY(grayscale, root, nil, offset, []string{filename})
+	c.Assert(err, NotNil)
+
+	db.SetFaults(storage.Faults{})
+	err = PutImage(root, nil, grayscale, v)
+	c.Assert(err, IsNil)
+
+	db.SetFaults(storage.Faults{CorruptEvery: 1})
+	_, err = GetImage(root, grayscale, v)
+	c.Assert(err, NotNil)
+
+	db.SetFaults(storage.Faults{TruncateEvery: 1})
+	_, err = GetImage(root, grayscale, v)
+	c.Assert(err, NotNil)
+
+	db.SetFaults(storage.Faults{})
+	_, err = GetImage(root, grayscale, v)
+	c.Assert(err, IsNil)
+}
+
+// Repeated image reads through a cached engine should be served from the cache.
+func (suite *TestSuite) TestCachedReads(c *C) {
+	root, _, err := suite.service.NewDataset()
+	c.Assert(err, IsNil)
+
+	config := dvid.NewConfig()
+	config.SetVersioned(true)
+	err = suite.service.NewData(root, "grayscale8", "cached", config)
+	c.Assert(err, IsNil)
+	dataservice, err := suite.service.DataService(root, "cached")
+	c.Assert(err, IsNil)
+	grayscale, ok := dataservice.(*Data)
+	c.Assert(ok, Equals, true)
+
+	db := storage.NewCachedEngine(suite.service.StorageEngine(), 10*dvid.Mega)
+	suite.service.SetStorageEngine(db)
+	defer suite.service.SetStorageEngine(db.Engine)
+
+	offset := dvid.Point3d{3, 13, 24}
+	size := dvid.Point2d{100, 100}
+	slice, err := dvid.NewOrthogSlice(dvid.XY, offset, size)
+	c.Assert(err, IsNil)
+	v, err := grayscale.NewExtHandler(slice, dvid.ImageGrayFromData(MakeSlice(offset, size), 100, 100))
+	c.Assert(err, IsNil)
+	c.Assert(PutImage(root, nil, grayscale, v), IsNil)
+
+	getImage := func(u dvid.UUID) []byte {
+		retrieved, err := GetImage(u, grayscale, v)
+		c.Assert(err, IsNil)
+		retrievedData, _, _, err := dvid.ImageData(retrieved)
+		c.Assert(err, IsNil)
+		return append([]byte{}, retrievedData...)
+	}
+	first := getImage(root)
+	hits, _, _, _ := storage.CacheStats()
+	c.Assert(getImage(root), DeepEquals, first)
+	newHits, _, _, _ := storage.CacheStats()
+	c.Assert(newHits > hits, Equals, true)
+	c.Assert(first, DeepEquals, MakeSlice(offset, size))
+
+	// Writes are seen by later reads.
+	c.Assert(suite.service.Lock(root), IsNil)
+	child, err := suite.service.NewVersion(root)
+	c.Assert(err, IsNil)
+	c.Assert(getImage(child), DeepEquals, first)
+	small, err := dvid.NewOrthogSlice(dvid.XY, offset, dvid.Point2d{10, 10})
+	c.Assert(err, IsNil)
+	v2, err := grayscale.NewExtHandler(small, dvid.ImageGrayFromData(make([]byte, 100), 10, 10))
+	c.Assert(err, IsNil)
+	c.Assert(PutImage(child, nil, grayscale, v2), IsNil)
+	retrievedData := getImage(child)
+	c.Assert(retrievedData[0], Equals, byte(0))
+	c.Assert(retrievedData[50], Equals, first[50])
+}
+
+// A branched child should read the blocks of its parent until it writes its own.
+func (suite *TestSuite) TestBranchInheritance(c *C) {
+	root, _, err := suite.service.NewDataset()
+	c.Assert(err, IsNil)
+
+	config := dvid.NewConfig()
+	config.SetVersioned(true)
+	err = suite.service.NewData(root, "grayscale8", "inherited", config)
+	c.Assert(err, IsNil)
+	dataservice, err := suite.service.DataService(root, "inherited")
+	c.Assert(err, IsNil)
+	grayscale, ok := dataservice.(*Data)
+	c.Assert(ok, Equals, true)
+
+	offset := dvid.Point3d{3, 13, 24}
+	size := dvid.Point2d{100, 100}
+	slice, err := dvid.NewOrthogSlice(dvid.XY, offset, size)
+	c.Assert(err, IsNil)
+	data := MakeSlice(offset, size)
+	v, err := grayscale.NewExtHandler(slice, dvid.ImageGrayFromData(data, 100, 100))
+	c.Assert(err, IsNil)
+	c.Assert(PutImage(root, nil, grayscale, v), IsNil)
+
+	c.Assert(suite.service.Lock(root), IsNil)
+	child, err := suite.service.NewVersion(root)
+	c.Assert(err, IsNil)
+
+	retrieved, err := GetImage(child, grayscale, v)
+	c.Assert(err, IsNil)
+	retrievedData, _, _, err := dvid.ImageData(retrieved)
+	c.Assert(err, IsNil)
+	c.Assert(retrievedData, DeepEquals, data)
+
+	// Overwrite part of the slice in the child.
+	smallSize := dvid.Point2d{10, 10}
+	small, err := dvid.NewOrthogSlice(dvid.XY, offset, smallSize)
+	c.Assert(err, IsNil)
+	zeros := make([]byte, 100)
+	v2, err := grayscale.NewExtHandler(small, dvid.ImageGrayFromData(zeros, 10, 10))
+	c.Assert(err, IsNil)
+	c.Assert(PutImage(child, nil, grayscale, v2), IsNil)
+
+	retrieved, err = GetImage(child, grayscale, v)
+	c.Assert(err, IsNil)
+	retrievedData, _, _, err = dvid.ImageData(retrieved)
+	c.Assert(err, IsNil)
+	c.Assert(retrievedData[0], Equals, byte(0))
+	c.Assert(retrievedData[50], Equals, data[50])
+
+	retrieved, err = GetImage(root, grayscale, v)
+	c.Assert(err, IsNil)
+	retrievedData, _, _, err = dvid.ImageData(retrieved)
+	c.Assert(err, IsNil)
+	c.Assert(retrievedData, DeepEquals, data)
+}
+
+// Loading images into a child should keep voxels of partial blocks inherited from its parent.
+func (suite *TestSuite) TestLoadIntoBranch(c *C) {
+	root, _, err := suite.service.NewDataset()
+	c.Assert(err, IsNil)
+
+	config := dvid.NewConfig()
+	config.SetVersioned(true)
+	err = suite.service.NewData(root, "grayscale8", "loaded", config)
+	c.Assert(err, IsNil)
+	dataservice, err := suite.service.DataService(root, "loaded")
+	c.Assert(err, IsNil)
+	grayscale, ok := dataservice.(*Data)
+	c.Assert(ok, Equals, true)
+
+	offset := dvid.Point3d{3, 13, 24}
+	size := dvid.Point2d{100, 100}
+	slice, err := dvid.NewOrthogSlice(dvid.XY, offset, size)
+	c.Assert(err, IsNil)
+	data := MakeSlice(offset, size)
+	v, err := grayscale.NewExtHandler(slice, dvid.ImageGrayFromData(data, 100, 100))
+	c.Assert(err, IsNil)
+	c.Assert(PutImage(root, nil, grayscale, v), IsNil)
+
+	c.Assert(suite.service.Lock(root), IsNil)
+	child, err := suite.service.NewVersion(root)
+	c.Assert(err, IsNil)
+
+	// Load a small image that covers only part of a block.
+	filename := filepath.Join(c.MkDir(), "zeros.png")
+	f, err := os.Create(filename)
+	c.Assert(err, IsNil)
+	c.Assert(png.Encode(f, dvid.ImageGrayFromData(make([]byte, 100), 10, 10)), IsNil)
+	c.Assert(f.Close(), IsNil)
+	c.Assert(LoadXY(grayscale, child, nil, offset, []string{filename}), IsNil)
+
+	retrieved, err := GetImage(child, grayscale, v)
+	c.Assert(err, IsNil)
+	retrievedData, _, _, err := dvid.ImageData(retrieved)
+	c.Assert(err, IsNil)
+
+	// The retrieved image shares the stored image's buffer, so compare against a new copy.
+	data = MakeSlice(offset, size)
+	for y := 0; y < 100; y++ {
+		for x := 0; x < 100; x++ {
+			expected := data[y*100+x]
+			if x < 10 && y < 10 {
+				expected = 0
+			}
+			c.Assert(retrievedData[y*100+x], Equals, expected, Commentf("voxel (%d,%d)", x, y))
+		}
+	}
+}
+
+func (suite *TestSuite) TestDiff(c *C) {
+	root, _, err := suite.service.NewDataset()
+	c.Assert(err, IsNil)
+
+	config := dvid.NewConfig()
+	config.SetVersioned(true)
+	err = suite.service.NewData(root, "grayscale8", "diffed", config)
+	c.Assert(err, IsNil)
+	dataservice, err := suite.service.DataService(root, "diffed")
+	c.Assert(err, IsNil)
+	grayscale, ok := dataservice.(*Data)
+	c.Assert(ok, Equals, true)
+
+	// The root slice spans blocks along x and y.
+	offset := dvid.Point3d{3, 13, 24}
+	size := dvid.Point2d{100, 100}
+	slice, err := dvid.NewOrthogSlice(dvid.XY, offset, size)
+	c.Assert(err, IsNil)
+	v, err := grayscale.NewExtHandler(slice, dvid.ImageGrayFromData(MakeSlice(offset, size), 100, 100))
+	c.Assert(err, IsNil)
+	c.Assert(PutImage(root, nil, grayscale, v), IsNil)
+
+	c.Assert(suite.service.Lock(root), IsNil)
+	child, err := suite.service.NewVersion(root)
+	c.Assert(err, IsNil)
+
+	// Only the block holding the child's small slice differs.
+	smallOffset := dvid.Point3d{40, 13, 24}
+	small, err := dvid.NewOrthogSlice(dvid.XY, smallOffset, dvid.Point2d{10, 10})
+	c.Assert(err, IsNil)
+	v2, err := grayscale.NewExtHandler(small, dvid.ImageGrayFromData(make([]byte, 100), 10, 10))
+	c.Assert(err, IsNil)
+	c.Assert(PutImage(child, nil, grayscale, v2), IsNil)
+
+	jsonStr, err := grayscale.GetDiff(root, child)
+	c.Assert(err, IsNil)
+	c.Assert(jsonStr, Equals, `{"BlockSize":[32,32,32],"Blocks":[[32,0,0]]}`)
+}
+
+func (suite *TestSuite) TestMerge(c *C) {
+	root, _, err := suite.service.NewDataset()
+	c.Assert(err, IsNil)
+
+	config := dvid.NewConfig()
+	config.SetVersioned(true)
+	err = suite.service.NewData(root, "grayscale8", "merged", config)
+	c.Assert(err, IsNil)
+	dataservice, err := suite.service.DataService(root, "merged")
+	c.Assert(err, IsNil)
+	grayscale, ok := dataservice.(*Data)
+	c.Assert(ok, Equals, true)
+
+	offset := dvid.Point3d{3, 13, 24}
+	size := dvid.Point2d{100, 100}
+	slice, err := dvid.NewOrthogSlice(dvid.XY, offset, size)
+	c.Assert(err, IsNil)
+	data := MakeSlice(offset, size)
+	v, err := grayscale.NewExtHandler(slice, dvid.ImageGrayFromData(data, 100, 100))
+	c.Assert(err, IsNil)
+	c.Assert(PutImage(root, nil, grayscale, v), IsNil)
+	c.Assert(suite.service.Lock(root), IsNil)
+
+	// Each branch fills a different part of the same block.
+	fill := func(u dvid.UUID, corner dvid.Point3d, value byte) {
+		small, err := dvid.NewOrthogSlice(dvid.XY, corner, dvid.Point2d{10, 10})
+		c.Assert(err, IsNil)
+		filled := make([]byte, 100)
+		for i := range filled {
+			filled[i] = value
+		}
+		v, err := grayscale.NewExtHandler(small, dvid.ImageGrayFromData(filled, 10, 10))
+		c.Assert(err, IsNil)
+		c.Assert(PutImage(u, nil, grayscale, v), IsNil)
+	}
+	team1, err := suite.service.NewVersion(root)
+	c.Assert(err, IsNil)
+	fill(team1, offset, 0xff)
+	c.Assert(suite.service.Lock(team1), IsNil)
+	team2, err := suite.service.NewVersion(root)
+	c.Assert(err, IsNil)
+	fill(team2, dvid.Point3d{15, 13, 24}, 0xfe)
+	c.Assert(suite.service.Lock(team2), IsNil)
+
+	parents := []dvid.UUID{team1, team2}
+	_, err = suite.service.Merge(parents, datastore.MergeStrategy{})
+	c.Assert(datastore.IsMergeConflictError(err), Equals, true)
+
+	// The voxel merge hook combines both branches' changes to the block.
+	merged, err := suite.service.Merge(parents, datastore.MergeStrategy{Type: datastore.MergeHook})
+	c.Assert(err, IsNil)
+	retrieved, err := GetImage(merged, grayscale, v)
+	c.Assert(err, IsNil)
+	retrievedData, _, _, err := dvid.ImageData(retrieved)
+	c.Assert(err, IsNil)
+	c.Assert(retrievedData[0], Equals, byte(0xff))
+	c.Assert(retrievedData[12], Equals, byte(0xfe))
+	c.Assert(retrievedData[50], Equals, data[50])
+
+	// Changing the same voxels differently can't be merged voxel by voxel.
+	team3, err := suite.service.NewVersion(root)
+	c.Assert(err, IsNil)
+	fill(team3, offset, 0x01)
+	c.Assert(suite.service.Lock(team3), IsNil)
+	_, err = suite.service.Merge([]dvid.UUID{team1, team3},
+		datastore.MergeStrategy{Type: datastore.MergeHook})
+	c.Assert(datastore.IsMergeConflictError(err), Equals, true)
+}
@@ -4,13 +4,14 @@
 package voxels
 
 import (
+	"bytes"
 	"encoding/binary"
 	"encoding/gob"
 	"encoding/json"
 	"fmt"
 	"image"
-	"log"
 	"net/http"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -54,6 +55,7 @@ $ dvid dataset <UUID> new <type name> <data name> <settings...>
     Configuration Settings (case-insensitive keys)
 
     Versioned      "true" or "false" (default)
+    Compression    "none", "snappy" (default), "lz4", or "gzip[:<level 1-9>]"
     BlockSize      Size in pixels  (default: %s)
     Res       Resolution of voxels (default: 1.0, 1.0, 1.0)
     Units  String of units (default: "nanometers")
@@ -127,6 +129,20 @@ GET  /api/node/<UUID>/<data name>/schema
 	of bytes returned for n-d images.
 
 
+GET  /api/node/<UUID>/<data name>/diff/<other UUID>
+
+    Returns JSON with the blocks that differ between the two version nodes, e.g.,
+    {"BlockSize": [32,32,32], "Blocks": [[0,0,32],[32,0,32]]}, where each block is
+    given by the coordinate of its first voxel.  Tiles or other data derived from
+    voxels data only need to be recomputed for these blocks.
+
+    Arguments:
+
+    UUID          Hexidecimal string with enough characters to uniquely identify a version node.
+    data name     Name of data to compare.
+    other UUID    Hexidecimal string identifying the version node to compare.
+
+
 GET  /api/node/<UUID>/<data name>/<dims>/<size>/<offset>[/<format>]
 POST /api/node/<UUID>/<data name>/<dims>/<size>/<offset>[/<format>]
 
@@ -200,6 +216,9 @@ func init() {
 type Operation struct {
 	ExtHandler
 	OpType
+
+	// First error encountered by any chunk handler for this operation.
+	storage.OpError
 }
 
 type OpType int
@@ -243,6 +262,9 @@ type IntHandler interface {
 
 	VersionMutex(dvid.VersionLocalID) *sync.Mutex
 
+	// Compressor returns the compression used for stored blocks.
+	Compressor() dvid.Compressor
+
 	ProcessChunk(*storage.Chunk)
 }
 
@@ -302,25 +324,55 @@ func GetVoxels(uuid dvid.UUID, i IntHandler, e ExtHandler) error {
 		return fmt.Errorf("Did not find a working key-value datastore to get image!")
 	}
 
-	op := Operation{e, GetOp}
+	// Read all blocks from one point in time so concurrent writes can't tear the result.
+	snapshot := storage.ReadSnapshot(db)
+	defer snapshot.Close()
+
+	op := Operation{ExtHandler: e, OpType: GetOp}
 	wg := new(sync.WaitGroup)
 	chunkOp := &storage.ChunkOp{&op, wg}
 
-	service := server.DatastoreService()
-	_, versionID, err := service.LocalIDFromUUID(uuid)
-
 	dataID := i.DataID()
+	reader, err := server.DatastoreService().VersionedReader(uuid, dataID.DataName(), snapshot)
+	if err != nil {
+		return err
+	}
+	versionID := reader.Version()
+
+	// Cached engines serve point reads from memory while range reads go to the engine.
+	cached := storage.IsCached(db)
 
 	for it, err := e.IndexIterator(i.BlockSize()); err == nil && it.Valid(); it.NextSpan() {
 		indexBeg, indexEnd, err := it.IndexSpan()
 		if err != nil {
 			return err
 		}
+
+		if cached {
+			err = getBlocks(reader, e, indexBeg, indexEnd, chunkOp, i.ProcessChunk)
+			if err != nil {
+				return fmt.Errorf("Unable to GET data %s: %s", dataID.DataName(), err.Error())
+			}
+			continue
+		}
+
+		// Blocks not written at this version are read from ancestors.
+		if reader.Inherits() {
+			keyvalues, err := reader.GetRange(indexBeg, indexEnd)
+			if err != nil {
+				return fmt.Errorf("Unable to GET data %s: %s", dataID.DataName(), err.Error())
+			}
+			wg.Add(len(keyvalues))
+			for _, kv := range keyvalues {
+				i.ProcessChunk(&storage.Chunk{chunkOp, kv})
+			}
+			continue
+		}
 		startKey := &datastore.DataKey{dataID.DsetID, dataID.ID, versionID, indexBeg}
 		endKey := &datastore.DataKey{dataID.DsetID, dataID.ID, versionID, indexEnd}
 
-		// Send the entire range of key/value pairs to ProcessChunk()
-		err = db.ProcessRange(startKey, endKey, chunkOp, i.ProcessChunk)
+		// Stream the range of key/value pairs to ProcessChunk()
+		err = processBlocks(snapshot, startKey, endKey, chunkOp, i.ProcessChunk)
 		if err != nil {
 			return fmt.Errorf("Unable to GET data %s: %s", dataID.DataName(), err.Error())
 		}
@@ -331,9 +383,64 @@ func GetVoxels(uuid dvid.UUID, i IntHandler, e ExtHandler) error {
 
 	// Reduce: Grab the resulting 2d image.
 	wg.Wait()
+	if err := op.Err(); err != nil {
+		return fmt.Errorf("Unable to GET data %s: %s", dataID.DataName(), err.Error())
+	}
+	return nil
+}
+
+// getBlocks reads each block in a span of indices and sends the blocks found to a
+// chunk handler.  Unlike a range read, each block can be served from a cache.
+func getBlocks(reader *datastore.VersionedReader, e ExtHandler, indexBeg, indexEnd dvid.Index,
+	chunkOp *storage.ChunkOp, f func(*storage.Chunk)) error {
+
+	ptBeg := indexBeg.Duplicate().(dvid.PointIndexer)
+	ptEnd := indexEnd.Duplicate().(dvid.PointIndexer)
+	begX := ptBeg.Value(0)
+	endX := ptEnd.Value(0)
+	c := dvid.ChunkPoint3d{begX, ptBeg.Value(1), ptBeg.Value(2)}
+	for x := begX; x <= endX; x++ {
+		c[0] = x
+		index := e.Index(c)
+		value, err := reader.Get(index)
+		if err != nil {
+			return err
+		}
+		if value == nil {
+			continue
+		}
+		if chunkOp.Wg != nil {
+			chunkOp.Wg.Add(1)
+		}
+		f(&storage.Chunk{chunkOp, storage.KeyValue{reader.Key(index), value}})
+	}
 	return nil
 }
 
+// processBlocks iterates through the blocks in a key range and sends each to a chunk
+// handler.  Unlike ProcessRange, blocks are read only as fast as they are handled.
+func processBlocks(db storage.KeyValueGetter, startKey, endKey storage.Key, chunkOp *storage.ChunkOp,
+	f func(*storage.Chunk)) error {
+
+	it, err := db.NewIterator(startKey, endKey, nil)
+	if err != nil {
+		return err
+	}
+	defer it.Close()
+
+	for ; it.Valid(); it.Next() {
+		key, err := it.Key()
+		if err != nil {
+			return err
+		}
+		if chunkOp.Wg != nil {
+			chunkOp.Wg.Add(1)
+		}
+		f(&storage.Chunk{chunkOp, storage.KeyValue{key, it.Value()}})
+	}
+	return it.Error()
+}
+
 // PutLocal adds image data to a version node, altering underlying blocks if the image
 // intersects the block.
 //
@@ -372,11 +479,14 @@ func (d *Data) PutLocal(request datastore.Request, reply *datastore.Response) er
 		return err
 	}
 
-	// Load and PUT each image.
+	// Load and PUT each image into a writable version.
 	uuid, err := server.MatchingUUID(uuidStr)
 	if err != nil {
 		return err
 	}
+	if _, err := server.WritableVersion(uuid, nil); err != nil {
+		return err
+	}
 
 	numSuccessful := 0
 	for _, filename := range filenames {
@@ -396,7 +506,7 @@ func (d *Data) PutLocal(request datastore.Request, reply *datastore.Response) er
 			return err
 		}
 		storage.FileBytesRead <- len(e.Data())
-		err = PutImage(uuid, d, e)
+		err = PutImage(uuid, request.Writes, d, e)
 		if err != nil {
 			return err
 		}
@@ -411,10 +521,10 @@ func (d *Data) PutLocal(request datastore.Request, reply *datastore.Response) er
 // PutImage adds a 2d image within given geometry to a version node.   Since chunk sizes
 // are larger than a 2d slice, this also requires integrating this image into current
 // chunks before writing result back out, so it's a PUT for nonexistant keys and GET/PUT
-// for existing keys.
-func PutImage(uuid dvid.UUID, i IntHandler, e ExtHandler) error {
+// for existing keys.  The write is counted by the token of the request making it.
+func PutImage(uuid dvid.UUID, token *datastore.WriteToken, i IntHandler, e ExtHandler) error {
 	service := server.DatastoreService()
-	_, versionID, err := service.LocalIDFromUUID(uuid)
+	_, versionID, err := service.WritableVersion(uuid, token)
 	if err != nil {
 		return err
 	}
@@ -423,8 +533,12 @@ func PutImage(uuid dvid.UUID, i IntHandler, e ExtHandler) error {
 	if db == nil {
 		return fmt.Errorf("Did not find a working key-value datastore to put image!")
 	}
+	reader, err := service.VersionedReader(uuid, i.DataID().DataName(), db)
+	if err != nil {
+		return err
+	}
 
-	op := Operation{e, PutOp}
+	op := Operation{ExtHandler: e, OpType: PutOp}
 	wg := new(sync.WaitGroup)
 	chunkOp := &storage.ChunkOp{&op, wg}
 
@@ -468,11 +582,8 @@ func PutImage(uuid dvid.UUID, i IntHandler, e ExtHandler) error {
 			extentChanged = true
 		}
 
-		startKey := &datastore.DataKey{dataID.DsetID, dataID.ID, versionID, ptBeg}
-		endKey := &datastore.DataKey{dataID.DsetID, dataID.ID, versionID, ptEnd}
-
-		// GET all the chunks for this range.
-		keyvalues, err := db.GetRange(startKey, endKey)
+		// GET all the chunks for this range, including those inherited from ancestors.
+		keyvalues, err := reader.GetRange(ptBeg, ptEnd)
 		if err != nil {
 			return fmt.Errorf("Error in reading data during PUT %s: %s", dataID.DataName(), err.Error())
 		}
@@ -517,7 +628,9 @@ func PutImage(uuid dvid.UUID, i IntHandler, e ExtHandler) error {
 		}
 	}
 	wg.Wait()
-
+	if err := op.Err(); err != nil {
+		return fmt.Errorf("Unable to PUT data %s: %s", dataID.DataName(), err.Error())
+	}
 	return nil
 }
 
@@ -540,15 +653,26 @@ func loadXYImage(i IntHandler, filename string, offset dvid.Point) (ExtHandler,
 }
 
 // Optimized bulk loading of XY images by loading all slices for a block before processing.
-// Trades off memory for speed.
-func LoadXY(i IntHandler, uuid dvid.UUID, offset dvid.Point, filenames []string) error {
+// Trades off memory for speed.  The write is counted by the token of the request making it.
+func LoadXY(i IntHandler, uuid dvid.UUID, token *datastore.WriteToken, offset dvid.Point,
+	filenames []string) (err error) {
+
 	if len(filenames) == 0 {
 		return nil
 	}
 	startTime := time.Now()
 
 	service := server.DatastoreService()
-	_, versionID, err := service.LocalIDFromUUID(uuid)
+	_, versionID, err := service.WritableVersion(uuid, token)
+	if err != nil {
+		return err
+	}
+
+	db := server.StorageEngine()
+	if db == nil {
+		return fmt.Errorf("Did not find a working key-value datastore to load images!")
+	}
+	reader, err := service.VersionedReader(uuid, i.DataID().DataName(), db)
 	if err != nil {
 		return err
 	}
@@ -561,11 +685,17 @@ func LoadXY(i IntHandler, uuid dvid.UUID, offset dvid.Point, filenames []string)
 	// Keep track of changing extents and mark dataset as dirty if changed.
 	var extentChanged dvid.Bool
 
-	// Handle cleanup given multiple goroutines still writing data.
+	// Handle cleanup given multiple goroutines still writing data.  Errors from these
+	// goroutines are recorded in op and returned once all writes are done.
+	op := &Operation{OpType: PutOp}
 	var writeWait, blockWait sync.WaitGroup
 	defer func() {
+		blockWait.Wait()
 		writeWait.Wait()
 		versionMutex.Unlock()
+		if err == nil {
+			err = op.Err()
+		}
 
 		if extentChanged.Value() {
 			err := service.SaveDataset(uuid)
@@ -621,7 +751,7 @@ func LoadXY(i IntHandler, uuid dvid.UUID, offset dvid.Point, filenames []string)
 					blocks[curBlocks][i].V = make([]byte, blockBytes, blockBytes)
 				}
 			}
-			err = loadOldBlocks(i, e, blocks[curBlocks], versionID)
+			err = loadOldBlocks(i, e, blocks[curBlocks], reader)
 			if err != nil {
 				return err
 			}
@@ -638,7 +768,7 @@ func LoadXY(i IntHandler, uuid dvid.UUID, offset dvid.Point, filenames []string)
 			// Process an XY image (slice).
 			changed, err := writeXYImage(i, ext, blocks[curBlocks], versionID)
 			if err != nil {
-				dvid.Log(dvid.Normal, "Error writing XY image: %s\n", err.Error())
+				op.SetError(fmt.Errorf("Error writing XY image: %s", err.Error()))
 			}
 			if changed {
 				extentChanged.SetTrue()
@@ -650,7 +780,10 @@ func LoadXY(i IntHandler, uuid dvid.UUID, offset dvid.Point, filenames []string)
 		// then asynchronously write blocks.
 		if lastSliceInBlock {
 			blockWait.Wait()
-			if err = AsyncWriteData(blocks[curBlocks], &writeWait); err != nil {
+			if err = op.Err(); err != nil {
+				return err
+			}
+			if err = AsyncWriteData(blocks[curBlocks], i.Compressor(), &writeWait, op); err != nil {
 				return err
 			}
 			curBlocks = (curBlocks + 1) % 2
@@ -665,18 +798,15 @@ func LoadXY(i IntHandler, uuid dvid.UUID, offset dvid.Point, filenames []string)
 	return nil
 }
 
-// Loads blocks with old data if they exist.
-func loadOldBlocks(i IntHandler, e ExtHandler, blocks Blocks, versionID dvid.VersionLocalID) error {
-	db := server.StorageEngine()
-	if db == nil {
-		return fmt.Errorf("Did not find a working key-value datastore to put image!")
-	}
-
+// Loads blocks with old data if they exist, including blocks inherited from ancestors
+// of the version being written.
+func loadOldBlocks(i IntHandler, e ExtHandler, blocks Blocks, reader *datastore.VersionedReader) error {
 	// Create a map of old blocks indexed by the index
 	oldBlocks := map[string]([]byte){}
 
 	// Iterate through index space for this data using ZYX ordering.
 	dataID := i.DataID()
+	versionID := reader.Version()
 	blockSize := i.BlockSize()
 	blockNum := 0
 	for it, err := e.IndexIterator(blockSize); err == nil && it.Valid(); it.NextSpan() {
@@ -686,9 +816,7 @@ func loadOldBlocks(i IntHandler, e ExtHandler, blocks Blocks, versionID dvid.Ver
 		}
 
 		// Get previous data.
-		keyBeg := &datastore.DataKey{dataID.DsetID, dataID.ID, versionID, indexBeg}
-		keyEnd := &datastore.DataKey{dataID.DsetID, dataID.ID, versionID, indexEnd}
-		keyvalues, err := db.GetRange(keyBeg, keyEnd)
+		keyvalues, err := reader.GetRange(indexBeg, indexEnd)
 		if err != nil {
 			return err
 		}
@@ -772,7 +900,8 @@ func writeXYImage(i IntHandler, e ExtHandler, blocks Blocks, versionID dvid.Vers
 const KVWriteSize = 500
 
 // AsyncWriteData writes blocks of voxel data asynchronously using batch writes.
-func AsyncWriteData(blocks Blocks, wg *sync.WaitGroup) error {
+// Errors in the asynchronous write are recorded in the given operation.
+func AsyncWriteData(blocks Blocks, compressor dvid.Compressor, wg *sync.WaitGroup, op *Operation) error {
 	db := server.StorageEngine()
 	if db == nil {
 		return fmt.Errorf("Did not find a working key-value datastore to put image!")
@@ -786,6 +915,14 @@ func AsyncWriteData(blocks Blocks, wg *sync.WaitGroup) error {
 			server.HandlerToken <- 1
 			wg.Done()
 		}()
+		// Use bulk loading if the engine supports it.
+		if db.IsBulkIniter() || db.IsBulkWriter() {
+			if err := bulkWriteData(db, blocks, compressor); err != nil {
+				op.SetError(fmt.Errorf("Unable to bulk load blocks: %s", err.Error()))
+			}
+			return
+		}
+
 		// If we can do write batches, use it, else do put ranges.
 		// With write batches, we write the byte slices immediately.
 		// The put range approach can lead to duplicated memory.
@@ -793,15 +930,15 @@ func AsyncWriteData(blocks Blocks, wg *sync.WaitGroup) error {
 		if ok {
 			batch := batcher.NewBatch()
 			for i, block := range blocks {
-				serialization, err := dvid.SerializeData(block.V, dvid.Snappy, dvid.CRC32)
+				serialization, err := dvid.SerializeDataWith(block.V, compressor, dvid.CRC32)
 				if err != nil {
-					fmt.Printf("Unable to serialize block: %s\n", err.Error())
+					op.SetError(fmt.Errorf("Unable to serialize block: %s", err.Error()))
 					return
 				}
 				batch.Put(block.K, serialization)
 				if i%KVWriteSize == KVWriteSize-1 || i == len(blocks)-1 {
 					if err := batch.Commit(); err != nil {
-						fmt.Printf("Error on trying to write batch: %s\n", err.Error())
+						op.SetError(fmt.Errorf("Error on trying to write batch: %s", err.Error()))
 						return
 					}
 					batch.Clear()
@@ -812,9 +949,9 @@ func AsyncWriteData(blocks Blocks, wg *sync.WaitGroup) error {
 			// Serialize and compress the blocks.
 			keyvalues := make(storage.KeyValues, len(blocks))
 			for i, block := range blocks {
-				serialization, err := dvid.SerializeData(block.V, dvid.Snappy, dvid.CRC32)
+				serialization, err := dvid.SerializeDataWith(block.V, compressor, dvid.CRC32)
 				if err != nil {
-					fmt.Printf("Unable to serialize block: %s\n", err.Error())
+					op.SetError(fmt.Errorf("Unable to serialize block: %s", err.Error()))
 					return
 				}
 				keyvalues[i] = storage.KeyValue{
@@ -826,7 +963,7 @@ func AsyncWriteData(blocks Blocks, wg *sync.WaitGroup) error {
 			// Write them in one swoop.
 			err := db.PutRange(keyvalues)
 			if err != nil {
-				fmt.Printf("Unable to write slice blocks: %s\n", err.Error())
+				op.SetError(fmt.Errorf("Unable to write slice blocks: %s", err.Error()))
 			}
 		}
 
@@ -834,6 +971,39 @@ func AsyncWriteData(blocks Blocks, wg *sync.WaitGroup) error {
 	return nil
 }
 
+// bulkWriteData serializes blocks and writes them in key order using the engine's
+// bulk loader.  Blank key ranges, e.g., a new layer of blocks, can use the faster
+// BulkIniter.
+func bulkWriteData(db storage.Engine, blocks Blocks, compressor dvid.Compressor) error {
+	if len(blocks) == 0 {
+		return nil
+	}
+	keyvalues := make(storage.KeyValues, len(blocks))
+	for i, block := range blocks {
+		serialization, err := dvid.SerializeDataWith(block.V, compressor, dvid.CRC32)
+		if err != nil {
+			return fmt.Errorf("Unable to serialize block: %s", err.Error())
+		}
+		keyvalues[i] = storage.KeyValue{
+			K: block.K,
+			V: serialization,
+		}
+	}
+	sort.Sort(keyvalues)
+
+	loader, err := storage.NewBulkLoader(db, keyvalues[0].K, keyvalues[len(keyvalues)-1].K)
+	if err != nil {
+		return err
+	}
+	defer loader.Close()
+	for _, kv := range keyvalues {
+		if err := loader.Put(kv.K, kv.V); err != nil {
+			return err
+		}
+	}
+	return loader.Commit()
+}
+
 type OpBounds struct {
 	blockBeg dvid.Point
 	dataBeg  dvid.Point
@@ -1519,6 +1689,120 @@ func (d *Data) JSONString() (jsonStr string, err error) {
 	return string(m), nil
 }
 
+// BlockDiff gives the blocks whose data differ between two versions.  Each block is
+// given by the coordinate of its first voxel.
+type BlockDiff struct {
+	BlockSize dvid.Point
+	Blocks    []dvid.Point
+}
+
+// GetDiff returns JSON giving the blocks that differ between the versions with the
+// given UUIDs.  Blocks differing in any channel of multichannel data are listed once.
+func (d *Data) GetDiff(uuid, other dvid.UUID) (string, error) {
+	firstKey, lastKey := d.VersionKeyRange(0)
+	diffs, err := server.DatastoreService().DiffVersions(uuid, other, d.DataName(),
+		firstKey.Index, lastKey.Index)
+	if err != nil {
+		return "", err
+	}
+	blockDiff := BlockDiff{d.BlockSize(), []dvid.Point{}}
+	found := make(map[dvid.IndexZYX]bool)
+	for _, diff := range diffs {
+		// Block indices end with the ZYX index, possibly after a channel.
+		if len(diff.Index) < dvid.IndexZYXSize {
+			return "", fmt.Errorf("Bad block index for data %s: %x", d.DataName(),
+				[]byte(diff.Index))
+		}
+		index, err := dvid.IndexZYX{}.IndexFromBytes(diff.Index[len(diff.Index)-dvid.IndexZYXSize:])
+		if err != nil {
+			return "", err
+		}
+		zyx := *(index.(*dvid.IndexZYX))
+		if found[zyx] {
+			continue
+		}
+		found[zyx] = true
+		blockDiff.Blocks = append(blockDiff.Blocks, dvid.ChunkPoint3d(zyx).MinVoxelPoint(d.BlockSize()))
+	}
+	m, err := json.Marshal(blockDiff)
+	if err != nil {
+		return "", err
+	}
+	return string(m), nil
+}
+
+// ServeDiff writes the JSON giving the blocks that differ between the version with
+// the given UUID and the other version named in the request's URL path, given as
+// parts /api/node/<UUID>/<data name>/diff/<other UUID>.
+func (d *Data) ServeDiff(uuid dvid.UUID, w http.ResponseWriter, r *http.Request, parts []string) error {
+	startTime := time.Now()
+	if len(parts) < 5 {
+		err := fmt.Errorf("ERROR: DVID requires other UUID to follow 'diff' command")
+		server.BadRequest(w, r, err.Error())
+		return err
+	}
+	other, err := server.MatchingUUID(parts[4])
+	if err != nil {
+		return err
+	}
+	jsonStr, err := d.GetDiff(uuid, other)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, jsonStr)
+	dvid.ElapsedTime(dvid.Debug, startTime, "HTTP %s: diff with %s (%s)", r.Method, other, r.URL)
+	return nil
+}
+
+// MergeValues merges a block changed in more than one parent of a merged version node,
+// taking each voxel from the parent that changed it.  An error is returned if parents
+// changed the same voxel to different values.  Missing blocks hold zero voxels.
+func (d *Data) MergeValues(index dvid.IndexBytes, ancestor []byte, values [][]byte) ([]byte, error) {
+	bytesPerVoxel := int(d.Values().BytesPerVoxel())
+	blockBytes := int(d.BlockSize().Prod()) * bytesPerVoxel
+	deserialize := func(value []byte) ([]byte, error) {
+		if value == nil {
+			return make([]byte, blockBytes), nil
+		}
+		block, _, err := dvid.DeserializeData(value, true)
+		if err != nil {
+			return nil, err
+		}
+		if len(block) != blockBytes {
+			return nil, fmt.Errorf("Block %x has %d bytes, expected %d", []byte(index),
+				len(block), blockBytes)
+		}
+		return block, nil
+	}
+	base, err := deserialize(ancestor)
+	if err != nil {
+		return nil, err
+	}
+	merged := make([]byte, blockBytes)
+	copy(merged, base)
+	changed := make([]bool, blockBytes/bytesPerVoxel)
+	for parent, value := range values {
+		block, err := deserialize(value)
+		if err != nil {
+			return nil, err
+		}
+		for i := 0; i < blockBytes; i += bytesPerVoxel {
+			voxel := block[i : i+bytesPerVoxel]
+			if bytes.Equal(voxel, base[i:i+bytesPerVoxel]) {
+				continue
+			}
+			if changed[i/bytesPerVoxel] && !bytes.Equal(voxel, merged[i:i+bytesPerVoxel]) {
+				return nil, fmt.Errorf("Voxel %d of block %x changed differently by parent %d",
+					i/bytesPerVoxel, []byte(index), parent)
+			}
+			copy(merged[i:i+bytesPerVoxel], voxel)
+			changed[i/bytesPerVoxel] = true
+		}
+	}
+	return dvid.SerializeDataWith(merged, d.Compressor(), dvid.CRC32)
+}
+
 // --- DataService interface ---
 
 // DoRPC acts as a switchboard for RPC commands.
@@ -1559,7 +1843,7 @@ func (d *Data) DoRPC(request datastore.Request, reply *datastore.Response) error
 			return err
 		}
 
-		return LoadXY(d, uuid, offset, filenames)
+		return LoadXY(d, uuid, request.Writes, offset, filenames)
 
 	case "put":
 		if len(request.Command) < 7 {
@@ -1628,6 +1912,8 @@ func (d *Data) DoHTTP(uuid dvid.UUID, w http.ResponseWriter, r *http.Request) er
 		w.Header().Set("Content-Type", "application/json")
 		fmt.Fprintf(w, jsonStr)
 		return nil
+	case "diff":
+		return d.ServeDiff(uuid, w, r, parts)
 	default:
 	}
 
@@ -1655,7 +1941,7 @@ func (d *Data) DoHTTP(uuid dvid.UUID, w http.ResponseWriter, r *http.Request) er
 			if err != nil {
 				return err
 			}
-			err = PutImage(uuid, d, e)
+			err = PutImage(uuid, datastore.HTTPWriteToken(r), d, e)
 			if err != nil {
 				return err
 			}
@@ -1731,7 +2017,9 @@ func (d *Data) processChunk(chunk *storage.Chunk) {
 
 	op, ok := chunk.Op.(*Operation)
 	if !ok {
-		log.Fatalf("Illegal operation passed to ProcessChunk() for data %s\n", d.DataName())
+		dvid.Log(dvid.Normal, "Illegal operation passed to ProcessChunk() for data %s\n",
+			d.DataName())
+		return
 	}
 
 	// Initialize the block buffer using the chunk of data.  For voxels, this chunk of
@@ -1743,8 +2031,9 @@ func (d *Data) processChunk(chunk *storage.Chunk) {
 	} else {
 		blockData, _, err = dvid.DeserializeData(chunk.V, true)
 		if err != nil {
-			dvid.Log(dvid.Normal, "Unable to deserialize block in '%s': %s\n",
-				d.DataID().DataName(), err.Error())
+			op.SetError(fmt.Errorf("Unable to deserialize block in '%s': %s",
+				d.DataID().DataName(), err.Error()))
+			return
 		}
 	}
 
@@ -1753,18 +2042,22 @@ func (d *Data) processChunk(chunk *storage.Chunk) {
 	switch op.OpType {
 	case GetOp:
 		if err = ReadFromBlock(op.ExtHandler, block, d.BlockSize()); err != nil {
-			log.Fatalln(err.Error())
+			op.SetError(err)
 		}
 	case PutOp:
 		if err = WriteToBlock(op.ExtHandler, block, d.BlockSize()); err != nil {
-			log.Fatalln(err.Error())
+			op.SetError(err)
+			return
 		}
 		db := server.StorageEngine()
-		serialization, err := dvid.SerializeData(blockData, dvid.Snappy, dvid.CRC32)
+		serialization, err := dvid.SerializeDataWith(blockData, d.Compressor(), dvid.CRC32)
 		if err != nil {
-			fmt.Printf("Unable to serialize block: %s\n", err.Error())
+			op.SetError(fmt.Errorf("Unable to serialize block: %s", err.Error()))
+			return
+		}
+		if err = db.Put(chunk.K, serialization); err != nil {
+			op.SetError(err)
 		}
-		db.Put(chunk.K, serialization)
 	}
 }
 
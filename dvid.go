@@ -102,9 +102,25 @@ Commands that can be performed without a running server:
 
 	about
 	help
-	init 
+	init [stores=<name>:<dir>,...] [routes=<selector>:<store name>,...] [cache=<MB>]
 	serve
 
+  The optional init settings route key spaces to additional stores.  A route
+  selector is one of keytype/<datasets|dataset|data|sync>, dataset/<local ID>,
+  or data/<data name>.  For example, to keep grayscale blocks on a separate disk:
+
+	dvid init stores=hdd:/mnt/hdd/dvid routes=data/grayscale:hdd
+
+  A store can be sharded across disks by joining its directories with "+":
+
+	dvid init stores=blocks:/disk1/dvid+/disk2/dvid routes=data/grayscale:blocks
+
+  The optional cache setting gives the size in megabytes of a read-through cache
+  of recently read values.  Cache hits and misses are reported by /api/load.
+
+  The about command lists software versions and, if a server is running, the
+  approximate storage used by each data instance broken down by version.
+
 `
 
 const helpServerMessage = `
@@ -236,22 +252,32 @@ func DoCommand(cmd dvid.Command) error {
 		return DoServe(cmd)
 	case "about":
 		fmt.Println(datastore.Versions())
+
+		// Add storage usage if a server is running.
+		if err := sendCommand(dvid.Command([]string{"about", "usage"})); err != nil {
+			fmt.Println("Storage usage is available from a running DVID server.")
+		}
 	// Send everything else to server via DVID terminal
 	default:
-		terminal := server.NewTerminal(*datastoreDir, *rpcAddress)
-		request := datastore.Request{Command: cmd}
-		if *useStdin {
-			var err error
-			request.Input, err = ioutil.ReadAll(os.Stdin)
-			if err != nil {
-				return fmt.Errorf("Error in reading from standard input: %s", err.Error())
-			}
-		}
-		return terminal.Send(request)
+		return sendCommand(cmd)
 	}
 	return nil
 }
 
+// sendCommand sends a command to a running server via the DVID terminal.
+func sendCommand(cmd dvid.Command) error {
+	terminal := server.NewTerminal(*datastoreDir, *rpcAddress)
+	request := datastore.Request{Command: cmd}
+	if *useStdin {
+		var err error
+		request.Input, err = ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			return fmt.Errorf("Error in reading from standard input: %s", err.Error())
+		}
+	}
+	return terminal.Send(request)
+}
+
 // DoInit performs the "init" command, creating a new DVID datastore.
 func DoInit(cmd dvid.Command) error {
 	create := true
@@ -118,6 +118,11 @@ func (img *Image) SubImage(r image.Rectangle) (*Image, error) {
 
 // Serialize writes compact byte slice representing image data.
 func (img *Image) Serialize(compress Compression, checksum Checksum) ([]byte, error) {
+	return img.SerializeWith(Compressor{compress, DefaultCompression}, checksum)
+}
+
+// SerializeWith writes compact byte slice representing image data using a Compressor.
+func (img *Image) SerializeWith(compressor Compressor, checksum Checksum) ([]byte, error) {
 	var buffer bytes.Buffer
 	err := buffer.WriteByte(byte(img.Which))
 	if err != nil {
@@ -195,7 +200,7 @@ func (img *Image) Serialize(compress Compression, checksum Checksum) ([]byte, er
 		return nil, err
 	}
 
-	return SerializeData(buffer.Bytes(), compress, checksum)
+	return SerializeDataWith(buffer.Bytes(), compressor, checksum)
 }
 
 // Deserialze deserializes an Image from a byte slice.
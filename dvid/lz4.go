@@ -0,0 +1,168 @@
+/*
+	This file implements LZ4 block compression, which favors speed over size.  The
+	block is preceded by its uncompressed length as a little-endian uint32 since the
+	LZ4 block format does not store it.
+*/
+
+package dvid
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+const (
+	lz4MinMatch     = 4
+	lz4HashLog      = 16
+	lz4MaxOffset    = 65535
+	lz4LastLiterals = 5  // The last bytes of a block are always literals.
+	lz4MFLimit      = 12 // The last match must start this many bytes before the end.
+
+	// A byte of compressed data can expand to at most this many bytes.
+	lz4MaxRatio = 255
+)
+
+// lz4Encode returns the LZ4 block compression of src, preceded by its length.
+func lz4Encode(src []byte) []byte {
+	dst := make([]byte, 4, 4+len(src)+len(src)/255+16)
+	binary.LittleEndian.PutUint32(dst, uint32(len(src)))
+
+	// Positions (+1) of recent 4-byte sequences by hash.
+	var table [1 << lz4HashLog]int32
+
+	anchor := 0
+	for i := 0; i < len(src)-lz4MFLimit; {
+		seq := binary.LittleEndian.Uint32(src[i:])
+		h := (seq * 2654435761) >> (32 - lz4HashLog)
+		ref := int(table[h]) - 1
+		table[h] = int32(i + 1)
+		if ref < 0 || i-ref > lz4MaxOffset || binary.LittleEndian.Uint32(src[ref:]) != seq {
+			i++
+			continue
+		}
+		matchLen := lz4MinMatch
+		for i+matchLen < len(src)-lz4LastLiterals && src[ref+matchLen] == src[i+matchLen] {
+			matchLen++
+		}
+		dst = lz4AppendSequence(dst, src[anchor:i], i-ref, matchLen)
+		i += matchLen
+		anchor = i
+	}
+	return lz4AppendSequence(dst, src[anchor:], 0, 0)
+}
+
+// lz4AppendSequence appends literals followed by a match or, if matchLen is 0,
+// the final literals of a block.
+func lz4AppendSequence(dst, literals []byte, offset, matchLen int) []byte {
+	litLen := len(literals)
+	var token byte
+	if litLen >= 15 {
+		token = 15 << 4
+	} else {
+		token = byte(litLen) << 4
+	}
+	if matchLen > 0 {
+		if matchLen-lz4MinMatch >= 15 {
+			token |= 15
+		} else {
+			token |= byte(matchLen - lz4MinMatch)
+		}
+	}
+	dst = append(dst, token)
+	if litLen >= 15 {
+		dst = lz4AppendLength(dst, litLen-15)
+	}
+	dst = append(dst, literals...)
+	if matchLen > 0 {
+		dst = append(dst, byte(offset), byte(offset>>8))
+		if matchLen-lz4MinMatch >= 15 {
+			dst = lz4AppendLength(dst, matchLen-lz4MinMatch-15)
+		}
+	}
+	return dst
+}
+
+func lz4AppendLength(dst []byte, n int) []byte {
+	for ; n >= 255; n -= 255 {
+		dst = append(dst, 255)
+	}
+	return append(dst, byte(n))
+}
+
+// lz4ReadLength adds the extra length bytes starting at src[i] to n.
+func lz4ReadLength(src []byte, i, n int) (int, int, error) {
+	for {
+		if i >= len(src) {
+			return 0, 0, fmt.Errorf("Corrupt LZ4 data: truncated length")
+		}
+		b := src[i]
+		i++
+		n += int(b)
+		if b != 255 {
+			return n, i, nil
+		}
+	}
+}
+
+// lz4Decode returns the data compressed by lz4Encode.
+func lz4Decode(src []byte) ([]byte, error) {
+	if len(src) < 4 {
+		return nil, fmt.Errorf("Corrupt LZ4 data: only %d bytes", len(src))
+	}
+	size := int(binary.LittleEndian.Uint32(src))
+	src = src[4:]
+	if size > lz4MaxRatio*len(src) {
+		return nil, fmt.Errorf("Corrupt LZ4 data: %d bytes cannot expand to %d bytes",
+			len(src), size)
+	}
+	dst := make([]byte, 0, size)
+
+	var err error
+	for i := 0; i < len(src); {
+		token := src[i]
+		i++
+		litLen := int(token >> 4)
+		if litLen == 15 {
+			if litLen, i, err = lz4ReadLength(src, i, litLen); err != nil {
+				return nil, err
+			}
+		}
+		if i+litLen > len(src) || len(dst)+litLen > size {
+			return nil, fmt.Errorf("Corrupt LZ4 data: bad literal length %d", litLen)
+		}
+		dst = append(dst, src[i:i+litLen]...)
+		i += litLen
+		if i == len(src) {
+			break
+		}
+
+		if i+2 > len(src) {
+			return nil, fmt.Errorf("Corrupt LZ4 data: truncated match offset")
+		}
+		offset := int(src[i]) | int(src[i+1])<<8
+		i += 2
+		if offset == 0 || offset > len(dst) {
+			return nil, fmt.Errorf("Corrupt LZ4 data: bad match offset %d", offset)
+		}
+		matchLen := int(token & 0x0F)
+		if matchLen == 15 {
+			if matchLen, i, err = lz4ReadLength(src, i, matchLen); err != nil {
+				return nil, err
+			}
+		}
+		matchLen += lz4MinMatch
+		if len(dst)+matchLen > size {
+			return nil, fmt.Errorf("Corrupt LZ4 data: bad match length %d", matchLen)
+		}
+
+		// Copy byte by byte since a match may overlap the bytes it produces.
+		start := len(dst) - offset
+		for j := 0; j < matchLen; j++ {
+			dst = append(dst, dst[start+j])
+		}
+	}
+	if len(dst) != size {
+		return nil, fmt.Errorf("Corrupt LZ4 data: got %d bytes, expected %d", len(dst), size)
+	}
+	return dst, nil
+}
@@ -6,21 +6,30 @@ package dvid
 
 import (
 	"bytes"
+	"compress/gzip"
 	"encoding/binary"
 	"encoding/gob"
 	"fmt"
 	"hash/crc32"
+	"io/ioutil"
 	_ "log"
+	"strconv"
+	"strings"
 
 	"github.com/janelia-flyem/go/snappy-go/snappy"
 )
 
-// Compression is the format of compression for storing data
+// Compression is the format of compression for storing data.  Only the lower 4 bits
+// are stored in a SerializationFormat.
 type Compression uint8
 
 const (
 	Uncompressed Compression = 0
 	Snappy                   = 1 << iota
+
+	// Later formats are numbered sequentially after Snappy.
+	Gzip Compression = 3
+	LZ4  Compression = 4
 )
 
 func (compress Compression) String() string {
@@ -29,11 +38,143 @@ func (compress Compression) String() string {
 		return "No compression"
 	case Snappy:
 		return "Google's Snappy compression"
+	case Gzip:
+		return "Gzip compression"
+	case LZ4:
+		return "LZ4 compression"
 	default:
 		return "Unknown compression"
 	}
 }
 
+// CompressionLevel trades speed for size in formats that support it.  Levels are
+// not stored in serializations since they are not needed for decompression.
+type CompressionLevel int8
+
+const (
+	// DefaultCompression uses the default level of a compression format.
+	DefaultCompression CompressionLevel = 0
+
+	// Range of gzip compression levels from fastest to smallest.
+	GzipBestSpeed       CompressionLevel = gzip.BestSpeed
+	GzipBestCompression CompressionLevel = gzip.BestCompression
+)
+
+// Compressor couples a compression format with a level.
+type Compressor struct {
+	Format Compression
+	Level  CompressionLevel
+}
+
+// NewCompressor returns a Compressor after checking that the format supports the
+// given level.
+func NewCompressor(format Compression, level CompressionLevel) (Compressor, error) {
+	switch format {
+	case Uncompressed, Snappy, LZ4:
+		if level != DefaultCompression {
+			return Compressor{}, fmt.Errorf("%s does not support compression levels", format)
+		}
+	case Gzip:
+		if level != DefaultCompression && (level < GzipBestSpeed || level > GzipBestCompression) {
+			return Compressor{}, fmt.Errorf("Gzip compression level must be %d-%d, not %d",
+				GzipBestSpeed, GzipBestCompression, level)
+		}
+	default:
+		return Compressor{}, fmt.Errorf("Illegal compression (%d)", format)
+	}
+	return Compressor{format, level}, nil
+}
+
+// ParseCompressor returns a Compressor from a specification of form
+// <format>[:<level>] where format is "none", "snappy", "gzip", or "lz4", e.g.,
+// "gzip:6".  The level is used only when writing: a SerializationFormat records just
+// the format, so values written at any level of a format read back the same way and
+// the level used for a stored value can't be recovered from it.
+func ParseCompressor(spec string) (Compressor, error) {
+	elems := strings.SplitN(strings.ToLower(spec), ":", 2)
+	var format Compression
+	switch elems[0] {
+	case "none":
+		format = Uncompressed
+	case "snappy":
+		format = Snappy
+	case "gzip":
+		format = Gzip
+	case "lz4":
+		format = LZ4
+	default:
+		return Compressor{}, fmt.Errorf("Unknown compression format '%s'", elems[0])
+	}
+	level := DefaultCompression
+	if len(elems) == 2 {
+		i, err := strconv.Atoi(elems[1])
+		if err != nil {
+			return Compressor{}, fmt.Errorf("Bad compression level '%s'", elems[1])
+		}
+		level = CompressionLevel(i)
+	}
+	return NewCompressor(format, level)
+}
+
+func (c Compressor) String() string {
+	if c.Level == DefaultCompression {
+		return c.Format.String()
+	}
+	return fmt.Sprintf("%s (level %d)", c.Format, c.Level)
+}
+
+// compress returns data compressed using the Compressor.
+func (c Compressor) compress(data []byte) ([]byte, error) {
+	switch c.Format {
+	case Uncompressed:
+		return data, nil
+	case Snappy:
+		return snappy.Encode(nil, data)
+	case Gzip:
+		level := int(c.Level)
+		if c.Level == DefaultCompression {
+			level = gzip.DefaultCompression
+		}
+		var buffer bytes.Buffer
+		w, err := gzip.NewWriterLevel(&buffer, level)
+		if err != nil {
+			return nil, err
+		}
+		if _, err = w.Write(data); err != nil {
+			return nil, err
+		}
+		if err = w.Close(); err != nil {
+			return nil, err
+		}
+		return buffer.Bytes(), nil
+	case LZ4:
+		return lz4Encode(data), nil
+	default:
+		return nil, fmt.Errorf("Illegal compression (%s) during serialization", c.Format)
+	}
+}
+
+// decompress returns data uncompressed using the given format.
+func decompress(data []byte, format Compression) ([]byte, error) {
+	switch format {
+	case Uncompressed:
+		return data, nil
+	case Snappy:
+		return snappy.Decode(nil, data)
+	case Gzip:
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return ioutil.ReadAll(r)
+	case LZ4:
+		return lz4Decode(data)
+	default:
+		return nil, fmt.Errorf("Illegal compressiont format (%d) in deserialization", format)
+	}
+}
+
 // Checksum is the type of checksum employed for error checking stored data
 type Checksum uint8
 
@@ -70,10 +211,15 @@ func DecodeSerializationFormat(s SerializationFormat) (compress Compression, che
 
 // Serialize a slice of bytes using optional compression, checksum
 func SerializeData(data []byte, compress Compression, checksum Checksum) (s []byte, err error) {
+	return SerializeDataWith(data, Compressor{compress, DefaultCompression}, checksum)
+}
+
+// SerializeDataWith serializes a slice of bytes using a Compressor and optional checksum.
+func SerializeDataWith(data []byte, compressor Compressor, checksum Checksum) (s []byte, err error) {
 	var buffer bytes.Buffer
 
 	// Store the requested compression and checksum
-	format := EncodeSerializationFormat(compress, checksum)
+	format := EncodeSerializationFormat(compressor.Format, checksum)
 	err = binary.Write(&buffer, binary.LittleEndian, format)
 	if err != nil {
 		return
@@ -81,14 +227,7 @@ func SerializeData(data []byte, compress Compression, checksum Checksum) (s []by
 
 	// Handle compression if requested
 	var byteData []byte
-	switch compress {
-	case Uncompressed:
-		byteData = data
-	case Snappy:
-		byteData, err = snappy.Encode(nil, data)
-	default:
-		err = fmt.Errorf("Illegal compression (%s) during serialization", compress)
-	}
+	byteData, err = compressor.compress(data)
 	if err != nil {
 		return
 	}
@@ -167,14 +306,8 @@ func DeserializeData(s []byte, uncompress bool) (data []byte, compress Compressi
 	}
 
 	// Uncompress if needed
-	if uncompress {
-		switch compress {
-		case Uncompressed:
-		case Snappy:
-			data, err = snappy.Decode(nil, data)
-		default:
-			err = fmt.Errorf("Illegal compressiont format (%d) in deserialization", compress)
-		}
+	if uncompress && err == nil {
+		data, err = decompress(data, compress)
 	}
 	return
 }
@@ -63,3 +63,58 @@ func (suite *DataSuite) TestSerialize(c *C) {
 	err = Deserialize(s, &returnComplexObj)
 	c.Assert(err, NotNil)
 }
+
+func (suite *DataSuite) TestCompressors(c *C) {
+	// Mix of compressible runs and incompressible bytes.
+	data := make([]byte, 100000)
+	for i := range data {
+		if (i/1000)%2 == 0 {
+			data[i] = byte(i / 1000)
+		} else {
+			data[i] = byte(i * 7919 >> 3)
+		}
+	}
+	for _, spec := range []string{"none", "snappy", "lz4", "gzip", "gzip:1", "gzip:9"} {
+		compressor, err := ParseCompressor(spec)
+		c.Assert(err, IsNil)
+		s, err := SerializeDataWith(data, compressor, CRC32)
+		c.Assert(err, IsNil)
+		if compressor.Format != Uncompressed && len(s) >= len(data) {
+			c.Errorf("%s serialization (%d bytes) not smaller than data (%d bytes)",
+				compressor, len(s), len(data))
+		}
+		returned, compress, err := DeserializeData(s, true)
+		c.Assert(err, IsNil)
+		c.Assert(compress, Equals, compressor.Format)
+		c.Assert(returned, DeepEquals, data)
+	}
+
+	for _, spec := range []string{"gzip:10", "snappy:3", "lz4:x", "zip"} {
+		_, err := ParseCompressor(spec)
+		c.Assert(err, NotNil)
+	}
+}
+
+func (suite *DataSuite) TestLZ4(c *C) {
+	for _, data := range [][]byte{
+		{},
+		[]byte("a"),
+		[]byte("aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"),
+		[]byte("abcdefghijklmnopabcdefghijklmnopabcdefghijklmnop!"),
+	} {
+		returned, err := lz4Decode(lz4Encode(data))
+		c.Assert(err, IsNil)
+		c.Assert(returned, DeepEquals, data)
+	}
+
+	// Corrupt data should fail rather than panic.
+	encoded := lz4Encode([]byte("abcdefghijklmnopabcdefghijklmnopabcdefghijklmnop!"))
+	for i := range encoded {
+		corrupt := make([]byte, len(encoded))
+		copy(corrupt, encoded)
+		corrupt[i] ^= 0xFF
+		lz4Decode(corrupt)
+	}
+	_, err := lz4Decode(encoded[:len(encoded)-1])
+	c.Assert(err, NotNil)
+}
@@ -95,6 +95,9 @@ func (terminal *Terminal) Shell() {
 // Send transmits an RPC command if a server is available or else it
 // runs the command in serverless mode.
 func (terminal *Terminal) Send(request datastore.Request) error {
+	if request.User == "" {
+		request.User = os.Getenv("USER")
+	}
 	var reply datastore.Response
 	if terminal.client != nil {
 		err := terminal.client.Call("RPCConnection.Do", request, &reply)
@@ -0,0 +1,63 @@
+package server_test
+
+import (
+	"encoding/json"
+
+	. "github.com/janelia-flyem/go/gocheck"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/server"
+)
+
+// DAGSuite checks queries of the version DAG.
+type DAGSuite struct {
+	service *server.Service
+	root    dvid.UUID
+}
+
+var _ = Suite(&DAGSuite{})
+
+func (s *DAGSuite) SetUpSuite(c *C) {
+	s.service, s.root, _ = openDatastore(c)
+	c.Assert(s.service.NewData(s.root, "keyvalue", "kv", dvid.NewConfig()), IsNil)
+	c.Assert(s.service.Lock(s.root), IsNil)
+}
+
+func (s *DAGSuite) TearDownSuite(c *C) {
+	server.CloseDatastore()
+}
+
+func (s *DAGSuite) TestDAGQueries(c *C) {
+	child, err := s.service.NewVersion(s.root)
+	c.Assert(err, IsNil)
+
+	query := func(args ...string) (string, error) {
+		command := append([]string{"dataset", string(child), "dag"}, args...)
+		request := datastore.Request{Command: dvid.Command(command)}
+		var reply datastore.Response
+		err := new(server.RPCConnection).Do(request, &reply)
+		return reply.Text, err
+	}
+
+	text, err := query("ancestors")
+	c.Assert(err, IsNil)
+	var nodes []datastore.DAGNode
+	c.Assert(json.Unmarshal([]byte(text), &nodes), IsNil)
+	c.Assert(nodes, HasLen, 1)
+	c.Assert(nodes[0].UUID, Equals, s.root)
+	c.Assert(nodes[0].Locked, Equals, true)
+
+	text, err = query("ancestor", string(s.root))
+	c.Assert(err, IsNil)
+	var node datastore.DAGNode
+	c.Assert(json.Unmarshal([]byte(text), &node), IsNil)
+	c.Assert(node.UUID, Equals, s.root)
+
+	_, err = query("ancestor")
+	c.Assert(err, NotNil)
+	_, err = query("heads", "extra")
+	c.Assert(err, NotNil)
+	_, err = query("cousins")
+	c.Assert(err, NotNil)
+}
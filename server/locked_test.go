@@ -0,0 +1,164 @@
+package server_test
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+
+	. "github.com/janelia-flyem/go/gocheck"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/server"
+
+	_ "github.com/janelia-flyem/dvid/datatype/keyvalue"
+	_ "github.com/janelia-flyem/dvid/datatype/labelmap"
+	_ "github.com/janelia-flyem/dvid/datatype/labels64"
+	_ "github.com/janelia-flyem/dvid/datatype/multichan16"
+	_ "github.com/janelia-flyem/dvid/datatype/tiles"
+	_ "github.com/janelia-flyem/dvid/datatype/voxels"
+)
+
+// LockedSuite checks that no data type can modify data at a locked node.
+type LockedSuite struct {
+	service *server.Service
+	root    dvid.UUID
+	file    string // an existing file to satisfy load commands
+}
+
+var _ = Suite(&LockedSuite{})
+
+// openDatastore creates and opens a datastore for the server in a new temporary
+// directory and returns the service and the root of a new dataset.  Since the server
+// holds one datastore at a time, each suite opens its own and closes it after its
+// tests.
+func openDatastore(c *C) (service *server.Service, root dvid.UUID, dir string) {
+	dir = c.MkDir()
+	c.Assert(datastore.Init(dir, true, dvid.Config{}), IsNil)
+
+	var err error
+	service, err = server.OpenDatastore(dir)
+	c.Assert(err, IsNil)
+
+	root, _, err = service.NewDataset()
+	c.Assert(err, IsNil)
+	return
+}
+
+// doRPC sends a command with "value" as input through the RPC interface.
+func doRPC(args ...string) error {
+	request := datastore.Request{Command: dvid.Command(args), Input: []byte("value")}
+	var reply datastore.Response
+	return new(server.RPCConnection).Do(request, &reply)
+}
+
+func (s *LockedSuite) SetUpSuite(c *C) {
+	var dir string
+	s.service, s.root, dir = openDatastore(c)
+
+	newData := func(typename, dataname string, settings map[string]string) {
+		config := dvid.NewConfig()
+		for key, value := range settings {
+			config[key] = value
+		}
+		c.Assert(s.service.NewData(s.root, typename, dataname, config), IsNil)
+	}
+	newData("keyvalue", "kv", nil)
+	newData("grayscale8", "grayscale", nil)
+	newData("labels64", "labels", nil)
+	newData("labelmap", "bodies", map[string]string{"labels": "labels"})
+	newData("multichan16", "mchan", nil)
+	newData("tiles", "tiles", map[string]string{"source": "grayscale"})
+
+	c.Assert(s.service.Lock(s.root), IsNil)
+
+	s.file = filepath.Join(dir, "input.png")
+	c.Assert(ioutil.WriteFile(s.file, []byte{}, 0644), IsNil)
+}
+
+func (s *LockedSuite) TearDownSuite(c *C) {
+	server.CloseDatastore()
+}
+
+func (s *LockedSuite) TestLockedRPC(c *C) {
+	root := string(s.root)
+	commands := [][]string{
+		{"node", root, "kv", "put", "key"},
+		{"node", root, "grayscale", "load", "0,0,0", s.file},
+		{"node", root, "grayscale", "put", "local", "xy", "0,0,0", s.file},
+		{"node", root, "labels", "load", "raveler", "0,0,0", s.file},
+		{"node", root, "labels", "composite", "grayscale", "composite"},
+		{"node", root, "bodies", "load", "raveler", s.file, s.file},
+		{"node", root, "bodies", "apply", "labels", "mapped"},
+		{"node", root, "mchan", "load", "local", s.file},
+		{"node", root, "tiles", "generate"},
+	}
+	for _, command := range commands {
+		err := doRPC(command...)
+		c.Assert(datastore.IsLockedError(err), Equals, true,
+			Commentf("command %q returned %v", command, err))
+	}
+
+	// The same writes are allowed in a branch of the locked node.
+	child, err := s.service.NewVersion(s.root)
+	c.Assert(err, IsNil)
+	c.Assert(doRPC("node", string(child), "kv", "put", "key"), IsNil)
+}
+
+func (s *LockedSuite) TestLockedHTTP(c *C) {
+	dataservice, err := s.service.DataService(s.root, "kv")
+	c.Assert(err, IsNil)
+
+	url := server.WebAPIPath + "node/" + string(s.root) + "/kv/key"
+	for _, method := range []string{"POST", "DELETE"} {
+		r, err := http.NewRequest(method, url, bytes.NewBufferString("value"))
+		c.Assert(err, IsNil)
+		err = dataservice.DoHTTP(s.root, httptest.NewRecorder(), r)
+		c.Assert(datastore.IsLockedError(err), Equals, true,
+			Commentf("%s returned %v", method, err))
+	}
+
+	// GET of locked data is allowed.
+	r, err := http.NewRequest("GET", url, nil)
+	c.Assert(err, IsNil)
+	err = dataservice.DoHTTP(s.root, httptest.NewRecorder(), r)
+	c.Assert(datastore.IsLockedError(err), Equals, false)
+
+	// POSTed images of voxels data, written through voxels.PutImage, are refused.
+	rect := image.Rect(0, 0, 4, 4)
+	gray := image.NewGray(rect)
+	labels := image.NewRGBA(rect)
+	for i := 3; i < len(labels.Pix); i += 4 {
+		labels.Pix[i] = 0xFF
+	}
+	images := map[string]image.Image{"grayscale": gray, "labels": labels}
+	for name, img := range images {
+		dataservice, err = s.service.DataService(s.root, dvid.DataString(name))
+		c.Assert(err, IsNil)
+		imageURL := server.WebAPIPath + "node/" + string(s.root) + "/" + name + "/xy/4_4/0_0_0"
+		err = dataservice.DoHTTP(s.root, httptest.NewRecorder(), postImage(c, imageURL, img))
+		c.Assert(datastore.IsLockedError(err), Equals, true,
+			Commentf("POST of %s returned %v", name, err))
+	}
+}
+
+// postImage returns a POST of a PNG image as the "image" form file, as sent to the
+// HTTP API of voxels data.
+func postImage(c *C, url string, img image.Image) *http.Request {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, err := writer.CreateFormFile("image", "image.png")
+	c.Assert(err, IsNil)
+	c.Assert(png.Encode(part, img), IsNil)
+	c.Assert(writer.Close(), IsNil)
+
+	r, err := http.NewRequest("POST", url, &body)
+	c.Assert(err, IsNil)
+	r.Header.Set("Content-Type", writer.FormDataContentType())
+	return r
+}
@@ -0,0 +1,66 @@
+package server_test
+
+import (
+	"strings"
+
+	. "github.com/janelia-flyem/go/gocheck"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/server"
+)
+
+// ProvenanceSuite checks that modifications of unlocked nodes are recorded in their
+// provenance.
+type ProvenanceSuite struct {
+	service *server.Service
+	root    dvid.UUID
+}
+
+var _ = Suite(&ProvenanceSuite{})
+
+func (s *ProvenanceSuite) SetUpSuite(c *C) {
+	s.service, s.root, _ = openDatastore(c)
+	c.Assert(s.service.NewData(s.root, "keyvalue", "kv", dvid.NewConfig()), IsNil)
+	c.Assert(s.service.Lock(s.root), IsNil)
+}
+
+func (s *ProvenanceSuite) TearDownSuite(c *C) {
+	server.CloseDatastore()
+}
+
+func (s *ProvenanceSuite) TestProvenance(c *C) {
+	child, err := s.service.NewVersion(s.root)
+	c.Assert(err, IsNil)
+	node := string(child)
+
+	do := func(user string, args ...string) (string, error) {
+		request := datastore.Request{Command: dvid.Command(args), Input: []byte("value"), User: user}
+		var reply datastore.Response
+		err := new(server.RPCConnection).Do(request, &reply)
+		return reply.Text, err
+	}
+
+	// Only requests that modify data are recorded.
+	_, err = do("alice", "node", node, "kv", "put", "key")
+	c.Assert(err, IsNil)
+	_, err = do("alice", "node", node, "kv", "get", "key")
+	c.Assert(err, IsNil)
+	_, err = do("alice", "node", node, "lock", "Added", "a", "key", "author=bob")
+	c.Assert(err, IsNil)
+
+	logs, err := s.service.NodeLogs(child)
+	c.Assert(err, IsNil)
+	c.Assert(logs[0].Message, Equals, "Added a key")
+	c.Assert(logs[0].Author, Equals, "bob")
+	c.Assert(logs[0].Operations, HasLen, 1)
+	op := logs[0].Operations[0]
+	c.Assert(op.User, Equals, "alice")
+	c.Assert(op.Data, Equals, dvid.DataString("kv"))
+	c.Assert(op.Command, Equals, "put key")
+
+	text, err := do("alice", "node", node, "log")
+	c.Assert(err, IsNil)
+	c.Assert(strings.Contains(text, "Added a key"), Equals, true)
+	c.Assert(strings.Contains(text, string(s.root)), Equals, true)
+}
@@ -0,0 +1,53 @@
+package server_test
+
+import (
+	. "github.com/janelia-flyem/go/gocheck"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/server"
+)
+
+// RefsSuite checks that nodes can be named by refs.
+type RefsSuite struct {
+	service *server.Service
+	root    dvid.UUID
+}
+
+var _ = Suite(&RefsSuite{})
+
+func (s *RefsSuite) SetUpSuite(c *C) {
+	s.service, s.root, _ = openDatastore(c)
+	c.Assert(s.service.NewData(s.root, "keyvalue", "kv", dvid.NewConfig()), IsNil)
+	c.Assert(s.service.Lock(s.root), IsNil)
+}
+
+func (s *RefsSuite) TearDownSuite(c *C) {
+	server.CloseDatastore()
+}
+
+func (s *RefsSuite) TestRefs(c *C) {
+	child, err := s.service.NewVersion(s.root)
+	c.Assert(err, IsNil)
+	c.Assert(doRPC("node", string(s.root), "ref", "new", "tag", "base"), IsNil)
+	c.Assert(doRPC("node", string(child), "ref", "new", "tag", "head"), NotNil)
+	c.Assert(doRPC("node", string(child), "ref", "new", "branch", "head"), IsNil)
+
+	// Ref names are accepted wherever a UUID is.
+	uuid, err := server.MatchingUUID("base")
+	c.Assert(err, IsNil)
+	c.Assert(uuid, Equals, s.root)
+	c.Assert(doRPC("node", "head", "kv", "put", "key"), IsNil)
+	c.Assert(datastore.IsLockedError(doRPC("node", "base", "kv", "put", "key")), Equals, true)
+
+	c.Assert(doRPC("node", string(s.root), "ref", "move", "base"), NotNil)
+	c.Assert(doRPC("node", "base", "ref", "move", "head"), IsNil)
+	uuid, err = server.MatchingUUID("head")
+	c.Assert(err, IsNil)
+	c.Assert(uuid, Equals, s.root)
+
+	c.Assert(doRPC("node", "base", "ref", "delete", "head"), IsNil)
+	c.Assert(doRPC("node", "base", "ref", "delete", "base"), IsNil)
+	_, err = server.MatchingUUID("base")
+	c.Assert(err, NotNil)
+}
@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/janelia-flyem/dvid/datastore"
@@ -17,8 +18,11 @@ import (
 const RPCHelpMessage = `Commands executed on the server (rpc address = %s):
 
 	help
-	about
+	about [usage]        (usage gives approximate bytes of data per version)
 	shutdown
+	fsck [repair]        (checks all keys, quarantining bad ones if repair)
+	backup <directory>   (writes a copy of the datastore that can be served)
+	compact [<UUID> <data name>]  (reclaims space held by deleted data)
 
 	types
 	types <datatype name> help
@@ -27,10 +31,24 @@ const RPCHelpMessage = `Commands executed on the server (rpc address = %s):
 	datasets new         (returns UUID of dataset's root node)
 
 	dataset <UUID> new <datatype name> <data name> <datatype-specific config>...
+	dataset <UUID> delete <data name>  (stored values are deleted in background)
+	dataset <UUID> refs  (lists tags and branches naming nodes of the dataset)
+	dataset <UUID> dag nodes|node|ancestors|descendants|heads
+	dataset <UUID> dag ancestor <UUID2>  (JSON of version DAG nodes, see HTTP API)
 	dataset <UUID> <data name> help
 
-	node <UUID> lock
+	node <UUID> lock [<message>] [author=<name>]  (locked nodes are read-only)
+	node <UUID> log      (provenance of the node and its ancestors)
+	node <UUID> ref new tag|branch <name>  (names can be used in place of UUIDs)
+	node <UUID> ref move <name>    (moves a branch to the node)
+	node <UUID> ref delete <name>
 	node <UUID> branch   (returns UUID of new child node)
+	node <UUID> merge <parent UUID>... [strategy=fail|prefer-<N>|hook]
+	                     (returns UUID of child merging the given locked nodes)
+	node <UUID> flatten  (copies data read from ancestors into the node)
+	node <UUID> archive  (stores a locked node as a delta of its first parent)
+	node <UUID> prune    (deletes all data of the node; children must be flattened)
+	node <UUID> <data name> verify [repair]
 	node <UUID> <data name> <type-specific commands>
 
 %s
@@ -65,7 +83,20 @@ func (c *RPCConnection) Do(cmd datastore.Request, reply *datastore.Response) err
 			runningService.WebAddress)
 
 	case "about":
-		reply.Text = fmt.Sprintf("%s\n", runningService.About())
+		var option string
+		cmd.CommandArgs(1, &option)
+		switch option {
+		case "":
+			reply.Text = fmt.Sprintf("%s\n", runningService.About())
+		case "usage":
+			text, err := runningService.UsageText()
+			if err != nil {
+				return err
+			}
+			reply.Text = text
+		default:
+			return fmt.Errorf("Unknown about option: %q", option)
+		}
 
 	case "shutdown":
 		Shutdown()
@@ -79,6 +110,52 @@ func (c *RPCConnection) Do(cmd datastore.Request, reply *datastore.Response) err
 			os.Exit(0)
 		}()
 
+	case "fsck":
+		var option string
+		cmd.CommandArgs(1, &option)
+		repair, err := repairOption(option)
+		if err != nil {
+			return err
+		}
+		report, err := runningService.Verify(repair)
+		if err != nil {
+			return err
+		}
+		reply.Text = report.String()
+
+	case "compact":
+		var uuidStr, dataname string
+		cmd.CommandArgs(1, &uuidStr, &dataname)
+		switch {
+		case uuidStr == "":
+			if err := runningService.Compact(); err != nil {
+				return err
+			}
+			reply.Text = "Compacted datastore\n"
+		case dataname == "":
+			return fmt.Errorf("The compact command requires both a UUID and data name or neither")
+		default:
+			uuid, err := MatchingUUID(uuidStr)
+			if err != nil {
+				return err
+			}
+			if err = runningService.CompactData(uuid, dvid.DataString(dataname)); err != nil {
+				return err
+			}
+			reply.Text = fmt.Sprintf("Compacted data %q\n", dataname)
+		}
+
+	case "backup":
+		var directory string
+		cmd.CommandArgs(1, &directory)
+		if directory == "" {
+			return fmt.Errorf("The backup command requires a target directory")
+		}
+		if err := runningService.Backup(directory); err != nil {
+			return err
+		}
+		reply.Text = fmt.Sprintf("Backup of datastore written to %s\n", directory)
+
 	case "types":
 		if len(cmd.Command) == 1 {
 			reply.Text = runningService.SupportedDataChart()
@@ -131,6 +208,33 @@ func (c *RPCConnection) Do(cmd datastore.Request, reply *datastore.Response) err
 				return err
 			}
 			reply.Text = fmt.Sprintf("Data %q [%s] added to node %s\n", dataname, typename, uuidStr)
+		case "delete":
+			cmd.CommandArgs(3, &dataname)
+			if dataname == "" {
+				return fmt.Errorf("The dataset delete command requires a data name")
+			}
+			reclaim, err := runningService.DeleteData(uuid, dvid.DataString(dataname))
+			if err != nil {
+				return err
+			}
+			reply.Text = fmt.Sprintf("Data %q deleted from dataset %s.  %s.\n", dataname,
+				reclaim.Dataset, reclaim)
+		case "dag":
+			var query string
+			cmd.CommandArgs(3, &query)
+			m, err := dagJSON(uuid, query, cmd.CommandArgs(4))
+			if err != nil {
+				return err
+			}
+			reply.Text = string(m)
+		case "refs":
+			refs, err := runningService.Refs(uuid)
+			if err != nil {
+				return err
+			}
+			for _, ref := range refs {
+				reply.Text += fmt.Sprintf("%-7s %-20s %s\n", ref.Type, ref.Name, ref.UUID)
+			}
 		default:
 			dataname := dvid.DataString(subcommand)
 			dataservice, err := runningService.DataService(uuid, dataname)
@@ -155,16 +259,68 @@ func (c *RPCConnection) Do(cmd datastore.Request, reply *datastore.Response) err
 		}
 		switch descriptor {
 		case "lock":
-			err := runningService.Lock(uuid)
+			author, found := cmd.Setting("author")
+			if !found {
+				author = cmd.User
+			}
+			message := strings.Join(cmd.CommandArgs(3), " ")
+			err := runningService.LockWithMessage(uuid, message, author)
+			if err != nil {
+				return err
+			}
+		case "log":
+			text, err := runningService.NodeLogText(uuid)
+			if err != nil {
+				return err
+			}
+			reply.Text = text
+		case "ref":
+			text, err := refCommand(cmd, uuid)
 			if err != nil {
 				return err
 			}
+			reply.Text = text
 		case "branch":
 			newuuid, err := runningService.NewVersion(uuid)
 			if err != nil {
 				return err
 			}
 			reply.Text = string(newuuid)
+		case "merge":
+			parents := []dvid.UUID{uuid}
+			for _, uuidStr := range cmd.CommandArgs(3) {
+				parent, err := MatchingUUID(uuidStr)
+				if err != nil {
+					return err
+				}
+				parents = append(parents, parent)
+			}
+			strategyStr, _ := cmd.Setting("strategy")
+			strategy, err := datastore.ParseMergeStrategy(strategyStr)
+			if err != nil {
+				return err
+			}
+			newuuid, err := runningService.Merge(parents, strategy)
+			if err != nil {
+				return err
+			}
+			reply.Text = string(newuuid)
+		case "flatten":
+			if err := runningService.Flatten(uuid); err != nil {
+				return err
+			}
+			reply.Text = fmt.Sprintf("Node %s no longer reads data from its ancestors\n", uuid)
+		case "archive":
+			if err := runningService.Archive(uuid); err != nil {
+				return err
+			}
+			reply.Text = fmt.Sprintf("Node %s archived as a delta of its parent\n", uuid)
+		case "prune":
+			deleted, err := runningService.Prune(uuid)
+			if err != nil {
+				return err
+			}
+			reply.Text = fmt.Sprintf("Node %s pruned, deleting %d key-value pairs\n", uuid, deleted)
 
 		default:
 			dataname := dvid.DataString(descriptor)
@@ -174,11 +330,29 @@ func (c *RPCConnection) Do(cmd datastore.Request, reply *datastore.Response) err
 			if err != nil {
 				return err
 			}
-			if subcommand == "help" {
+			switch subcommand {
+			case "help":
 				reply.Text = dataservice.Help()
 				return nil
+			case "verify":
+				var option string
+				cmd.CommandArgs(4, &option)
+				repair, err := repairOption(option)
+				if err != nil {
+					return err
+				}
+				report, err := runningService.VerifyData(uuid, dataname, repair)
+				if err != nil {
+					return err
+				}
+				reply.Text = report.String()
+				return nil
 			}
-			return dataservice.DoRPC(cmd, reply)
+			cmd.Writes = datastore.NewWriteToken()
+			if err = dataservice.DoRPC(cmd, reply); err != nil {
+				return err
+			}
+			recordModification(uuid, cmd.Writes, cmd.User, dataname, strings.Join(cmd.Command[3:], " "))
 		}
 
 	default:
@@ -186,3 +360,44 @@ func (c *RPCConnection) Do(cmd datastore.Request, reply *datastore.Response) err
 	}
 	return nil
 }
+
+// refCommand creates, moves or deletes a ref naming the node with the given UUID.
+func refCommand(cmd datastore.Request, uuid dvid.UUID) (string, error) {
+	var action, arg1, arg2 string
+	cmd.CommandArgs(3, &action, &arg1, &arg2)
+	switch action {
+	case "new":
+		refType, err := datastore.ParseRefType(arg1)
+		if err != nil {
+			return "", err
+		}
+		if err = runningService.NewRef(uuid, arg2, refType, cmd.User); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Node %s named by %s %q\n", uuid, refType, arg2), nil
+	case "move":
+		if err := runningService.MoveRef(uuid, arg1, cmd.User); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Branch %q moved to node %s\n", arg1, uuid), nil
+	case "delete":
+		if err := runningService.DeleteRef(uuid, arg1, cmd.User); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Ref %q deleted\n", arg1), nil
+	}
+	return "", fmt.Errorf("Unknown ref command %q: use new, move or delete", action)
+}
+
+// repairOption returns true if the optional argument of an integrity check asks
+// for repair.
+func repairOption(option string) (bool, error) {
+	switch option {
+	case "":
+		return false, nil
+	case "repair":
+		return true, nil
+	default:
+		return false, fmt.Errorf("Unknown option %q: expected 'repair' or nothing", option)
+	}
+}
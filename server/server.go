@@ -72,10 +72,11 @@ var (
 	GzipAPI = false
 )
 
-// Service holds information on the servers attached to a DVID datastore.  If more than
-// one storage engine is used by a DVID server, e.g., polyglot persistence where graphs
-// are managed by a graph database and key-value by a key-value database, this would
-// be the level at which the storage engines are integrated.
+// Service holds information on the servers attached to a DVID datastore.  Key spaces
+// can be routed to multiple key-value stores within the datastore service (see the
+// "stores" and "routes" settings of datastore.Init).  If other kinds of storage engines
+// are used by a DVID server, e.g., polyglot persistence where graphs are managed by a
+// graph database, this would be the level at which the storage engines are integrated.
 type Service struct {
 	// The currently opened DVID datastore
 	*datastore.Service
@@ -147,6 +148,34 @@ func VersionLocalID(uuid dvid.UUID) (dvid.VersionLocalID, error) {
 	return versionID, nil
 }
 
+// WritableVersion returns a server-specific local ID for the node with the given UUID
+// or an error if the node is locked and can't be modified.  The write is counted by the
+// token of the request making it.
+func WritableVersion(uuid dvid.UUID, token *datastore.WriteToken) (dvid.VersionLocalID, error) {
+	if runningService.Service == nil {
+		return 0, fmt.Errorf("Datastore service has not been started on this server.")
+	}
+	_, versionID, err := runningService.Service.WritableVersion(uuid, token)
+	if err != nil {
+		return 0, err
+	}
+	return versionID, nil
+}
+
+// recordModification adds a request to the provenance of a node if it modified data
+// at the node, i.e., if the request's token counted any writes.
+func recordModification(uuid dvid.UUID, token *datastore.WriteToken, user string,
+	dataname dvid.DataString, command string) {
+
+	if token.Writes() == 0 {
+		return
+	}
+	op := datastore.Operation{Time: time.Now(), User: user, Data: dataname, Command: command}
+	if err := runningService.RecordOperation(uuid, op); err != nil {
+		dvid.Error("Unable to record provenance of node %s: %s\n", uuid, err.Error())
+	}
+}
+
 // StorageEngine returns the default storage engine or nil if it's not available.
 func StorageEngine() storage.Engine {
 	if runningService.Service == nil {
@@ -155,13 +184,20 @@ func StorageEngine() storage.Engine {
 	return runningService.StorageEngine()
 }
 
+// CloseDatastore shuts down the datastore being served, after which another
+// datastore may be opened.
+func CloseDatastore() {
+	if runningService.Service != nil {
+		runningService.Service.Shutdown()
+		runningService.Service = nil
+	}
+}
+
 // Shutdown handles graceful cleanup of server functions before exiting DVID.
 // This may not be so graceful if the chunk handler uses cgo since the interrupt
 // may be caught during cgo execution.
 func Shutdown() {
-	if runningService.Service != nil {
-		runningService.Service.Shutdown()
-	}
+	CloseDatastore()
 	waits := 0
 	for {
 		active := MaxChunkHandlers - len(HandlerToken)
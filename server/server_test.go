@@ -0,0 +1,33 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/janelia-flyem/go/gocheck"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+// Hook up gocheck into the "go test" runner.
+func Test(t *testing.T) { TestingT(t) }
+
+type WebSuite struct{}
+
+var _ = Suite(&WebSuite{})
+
+func (s *WebSuite) TestDataRequestError(c *C) {
+	r, err := http.NewRequest("POST", WebAPIPath+"node/abc/kv/key", nil)
+	c.Assert(err, IsNil)
+
+	w := httptest.NewRecorder()
+	dataRequestError(w, r, &datastore.LockedError{dvid.UUID("abc")})
+	c.Assert(w.Code, Equals, http.StatusConflict)
+
+	w = httptest.NewRecorder()
+	dataRequestError(w, r, fmt.Errorf("Some other problem"))
+	c.Assert(w.Code, Equals, http.StatusBadRequest)
+}
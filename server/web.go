@@ -8,6 +8,7 @@ package server
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"path/filepath"
 	"runtime"
@@ -49,20 +50,58 @@ should be replaced by appropriate values.</p>
 
     <li><a href="/api/server/info">GET /api/server/info</a></li>
     <li><a href="/api/server/types">GET /api/server/types</a></li>
+    <li><a href="/api/server/storage">GET /api/server/storage</a><br />
+        Returns statistics of each storage engine, e.g., leveldb level sizes and file counts.</li>
+    <li>POST /api/server/backup<br />
+        The target directory should be sent via JSON, e.g., {"directory": "/backups/dvid"}.</li>
 
     <li><a href="/api/datasets/info">GET /api/datasets/info</a></li>
     <li><a href="/api/datasets/list">GET /api/datasets/list</a></li>
     <li>POST /api/datasets/new</li>
 
     <li>GET /api/dataset/{UUID}/info</li>
+    <li>GET /api/dataset/{UUID}/dag/{query}<br />
+        Returns nodes of the dataset's version DAG with their parents, children, lock
+        state, refs and timestamps.  The query is "nodes" for all nodes, "node" for the
+        {UUID} node, "ancestors" or "descendants" of the {UUID} node, "heads" for nodes
+        without children, or "ancestor/{UUID2}" for the lowest common ancestor of the
+        {UUID} and {UUID2} nodes.</li>
+    <li>GET /api/dataset/{UUID}/refs<br />
+        Returns the tags and branches naming nodes of the dataset.</li>
     <li>POST /api/dataset/{UUID}/new/{datatype name}/{data name}<br />
         Type-specific configuration settings should be sent via JSON.</li>
 
+    <li>DELETE /api/dataset/{UUID}/{data name}<br />
+        Removes the data from the dataset and deletes its stored values in the background.
+        Data used by other data, e.g., the source of tiles, cannot be deleted.</li>
+
     <li>GET /api/dataset/{UUID}/{data name}/{type-specific commands}</li>
 
-    <li>POST /api/node/{UUID}/lock</li>
+    <li>POST /api/node/{UUID}/lock<br />
+        Locked nodes are read-only.  Requests that modify their data return 409 Conflict.
+        An optional message and author can be sent via JSON, e.g.,
+        {"message": "Proofread bodies in ROI 3", "author": "jdoe"}.</li>
+    <li>GET /api/node/{UUID}/log<br />
+        Returns the provenance of the node and its ancestors, most recent first, including
+        lock messages and the requests that modified data at each node.  Requests that
+        modify data can name their user with a "user" query string, e.g., "?user=jdoe".</li>
+    <li>POST /api/node/{UUID}/ref/new/{tag or branch}/{name}<br />
+        Names the node by a tag or branch that can be used in place of a UUID.  Tags can
+        only name locked nodes.  Names must include a letter that is not hexadecimal.</li>
+    <li>POST /api/node/{UUID}/ref/move/{name}<br />
+        Moves the named branch to the node.</li>
+    <li>DELETE /api/node/{UUID}/ref/{name}</li>
     <li>POST /api/node/{UUID}/branch<br /></li>
-
+    <li>POST /api/node/{UUID}/merge<br />
+        Creates a child of this node and other locked parents that combines their changes.
+        Parents and an optional conflict strategy are sent via JSON, e.g.,
+        {"parents": ["3f8c"], "strategy": "fail"}.  The strategy is "fail" (default),
+        "prefer-N" to take values of the Nth parent, starting with this node as 0, or
+        "hook" to use data type-specific merging.  Unresolved conflicts return 409 Conflict.</li>
+
+    <li>GET /api/node/{UUID}/{data name}/usage<br />
+        Returns approximate storage used by the data for each version.  Append "/exact"
+        to also count keys and bytes by scanning.</li>
     <li>GET /api/node/{UUID}/{data name}/{type-specific commands}</li>
     <li>POST /api/node/{UUID}/{data name}/{type-specific commands}</li>
   </ul>
@@ -80,6 +119,18 @@ func BadRequest(w http.ResponseWriter, r *http.Request, message string) {
 	http.Error(w, errorMsg, http.StatusBadRequest)
 }
 
+// dataRequestError replies to a failed data request.  Attempts to modify data at a
+// locked node return 409 Conflict, other errors are bad requests.
+func dataRequestError(w http.ResponseWriter, r *http.Request, err error) {
+	if datastore.IsLockedError(err) {
+		errorMsg := fmt.Sprintf("ERROR using REST API: %s (%s).\n", err.Error(), r.URL.Path)
+		dvid.Error(errorMsg)
+		http.Error(w, errorMsg, http.StatusConflict)
+		return
+	}
+	BadRequest(w, r, err.Error())
+}
+
 // Index file redirection.
 func indexHandler(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, "/index.html", http.StatusMovedPermanently)
@@ -135,6 +186,7 @@ func helpRequest(w http.ResponseWriter, r *http.Request) {
 }
 
 func loadRequest(w http.ResponseWriter, r *http.Request) {
+	_, _, _, cacheBytes := storage.CacheStats()
 	m, err := json.Marshal(map[string]int{
 		"file bytes read":     storage.FileBytesReadPerSec,
 		"file bytes written":  storage.FileBytesWrittenPerSec,
@@ -144,6 +196,9 @@ func loadRequest(w http.ResponseWriter, r *http.Request) {
 		"value bytes written": storage.StoreValueBytesWrittenPerSec,
 		"GET requests":        storage.GetsPerSec,
 		"PUT requests":        storage.PutsPerSec,
+		"cache hits":          storage.CacheHitsPerSec,
+		"cache misses":        storage.CacheMissesPerSec,
+		"cache bytes":         int(cacheBytes),
 		"handlers active":     int(100 * ActiveHandlers / MaxChunkHandlers),
 		"goroutines":          runtime.NumGoroutine(),
 	})
@@ -177,7 +232,7 @@ func serverRequest(w http.ResponseWriter, r *http.Request) {
 	parts := strings.Split(url, "/")
 
 	badRequest := func() {
-		BadRequest(w, r, WebAPIPath+"server/ must be followed with 'info' or 'types'")
+		BadRequest(w, r, WebAPIPath+"server/ must be followed with 'info', 'types', 'storage' or 'backup'")
 	}
 
 	if len(parts) != 1 {
@@ -202,6 +257,36 @@ func serverRequest(w http.ResponseWriter, r *http.Request) {
 		}
 		w.Header().Set("Content-Type", "application/json")
 		fmt.Fprintf(w, jsonStr)
+	case "storage":
+		m, err := json.Marshal(runningService.StorageStats())
+		if err != nil {
+			BadRequest(w, r, err.Error())
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, string(m))
+	case "backup":
+		if strings.ToLower(r.Method) != "post" {
+			BadRequest(w, r, "Server 'backup' request must be made with HTTP POST method")
+			return
+		}
+		decoder := json.NewDecoder(r.Body)
+		var config dvid.Config
+		if err := decoder.Decode(&config); err != nil {
+			BadRequest(w, r, fmt.Sprintf("Error decoding POSTed JSON for 'backup': %s", err.Error()))
+			return
+		}
+		directory, found, err := config.GetString("directory")
+		if err != nil || !found || directory == "" {
+			BadRequest(w, r, "Server 'backup' request requires a target 'directory'")
+			return
+		}
+		if err = runningService.Backup(directory); err != nil {
+			BadRequest(w, r, err.Error())
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, "{%q: %q}", "Backup", directory)
 	default:
 		badRequest()
 	}
@@ -285,6 +370,39 @@ func datasetRequest(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Handle queries of the dataset's version DAG.
+	if parts[1] == "dag" {
+		if len(parts) < 3 {
+			BadRequest(w, r, "Bad URL: Expecting /api/dataset/<UUID>/dag/<query>")
+			return
+		}
+		m, err := dagJSON(uuid, parts[2], parts[3:])
+		if err != nil {
+			BadRequest(w, r, err.Error())
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(m)
+		return
+	}
+
+	// Handle query of the dataset's tags and branches.
+	if parts[1] == "refs" && len(parts) == 2 {
+		refs, err := runningService.Refs(uuid)
+		if err != nil {
+			BadRequest(w, r, err.Error())
+			return
+		}
+		m, err := json.Marshal(refs)
+		if err != nil {
+			BadRequest(w, r, err.Error())
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(m)
+		return
+	}
+
 	// Handle creation of new data in dataset via POST.
 	if parts[1] == "new" {
 		if action != "post" {
@@ -314,6 +432,20 @@ func datasetRequest(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Handle deletion of data via DELETE.
+	if len(parts) == 2 && action == "delete" {
+		dataname := dvid.DataString(parts[1])
+		reclaim, err := runningService.DeleteData(uuid, dataname)
+		if err != nil {
+			BadRequest(w, r, err.Error())
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, "{%q: %q}", "result", fmt.Sprintf("Deleted %s from dataset %s. %s.",
+			dataname, reclaim.Dataset, reclaim))
+		return
+	}
+
 	// Forward all other commands to the data service.
 	dataname := dvid.DataString(parts[1])
 	dataservice, err := runningService.DataService(uuid, dataname)
@@ -321,10 +453,45 @@ func datasetRequest(w http.ResponseWriter, r *http.Request) {
 		BadRequest(w, r, err.Error())
 		return
 	}
-	err = dataservice.DoHTTP(uuid, w, r)
+	doDataHTTP(w, r, uuid, dataservice)
+}
+
+// dagJSON returns the JSON result of a query of the version DAG holding the node
+// with the given UUID.
+func dagJSON(uuid dvid.UUID, query string, args []string) ([]byte, error) {
+	if query != "ancestor" && len(args) != 0 {
+		return nil, fmt.Errorf("DAG query %q takes no arguments", query)
+	}
+	var result interface{}
+	var err error
+	switch query {
+	case "nodes":
+		result, err = runningService.DAGNodes(uuid)
+	case "node":
+		result, err = runningService.DAGNode(uuid)
+	case "ancestors":
+		result, err = runningService.Ancestors(uuid)
+	case "descendants":
+		result, err = runningService.Descendants(uuid)
+	case "heads":
+		result, err = runningService.Heads(uuid)
+	case "ancestor":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("DAG query 'ancestor' requires one other node")
+		}
+		var other dvid.UUID
+		if other, err = MatchingUUID(args[0]); err != nil {
+			return nil, err
+		}
+		result, err = runningService.CommonAncestor([]dvid.UUID{uuid, other})
+	default:
+		return nil, fmt.Errorf("Unknown DAG query %q: use nodes, node, ancestors, "+
+			"descendants, heads or ancestor", query)
+	}
 	if err != nil {
-		BadRequest(w, r, err.Error())
+		return nil, err
 	}
+	return json.Marshal(result)
 }
 
 func nodeRequest(w http.ResponseWriter, r *http.Request) {
@@ -347,13 +514,21 @@ func nodeRequest(w http.ResponseWriter, r *http.Request) {
 	// Handle the dataset command.
 	switch parts[1] {
 	case "lock":
-		err := runningService.Lock(uuid)
+		lockRequest(w, r, uuid)
+
+	case "log":
+		logs, err := runningService.NodeLogs(uuid)
 		if err != nil {
 			BadRequest(w, r, err.Error())
-		} else {
-			w.Header().Set("Content-Type", "text/plain")
-			fmt.Fprintln(w, "Lock on node %s successful.", uuid)
+			return
+		}
+		m, err := json.Marshal(logs)
+		if err != nil {
+			BadRequest(w, r, err.Error())
+			return
 		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(m)
 
 	case "branch":
 		newuuid, err := runningService.NewVersion(uuid)
@@ -364,16 +539,175 @@ func nodeRequest(w http.ResponseWriter, r *http.Request) {
 			fmt.Fprintf(w, "{%q: %q}", "Branch", newuuid)
 		}
 
+	case "merge":
+		mergeRequest(w, r, uuid)
+
+	case "ref":
+		refRequest(w, r, uuid, parts[2:])
+
 	default:
 		dataname := dvid.DataString(parts[1])
+		if len(parts) > 2 && parts[2] == "usage" {
+			usageRequest(w, r, uuid, dataname, parts[3:])
+			return
+		}
 		dataservice, err := runningService.DataService(uuid, dataname)
 		if err != nil {
 			BadRequest(w, r, err.Error())
 			return
 		}
-		err = dataservice.DoHTTP(uuid, w, r)
+		doDataHTTP(w, r, uuid, dataservice)
+	}
+}
+
+// doDataHTTP forwards a request to a data service, adding requests that modify data
+// to the node's provenance.
+func doDataHTTP(w http.ResponseWriter, r *http.Request, uuid dvid.UUID,
+	dataservice datastore.DataService) {
+
+	token := datastore.NewWriteToken()
+	if err := dataservice.DoHTTP(uuid, w, datastore.WithWriteToken(r, token)); err != nil {
+		dataRequestError(w, r, err)
+		return
+	}
+	if r.Method != "GET" && r.Method != "HEAD" {
+		recordModification(uuid, token, requestUser(r), dataservice.DataName(),
+			r.Method+" "+r.URL.Path)
+	}
+}
+
+// requestUser returns the user making a request, given by a "user" query string, or
+// the remote address if no user was given.
+func requestUser(r *http.Request) string {
+	if user := r.URL.Query().Get("user"); user != "" {
+		return user
+	}
+	return r.RemoteAddr
+}
+
+// lockRequest locks a node.  An optional message and author can be POSTed as JSON.
+func lockRequest(w http.ResponseWriter, r *http.Request, uuid dvid.UUID) {
+	var config struct {
+		Message string
+		Author  string
+	}
+	if r.Body != nil {
+		if err := json.NewDecoder(r.Body).Decode(&config); err != nil && err != io.EOF {
+			BadRequest(w, r, fmt.Sprintf("Error decoding POSTed JSON for 'lock': %s", err.Error()))
+			return
+		}
+	}
+	if config.Message != "" && config.Author == "" {
+		config.Author = requestUser(r)
+	}
+	if err := runningService.LockWithMessage(uuid, config.Message, config.Author); err != nil {
+		BadRequest(w, r, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	fmt.Fprintf(w, "Lock on node %s successful.\n", uuid)
+}
+
+// refRequest creates, moves or deletes a ref naming the node with the given UUID.
+func refRequest(w http.ResponseWriter, r *http.Request, uuid dvid.UUID, parts []string) {
+	action := strings.ToLower(r.Method)
+	user := requestUser(r)
+	var err error
+	var result string
+	switch {
+	case action == "delete" && len(parts) == 1:
+		err = runningService.DeleteRef(uuid, parts[0], user)
+		result = fmt.Sprintf("Deleted ref %s", parts[0])
+	case action == "post" && len(parts) == 3 && parts[0] == "new":
+		var refType datastore.RefType
+		if refType, err = datastore.ParseRefType(parts[1]); err == nil {
+			err = runningService.NewRef(uuid, parts[2], refType, user)
+		}
+		result = fmt.Sprintf("Node %s named by %s %s", uuid, parts[1], parts[2])
+	case action == "post" && len(parts) == 2 && parts[0] == "move":
+		err = runningService.MoveRef(uuid, parts[1], user)
+		result = fmt.Sprintf("Moved branch %s to node %s", parts[1], uuid)
+	default:
+		BadRequest(w, r, "Expecting POST .../ref/new/<tag or branch>/<name>, "+
+			"POST .../ref/move/<name>, or DELETE .../ref/<name>")
+		return
+	}
+	if err != nil {
+		BadRequest(w, r, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, "{%q: %q}", "result", result)
+}
+
+// mergeRequest creates a child merging the node with the given UUID and the other
+// parents given in the POSTed JSON.
+func mergeRequest(w http.ResponseWriter, r *http.Request, uuid dvid.UUID) {
+	if strings.ToLower(r.Method) != "post" {
+		BadRequest(w, r, "Node 'merge' request must be made with HTTP POST method")
+		return
+	}
+	var config struct {
+		Parents  []string
+		Strategy string
+	}
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&config); err != nil {
+		BadRequest(w, r, fmt.Sprintf("Error decoding POSTed JSON for 'merge': %s", err.Error()))
+		return
+	}
+	parents := []dvid.UUID{uuid}
+	for _, uuidStr := range config.Parents {
+		parent, err := MatchingUUID(uuidStr)
 		if err != nil {
 			BadRequest(w, r, err.Error())
+			return
 		}
+		parents = append(parents, parent)
+	}
+	strategy, err := datastore.ParseMergeStrategy(config.Strategy)
+	if err != nil {
+		BadRequest(w, r, err.Error())
+		return
+	}
+	newuuid, err := runningService.Merge(parents, strategy)
+	if datastore.IsMergeConflictError(err) {
+		errorMsg := fmt.Sprintf("ERROR using REST API: %s (%s).\n", err.Error(), r.URL.Path)
+		dvid.Error(errorMsg)
+		http.Error(w, errorMsg, http.StatusConflict)
+		return
+	}
+	if err != nil {
+		BadRequest(w, r, err.Error())
+		return
 	}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, "{%q: %q}", "Merge", newuuid)
+}
+
+// usageRequest returns the storage used by data across versions.  An optional
+// "exact" path element requests exact counts from a scan of the data's keys.
+func usageRequest(w http.ResponseWriter, r *http.Request, uuid dvid.UUID,
+	dataname dvid.DataString, options []string) {
+
+	var exact bool
+	if len(options) > 0 && options[0] != "" {
+		if len(options) > 1 || options[0] != "exact" {
+			BadRequest(w, r, "Data 'usage' request can only be followed by 'exact'")
+			return
+		}
+		exact = true
+	}
+	usage, err := runningService.DataUsage(uuid, dataname, exact)
+	if err != nil {
+		BadRequest(w, r, err.Error())
+		return
+	}
+	m, err := json.Marshal(usage)
+	if err != nil {
+		BadRequest(w, r, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, string(m))
 }
@@ -0,0 +1,1444 @@
+// +build bptree
+
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"hash/fnv"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+const (
+	Version = "Pure-Go B+tree Storage Engine"
+
+	Driver = "github.com/janelia-flyem/dvid/storage/bptree.go"
+
+	// Default size of the cache holding recently read B+tree nodes.
+	DefaultCacheSize = 256 * dvid.Mega
+
+	// If Sync=true, each commit is flushed to disk before it is considered complete,
+	// so committed batches survive machine crashes.  If Sync=false, a crash may lose
+	// recent commits although the file is always left in a consistent state.
+	DefaultSync = true
+)
+
+const (
+	// Name of the single file holding all data within the datastore directory.
+	bptFilename = "dvid.bpt"
+
+	// Identifies a DVID B+tree file and its format version.
+	bptMagic   = 0x44564244
+	bptVersion = 1
+
+	// Size of a page, the unit of allocation within the file.  Nodes larger than
+	// a page, e.g., leaves holding large values, span contiguous pages.
+	bptPageSize = 4096
+
+	// Bytes of a node page header: flags, pad, count, overflow, payload size, checksum.
+	bptHeaderSize = 20
+
+	// Bytes of a meta page that are used.
+	bptMetaSize = 56
+
+	// Number of deletes committed per transaction during DeleteRange.
+	bptDeleteBatchSize = 10000
+
+	// Bytes of presorted key-value pairs held in a batch before a bulk load commits.
+	bptBulkBatchBytes = 8 * dvid.Mega
+)
+
+// Flags for the type of node stored in a page.
+const (
+	bptLeafPage uint16 = 1 << iota
+	bptBranchPage
+	bptFreelistPage
+)
+
+type pgid uint64
+
+/*
+	The file is a copy-on-write B+tree.  Pages 0 and 1 hold alternating meta pages that
+	point to the root node and the list of free pages.  A transaction never modifies
+	pages reachable from the last committed meta page.  Instead, modified nodes are
+	written to free pages, the file is synced, and then the meta page not in use is
+	overwritten with a higher transaction id.  On open, the valid meta page with the
+	highest transaction id is used, so a crash at any point leaves either the old or
+	the new tree intact.
+
+	Readers pin the tree of the transaction they started with, and pages freed by later
+	transactions are not reused until no reader can reach them.
+*/
+
+// bptMeta locates the tree and freelist for a committed transaction.
+type bptMeta struct {
+	txid          uint64
+	root          pgid
+	freelist      pgid
+	freelistPages uint32
+	numPages      pgid // Pages at or beyond this id have never been allocated.
+}
+
+func (m *bptMeta) bytes() []byte {
+	b := make([]byte, bptPageSize)
+	binary.LittleEndian.PutUint32(b[0:4], bptMagic)
+	binary.LittleEndian.PutUint32(b[4:8], bptVersion)
+	binary.LittleEndian.PutUint32(b[8:12], bptPageSize)
+	binary.LittleEndian.PutUint32(b[12:16], m.freelistPages)
+	binary.LittleEndian.PutUint64(b[16:24], m.txid)
+	binary.LittleEndian.PutUint64(b[24:32], uint64(m.root))
+	binary.LittleEndian.PutUint64(b[32:40], uint64(m.freelist))
+	binary.LittleEndian.PutUint64(b[40:48], uint64(m.numPages))
+	h := fnv.New64a()
+	h.Write(b[0:48])
+	binary.LittleEndian.PutUint64(b[48:56], h.Sum64())
+	return b
+}
+
+// bptMetaFromBytes returns the meta data if the bytes hold a valid meta page.
+func bptMetaFromBytes(b []byte) (*bptMeta, error) {
+	if len(b) < bptMetaSize {
+		return nil, fmt.Errorf("meta page too small (%d bytes)", len(b))
+	}
+	if binary.LittleEndian.Uint32(b[0:4]) != bptMagic {
+		return nil, fmt.Errorf("not a DVID B+tree file")
+	}
+	if v := binary.LittleEndian.Uint32(b[4:8]); v != bptVersion {
+		return nil, fmt.Errorf("unsupported B+tree file version %d", v)
+	}
+	if sz := binary.LittleEndian.Uint32(b[8:12]); sz != bptPageSize {
+		return nil, fmt.Errorf("unsupported page size %d", sz)
+	}
+	h := fnv.New64a()
+	h.Write(b[0:48])
+	if h.Sum64() != binary.LittleEndian.Uint64(b[48:56]) {
+		return nil, fmt.Errorf("meta page checksum mismatch")
+	}
+	return &bptMeta{
+		freelistPages: binary.LittleEndian.Uint32(b[12:16]),
+		txid:          binary.LittleEndian.Uint64(b[16:24]),
+		root:          pgid(binary.LittleEndian.Uint64(b[24:32])),
+		freelist:      pgid(binary.LittleEndian.Uint64(b[32:40])),
+		numPages:      pgid(binary.LittleEndian.Uint64(b[40:48])),
+	}, nil
+}
+
+// bptNode is a decoded leaf or branch node.  Nodes read from the file are shared
+// through the cache and must not be modified.
+type bptNode struct {
+	leaf bool
+	keys [][]byte
+
+	// Values for a leaf node.
+	vals [][]byte
+
+	// Children for a branch node.  keys[i] is a lower bound for keys under children[i].
+	children []pgid
+
+	// Number of pages the node occupied when read.
+	pages uint32
+}
+
+// size returns the bytes needed to encode element i.
+func (n *bptNode) size(i int) int {
+	var buf [binary.MaxVarintLen64]byte
+	sz := binary.PutUvarint(buf[:], uint64(len(n.keys[i]))) + len(n.keys[i])
+	if n.leaf {
+		sz += binary.PutUvarint(buf[:], uint64(len(n.vals[i]))) + len(n.vals[i])
+	} else {
+		sz += 8
+	}
+	return sz
+}
+
+// childIndex returns the index of the child whose key range may hold the key.
+func (n *bptNode) childIndex(k []byte) int {
+	i := sort.Search(len(n.keys), func(i int) bool {
+		return bytes.Compare(n.keys[i], k) > 0
+	})
+	if i > 0 {
+		i--
+	}
+	return i
+}
+
+// search returns the position of the first key >= k.
+func (n *bptNode) search(k []byte) int {
+	return sort.Search(len(n.keys), func(i int) bool {
+		return bytes.Compare(n.keys[i], k) >= 0
+	})
+}
+
+// split partitions the node's elements into nodes that each fit within a page where
+// possible.  Branch pieces hold at least two children so the tree height is bounded.
+func (n *bptNode) split() []*bptNode {
+	minElements := 1
+	if !n.leaf {
+		minElements = 2
+	}
+	maxSize := bptPageSize - bptHeaderSize
+	pieces := []*bptNode{}
+	piece := &bptNode{leaf: n.leaf}
+	size := 0
+	for i := range n.keys {
+		elemSize := n.size(i)
+		if len(piece.keys) >= minElements && size+elemSize > maxSize {
+			pieces = append(pieces, piece)
+			piece = &bptNode{leaf: n.leaf}
+			size = 0
+		}
+		piece.keys = append(piece.keys, n.keys[i])
+		if n.leaf {
+			piece.vals = append(piece.vals, n.vals[i])
+		} else {
+			piece.children = append(piece.children, n.children[i])
+		}
+		size += elemSize
+	}
+	return append(pieces, piece)
+}
+
+// encode returns the node as whole pages.
+func (n *bptNode) encode() []byte {
+	payload := make([]byte, 0, bptPageSize)
+	var buf [binary.MaxVarintLen64]byte
+	for i, k := range n.keys {
+		payload = append(payload, buf[:binary.PutUvarint(buf[:], uint64(len(k)))]...)
+		payload = append(payload, k...)
+		if n.leaf {
+			v := n.vals[i]
+			payload = append(payload, buf[:binary.PutUvarint(buf[:], uint64(len(v)))]...)
+			payload = append(payload, v...)
+		} else {
+			var child [8]byte
+			binary.LittleEndian.PutUint64(child[:], uint64(n.children[i]))
+			payload = append(payload, child[:]...)
+		}
+	}
+	flags := bptBranchPage
+	if n.leaf {
+		flags = bptLeafPage
+	}
+	return encodePage(flags, len(n.keys), payload)
+}
+
+// encodePage returns a header and payload padded to whole pages.
+func encodePage(flags uint16, count int, payload []byte) []byte {
+	numPages := (bptHeaderSize + len(payload) + bptPageSize - 1) / bptPageSize
+	b := make([]byte, numPages*bptPageSize)
+	binary.LittleEndian.PutUint16(b[0:2], flags)
+	binary.LittleEndian.PutUint32(b[4:8], uint32(count))
+	binary.LittleEndian.PutUint32(b[8:12], uint32(numPages-1))
+	binary.LittleEndian.PutUint32(b[12:16], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(b[16:20], crc32.ChecksumIEEE(payload))
+	copy(b[bptHeaderSize:], payload)
+	return b
+}
+
+// decodeNode returns a node from its payload.
+func decodeNode(flags uint16, count int, payload []byte) (*bptNode, error) {
+	n := &bptNode{
+		leaf: flags == bptLeafPage,
+		keys: make([][]byte, count),
+	}
+	if n.leaf {
+		n.vals = make([][]byte, count)
+	} else {
+		n.children = make([]pgid, count)
+	}
+	pos := 0
+	readBytes := func() ([]byte, error) {
+		length, nbytes := binary.Uvarint(payload[pos:])
+		if nbytes <= 0 || pos+nbytes+int(length) > len(payload) {
+			return nil, fmt.Errorf("bad element length")
+		}
+		pos += nbytes
+		b := payload[pos : pos+int(length)]
+		pos += int(length)
+		return b, nil
+	}
+	var err error
+	for i := 0; i < count; i++ {
+		if n.keys[i], err = readBytes(); err != nil {
+			return nil, err
+		}
+		if n.leaf {
+			if n.vals[i], err = readBytes(); err != nil {
+				return nil, err
+			}
+		} else {
+			if pos+8 > len(payload) {
+				return nil, fmt.Errorf("bad child pointer")
+			}
+			n.children[i] = pgid(binary.LittleEndian.Uint64(payload[pos : pos+8]))
+			pos += 8
+		}
+	}
+	return n, nil
+}
+
+// bptFreelist tracks pages that can be allocated.
+type bptFreelist struct {
+	// Sorted ids of pages that are free for allocation.
+	ids []pgid
+
+	// Pages freed by each transaction, held until no reader can reach them.
+	pending map[uint64][]pgid
+}
+
+func (f *bptFreelist) copy() *bptFreelist {
+	dup := &bptFreelist{
+		ids:     make([]pgid, len(f.ids)),
+		pending: make(map[uint64][]pgid, len(f.pending)),
+	}
+	copy(dup.ids, f.ids)
+	for txid, ids := range f.pending {
+		dup.pending[txid] = ids
+	}
+	return dup
+}
+
+// allocate returns the first id of a contiguous run of n free pages or 0 if none.
+func (f *bptFreelist) allocate(n int) pgid {
+	var start pgid
+	for i, id := range f.ids {
+		if i == 0 || id != f.ids[i-1]+1 {
+			start = id
+		}
+		if int(id-start)+1 == n {
+			first := i - n + 1
+			f.ids = append(f.ids[:first], f.ids[i+1:]...)
+			return start
+		}
+	}
+	return 0
+}
+
+// free marks pages as freed by a transaction.
+func (f *bptFreelist) free(txid uint64, start pgid, n uint32) {
+	ids := f.pending[txid]
+	for i := pgid(0); i < pgid(n); i++ {
+		ids = append(ids, start+i)
+	}
+	f.pending[txid] = ids
+}
+
+// release makes pages freed by transactions <= txid available for allocation.
+func (f *bptFreelist) release(txid uint64) {
+	for t, ids := range f.pending {
+		if t <= txid {
+			f.ids = append(f.ids, ids...)
+			delete(f.pending, t)
+		}
+	}
+	sort.Sort(pgids(f.ids))
+}
+
+// all returns both free and pending page ids.
+func (f *bptFreelist) all() []pgid {
+	ids := make([]pgid, len(f.ids))
+	copy(ids, f.ids)
+	for _, pending := range f.pending {
+		ids = append(ids, pending...)
+	}
+	sort.Sort(pgids(ids))
+	return ids
+}
+
+type pgids []pgid
+
+func (s pgids) Len() int           { return len(s) }
+func (s pgids) Less(i, j int) bool { return s[i] < s[j] }
+func (s pgids) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// --- The BPTreeDB Implementation must satisfy a Engine interface ----
+
+// BPTreeDB is a pure Go key-value store kept in a single copy-on-write B+tree file.
+// It requires no cgo, and batches are committed atomically and durably.  A single
+// writer is allowed at a time while readers proceed without blocking.
+type BPTreeDB struct {
+	// Directory of datastore
+	directory string
+
+	// Config at time of Open()
+	config dvid.Config
+
+	file *os.File
+	sync bool
+
+	// Serializes write transactions.
+	writeLock sync.Mutex
+
+	// Serializes commits of storage transactions (Txn).
+	txnMutex
+
+	// Guards the committed state and open readers below.
+	stateLock sync.Mutex
+	meta      *bptMeta
+	freelist  *bptFreelist
+	readers   map[uint64]int
+
+	// Cache of decoded nodes.
+	cacheLock  sync.Mutex
+	cache      map[pgid]*bptNode
+	cacheBytes int
+	cacheSize  int
+}
+
+// NewStore returns a B+tree backend kept in a single file within the given directory.
+// As with the leveldb drivers, creating a store that already exists or opening one
+// that doesn't is an error.
+func NewStore(path string, create bool, config dvid.Config) (Engine, error) {
+	cacheSize, found, err := config.GetInt("CacheSize")
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		cacheSize = DefaultCacheSize
+	} else {
+		cacheSize *= dvid.Mega
+	}
+	doSync, found, err := config.GetBool("Sync")
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		doSync = DefaultSync
+	}
+
+	db := &BPTreeDB{
+		directory: path,
+		config:    config,
+		sync:      doSync,
+		readers:   make(map[uint64]int),
+		cache:     make(map[pgid]*bptNode),
+		cacheSize: cacheSize,
+	}
+	filename := filepath.Join(path, bptFilename)
+	if create {
+		if err := os.MkdirAll(path, 0755); err != nil {
+			return nil, err
+		}
+		db.file, err = os.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("Cannot create B+tree store: %s", err.Error())
+		}
+		if err = db.initFile(); err != nil {
+			db.file.Close()
+			return nil, err
+		}
+	} else {
+		db.file, err = os.OpenFile(filename, os.O_RDWR, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("Cannot open B+tree store: %s", err.Error())
+		}
+		if err = db.loadFile(); err != nil {
+			db.file.Close()
+			return nil, err
+		}
+	}
+	return db, nil
+}
+
+// initFile writes the meta pages, an empty freelist at page 2 and an empty root leaf
+// at page 3.
+func (db *BPTreeDB) initFile() error {
+	db.meta = &bptMeta{
+		root:          3,
+		freelist:      2,
+		freelistPages: 1,
+		numPages:      4,
+	}
+	db.freelist = &bptFreelist{ids: []pgid{}, pending: make(map[uint64][]pgid)}
+	metaBytes := db.meta.bytes()
+	pages := [][]byte{
+		metaBytes,
+		metaBytes,
+		encodePage(bptFreelistPage, 0, nil),
+		(&bptNode{leaf: true}).encode(),
+	}
+	for i, b := range pages {
+		if _, err := db.file.WriteAt(b, int64(i*bptPageSize)); err != nil {
+			return err
+		}
+	}
+	return db.file.Sync()
+}
+
+// loadFile reads the most recent valid meta page and its freelist.
+func (db *BPTreeDB) loadFile() error {
+	var metaErr error
+	for i := 0; i < 2; i++ {
+		b := make([]byte, bptMetaSize)
+		if _, err := db.file.ReadAt(b, int64(i*bptPageSize)); err != nil {
+			metaErr = err
+			continue
+		}
+		meta, err := bptMetaFromBytes(b)
+		if err != nil {
+			metaErr = err
+			continue
+		}
+		if db.meta == nil || meta.txid > db.meta.txid {
+			db.meta = meta
+		}
+	}
+	if db.meta == nil {
+		return fmt.Errorf("No valid meta page in B+tree store %s: %s", db.directory, metaErr)
+	}
+	flags, count, payload, err := db.readPage(db.meta.freelist)
+	if err != nil {
+		return err
+	}
+	if flags != bptFreelistPage || len(payload) < count*8 {
+		return fmt.Errorf("Bad freelist page %d in B+tree store %s", db.meta.freelist, db.directory)
+	}
+	db.freelist = &bptFreelist{ids: make([]pgid, count), pending: make(map[uint64][]pgid)}
+	for i := range db.freelist.ids {
+		db.freelist.ids[i] = pgid(binary.LittleEndian.Uint64(payload[i*8 : i*8+8]))
+	}
+	return nil
+}
+
+// readPage returns the verified header fields and payload of the page run at id.
+func (db *BPTreeDB) readPage(id pgid) (flags uint16, count int, payload []byte, err error) {
+	b := make([]byte, bptPageSize)
+	if _, err = db.file.ReadAt(b, int64(id)*bptPageSize); err != nil {
+		err = fmt.Errorf("Cannot read page %d of B+tree store %s: %s", id, db.directory, err.Error())
+		return
+	}
+	flags = binary.LittleEndian.Uint16(b[0:2])
+	count = int(binary.LittleEndian.Uint32(b[4:8]))
+	overflow := binary.LittleEndian.Uint32(b[8:12])
+	size := int(binary.LittleEndian.Uint32(b[12:16]))
+	checksum := binary.LittleEndian.Uint32(b[16:20])
+	if size > int(overflow+1)*bptPageSize-bptHeaderSize {
+		err = fmt.Errorf("Corrupt page %d in B+tree store %s: bad payload size", id, db.directory)
+		return
+	}
+	if overflow > 0 {
+		b = make([]byte, int(overflow+1)*bptPageSize)
+		if _, err = db.file.ReadAt(b, int64(id)*bptPageSize); err != nil {
+			err = fmt.Errorf("Cannot read page %d of B+tree store %s: %s", id, db.directory, err.Error())
+			return
+		}
+	}
+	payload = b[bptHeaderSize : bptHeaderSize+size]
+	if crc32.ChecksumIEEE(payload) != checksum {
+		err = fmt.Errorf("Corrupt page %d in B+tree store %s: checksum mismatch", id, db.directory)
+	}
+	return
+}
+
+// node returns the decoded node at a page, using the cache when possible.
+func (db *BPTreeDB) node(id pgid) (*bptNode, error) {
+	db.cacheLock.Lock()
+	n, found := db.cache[id]
+	db.cacheLock.Unlock()
+	if found {
+		return n, nil
+	}
+
+	flags, count, payload, err := db.readPage(id)
+	if err != nil {
+		return nil, err
+	}
+	if flags != bptLeafPage && flags != bptBranchPage {
+		return nil, fmt.Errorf("Page %d in B+tree store %s is not a tree node", id, db.directory)
+	}
+	n, err = decodeNode(flags, count, payload)
+	if err != nil {
+		return nil, fmt.Errorf("Corrupt node at page %d in B+tree store %s: %s",
+			id, db.directory, err.Error())
+	}
+	n.pages = uint32((bptHeaderSize + len(payload) + bptPageSize - 1) / bptPageSize)
+
+	db.cacheLock.Lock()
+	if db.cacheBytes+len(payload) > db.cacheSize {
+		db.cache = make(map[pgid]*bptNode)
+		db.cacheBytes = 0
+	}
+	db.cache[id] = n
+	db.cacheBytes += len(payload)
+	db.cacheLock.Unlock()
+	return n, nil
+}
+
+// evict removes a page from the node cache since it is about to be rewritten.
+func (db *BPTreeDB) evict(id pgid) {
+	db.cacheLock.Lock()
+	delete(db.cache, id)
+	db.cacheLock.Unlock()
+}
+
+// ---- Engine interface ----
+
+func (db *BPTreeDB) IsBatcher() bool       { return true }
+func (db *BPTreeDB) IsSnapshotter() bool   { return true }
+func (db *BPTreeDB) IsBulkIniter() bool    { return true }
+func (db *BPTreeDB) IsBulkWriter() bool    { return true }
+func (db *BPTreeDB) IsSizeEstimator() bool { return false }
+
+func (db *BPTreeDB) GetConfig() dvid.Config {
+	return db.config
+}
+
+// ---- Read transactions ----
+
+// bptReader reads the tree committed by a transaction.  The tree's pages are not
+// reused until the reader is closed.
+type bptReader struct {
+	db   *BPTreeDB
+	txid uint64
+	root pgid
+}
+
+func (db *BPTreeDB) beginRead() *bptReader {
+	db.stateLock.Lock()
+	r := &bptReader{db, db.meta.txid, db.meta.root}
+	db.readers[r.txid]++
+	db.stateLock.Unlock()
+	return r
+}
+
+func (r *bptReader) close() {
+	if r.db == nil {
+		return
+	}
+	r.db.stateLock.Lock()
+	r.db.readers[r.txid]--
+	if r.db.readers[r.txid] <= 0 {
+		delete(r.db.readers, r.txid)
+	}
+	r.db.stateLock.Unlock()
+	r.db = nil
+}
+
+// Get returns a value given a key.  If the key is not present, a nil value
+// and nil error is returned as with the leveldb drivers.
+func (r *bptReader) Get(k Key) (v []byte, err error) {
+	kBytes := k.Bytes()
+	n, err := r.db.node(r.root)
+	for err == nil && !n.leaf {
+		n, err = r.db.node(n.children[n.childIndex(kBytes)])
+	}
+	if err != nil {
+		return nil, err
+	}
+	i := n.search(kBytes)
+	if i < len(n.keys) && bytes.Equal(n.keys[i], kBytes) {
+		v = make([]byte, len(n.vals[i]))
+		copy(v, n.vals[i])
+	}
+	StoreValueBytesRead <- len(v)
+	return
+}
+
+// GetRange returns a range of values spanning (kStart, kEnd) keys.  These key-value
+// pairs will be sorted in ascending key order.
+func (r *bptReader) GetRange(kStart, kEnd Key) (values []KeyValue, err error) {
+	values = []KeyValue{}
+	err = r.scan(kStart, kEnd, func(key Key, value []byte) error {
+		values = append(values, KeyValue{key, value})
+		return nil
+	})
+	return
+}
+
+// KeysInRange returns a range of present keys spanning (kStart, kEnd).  Values
+// associated with the keys are not read.
+func (r *bptReader) KeysInRange(kStart, kEnd Key) (keys []Key, err error) {
+	keys = []Key{}
+	it := newRangeIterator(r.newCursor(false), kStart, kEnd, nil)
+	defer it.Close()
+	for ; it.Valid(); it.Next() {
+		var key Key
+		if key, err = it.Key(); err != nil {
+			return
+		}
+		keys = append(keys, key)
+	}
+	err = it.Error()
+	return
+}
+
+// ProcessRange sends a range of key-value pairs to chunk handlers.  Since the range
+// is read from a committed tree, handlers may safely write to the store.
+func (r *bptReader) ProcessRange(kStart, kEnd Key, op *ChunkOp, f func(*Chunk)) error {
+	return r.scan(kStart, kEnd, func(key Key, value []byte) error {
+		if op != nil && op.Wg != nil {
+			op.Wg.Add(1)
+		}
+		chunk := &Chunk{
+			op,
+			KeyValue{key, value},
+		}
+		f(chunk)
+		return nil
+	})
+}
+
+// scan calls f for each key-value pair in the range in ascending key order.
+func (r *bptReader) scan(kStart, kEnd Key, f func(Key, []byte) error) error {
+	it := newRangeIterator(r.newCursor(false), kStart, kEnd, nil)
+	defer it.Close()
+	for ; it.Valid(); it.Next() {
+		key, err := it.Key()
+		if err != nil {
+			return err
+		}
+		if err = f(key, it.Value()); err != nil {
+			return err
+		}
+	}
+	return it.Error()
+}
+
+// NewIterator returns an Iterator over key/value pairs spanning (kStart, kEnd).
+func (r *bptReader) NewIterator(kStart, kEnd Key, opts *IteratorOptions) (Iterator, error) {
+	return newRangeIterator(r.newCursor(false), kStart, kEnd, opts), nil
+}
+
+// bptFrame is a node and position along a cursor's path from the root.
+type bptFrame struct {
+	node  *bptNode
+	index int
+}
+
+// bptCursor traverses the leaves of a committed tree.
+type bptCursor struct {
+	reader  *bptReader
+	release bool // Close the reader when the cursor is closed.
+	stack   []bptFrame
+	err     error
+}
+
+func (r *bptReader) newCursor(release bool) *bptCursor {
+	return &bptCursor{reader: r, release: release}
+}
+
+// descend pushes frames from the node at id down to a leaf, positioning each frame
+// at the first or last element.
+func (cur *bptCursor) descend(id pgid, last bool) {
+	for cur.err == nil {
+		n, err := cur.reader.db.node(id)
+		if err != nil {
+			cur.err = err
+			return
+		}
+		index := 0
+		if last {
+			index = len(n.keys) - 1
+		}
+		cur.stack = append(cur.stack, bptFrame{n, index})
+		if n.leaf || len(n.keys) == 0 {
+			return
+		}
+		id = n.children[index]
+	}
+}
+
+// step moves the cursor to the next (or previous) element, crossing leaves as needed.
+func (cur *bptCursor) step(forward bool) {
+	for cur.err == nil && len(cur.stack) > 0 {
+		top := &cur.stack[len(cur.stack)-1]
+		if forward {
+			top.index++
+		} else {
+			top.index--
+		}
+		if top.index >= 0 && top.index < len(top.node.keys) {
+			if top.node.leaf {
+				return
+			}
+			cur.descend(top.node.children[top.index], !forward)
+			if cur.Valid() {
+				return
+			}
+			// An empty leaf was reached, so continue stepping from it.
+			continue
+		}
+		cur.stack = cur.stack[:len(cur.stack)-1]
+	}
+}
+
+func (cur *bptCursor) Valid() bool {
+	if cur.err != nil || len(cur.stack) == 0 {
+		return false
+	}
+	top := cur.stack[len(cur.stack)-1]
+	return top.node.leaf && top.index >= 0 && top.index < len(top.node.keys)
+}
+
+func (cur *bptCursor) Key() []byte {
+	if !cur.Valid() {
+		return nil
+	}
+	top := cur.stack[len(cur.stack)-1]
+	key := make([]byte, len(top.node.keys[top.index]))
+	copy(key, top.node.keys[top.index])
+	return key
+}
+
+func (cur *bptCursor) Value() []byte {
+	if !cur.Valid() {
+		return nil
+	}
+	top := cur.stack[len(cur.stack)-1]
+	value := make([]byte, len(top.node.vals[top.index]))
+	copy(value, top.node.vals[top.index])
+	return value
+}
+
+func (cur *bptCursor) Seek(key []byte) {
+	cur.stack = cur.stack[:0]
+	id := cur.reader.root
+	for cur.err == nil {
+		n, err := cur.reader.db.node(id)
+		if err != nil {
+			cur.err = err
+			return
+		}
+		if n.leaf {
+			cur.stack = append(cur.stack, bptFrame{n, n.search(key)})
+			if !cur.Valid() {
+				// Key is past this leaf so move to the first key of the next leaf.
+				cur.stack[len(cur.stack)-1].index--
+				cur.step(true)
+			}
+			return
+		}
+		if len(n.keys) == 0 {
+			return
+		}
+		index := n.childIndex(key)
+		cur.stack = append(cur.stack, bptFrame{n, index})
+		id = n.children[index]
+	}
+}
+
+func (cur *bptCursor) SeekToFirst() {
+	cur.stack = cur.stack[:0]
+	cur.descend(cur.reader.root, false)
+	if !cur.Valid() && len(cur.stack) > 0 {
+		cur.stack[len(cur.stack)-1].index = -1
+		cur.step(true)
+	}
+}
+
+func (cur *bptCursor) SeekToLast() {
+	cur.stack = cur.stack[:0]
+	cur.descend(cur.reader.root, true)
+	if !cur.Valid() && len(cur.stack) > 0 {
+		top := &cur.stack[len(cur.stack)-1]
+		top.index = len(top.node.keys)
+		cur.step(false)
+	}
+}
+
+func (cur *bptCursor) Next() {
+	if cur.Valid() {
+		cur.step(true)
+	}
+}
+
+func (cur *bptCursor) Prev() {
+	if cur.Valid() {
+		cur.step(false)
+	}
+}
+
+func (cur *bptCursor) GetError() error { return cur.err }
+
+func (cur *bptCursor) Close() {
+	cur.stack = nil
+	if cur.release {
+		cur.reader.close()
+	}
+}
+
+// ---- KeyValueDB interface -----
+
+// Close closes the B+tree file.
+func (db *BPTreeDB) Close() {
+	db.writeLock.Lock()
+	defer db.writeLock.Unlock()
+	if db.file != nil {
+		if err := db.file.Close(); err != nil {
+			dvid.Log(dvid.Normal, "Error closing B+tree store %s: %s\n", db.directory, err.Error())
+		}
+		db.file = nil
+	}
+}
+
+// Get returns a value given a key.
+func (db *BPTreeDB) Get(k Key) ([]byte, error) {
+	r := db.beginRead()
+	defer r.close()
+	return r.Get(k)
+}
+
+// GetRange returns a range of values spanning (kStart, kEnd) keys.  These key-value
+// pairs will be sorted in ascending key order.
+func (db *BPTreeDB) GetRange(kStart, kEnd Key) ([]KeyValue, error) {
+	r := db.beginRead()
+	defer r.close()
+	return r.GetRange(kStart, kEnd)
+}
+
+// KeysInRange returns a range of present keys spanning (kStart, kEnd).  Values
+// associated with the keys are not read.
+func (db *BPTreeDB) KeysInRange(kStart, kEnd Key) ([]Key, error) {
+	r := db.beginRead()
+	defer r.close()
+	return r.KeysInRange(kStart, kEnd)
+}
+
+// ProcessRange sends a range of key-value pairs to chunk handlers.
+func (db *BPTreeDB) ProcessRange(kStart, kEnd Key, op *ChunkOp, f func(*Chunk)) error {
+	r := db.beginRead()
+	defer r.close()
+	return r.ProcessRange(kStart, kEnd, op, f)
+}
+
+// NewIterator returns an Iterator over key/value pairs spanning (kStart, kEnd).  The
+// iterator reads the tree committed when it was created.
+func (db *BPTreeDB) NewIterator(kStart, kEnd Key, opts *IteratorOptions) (Iterator, error) {
+	return newRangeIterator(db.beginRead().newCursor(true), kStart, kEnd, opts), nil
+}
+
+// Put writes a value with given key.
+func (db *BPTreeDB) Put(k Key, v []byte) error {
+	kBytes := k.Bytes()
+	err := db.update(func(tx *bptWriteTx) error {
+		return tx.put(kBytes, v)
+	})
+	if err != nil {
+		return err
+	}
+	StoreKeyBytesWritten <- len(kBytes)
+	StoreValueBytesWritten <- len(v)
+	return nil
+}
+
+// PutRange puts key/value pairs that have been sorted in sequential key order.
+// All pairs are committed in a single transaction.
+func (db *BPTreeDB) PutRange(values []KeyValue) error {
+	var keyBytesPut, valueBytesPut int
+	err := db.update(func(tx *bptWriteTx) error {
+		for _, kv := range values {
+			kBytes := kv.K.Bytes()
+			if err := tx.put(kBytes, kv.V); err != nil {
+				return err
+			}
+			keyBytesPut += len(kBytes)
+			valueBytesPut += len(kv.V)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	StoreKeyBytesWritten <- keyBytesPut
+	StoreValueBytesWritten <- valueBytesPut
+	return nil
+}
+
+// Delete removes a value with given key.
+func (db *BPTreeDB) Delete(k Key) error {
+	return db.update(func(tx *bptWriteTx) error {
+		return tx.del(k.Bytes())
+	})
+}
+
+// DeleteRange removes all key-value pairs spanning (kStart, kEnd).  Keys are deleted
+// in a series of transactions to bound the memory used by each.
+func (db *BPTreeDB) DeleteRange(kStart, kEnd Key) error {
+	for {
+		keys := [][]byte{}
+		r := db.beginRead()
+		it := newRangeIterator(r.newCursor(true), kStart, kEnd, &IteratorOptions{Limit: bptDeleteBatchSize})
+		for ; it.Valid(); it.Next() {
+			keys = append(keys, it.cur.Key())
+		}
+		err := it.Error()
+		it.Close()
+		if err != nil {
+			return err
+		}
+		if len(keys) == 0 {
+			return nil
+		}
+		err = db.update(func(tx *bptWriteTx) error {
+			for _, key := range keys {
+				if err := tx.del(key); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil || len(keys) < bptDeleteBatchSize {
+			return err
+		}
+	}
+}
+
+// ---- Write transactions ----
+
+// bptTxNode is a node copied for modification within a write transaction.
+type bptTxNode struct {
+	bptNode
+
+	// Page the node was read from or 0 if the node is new.
+	id pgid
+
+	// Children copied into this transaction, indexed like children.
+	kids []*bptTxNode
+
+	dirty bool
+}
+
+// bptChild is a written node and its smallest key.
+type bptChild struct {
+	key []byte
+	id  pgid
+}
+
+// bptWriteTx modifies a copy of the tree.  Modified nodes are only written to free
+// pages on commit.
+type bptWriteTx struct {
+	db       *BPTreeDB
+	meta     bptMeta
+	freelist *bptFreelist
+	root     *bptTxNode
+}
+
+// update runs a function within a write transaction and commits it if no error is
+// returned.
+func (db *BPTreeDB) update(f func(*bptWriteTx) error) error {
+	db.writeLock.Lock()
+	defer db.writeLock.Unlock()
+	if db.file == nil {
+		return fmt.Errorf("Cannot write to closed B+tree store %s", db.directory)
+	}
+
+	db.stateLock.Lock()
+	tx := &bptWriteTx{
+		db:       db,
+		meta:     *db.meta,
+		freelist: db.freelist.copy(),
+	}
+	oldest := db.meta.txid
+	for txid := range db.readers {
+		if txid < oldest {
+			oldest = txid
+		}
+	}
+	db.stateLock.Unlock()
+
+	tx.meta.txid++
+	tx.freelist.release(oldest)
+	root, err := tx.copyNode(tx.meta.root)
+	if err != nil {
+		return err
+	}
+	tx.root = root
+	if err := f(tx); err != nil {
+		return err
+	}
+	return tx.commit()
+}
+
+// copyNode returns a modifiable copy of a committed node.
+func (tx *bptWriteTx) copyNode(id pgid) (*bptTxNode, error) {
+	n, err := tx.db.node(id)
+	if err != nil {
+		return nil, err
+	}
+	txNode := &bptTxNode{
+		bptNode: bptNode{
+			leaf:  n.leaf,
+			keys:  make([][]byte, len(n.keys)),
+			pages: n.pages,
+		},
+		id: id,
+	}
+	copy(txNode.keys, n.keys)
+	if n.leaf {
+		txNode.vals = make([][]byte, len(n.vals))
+		copy(txNode.vals, n.vals)
+	} else {
+		txNode.children = make([]pgid, len(n.children))
+		copy(txNode.children, n.children)
+		txNode.kids = make([]*bptTxNode, len(n.children))
+	}
+	return txNode, nil
+}
+
+// leafPath returns the path of nodes from the root to the leaf that may hold a key.
+func (tx *bptWriteTx) leafPath(k []byte) ([]*bptTxNode, error) {
+	path := []*bptTxNode{tx.root}
+	n := tx.root
+	for !n.leaf && len(n.keys) > 0 {
+		i := n.childIndex(k)
+		if n.kids[i] == nil {
+			kid, err := tx.copyNode(n.children[i])
+			if err != nil {
+				return nil, err
+			}
+			n.kids[i] = kid
+		}
+		n = n.kids[i]
+		path = append(path, n)
+	}
+	return path, nil
+}
+
+func (tx *bptWriteTx) put(k, v []byte) error {
+	path, err := tx.leafPath(k)
+	if err != nil {
+		return err
+	}
+	key := make([]byte, len(k))
+	copy(key, k)
+	value := make([]byte, len(v))
+	copy(value, v)
+
+	leaf := path[len(path)-1]
+	i := leaf.search(key)
+	if i < len(leaf.keys) && bytes.Equal(leaf.keys[i], key) {
+		leaf.vals[i] = value
+	} else {
+		leaf.keys = append(leaf.keys, nil)
+		copy(leaf.keys[i+1:], leaf.keys[i:])
+		leaf.keys[i] = key
+		leaf.vals = append(leaf.vals, nil)
+		copy(leaf.vals[i+1:], leaf.vals[i:])
+		leaf.vals[i] = value
+	}
+	for _, n := range path {
+		n.dirty = true
+	}
+	return nil
+}
+
+func (tx *bptWriteTx) del(k []byte) error {
+	path, err := tx.leafPath(k)
+	if err != nil {
+		return err
+	}
+	leaf := path[len(path)-1]
+	i := leaf.search(k)
+	if i == len(leaf.keys) || !bytes.Equal(leaf.keys[i], k) {
+		return nil
+	}
+	leaf.keys = append(leaf.keys[:i], leaf.keys[i+1:]...)
+	leaf.vals = append(leaf.vals[:i], leaf.vals[i+1:]...)
+	for _, n := range path {
+		n.dirty = true
+	}
+	return nil
+}
+
+// allocate returns the first page of a contiguous run of n pages.
+func (tx *bptWriteTx) allocate(n int) pgid {
+	id := tx.freelist.allocate(n)
+	if id == 0 {
+		id = tx.meta.numPages
+		tx.meta.numPages += pgid(n)
+	}
+	return id
+}
+
+// write allocates pages for the encoded node or freelist and writes them.
+func (tx *bptWriteTx) write(b []byte) (pgid, error) {
+	numPages := len(b) / bptPageSize
+	id := tx.allocate(numPages)
+	tx.db.evict(id)
+	if _, err := tx.db.file.WriteAt(b, int64(id)*bptPageSize); err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// spill writes a dirty node and its dirty descendants, splitting nodes that are too
+// large.  The written nodes are returned for insertion into the parent.  Nodes left
+// empty are dropped.
+func (tx *bptWriteTx) spill(n *bptTxNode) ([]bptChild, error) {
+	if !n.leaf {
+		keys := [][]byte{}
+		children := []pgid{}
+		for i, id := range n.children {
+			if n.kids != nil && n.kids[i] != nil && n.kids[i].dirty {
+				written, err := tx.spill(n.kids[i])
+				if err != nil {
+					return nil, err
+				}
+				for _, child := range written {
+					keys = append(keys, child.key)
+					children = append(children, child.id)
+				}
+			} else {
+				keys = append(keys, n.keys[i])
+				children = append(children, id)
+			}
+		}
+		n.keys = keys
+		n.children = children
+		n.kids = nil
+	}
+	if n.id != 0 {
+		tx.freelist.free(tx.meta.txid, n.id, n.pages)
+	}
+	if len(n.keys) == 0 {
+		return nil, nil
+	}
+	written := []bptChild{}
+	for _, piece := range n.split() {
+		id, err := tx.write(piece.encode())
+		if err != nil {
+			return nil, err
+		}
+		written = append(written, bptChild{piece.keys[0], id})
+	}
+	return written, nil
+}
+
+// commit writes the modified tree and freelist, then makes them visible by writing
+// a new meta page.
+func (tx *bptWriteTx) commit() error {
+	if !tx.root.dirty {
+		return nil
+	}
+	db := tx.db
+
+	written, err := tx.spill(tx.root)
+	if err != nil {
+		return err
+	}
+	for len(written) > 1 {
+		root := &bptTxNode{dirty: true}
+		for _, child := range written {
+			root.keys = append(root.keys, child.key)
+			root.children = append(root.children, child.id)
+		}
+		if written, err = tx.spill(root); err != nil {
+			return err
+		}
+	}
+	if len(written) == 0 {
+		id, err := tx.write((&bptNode{leaf: true}).encode())
+		if err != nil {
+			return err
+		}
+		tx.meta.root = id
+	} else {
+		tx.meta.root = written[0].id
+	}
+
+	// Write the freelist, including pages still held for readers since there
+	// are no readers when the file is next opened.
+	tx.freelist.free(tx.meta.txid, tx.meta.freelist, tx.meta.freelistPages)
+	maxIds := len(tx.freelist.all())
+	numPages := (bptHeaderSize + maxIds*8 + bptPageSize - 1) / bptPageSize
+	freelistID := tx.allocate(numPages)
+	ids := tx.freelist.all()
+	payload := make([]byte, len(ids)*8)
+	for i, id := range ids {
+		binary.LittleEndian.PutUint64(payload[i*8:i*8+8], uint64(id))
+	}
+	b := encodePage(bptFreelistPage, len(ids), payload)
+	db.evict(freelistID)
+	if _, err := db.file.WriteAt(b, int64(freelistID)*bptPageSize); err != nil {
+		return err
+	}
+	tx.meta.freelist = freelistID
+	tx.meta.freelistPages = uint32(len(b) / bptPageSize)
+
+	// Make the transaction visible with the meta page not used by the last commit.
+	if db.sync {
+		if err := db.file.Sync(); err != nil {
+			return err
+		}
+	}
+	metaOffset := int64(tx.meta.txid%2) * bptPageSize
+	if _, err := db.file.WriteAt(tx.meta.bytes(), metaOffset); err != nil {
+		return err
+	}
+	if db.sync {
+		if err := db.file.Sync(); err != nil {
+			return err
+		}
+	}
+
+	db.stateLock.Lock()
+	db.meta = &tx.meta
+	db.freelist = tx.freelist
+	db.stateLock.Unlock()
+	return nil
+}
+
+// --- Snapshotter interface ----
+
+// bptSnapshot reads the tree committed when the snapshot was taken.
+type bptSnapshot struct {
+	*bptReader
+}
+
+// NewSnapshot returns a read-only view of the store at this point in time.
+func (db *BPTreeDB) NewSnapshot() Snapshot {
+	return bptSnapshot{db.beginRead()}
+}
+
+func (snapshot bptSnapshot) Close() {
+	snapshot.close()
+}
+
+// --- Checkpointer interface ----
+
+// Checkpoint copies the B+tree file into a new store directory.  Writes are blocked
+// during the copy while reads proceed.
+func (db *BPTreeDB) Checkpoint(directory string) error {
+	db.writeLock.Lock()
+	defer db.writeLock.Unlock()
+	if db.file == nil {
+		return fmt.Errorf("Cannot checkpoint closed B+tree store %s", db.directory)
+	}
+	db.stateLock.Lock()
+	size := int64(db.meta.numPages) * bptPageSize
+	db.stateLock.Unlock()
+
+	if err := os.MkdirAll(directory, 0755); err != nil {
+		return err
+	}
+	filename := filepath.Join(directory, bptFilename)
+	dst, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return fmt.Errorf("Cannot create B+tree checkpoint: %s", err.Error())
+	}
+	_, err = io.Copy(dst, io.NewSectionReader(db.file, 0, size))
+	if err == nil {
+		err = dst.Sync()
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// --- StatsReporter interface ----
+
+// Stats returns the number of pages in the B+tree file, how many are free for reuse,
+// and the number of decoded nodes held in the cache.
+func (db *BPTreeDB) Stats() map[string]string {
+	db.stateLock.Lock()
+	numPages := db.meta.numPages
+	numFree := len(db.freelist.ids)
+	numPending := len(db.freelist.all()) - numFree
+	db.stateLock.Unlock()
+
+	db.cacheLock.Lock()
+	numCached := len(db.cache)
+	db.cacheLock.Unlock()
+
+	return map[string]string{
+		"bptree.pages":         fmt.Sprintf("%d", numPages),
+		"bptree.free-pages":    fmt.Sprintf("%d", numFree),
+		"bptree.pending-pages": fmt.Sprintf("%d", numPending),
+		"bptree.file-bytes":    fmt.Sprintf("%d", int64(numPages)*bptPageSize),
+		"bptree.cached-nodes":  fmt.Sprintf("%d", numCached),
+	}
+}
+
+// --- Batcher interface ----
+
+// bptBatchOp is a single queued put or delete.
+type bptBatchOp struct {
+	key    []byte
+	value  []byte
+	delete bool
+}
+
+type bptBatch struct {
+	db  *BPTreeDB
+	ops []bptBatchOp
+}
+
+// NewBatch returns an implementation that allows batch writes
+func (db *BPTreeDB) NewBatch() Batch {
+	return &bptBatch{db: db, ops: []bptBatchOp{}}
+}
+
+// --- Batch interface ---
+
+// Commit applies all queued operations in a single transaction.  Either all
+// operations are durably committed or none are.
+func (batch *bptBatch) Commit() error {
+	if batch.db == nil {
+		return fmt.Errorf("Cannot commit a closed batch")
+	}
+	return batch.db.update(func(tx *bptWriteTx) error {
+		for _, op := range batch.ops {
+			var err error
+			if op.delete {
+				err = tx.del(op.key)
+			} else {
+				err = tx.put(op.key, op.value)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (batch *bptBatch) Delete(k Key) {
+	batch.ops = append(batch.ops, bptBatchOp{key: k.Bytes(), delete: true})
+}
+
+func (batch *bptBatch) Put(k Key, v []byte) {
+	kBytes := k.Bytes()
+	StoreKeyBytesWritten <- len(kBytes)
+	StoreValueBytesWritten <- len(v)
+	value := make([]byte, len(v))
+	copy(value, v)
+	batch.ops = append(batch.ops, bptBatchOp{key: kBytes, value: value})
+}
+
+func (batch *bptBatch) Clear() {
+	batch.ops = []bptBatchOp{}
+}
+
+func (batch *bptBatch) Close() {
+	batch.ops = nil
+	batch.db = nil
+}
+
+// --- BulkIniter and BulkWriter interfaces ----
+
+// NewBulkIniter returns a BulkLoader for a blank key range.
+func (db *BPTreeDB) NewBulkIniter(kStart, kEnd Key) (BulkLoader, error) {
+	blank, err := IsBlankRange(db, kStart, kEnd)
+	if err != nil {
+		return nil, err
+	}
+	if !blank {
+		return nil, fmt.Errorf("Cannot bulk initialize key range (%s, %s) that holds data",
+			kStart, kEnd)
+	}
+	return newBatchedWriter(db, bptBulkBatchBytes, kStart, kEnd), nil
+}
+
+// NewBulkWriter returns a BulkLoader that may overwrite existing data.
+func (db *BPTreeDB) NewBulkWriter() (BulkLoader, error) {
+	return newBatchedWriter(db, bptBulkBatchBytes, nil, nil), nil
+}
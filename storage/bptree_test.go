@@ -0,0 +1,131 @@
+// +build bptree
+
+package storage
+
+import (
+	"fmt"
+
+	. "github.com/janelia-flyem/go/gocheck"
+
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+type BPTreeSuite struct{}
+
+var _ = Suite(&BPTreeSuite{})
+
+func bptKey(i int) TestKey {
+	return NewKey(fmt.Sprintf("bptree %08d", i))
+}
+
+func bptValue(i, size int) []byte {
+	value := make([]byte, size)
+	for j := range value {
+		value[j] = byte(i + j)
+	}
+	return value
+}
+
+func (s *BPTreeSuite) TestReopen(c *C) {
+	dir := c.MkDir()
+	db, err := NewStore(dir, true, dvid.Config{})
+	c.Assert(err, IsNil)
+
+	// Enough pairs with a mix of small and multi-page values to split nodes.
+	const numKeys = 5000
+	batch := db.(Batcher).NewBatch()
+	for i := 0; i < numKeys; i++ {
+		size := 100
+		if i%500 == 0 {
+			size = 3 * bptPageSize
+		}
+		batch.Put(bptKey(i), bptValue(i, size))
+	}
+	c.Assert(batch.Commit(), IsNil)
+	batch.Close()
+	c.Assert(db.DeleteRange(bptKey(1000), bptKey(1999)), IsNil)
+	db.Close()
+
+	_, err = NewStore(dir, true, dvid.Config{})
+	c.Assert(err, NotNil)
+
+	db, err = NewStore(dir, false, dvid.Config{})
+	c.Assert(err, IsNil)
+	defer db.Close()
+
+	keys, err := db.KeysInRange(bptKey(0), bptKey(numKeys))
+	c.Assert(err, IsNil)
+	c.Assert(keys, HasLen, numKeys-1000)
+
+	value, err := db.Get(bptKey(2500))
+	c.Assert(err, IsNil)
+	c.Assert(value, DeepEquals, bptValue(2500, 3*bptPageSize))
+	value, err = db.Get(bptKey(1500))
+	c.Assert(err, IsNil)
+	c.Assert(value, IsNil)
+
+	// Reverse iteration crosses all leaves.
+	it, err := db.NewIterator(bptKey(0), bptKey(numKeys), &IteratorOptions{Reverse: true})
+	c.Assert(err, IsNil)
+	num := 0
+	for ; it.Valid(); it.Next() {
+		num++
+	}
+	c.Assert(it.Error(), IsNil)
+	it.Close()
+	c.Assert(num, Equals, numKeys-1000)
+}
+
+func (s *BPTreeSuite) TestPageReuse(c *C) {
+	dir := c.MkDir()
+	db, err := NewStore(dir, true, dvid.Config{})
+	c.Assert(err, IsNil)
+	defer db.Close()
+	bptdb := db.(*BPTreeDB)
+
+	for i := 0; i < 100; i++ {
+		c.Assert(db.Put(bptKey(i%10), bptValue(i, 1000)), IsNil)
+	}
+	numPages := bptdb.meta.numPages
+
+	// A snapshot pins its pages so the file grows while it is open.
+	snapshot := db.(Snapshotter).NewSnapshot()
+	for i := 0; i < 100; i++ {
+		c.Assert(db.Put(bptKey(i%10), bptValue(i, 1000)), IsNil)
+	}
+	value, err := snapshot.Get(bptKey(9))
+	c.Assert(err, IsNil)
+	c.Assert(value, DeepEquals, bptValue(99, 1000))
+	c.Assert(bptdb.meta.numPages > numPages, Equals, true)
+	snapshot.Close()
+
+	// Once the snapshot is closed, its pages are reused.
+	numPages = bptdb.meta.numPages
+	for i := 0; i < 100; i++ {
+		c.Assert(db.Put(bptKey(i%10), bptValue(i, 1000)), IsNil)
+	}
+	c.Assert(bptdb.meta.numPages, Equals, numPages)
+}
+
+func (s *BPTreeSuite) TestCheckpoint(c *C) {
+	db, err := NewStore(c.MkDir(), true, dvid.Config{})
+	c.Assert(err, IsNil)
+	defer db.Close()
+	for i := 0; i < 1000; i++ {
+		c.Assert(db.Put(bptKey(i), bptValue(i, 100)), IsNil)
+	}
+
+	dir := c.MkDir() + "/checkpoint"
+	c.Assert(db.(Checkpointer).Checkpoint(dir), IsNil)
+	c.Assert(db.Delete(bptKey(0)), IsNil)
+
+	checkpoint, err := NewStore(dir, false, dvid.Config{})
+	c.Assert(err, IsNil)
+	defer checkpoint.Close()
+	keys, err := checkpoint.KeysInRange(bptKey(0), bptKey(1000))
+	c.Assert(err, IsNil)
+	c.Assert(keys, HasLen, 1000)
+	value, err := checkpoint.Get(bptKey(0))
+	c.Assert(err, IsNil)
+	c.Assert(value, DeepEquals, bptValue(0, 100))
+}
@@ -0,0 +1,113 @@
+/*
+	This file supports bulk loading of presorted key-value pairs.  No engine builds
+	sorted table files for ingestion: bulk loads are batched writes, committed in
+	large batches and checked for key order, so they go through the same write path
+	as any other batch.
+*/
+
+package storage
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// NewBulkLoader returns the BulkLoader an engine uses for writing presorted key-value
+// pairs into the key range (kStart, kEnd).  A BulkIniter is used if the range is
+// blank, which lets engines use larger batches, else a BulkWriter.  An error is
+// returned if the engine supports neither.
+func NewBulkLoader(db Engine, kStart, kEnd Key) (BulkLoader, error) {
+	if db.IsBulkIniter() {
+		if initer, ok := db.(BulkIniter); ok {
+			blank, err := IsBlankRange(db, kStart, kEnd)
+			if err != nil {
+				return nil, err
+			}
+			if blank {
+				return initer.NewBulkIniter(kStart, kEnd)
+			}
+		}
+	}
+	if db.IsBulkWriter() {
+		if writer, ok := db.(BulkWriter); ok {
+			return writer.NewBulkWriter()
+		}
+	}
+	return nil, fmt.Errorf("Storage engine %s does not support bulk loading", Version)
+}
+
+// IsBlankRange returns true if there are no key-value pairs in the range (kStart, kEnd).
+func IsBlankRange(db KeyValueGetter, kStart, kEnd Key) (bool, error) {
+	it, err := db.NewIterator(kStart, kEnd, &IteratorOptions{Limit: 1})
+	if err != nil {
+		return false, err
+	}
+	defer it.Close()
+	return !it.Valid(), it.Error()
+}
+
+// batchedWriter is a BulkLoader that writes presorted key-value pairs through any
+// Batcher, committing a batch whenever enough pairs have accumulated.  An optional key
+// range restricts the keys that may be put.
+type batchedWriter struct {
+	batcher  Batcher
+	batch    Batch
+	maxBytes int
+	curBytes int
+	lastKey  []byte
+	minKey   []byte
+	maxKey   []byte
+}
+
+// newBatchedWriter returns a batchedWriter that commits whenever maxBytes of keys and
+// values have accumulated.  If kStart and kEnd are non-nil, puts are restricted
+// to that range.
+func newBatchedWriter(batcher Batcher, maxBytes int, kStart, kEnd Key) *batchedWriter {
+	loader := &batchedWriter{
+		batcher:  batcher,
+		batch:    batcher.NewBatch(),
+		maxBytes: maxBytes,
+	}
+	if kStart != nil && kEnd != nil {
+		loader.minKey = kStart.Bytes()
+		loader.maxKey = kEnd.Bytes()
+	}
+	return loader
+}
+
+func (loader *batchedWriter) Put(k Key, v []byte) error {
+	kBytes := k.Bytes()
+	if loader.lastKey != nil && bytes.Compare(kBytes, loader.lastKey) < 0 {
+		return fmt.Errorf("Bulk load keys must be presorted: key %x follows %x",
+			kBytes, loader.lastKey)
+	}
+	if loader.maxKey != nil {
+		if bytes.Compare(kBytes, loader.minKey) < 0 || bytes.Compare(kBytes, loader.maxKey) > 0 {
+			return fmt.Errorf("Bulk load key %x is outside initialized range (%x, %x)",
+				kBytes, loader.minKey, loader.maxKey)
+		}
+	}
+	loader.lastKey = kBytes
+	loader.batch.Put(k, v)
+	loader.curBytes += len(kBytes) + len(v)
+	if loader.curBytes >= loader.maxBytes {
+		return loader.Commit()
+	}
+	return nil
+}
+
+func (loader *batchedWriter) Commit() error {
+	if loader.curBytes == 0 {
+		return nil
+	}
+	if err := loader.batch.Commit(); err != nil {
+		return err
+	}
+	loader.batch.Clear()
+	loader.curBytes = 0
+	return nil
+}
+
+func (loader *batchedWriter) Close() {
+	loader.batch.Close()
+}
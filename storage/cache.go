@@ -0,0 +1,445 @@
+/*
+	This file implements a read-through cache that can wrap any storage engine.  Values
+	are cached by key in a size-bounded LRU that is sharded to reduce lock contention
+	when many handlers read blocks concurrently.
+*/
+
+package storage
+
+import (
+	"bytes"
+	"container/list"
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+)
+
+// Number of independently locked LRU shards in a cache.
+const cacheShards = 16
+
+// Approximate bytes of bookkeeping per cached key-value pair.
+const cacheEntryOverhead = 64
+
+// Totals across all caches, read by the load monitor.
+var (
+	cacheHits      int64
+	cacheMisses    int64
+	cacheEvictions int64
+	cacheBytes     int64
+)
+
+// CacheStats returns the total hits, misses, and evictions across all caches since
+// the server started, as well as the bytes currently cached.
+func CacheStats() (hits, misses, evictions, bytes int64) {
+	return atomic.LoadInt64(&cacheHits), atomic.LoadInt64(&cacheMisses),
+		atomic.LoadInt64(&cacheEvictions), atomic.LoadInt64(&cacheBytes)
+}
+
+type cacheEntry struct {
+	key   string
+	value []byte
+}
+
+func (e *cacheEntry) size() int64 {
+	return int64(len(e.key) + len(e.value) + cacheEntryOverhead)
+}
+
+// cacheShard is an LRU of key-value pairs.  The shard is bypassed while writes to
+// the wrapped engine are in progress, and each write increments gen when it begins
+// and ends so a value read from the engine during an overlapping write is not cached.
+// Bulk loads count as writes from the time a loader is created until it is closed
+// since loaded pairs may reach the engine at any time in between.
+type cacheShard struct {
+	sync.Mutex
+	lru      *list.List
+	entries  map[string]*list.Element
+	bytes    int64
+	maxBytes int64
+	gen      uint64
+	writes   int
+}
+
+// get returns a cached value if no writes are in progress and the shard has not been
+// invalidated since generation gen.
+func (shard *cacheShard) get(key string, gen uint64) ([]byte, bool) {
+	shard.Lock()
+	defer shard.Unlock()
+	if gen != shard.gen || shard.writes > 0 {
+		return nil, false
+	}
+	elem, found := shard.entries[key]
+	if !found {
+		return nil, false
+	}
+	shard.lru.MoveToFront(elem)
+	return elem.Value.(*cacheEntry).value, true
+}
+
+// add caches a pair unless the shard was invalidated since generation gen.
+func (shard *cacheShard) add(key string, value []byte, gen uint64) {
+	entry := &cacheEntry{key, value}
+	if entry.size() > shard.maxBytes {
+		return
+	}
+	shard.Lock()
+	defer shard.Unlock()
+	if gen != shard.gen || shard.writes > 0 {
+		return
+	}
+	if elem, found := shard.entries[key]; found {
+		shard.remove(elem)
+	}
+	shard.entries[key] = shard.lru.PushFront(entry)
+	shard.bytes += entry.size()
+	atomic.AddInt64(&cacheBytes, entry.size())
+	for shard.bytes > shard.maxBytes {
+		shard.remove(shard.lru.Back())
+		atomic.AddInt64(&cacheEvictions, 1)
+	}
+}
+
+// remove drops an element.  The caller must hold the lock.
+func (shard *cacheShard) remove(elem *list.Element) {
+	entry := shard.lru.Remove(elem).(*cacheEntry)
+	delete(shard.entries, entry.key)
+	shard.bytes -= entry.size()
+	atomic.AddInt64(&cacheBytes, -entry.size())
+}
+
+func (shard *cacheShard) generation() uint64 {
+	shard.Lock()
+	defer shard.Unlock()
+	return shard.gen
+}
+
+// beginWrite bypasses the shard until a matching end of the write.
+func (shard *cacheShard) beginWrite() {
+	shard.Lock()
+	defer shard.Unlock()
+	shard.writes++
+	shard.gen++
+}
+
+// endWrite drops the pair with the written key.
+func (shard *cacheShard) endWrite(key string) {
+	shard.Lock()
+	defer shard.Unlock()
+	shard.writes--
+	shard.gen++
+	if elem, found := shard.entries[key]; found {
+		shard.remove(elem)
+	}
+}
+
+// endWriteRange drops all pairs with keys in the inclusive range [start, end].
+func (shard *cacheShard) endWriteRange(start, end []byte) {
+	shard.Lock()
+	defer shard.Unlock()
+	shard.writes--
+	shard.gen++
+	for key, elem := range shard.entries {
+		kBytes := []byte(key)
+		if bytes.Compare(kBytes, start) >= 0 && bytes.Compare(kBytes, end) <= 0 {
+			shard.remove(elem)
+		}
+	}
+}
+
+// endLoad drops all pairs since bulk loads don't track their keys.
+func (shard *cacheShard) endLoad() {
+	shard.Lock()
+	defer shard.Unlock()
+	shard.writes--
+	shard.drop()
+}
+
+func (shard *cacheShard) clear() {
+	shard.Lock()
+	defer shard.Unlock()
+	shard.drop()
+}
+
+// drop removes all pairs and invalidates reads in progress.  The caller must hold
+// the lock.
+func (shard *cacheShard) drop() {
+	shard.gen++
+	atomic.AddInt64(&cacheBytes, -shard.bytes)
+	shard.lru.Init()
+	shard.entries = make(map[string]*list.Element)
+	shard.bytes = 0
+}
+
+// CachedEngine is an Engine that keeps recently read values in memory.  Writes
+// through a CachedEngine, including batch commits, invalidate cached values.  The
+// cache is bypassed while bulk loaders are open.  Writes made directly to the wrapped
+// engine are not seen by the cache.
+//
+// Point reads through snapshots use the cache as long as no writes have invalidated
+// it since the snapshot was taken.  Range reads and iterators go directly to the
+// wrapped engine.
+type CachedEngine struct {
+	Engine
+	shards [cacheShards]cacheShard
+
+	// Used for transaction commits if the wrapped engine has no lock of its own.
+	txnMutex
+}
+
+// NewCachedEngine wraps an engine with a cache holding up to the given bytes of
+// key-value pairs.
+func NewCachedEngine(db Engine, maxBytes int64) *CachedEngine {
+	c := &CachedEngine{Engine: db}
+	for i := range c.shards {
+		c.shards[i].lru = list.New()
+		c.shards[i].entries = make(map[string]*list.Element)
+		c.shards[i].maxBytes = maxBytes / cacheShards
+	}
+	return c
+}
+
+func shardIndex(key string) int {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % cacheShards)
+}
+
+func (c *CachedEngine) shard(key string) *cacheShard {
+	return &c.shards[shardIndex(key)]
+}
+
+// Clear drops all cached values.
+func (c *CachedEngine) Clear() {
+	for i := range c.shards {
+		c.shards[i].clear()
+	}
+}
+
+func (c *CachedEngine) beginWrite(keys ...Key) {
+	for _, k := range keys {
+		c.shard(string(k.Bytes())).beginWrite()
+	}
+}
+
+func (c *CachedEngine) endWrite(keys ...Key) {
+	for _, k := range keys {
+		key := string(k.Bytes())
+		c.shard(key).endWrite(key)
+	}
+}
+
+// ---- KeyValueDB interface -----
+
+// Close clears the cache and closes the wrapped engine.
+func (c *CachedEngine) Close() {
+	c.Clear()
+	c.Engine.Close()
+}
+
+// Get returns a value given a key, reading the wrapped engine if the value is not
+// cached.  Missing keys are cached as nil values.  Since cached values are shared,
+// callers must not modify the returned slice.
+func (c *CachedEngine) Get(k Key) ([]byte, error) {
+	key := string(k.Bytes())
+	shard := c.shard(key)
+	gen := shard.generation()
+	if value, found := shard.get(key, gen); found {
+		atomic.AddInt64(&cacheHits, 1)
+		return value, nil
+	}
+	atomic.AddInt64(&cacheMisses, 1)
+	value, err := c.Engine.Get(k)
+	if err != nil {
+		return nil, err
+	}
+	shard.add(key, value, gen)
+	return value, nil
+}
+
+// Put writes a value with given key.
+func (c *CachedEngine) Put(k Key, v []byte) error {
+	c.beginWrite(k)
+	defer c.endWrite(k)
+	return c.Engine.Put(k, v)
+}
+
+// PutRange puts key/value pairs that have been sorted in sequential key order.
+func (c *CachedEngine) PutRange(values []KeyValue) error {
+	keys := make([]Key, len(values))
+	for i, kv := range values {
+		keys[i] = kv.K
+	}
+	c.beginWrite(keys...)
+	defer c.endWrite(keys...)
+	return c.Engine.PutRange(values)
+}
+
+// Delete removes a value with given key.
+func (c *CachedEngine) Delete(k Key) error {
+	c.beginWrite(k)
+	defer c.endWrite(k)
+	return c.Engine.Delete(k)
+}
+
+// DeleteRange removes all key-value pairs spanning (kStart, kEnd).
+func (c *CachedEngine) DeleteRange(kStart, kEnd Key) error {
+	for i := range c.shards {
+		c.shards[i].beginWrite()
+	}
+	err := c.Engine.DeleteRange(kStart, kEnd)
+	start, end := kStart.Bytes(), kEnd.Bytes()
+	for i := range c.shards {
+		c.shards[i].endWriteRange(start, end)
+	}
+	return err
+}
+
+// --- Snapshotter interface ----
+
+// cachedSnapshot serves point reads from the cache while the cache holds the same
+// values as the snapshot, i.e., while a shard's generation is unchanged since the
+// snapshot was taken.
+type cachedSnapshot struct {
+	Snapshot
+	c    *CachedEngine
+	gens [cacheShards]uint64
+}
+
+// NewSnapshot returns a snapshot of the wrapped engine.  If the wrapped engine can't
+// provide snapshots, reads see the engine's current state as with ReadSnapshot.
+func (c *CachedEngine) NewSnapshot() Snapshot {
+	snapshotter, ok := c.Engine.(Snapshotter)
+	if !ok {
+		return engineView{c}
+	}
+	snapshot := &cachedSnapshot{c: c}
+	for i := range c.shards {
+		snapshot.gens[i] = c.shards[i].generation()
+	}
+	snapshot.Snapshot = snapshotter.NewSnapshot()
+	return snapshot
+}
+
+// Get returns a value given a key, using the cache if no writes could have changed
+// the value since the snapshot was taken.
+func (snapshot *cachedSnapshot) Get(k Key) ([]byte, error) {
+	key := string(k.Bytes())
+	i := shardIndex(key)
+	shard, gen := &snapshot.c.shards[i], snapshot.gens[i]
+	if value, found := shard.get(key, gen); found {
+		atomic.AddInt64(&cacheHits, 1)
+		return value, nil
+	}
+	atomic.AddInt64(&cacheMisses, 1)
+	value, err := snapshot.Snapshot.Get(k)
+	if err != nil {
+		return nil, err
+	}
+	shard.add(key, value, gen)
+	return value, nil
+}
+
+// --- Batcher interface ----
+
+// cachedBatch invalidates the keys it writes when committed.
+type cachedBatch struct {
+	Batch
+	c    *CachedEngine
+	keys []Key
+}
+
+func (c *CachedEngine) Unwrap() Engine { return c.Engine }
+func (c *CachedEngine) IsCached() bool { return true }
+
+func (c *CachedEngine) txnLock() *sync.Mutex {
+	return c.txnMutex.wrappedTxnLock(c.Engine)
+}
+
+// NewBatch returns an implementation that allows batch writes.  If the wrapped engine
+// can't batch, operations are written one at a time on commit.
+func (c *CachedEngine) NewBatch() Batch {
+	batcher, ok := c.Engine.(Batcher)
+	if !ok {
+		return &cachedBatch{Batch: &engineBatch{db: c.Engine}, c: c}
+	}
+	return &cachedBatch{Batch: batcher.NewBatch(), c: c}
+}
+
+func (batch *cachedBatch) Commit() error {
+	keys := batch.keys
+	batch.c.beginWrite(keys...)
+	defer batch.c.endWrite(keys...)
+	batch.keys = nil
+	return batch.Batch.Commit()
+}
+
+func (batch *cachedBatch) Delete(k Key) {
+	batch.Batch.Delete(k)
+	batch.keys = append(batch.keys, k)
+}
+
+func (batch *cachedBatch) Put(k Key, v []byte) {
+	batch.Batch.Put(k, v)
+	batch.keys = append(batch.keys, k)
+}
+
+func (batch *cachedBatch) Clear() {
+	batch.Batch.Clear()
+	batch.keys = nil
+}
+
+// --- BulkIniter and BulkWriter interfaces ----
+
+// cachedLoader bypasses the cache from the time the loader is created until it is
+// closed.  Bulk loads can be too large to track individual keys and loaders may
+// write pairs to the engine before they are committed.
+type cachedLoader struct {
+	BulkLoader
+	c      *CachedEngine
+	closed bool
+}
+
+func newCachedLoader(loader BulkLoader, c *CachedEngine) *cachedLoader {
+	for i := range c.shards {
+		c.shards[i].beginWrite()
+		c.shards[i].clear()
+	}
+	return &cachedLoader{BulkLoader: loader, c: c}
+}
+
+// NewBulkIniter returns a BulkLoader for a blank key range.
+func (c *CachedEngine) NewBulkIniter(kStart, kEnd Key) (BulkLoader, error) {
+	initer, ok := c.Engine.(BulkIniter)
+	if !ok {
+		return nil, fmt.Errorf("Cached storage engine does not support bulk initialization")
+	}
+	loader, err := initer.NewBulkIniter(kStart, kEnd)
+	if err != nil {
+		return nil, err
+	}
+	return newCachedLoader(loader, c), nil
+}
+
+// NewBulkWriter returns a BulkLoader that may overwrite existing data.
+func (c *CachedEngine) NewBulkWriter() (BulkLoader, error) {
+	writer, ok := c.Engine.(BulkWriter)
+	if !ok {
+		return nil, fmt.Errorf("Cached storage engine does not support bulk writing")
+	}
+	loader, err := writer.NewBulkWriter()
+	if err != nil {
+		return nil, err
+	}
+	return newCachedLoader(loader, c), nil
+}
+
+func (loader *cachedLoader) Close() {
+	loader.BulkLoader.Close()
+	if loader.closed {
+		return
+	}
+	loader.closed = true
+	for i := range loader.c.shards {
+		loader.c.shards[i].endLoad()
+	}
+}
@@ -0,0 +1,124 @@
+package storage
+
+import (
+	. "github.com/janelia-flyem/go/gocheck"
+
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+type CacheSuite struct {
+	cache *CachedEngine
+}
+
+var _ = Suite(&CacheSuite{})
+
+func (s *CacheSuite) SetUpTest(c *C) {
+	db, err := NewStore(c.MkDir(), true, dvid.Config{})
+	c.Assert(err, IsNil)
+	s.cache = NewCachedEngine(db, dvid.Mega)
+	for _, k := range []string{"cache a", "cache b", "cache c"} {
+		c.Assert(s.cache.Put(NewKey(k), []byte("value "+k)), IsNil)
+	}
+}
+
+func (s *CacheSuite) TearDownTest(c *C) {
+	s.cache.Close()
+}
+
+func (s *CacheSuite) TestReadThrough(c *C) {
+	hits, misses, _, _ := CacheStats()
+	for i := 0; i < 3; i++ {
+		value, err := s.cache.Get(NewKey("cache a"))
+		c.Assert(err, IsNil)
+		c.Assert(string(value), Equals, "value cache a")
+		value, err = s.cache.Get(NewKey("cache z"))
+		c.Assert(err, IsNil)
+		c.Assert(value, IsNil)
+	}
+	newHits, newMisses, _, _ := CacheStats()
+	c.Assert(newHits-hits, Equals, int64(4))
+	c.Assert(newMisses-misses, Equals, int64(2))
+}
+
+func (s *CacheSuite) TestInvalidation(c *C) {
+	get := func(k string) string {
+		value, err := s.cache.Get(NewKey(k))
+		c.Assert(err, IsNil)
+		return string(value)
+	}
+	c.Assert(get("cache a"), Equals, "value cache a")
+	c.Assert(s.cache.Put(NewKey("cache a"), []byte("new a")), IsNil)
+	c.Assert(get("cache a"), Equals, "new a")
+
+	c.Assert(s.cache.Delete(NewKey("cache a")), IsNil)
+	c.Assert(get("cache a"), Equals, "")
+
+	c.Assert(get("cache b"), Equals, "value cache b")
+	c.Assert(get("cache c"), Equals, "value cache c")
+	c.Assert(s.cache.DeleteRange(NewKey("cache b"), NewKey("cache c")), IsNil)
+	c.Assert(get("cache b"), Equals, "")
+	c.Assert(get("cache c"), Equals, "")
+
+	if s.cache.IsBatcher() {
+		c.Assert(get("cache d"), Equals, "")
+		batch := s.cache.NewBatch()
+		batch.Put(NewKey("cache d"), []byte("batch d"))
+		c.Assert(batch.Commit(), IsNil)
+		batch.Close()
+		c.Assert(get("cache d"), Equals, "batch d")
+	}
+}
+
+func (s *CacheSuite) TestEviction(c *C) {
+	_, _, evictions, _ := CacheStats()
+	value := make([]byte, 1000)
+	for i := 0; i < 2000; i++ {
+		k := NewKey(string([]byte{'e', byte(i >> 8), byte(i)}))
+		c.Assert(s.cache.Put(k, value), IsNil)
+		_, err := s.cache.Get(k)
+		c.Assert(err, IsNil)
+	}
+	var cached int64
+	for i := range s.cache.shards {
+		c.Assert(s.cache.shards[i].bytes <= s.cache.shards[i].maxBytes, Equals, true)
+		cached += s.cache.shards[i].bytes
+	}
+	c.Assert(cached <= dvid.Mega, Equals, true)
+	_, _, newEvictions, _ := CacheStats()
+	c.Assert(newEvictions > evictions, Equals, true)
+}
+
+func (s *CacheSuite) TestBulkLoad(c *C) {
+	get := func(k string) string {
+		value, err := s.cache.Get(NewKey(k))
+		c.Assert(err, IsNil)
+		return string(value)
+	}
+	batcher, ok := s.cache.Engine.(Batcher)
+	c.Assert(ok, Equals, true)
+	c.Assert(get("cache a"), Equals, "value cache a")
+
+	// Loaders can write pairs before they are committed, so the cache is bypassed
+	// until the loader is closed.
+	loader := newCachedLoader(newBatchedWriter(batcher, 1, nil, nil), s.cache)
+	c.Assert(loader.Put(NewKey("cache a"), []byte("loaded a")), IsNil)
+	c.Assert(get("cache a"), Equals, "loaded a")
+	c.Assert(loader.Put(NewKey("cache b"), []byte("loaded b")), IsNil)
+	c.Assert(get("cache b"), Equals, "loaded b")
+	c.Assert(loader.Commit(), IsNil)
+	loader.Close()
+	loader.Close()
+
+	hits, _, _, _ := CacheStats()
+	c.Assert(get("cache a"), Equals, "loaded a")
+	c.Assert(get("cache a"), Equals, "loaded a")
+	newHits, _, _, _ := CacheStats()
+	c.Assert(newHits-hits, Equals, int64(1))
+}
+
+func (s *CacheSuite) TestWrapped(c *C) {
+	faulty := NewFaultyEngine(s.cache, Faults{})
+	c.Assert(IsCached(faulty), Equals, true)
+	c.Assert(IsCached(s.cache.Engine), Equals, false)
+	c.Assert(BaseEngine(faulty), Equals, s.cache.Engine)
+}
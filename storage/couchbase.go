@@ -58,6 +58,9 @@ type goCouch struct {
 	client *couchbase.Client
 	pool   *couchbase.Pool
 	bucket *couchbase.Bucket
+
+	// Serializes transaction commits.
+	txnMutex
 }
 
 // NewEngine returns a couchbase backend.
@@ -96,6 +99,7 @@ func (db *goCouch) ProvidesIterator() bool { return true }
 func (db *goCouch) IsBulkIniter() bool     { return true }
 func (db *goCouch) IsBulkLoader() bool     { return true }
 func (db *goCouch) IsBatcher() bool        { return true }
+func (db *goCouch) IsSizeEstimator() bool  { return false }
 func (db *goCouch) GetOptions() *Options   { return &Options{} }
 
 // ---- KeyValueDB interface -----
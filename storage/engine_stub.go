@@ -1,4 +1,4 @@
-// +build !levigo,!hyperleveldb,!goleveldb
+// +build !levigo,!hyperleveldb,!goleveldb,!memory,!bptree
 
 package storage
 
@@ -27,9 +27,11 @@ func NewStore(path string, create bool, config dvid.Config) (Engine, error) {
 
 // ---- Engine interface ----
 
-func (db *EngineStub) IsBatcher() bool    { return false }
-func (db *EngineStub) IsBulkIniter() bool { return false }
-func (db *EngineStub) IsBulkWriter() bool { return false }
+func (db *EngineStub) IsBatcher() bool       { return false }
+func (db *EngineStub) IsSnapshotter() bool   { return false }
+func (db *EngineStub) IsBulkIniter() bool    { return false }
+func (db *EngineStub) IsBulkWriter() bool    { return false }
+func (db *EngineStub) IsSizeEstimator() bool { return false }
 
 func (db *EngineStub) GetConfig() dvid.Config {
 	return db.Config
@@ -62,6 +64,25 @@ func (db *EngineStub) ProcessRange(kStart, kEnd Key, op *ChunkOp, f func(*Chunk)
 	return nil
 }
 
+// NewIterator returns an Iterator that is never valid since the stub holds no data.
+func (db *EngineStub) NewIterator(kStart, kEnd Key, opts *IteratorOptions) (Iterator, error) {
+	return newRangeIterator(stubCursor{}, kStart, kEnd, opts), nil
+}
+
+// stubCursor is an always-empty cursor.
+type stubCursor struct{}
+
+func (c stubCursor) Valid() bool     { return false }
+func (c stubCursor) Key() []byte     { return nil }
+func (c stubCursor) Value() []byte   { return nil }
+func (c stubCursor) Seek([]byte)     {}
+func (c stubCursor) SeekToFirst()    {}
+func (c stubCursor) SeekToLast()     {}
+func (c stubCursor) Next()           {}
+func (c stubCursor) Prev()           {}
+func (c stubCursor) GetError() error { return nil }
+func (c stubCursor) Close()          {}
+
 // Put writes a value with given key.
 func (db *EngineStub) Put(k Key, v []byte) error {
 	return nil
@@ -77,3 +98,8 @@ func (db *EngineStub) PutRange(values []KeyValue) error {
 func (db *EngineStub) Delete(k Key) (err error) {
 	return
 }
+
+// DeleteRange removes all key-value pairs spanning (kStart, kEnd).
+func (db *EngineStub) DeleteRange(kStart, kEnd Key) error {
+	return nil
+}
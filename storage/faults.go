@@ -0,0 +1,287 @@
+/*
+	This file implements an engine wrapper that injects failures into any storage
+	engine so error handling can be tested, e.g., that a failed write or a corrupt
+	value is returned to the HTTP or RPC caller instead of crashing the server.
+*/
+
+package storage
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Faults describes the failures injected by a FaultyEngine.  Zero values inject
+// no failures.
+type Faults struct {
+	// Every Nth put returns an error without writing.  Puts include Put, PutRange,
+	// batch commits, and bulk loader puts.
+	PutErrorEvery int
+
+	// Every Nth value read is truncated to half its length.
+	TruncateEvery int
+
+	// Every Nth value read has its last byte altered so checksums fail.
+	CorruptEvery int
+
+	// Delay before each read or write.
+	Delay time.Duration
+}
+
+// FaultyEngine wraps an engine and injects failures into its reads and writes.
+// Values read are counted across Get, range reads, and iterators, including those
+// of snapshots.
+type FaultyEngine struct {
+	Engine
+
+	mu     sync.RWMutex
+	faults Faults
+
+	// Used for transaction commits if the wrapped engine has no lock of its own.
+	txnMutex
+
+	puts  int64
+	reads int64
+}
+
+// NewFaultyEngine wraps an engine with the given faults.
+func NewFaultyEngine(db Engine, faults Faults) *FaultyEngine {
+	return &FaultyEngine{Engine: db, faults: faults}
+}
+
+// SetFaults changes the injected faults and restarts the counts of puts and reads.
+func (db *FaultyEngine) SetFaults(faults Faults) {
+	db.mu.Lock()
+	db.faults = faults
+	atomic.StoreInt64(&db.puts, 0)
+	atomic.StoreInt64(&db.reads, 0)
+	db.mu.Unlock()
+}
+
+func (db *FaultyEngine) getFaults() Faults {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.faults
+}
+
+func (db *FaultyEngine) delay() {
+	if delay := db.getFaults().Delay; delay > 0 {
+		time.Sleep(delay)
+	}
+}
+
+// putError returns an error if this put should fail.
+func (db *FaultyEngine) putError() error {
+	faults := db.getFaults()
+	if faults.Delay > 0 {
+		time.Sleep(faults.Delay)
+	}
+	if faults.PutErrorEvery <= 0 {
+		return nil
+	}
+	n := atomic.AddInt64(&db.puts, 1)
+	if n%int64(faults.PutErrorEvery) == 0 {
+		return fmt.Errorf("Injected failure of put %d", n)
+	}
+	return nil
+}
+
+// value returns a read value, possibly truncated or corrupted.  The original value is
+// never modified.
+func (db *FaultyEngine) value(v []byte) []byte {
+	faults := db.getFaults()
+	if v == nil || (faults.TruncateEvery <= 0 && faults.CorruptEvery <= 0) {
+		return v
+	}
+	n := atomic.AddInt64(&db.reads, 1)
+	switch {
+	case faults.TruncateEvery > 0 && n%int64(faults.TruncateEvery) == 0:
+		return v[:len(v)/2]
+	case faults.CorruptEvery > 0 && n%int64(faults.CorruptEvery) == 0 && len(v) > 0:
+		corrupt := make([]byte, len(v))
+		copy(corrupt, v)
+		corrupt[len(corrupt)-1] ^= 0xFF
+		return corrupt
+	}
+	return v
+}
+
+// faultyReader injects faults into the reads of an engine or snapshot.
+type faultyReader struct {
+	KeyValueGetter
+	db *FaultyEngine
+}
+
+func (r faultyReader) Get(k Key) ([]byte, error) {
+	r.db.delay()
+	v, err := r.KeyValueGetter.Get(k)
+	if err != nil {
+		return nil, err
+	}
+	return r.db.value(v), nil
+}
+
+func (r faultyReader) GetRange(kStart, kEnd Key) ([]KeyValue, error) {
+	r.db.delay()
+	values, err := r.KeyValueGetter.GetRange(kStart, kEnd)
+	if err != nil {
+		return nil, err
+	}
+	for i := range values {
+		values[i].V = r.db.value(values[i].V)
+	}
+	return values, nil
+}
+
+func (r faultyReader) KeysInRange(kStart, kEnd Key) ([]Key, error) {
+	r.db.delay()
+	return r.KeyValueGetter.KeysInRange(kStart, kEnd)
+}
+
+func (r faultyReader) ProcessRange(kStart, kEnd Key, op *ChunkOp, f func(*Chunk)) error {
+	r.db.delay()
+	return r.KeyValueGetter.ProcessRange(kStart, kEnd, op, func(chunk *Chunk) {
+		chunk.V = r.db.value(chunk.V)
+		f(chunk)
+	})
+}
+
+func (r faultyReader) NewIterator(kStart, kEnd Key, opts *IteratorOptions) (Iterator, error) {
+	r.db.delay()
+	it, err := r.KeyValueGetter.NewIterator(kStart, kEnd, opts)
+	if err != nil {
+		return nil, err
+	}
+	return faultyIterator{it, r.db}, nil
+}
+
+type faultyIterator struct {
+	Iterator
+	db *FaultyEngine
+}
+
+func (it faultyIterator) Value() []byte {
+	return it.db.value(it.Iterator.Value())
+}
+
+// ---- KeyValueDB interface -----
+
+func (db *FaultyEngine) Get(k Key) ([]byte, error) {
+	return faultyReader{db.Engine, db}.Get(k)
+}
+
+func (db *FaultyEngine) GetRange(kStart, kEnd Key) ([]KeyValue, error) {
+	return faultyReader{db.Engine, db}.GetRange(kStart, kEnd)
+}
+
+func (db *FaultyEngine) KeysInRange(kStart, kEnd Key) ([]Key, error) {
+	return faultyReader{db.Engine, db}.KeysInRange(kStart, kEnd)
+}
+
+func (db *FaultyEngine) ProcessRange(kStart, kEnd Key, op *ChunkOp, f func(*Chunk)) error {
+	return faultyReader{db.Engine, db}.ProcessRange(kStart, kEnd, op, f)
+}
+
+func (db *FaultyEngine) NewIterator(kStart, kEnd Key, opts *IteratorOptions) (Iterator, error) {
+	return faultyReader{db.Engine, db}.NewIterator(kStart, kEnd, opts)
+}
+
+func (db *FaultyEngine) Put(k Key, v []byte) error {
+	if err := db.putError(); err != nil {
+		return err
+	}
+	return db.Engine.Put(k, v)
+}
+
+func (db *FaultyEngine) PutRange(values []KeyValue) error {
+	if err := db.putError(); err != nil {
+		return err
+	}
+	return db.Engine.PutRange(values)
+}
+
+func (db *FaultyEngine) Delete(k Key) error {
+	db.delay()
+	return db.Engine.Delete(k)
+}
+
+func (db *FaultyEngine) DeleteRange(kStart, kEnd Key) error {
+	db.delay()
+	return db.Engine.DeleteRange(kStart, kEnd)
+}
+
+// --- Snapshotter interface ----
+
+type faultySnapshot struct {
+	faultyReader
+	snapshot Snapshot
+}
+
+func (snapshot faultySnapshot) Close() {
+	snapshot.snapshot.Close()
+}
+
+// NewSnapshot returns a snapshot of the wrapped engine with the same faults.
+func (db *FaultyEngine) NewSnapshot() Snapshot {
+	snapshot := db.Engine.(Snapshotter).NewSnapshot()
+	return faultySnapshot{faultyReader{snapshot, db}, snapshot}
+}
+
+// --- Batcher interface ----
+
+type faultyBatch struct {
+	Batch
+	db *FaultyEngine
+}
+
+func (db *FaultyEngine) Unwrap() Engine { return db.Engine }
+
+func (db *FaultyEngine) txnLock() *sync.Mutex {
+	return db.txnMutex.wrappedTxnLock(db.Engine)
+}
+
+// NewBatch returns a batch of the wrapped engine whose commits can fail.
+func (db *FaultyEngine) NewBatch() Batch {
+	return faultyBatch{db.Engine.(Batcher).NewBatch(), db}
+}
+
+func (batch faultyBatch) Commit() error {
+	if err := batch.db.putError(); err != nil {
+		return err
+	}
+	return batch.Batch.Commit()
+}
+
+// --- BulkIniter and BulkWriter interfaces ----
+
+type faultyLoader struct {
+	BulkLoader
+	db *FaultyEngine
+}
+
+func (loader faultyLoader) Put(k Key, v []byte) error {
+	if err := loader.db.putError(); err != nil {
+		return err
+	}
+	return loader.BulkLoader.Put(k, v)
+}
+
+// NewBulkIniter returns a BulkLoader for a blank key range whose puts can fail.
+func (db *FaultyEngine) NewBulkIniter(kStart, kEnd Key) (BulkLoader, error) {
+	loader, err := db.Engine.(BulkIniter).NewBulkIniter(kStart, kEnd)
+	if err != nil {
+		return nil, err
+	}
+	return faultyLoader{loader, db}, nil
+}
+
+// NewBulkWriter returns a BulkLoader whose puts can fail.
+func (db *FaultyEngine) NewBulkWriter() (BulkLoader, error) {
+	loader, err := db.Engine.(BulkWriter).NewBulkWriter()
+	if err != nil {
+		return nil, err
+	}
+	return faultyLoader{loader, db}, nil
+}
@@ -0,0 +1,78 @@
+package storage
+
+import (
+	. "github.com/janelia-flyem/go/gocheck"
+
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+type FaultSuite struct {
+	db Engine
+}
+
+var _ = Suite(&FaultSuite{})
+
+func (s *FaultSuite) SetUpTest(c *C) {
+	var err error
+	s.db, err = NewStore(c.MkDir(), true, dvid.Config{})
+	c.Assert(err, IsNil)
+}
+
+func (s *FaultSuite) TearDownTest(c *C) {
+	s.db.Close()
+}
+
+func (s *FaultSuite) TestPutErrors(c *C) {
+	db := NewFaultyEngine(s.db, Faults{PutErrorEvery: 2})
+	c.Assert(db.Put(NewKey("fault a"), []byte("a")), IsNil)
+	c.Assert(db.Put(NewKey("fault b"), []byte("b")), NotNil)
+	c.Assert(db.Put(NewKey("fault c"), []byte("c")), IsNil)
+
+	// The failed put was never written.
+	value, err := s.db.Get(NewKey("fault b"))
+	c.Assert(err, IsNil)
+	c.Assert(value, IsNil)
+
+	// Batch commits count as puts.
+	if db.IsBatcher() {
+		batch := db.NewBatch()
+		batch.Put(NewKey("fault d"), []byte("d"))
+		c.Assert(batch.Commit(), NotNil)
+		batch.Close()
+		value, err = s.db.Get(NewKey("fault d"))
+		c.Assert(err, IsNil)
+		c.Assert(value, IsNil)
+	}
+
+	db.SetFaults(Faults{})
+	c.Assert(db.Put(NewKey("fault b"), []byte("b")), IsNil)
+}
+
+func (s *FaultSuite) TestBadValues(c *C) {
+	data := []byte("some data that is checksummed when stored")
+	serialization, err := dvid.SerializeData(data, dvid.Uncompressed, dvid.CRC32)
+	c.Assert(err, IsNil)
+	c.Assert(s.db.Put(NewKey("fault a"), serialization), IsNil)
+
+	db := NewFaultyEngine(s.db, Faults{CorruptEvery: 1})
+	value, err := db.Get(NewKey("fault a"))
+	c.Assert(err, IsNil)
+	_, _, err = dvid.DeserializeData(value, true)
+	c.Assert(err, NotNil)
+
+	db.SetFaults(Faults{TruncateEvery: 2})
+	values, err := db.GetRange(NewKey("fault a"), NewKey("fault a"))
+	c.Assert(err, IsNil)
+	c.Assert(values, HasLen, 1)
+	c.Assert(values[0].V, DeepEquals, serialization)
+	value, err = db.Get(NewKey("fault a"))
+	c.Assert(err, IsNil)
+	c.Assert(value, HasLen, len(serialization)/2)
+
+	// The stored value is untouched.
+	value, err = s.db.Get(NewKey("fault a"))
+	c.Assert(err, IsNil)
+	uncorrupted, _, err := dvid.DeserializeData(value, true)
+	c.Assert(err, IsNil)
+	c.Assert(uncorrupted, DeepEquals, data)
+}
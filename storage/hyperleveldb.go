@@ -4,6 +4,7 @@ package storage
 
 import (
 	"bytes"
+	"fmt"
 
 	"github.com/janelia-flyem/dvid/dvid"
 	"github.com/janelia-flyem/go/hyperleveldb"
@@ -85,7 +86,12 @@ type LevelDB struct {
 	config dvid.Config
 
 	options *leveldbOptions
-	ldb     *levigo.DB
+
+	// Reads the latest state of the leveldb
+	ldbReader
+
+	// Serializes transaction commits.
+	txnMutex
 }
 
 // NewStore returns a leveldb backend.
@@ -184,15 +190,18 @@ func NewStore(path string, create bool, config dvid.Config) (Engine, error) {
 		return nil, err
 	}
 	leveldb.ldb = ldb
+	leveldb.ro = opt.ReadOptions
 
 	return leveldb, nil
 }
 
 // ---- Engine interface ----
 
-func (db *LevelDB) IsBatcher() bool    { return true }
-func (db *LevelDB) IsBulkIniter() bool { return true }
-func (db *LevelDB) IsBulkWriter() bool { return true }
+func (db *LevelDB) IsBatcher() bool       { return true }
+func (db *LevelDB) IsSnapshotter() bool   { return true }
+func (db *LevelDB) IsBulkIniter() bool    { return true }
+func (db *LevelDB) IsBulkWriter() bool    { return true }
+func (db *LevelDB) IsSizeEstimator() bool { return true }
 
 func (db *LevelDB) GetConfig() dvid.Config {
 	return db.config
@@ -227,11 +236,37 @@ func (db *LevelDB) Close() {
 	}
 }
 
+// ldbReader performs reads on a leveldb, either on its latest state or, if a snapshot
+// is given, on the state at the time of the snapshot.
+type ldbReader struct {
+	ldb  *levigo.DB
+	snap *levigo.Snapshot
+
+	// Shared read options for point reads of the latest state.
+	ro *levigo.ReadOptions
+}
+
+// newReadOptions returns read options that use any snapshot.  The caller must close
+// the returned options.
+func (r ldbReader) newReadOptions() *levigo.ReadOptions {
+	ro := levigo.NewReadOptions()
+	if r.snap != nil {
+		ro.SetSnapshot(r.snap)
+	}
+	return ro
+}
+
 // Get returns a value given a key.
-func (db *LevelDB) Get(k Key) (v []byte, err error) {
+func (r ldbReader) Get(k Key) (v []byte, err error) {
 	dvid.StartCgo()
-	ro := db.options.ReadOptions
-	v, err = db.ldb.Get(ro, k.Bytes())
+	ro := r.ro
+	if r.snap != nil {
+		ro = r.newReadOptions()
+	}
+	v, err = r.ldb.Get(ro, k.Bytes())
+	if r.snap != nil {
+		ro.Close()
+	}
 	dvid.StopCgo()
 	StoreValueBytesRead <- len(v)
 	return
@@ -239,12 +274,13 @@ func (db *LevelDB) Get(k Key) (v []byte, err error) {
 
 // GetRange returns a range of values spanning (kStart, kEnd) keys.  These key-value
 // pairs will be sorted in ascending key order.
-func (db *LevelDB) GetRange(kStart, kEnd Key) (values []KeyValue, err error) {
+func (r ldbReader) GetRange(kStart, kEnd Key) (values []KeyValue, err error) {
 	dvid.StartCgo()
-	ro := levigo.NewReadOptions()
-	it := db.ldb.NewIterator(ro)
+	ro := r.newReadOptions()
+	it := r.ldb.NewIterator(ro)
 	defer func() {
 		it.Close()
+		ro.Close()
 		dvid.StopCgo()
 	}()
 
@@ -278,12 +314,13 @@ func (db *LevelDB) GetRange(kStart, kEnd Key) (values []KeyValue, err error) {
 
 // KeysInRange returns a range of present keys spanning (kStart, kEnd).  Values
 // associated with the keys are not read.
-func (db *LevelDB) KeysInRange(kStart, kEnd Key) (keys []Key, err error) {
+func (r ldbReader) KeysInRange(kStart, kEnd Key) (keys []Key, err error) {
 	dvid.StartCgo()
-	ro := levigo.NewReadOptions()
-	it := db.ldb.NewIterator(ro)
+	ro := r.newReadOptions()
+	it := r.ldb.NewIterator(ro)
 	defer func() {
 		it.Close()
+		ro.Close()
 		dvid.StopCgo()
 	}()
 
@@ -313,12 +350,13 @@ func (db *LevelDB) KeysInRange(kStart, kEnd Key) (keys []Key, err error) {
 }
 
 // ProcessRange sends a range of key-value pairs to chunk handlers.
-func (db *LevelDB) ProcessRange(kStart, kEnd Key, op *ChunkOp, f func(*Chunk)) error {
+func (r ldbReader) ProcessRange(kStart, kEnd Key, op *ChunkOp, f func(*Chunk)) error {
 	dvid.StartCgo()
-	ro := levigo.NewReadOptions()
-	it := db.ldb.NewIterator(ro)
+	ro := r.newReadOptions()
+	it := r.ldb.NewIterator(ro)
 	defer func() {
 		it.Close()
+		ro.Close()
 		dvid.StopCgo()
 	}()
 
@@ -355,6 +393,31 @@ func (db *LevelDB) ProcessRange(kStart, kEnd Key, op *ChunkOp, f func(*Chunk)) e
 	}
 }
 
+// NewIterator returns an Iterator over key/value pairs spanning (kStart, kEnd).
+// The returned Iterator must be closed to release the underlying leveldb iterator.
+func (r ldbReader) NewIterator(kStart, kEnd Key, opts *IteratorOptions) (Iterator, error) {
+	dvid.StartCgo()
+	defer dvid.StopCgo()
+
+	ro := r.newReadOptions()
+	ro.SetFillCache(false)
+	cur := &ldbCursor{r.ldb.NewIterator(ro), ro}
+	return newRangeIterator(cur, kStart, kEnd, opts), nil
+}
+
+// ldbCursor is a leveldb iterator that also releases its read options on Close.
+type ldbCursor struct {
+	*levigo.Iterator
+	ro *levigo.ReadOptions
+}
+
+func (cur *ldbCursor) Close() {
+	dvid.StartCgo()
+	defer dvid.StopCgo()
+	cur.Iterator.Close()
+	cur.ro.Close()
+}
+
 // Put writes a value with given key.
 func (db *LevelDB) Put(k Key, v []byte) error {
 	dvid.StartCgo()
@@ -402,6 +465,77 @@ func (db *LevelDB) Delete(k Key) (err error) {
 	return
 }
 
+// Number of deletes per batch write during DeleteRange.
+const deleteRangeBatchSize = 10000
+
+// DeleteRange removes all key-value pairs spanning (kStart, kEnd) using batched
+// deletes, then compacts the range so deleted entries don't slow later reads.
+func (db *LevelDB) DeleteRange(kStart, kEnd Key) error {
+	dvid.StartCgo()
+	ro := levigo.NewReadOptions()
+	ro.SetFillCache(false)
+	it := db.ldb.NewIterator(ro)
+	wb := levigo.NewWriteBatch()
+	defer func() {
+		wb.Close()
+		it.Close()
+		ro.Close()
+		dvid.StopCgo()
+	}()
+
+	wo := db.options.WriteOptions
+	startBytes := kStart.Bytes()
+	endBytes := kEnd.Bytes()
+	numDeletes := 0
+	for it.Seek(startBytes); it.Valid(); it.Next() {
+		itKey := it.Key()
+		StoreKeyBytesRead <- len(itKey)
+		if bytes.Compare(itKey, endBytes) > 0 {
+			break
+		}
+		wb.Delete(itKey)
+		numDeletes++
+		if numDeletes%deleteRangeBatchSize == 0 {
+			if err := db.ldb.Write(wo, wb); err != nil {
+				return err
+			}
+			wb.Clear()
+		}
+	}
+	if err := it.GetError(); err != nil {
+		return err
+	}
+	if numDeletes%deleteRangeBatchSize != 0 {
+		if err := db.ldb.Write(wo, wb); err != nil {
+			return err
+		}
+	}
+	if numDeletes > 0 {
+		db.ldb.CompactRange(levigo.Range{startBytes, inclusiveLimit(endBytes)})
+	}
+	return nil
+}
+
+// --- Snapshotter interface ----
+
+type ldbSnapshot struct {
+	ldbReader
+}
+
+// NewSnapshot returns a read-only view of the leveldb at this point in time.
+func (db *LevelDB) NewSnapshot() Snapshot {
+	dvid.StartCgo()
+	defer dvid.StopCgo()
+	return &ldbSnapshot{ldbReader{ldb: db.ldb, snap: db.ldb.NewSnapshot()}}
+}
+
+// Close releases the leveldb snapshot.
+func (snapshot *ldbSnapshot) Close() {
+	dvid.StartCgo()
+	defer dvid.StopCgo()
+	snapshot.ldb.ReleaseSnapshot(snapshot.snap)
+}
+
 // --- Batcher interface ----
 
 type goBatch struct {
@@ -452,6 +586,95 @@ func (batch *goBatch) Close() {
 	batch.WriteBatch.Close()
 }
 
+// --- BulkIniter and BulkWriter interfaces ----
+
+const (
+	// Bytes of presorted key-value pairs written per unsynced batch when loading
+	// into a blank key range.  Large batches are safe since no data is overwritten.
+	bulkIniterBatchBytes = 64 * dvid.Mega
+
+	// Bytes of presorted key-value pairs written per unsynced batch when loading
+	// over possibly existing data.
+	bulkWriterBatchBytes = 8 * dvid.Mega
+)
+
+// NewBulkIniter returns a BulkLoader that writes presorted key-value pairs into a
+// blank key range using large, unsynced write batches.  Pairs go through the log and
+// memtable like other writes rather than being ingested as table files.
+func (db *LevelDB) NewBulkIniter(kStart, kEnd Key) (BulkLoader, error) {
+	blank, err := IsBlankRange(db, kStart, kEnd)
+	if err != nil {
+		return nil, err
+	}
+	if !blank {
+		return nil, fmt.Errorf("Cannot bulk initialize key range (%s, %s) that holds data",
+			kStart, kEnd)
+	}
+	return newBatchedWriter(db, bulkIniterBatchBytes, kStart, kEnd), nil
+}
+
+// NewBulkWriter returns a BulkLoader that writes presorted key-value pairs using
+// unsynced batches.
+func (db *LevelDB) NewBulkWriter() (BulkLoader, error) {
+	return newBatchedWriter(db, bulkWriterBatchBytes, nil, nil), nil
+}
+
+// --- Compacter and StatsReporter interfaces ----
+
+// Number of levels in a leveldb.
+const leveldbNumLevels = 7
+
+// inclusiveLimit returns the limit of a leveldb range ending with the given key.  A
+// leveldb range excludes its limit, so the limit is the key extended by a zero byte,
+// the first key that follows it.
+func inclusiveLimit(end []byte) []byte {
+	limit := make([]byte, len(end)+1)
+	copy(limit, end)
+	return limit
+}
+
+// CompactRange compacts the leveldb tables holding keys in the range [kStart, kEnd],
+// dropping deleted and overwritten values.  It blocks until compaction is complete.
+func (db *LevelDB) CompactRange(kStart, kEnd Key) error {
+	dvid.StartCgo()
+	defer dvid.StopCgo()
+
+	db.ldb.CompactRange(levigo.Range{Start: kStart.Bytes(), Limit: inclusiveLimit(kEnd.Bytes())})
+	return nil
+}
+
+// Stats returns the number of files at each level and the leveldb summary of level
+// sizes and compaction activity.
+func (db *LevelDB) Stats() map[string]string {
+	dvid.StartCgo()
+	defer dvid.StopCgo()
+
+	stats := make(map[string]string)
+	for level := 0; level < leveldbNumLevels; level++ {
+		property := fmt.Sprintf("leveldb.num-files-at-level%d", level)
+		stats[property] = db.ldb.PropertyValue(property)
+	}
+	stats["leveldb.stats"] = db.ldb.PropertyValue("leveldb.stats")
+	return stats
+}
+
+// --- SizeEstimator interface ----
+
+// ApproximateSize returns the approximate number of bytes of file system space used
+// by keys in the range [kStart, kEnd].  Recent writes still held in the log may not
+// be counted until they are compacted into tables.
+func (db *LevelDB) ApproximateSize(kStart, kEnd Key) (uint64, error) {
+	dvid.StartCgo()
+	defer dvid.StopCgo()
+
+	ranges := Ranges{levigo.Range{Start: kStart.Bytes(), Limit: inclusiveLimit(kEnd.Bytes())}}
+	sizes := Sizes(db.ldb.GetApproximateSizes([]levigo.Range(ranges)))
+	if len(sizes) != 1 {
+		return 0, fmt.Errorf("Expected 1 approximate size from leveldb, got %d", len(sizes))
+	}
+	return sizes[0], nil
+}
+
 // --- Options ----
 
 type leveldbOptions struct {
@@ -0,0 +1,210 @@
+/*
+	This file supports streaming iteration over a range of keys.  Each storage engine
+	supplies a simple cursor over its ordered keys, and a common range iterator
+	enforces the key range, direction and limits and feeds the load monitor.
+*/
+
+package storage
+
+import (
+	"bytes"
+)
+
+// IteratorOptions modify how an Iterator traverses a key range.  A nil *IteratorOptions
+// iterates in ascending key order without a limit.
+type IteratorOptions struct {
+	// Reverse iterates from the end of the key range toward the start.
+	Reverse bool
+
+	// Limit is the maximum number of key-value pairs visited after the iterator is
+	// positioned.  A Limit of zero means no limit.
+	Limit int
+}
+
+// Iterator streams key-value pairs within an inclusive key range so callers can process
+// large ranges without holding all pairs in memory and stop whenever they wish.  A new
+// Iterator is positioned at the first pair in iteration order, i.e., the lowest key for
+// forward iteration and the highest key for reverse iteration.
+//
+// Typical use:
+//
+//    it, err := db.NewIterator(kStart, kEnd, nil)
+//    if err != nil {
+//        return err
+//    }
+//    defer it.Close()
+//    for ; it.Valid(); it.Next() {
+//        key, err := it.Key()
+//        ...
+//        value := it.Value()
+//    }
+//    return it.Error()
+type Iterator interface {
+	// Valid returns true if the iterator is positioned at a key-value pair within the
+	// key range and the limit has not been reached.
+	Valid() bool
+
+	// Seek positions the iterator at the first pair at or after the given key in
+	// iteration order.  For reverse iteration, this is the last pair with key <= k.
+	// Seeking resets the count used for any limit.
+	Seek(k Key)
+
+	// Next moves the iterator one pair forward in iteration order.
+	Next()
+
+	// Prev moves the iterator one pair backward in iteration order.
+	Prev()
+
+	// Key returns the current key.
+	Key() (Key, error)
+
+	// Value returns the current value.
+	Value() []byte
+
+	// Error returns any error encountered during iteration.
+	Error() error
+
+	// Close releases resources held by the iterator.  It must be called when done.
+	Close()
+}
+
+// cursor is the minimal ordered key traversal a storage engine must provide to
+// construct an Iterator.  It mirrors the levigo iterator API.
+type cursor interface {
+	Valid() bool
+	Key() []byte
+	Value() []byte
+	Seek(key []byte)
+	SeekToFirst()
+	SeekToLast()
+	Next()
+	Prev()
+	GetError() error
+	Close()
+}
+
+// rangeIterator restricts a cursor to an inclusive key range, handling iteration
+// direction and limits.
+type rangeIterator struct {
+	cur      cursor
+	kStart   Key
+	minBytes []byte
+	maxBytes []byte
+	reverse  bool
+	limit    int
+	count    int
+	err      error
+
+	// If true, bytes read are not sent to the load monitor, e.g., because an
+	// underlying engine's iterator has already counted them.
+	unmonitored bool
+}
+
+// newRangeIterator returns an Iterator over [kStart, kEnd] positioned at the first
+// pair in iteration order.
+func newRangeIterator(cur cursor, kStart, kEnd Key, opts *IteratorOptions) *rangeIterator {
+	it := &rangeIterator{
+		cur:      cur,
+		kStart:   kStart,
+		minBytes: kStart.Bytes(),
+		maxBytes: kEnd.Bytes(),
+	}
+	if opts != nil {
+		it.reverse = opts.Reverse
+		it.limit = opts.Limit
+	}
+	if it.reverse {
+		it.seekBytes(it.maxBytes)
+	} else {
+		it.seekBytes(it.minBytes)
+	}
+	return it
+}
+
+// seekBytes positions the cursor at the first key >= b, or for reverse iteration,
+// the last key <= b.
+func (it *rangeIterator) seekBytes(b []byte) {
+	it.count = 0
+	it.cur.Seek(b)
+	if it.reverse {
+		if !it.cur.Valid() {
+			it.cur.SeekToLast()
+		} else if bytes.Compare(it.cur.Key(), b) > 0 {
+			it.cur.Prev()
+		}
+	}
+}
+
+func (it *rangeIterator) Valid() bool {
+	if it.err != nil || !it.cur.Valid() {
+		return false
+	}
+	if it.limit > 0 && (it.count < 0 || it.count >= it.limit) {
+		return false
+	}
+	itKey := it.cur.Key()
+	return bytes.Compare(itKey, it.minBytes) >= 0 && bytes.Compare(itKey, it.maxBytes) <= 0
+}
+
+func (it *rangeIterator) Seek(k Key) {
+	b := k.Bytes()
+	if it.reverse {
+		if bytes.Compare(b, it.maxBytes) > 0 {
+			b = it.maxBytes
+		}
+	} else if bytes.Compare(b, it.minBytes) < 0 {
+		b = it.minBytes
+	}
+	it.seekBytes(b)
+}
+
+func (it *rangeIterator) Next() {
+	if it.reverse {
+		it.cur.Prev()
+	} else {
+		it.cur.Next()
+	}
+	it.count++
+}
+
+func (it *rangeIterator) Prev() {
+	if it.reverse {
+		it.cur.Next()
+	} else {
+		it.cur.Prev()
+	}
+	it.count--
+}
+
+func (it *rangeIterator) Key() (Key, error) {
+	itKey := it.cur.Key()
+	if !it.unmonitored {
+		StoreKeyBytesRead <- len(itKey)
+	}
+
+	// Convert byte representation of key to storage.Key
+	key, err := it.kStart.BytesToKey(itKey)
+	if err != nil && it.err == nil {
+		it.err = err
+	}
+	return key, err
+}
+
+func (it *rangeIterator) Value() []byte {
+	itValue := it.cur.Value()
+	if !it.unmonitored {
+		StoreValueBytesRead <- len(itValue)
+	}
+	return itValue
+}
+
+func (it *rangeIterator) Error() error {
+	if it.err != nil {
+		return it.err
+	}
+	return it.cur.GetError()
+}
+
+func (it *rangeIterator) Close() {
+	it.cur.Close()
+}
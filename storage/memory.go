@@ -0,0 +1,540 @@
+// +build memory
+
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+const (
+	Version = "In-memory Storage Engine"
+
+	Driver = "github.com/janelia-flyem/dvid/storage/memory.go"
+)
+
+var (
+	// Stores are kept per path for the life of the process so a datastore can be
+	// closed and reopened, e.g., datastore.Init() followed by datastore.Open().
+	memStores     = make(map[string]*memStore)
+	memStoresLock sync.Mutex
+)
+
+// memEntry is a single key-value pair held by the in-memory engine.
+type memEntry struct {
+	key   []byte
+	value []byte
+}
+
+// memStore is an ordered set of key-value pairs.  Entries are kept in a slice
+// sorted by key bytes so range queries match the ordering of the leveldb drivers.
+type memStore struct {
+	sync.RWMutex
+	entries []memEntry
+
+	// Serializes transaction commits, which is shared by every MemoryDB on the store.
+	txnMutex
+}
+
+// --- The MemoryDB Implementation must satisfy a Engine interface ----
+
+// MemoryDB is a pure Go key-value store held entirely in memory.  It is useful
+// for tests and ephemeral servers since it requires no cgo, but nothing is
+// persisted past the end of the DVID process.
+type MemoryDB struct {
+	// Directory of datastore.  Only used to identify the store within this process.
+	directory string
+
+	// Config at time of Open()
+	config dvid.Config
+
+	*memStore
+}
+
+// NewStore returns an in-memory backend.  As with the leveldb drivers, creating a
+// store that already exists or opening one that doesn't is an error.
+func NewStore(path string, create bool, config dvid.Config) (Engine, error) {
+	memStoresLock.Lock()
+	defer memStoresLock.Unlock()
+
+	store, found := memStores[path]
+	if create {
+		if found {
+			return nil, fmt.Errorf("In-memory store already exists for %s", path)
+		}
+		dvid.Log(dvid.Normal, "Using in-memory storage engine; data will not persist past shutdown.\n")
+		store = &memStore{entries: []memEntry{}}
+		memStores[path] = store
+	} else if !found {
+		return nil, fmt.Errorf("No in-memory store for %s has been created by this process", path)
+	}
+	db := &MemoryDB{
+		directory: path,
+		config:    config,
+		memStore:  store,
+	}
+	return db, nil
+}
+
+// search returns the position of the first entry with key >= kBytes.
+// The caller must hold at least a read lock.
+func (store *memStore) search(kBytes []byte) int {
+	return sort.Search(len(store.entries), func(i int) bool {
+		return bytes.Compare(store.entries[i].key, kBytes) >= 0
+	})
+}
+
+// put inserts or replaces a value.  The caller must hold the write lock.
+func (store *memStore) put(kBytes, v []byte) {
+	value := make([]byte, len(v))
+	copy(value, v)
+	i := store.search(kBytes)
+	if i < len(store.entries) && bytes.Equal(store.entries[i].key, kBytes) {
+		store.entries[i].value = value
+		return
+	}
+	key := make([]byte, len(kBytes))
+	copy(key, kBytes)
+	store.entries = append(store.entries, memEntry{})
+	copy(store.entries[i+1:], store.entries[i:])
+	store.entries[i] = memEntry{key, value}
+}
+
+// del removes a key if present.  The caller must hold the write lock.
+func (store *memStore) del(kBytes []byte) {
+	i := store.search(kBytes)
+	if i < len(store.entries) && bytes.Equal(store.entries[i].key, kBytes) {
+		store.entries = append(store.entries[:i], store.entries[i+1:]...)
+	}
+}
+
+// span returns copies of all entries with keys in the inclusive range
+// [kStart, kEnd].  Copies are returned so callers can process them without
+// holding the lock.
+func (store *memStore) span(kStart, kEnd Key) []memEntry {
+	store.RLock()
+	defer store.RUnlock()
+
+	endBytes := kEnd.Bytes()
+	span := []memEntry{}
+	for i := store.search(kStart.Bytes()); i < len(store.entries); i++ {
+		e := store.entries[i]
+		if bytes.Compare(e.key, endBytes) > 0 {
+			break
+		}
+		value := make([]byte, len(e.value))
+		copy(value, e.value)
+		span = append(span, memEntry{e.key, value})
+	}
+	return span
+}
+
+// ---- Engine interface ----
+
+func (db *MemoryDB) IsBatcher() bool       { return true }
+func (db *MemoryDB) IsSnapshotter() bool   { return true }
+func (db *MemoryDB) IsBulkIniter() bool    { return true }
+func (db *MemoryDB) IsBulkWriter() bool    { return true }
+func (db *MemoryDB) IsSizeEstimator() bool { return true }
+
+func (db *MemoryDB) GetConfig() dvid.Config {
+	return db.config
+}
+
+// ---- KeyValueDB interface -----
+
+// Close is a no-op since stored key-value pairs are retained until the process exits.
+func (db *MemoryDB) Close() {}
+
+// Get returns a value given a key.  If the key is not present, a nil value
+// and nil error is returned as with the leveldb drivers.
+func (db *MemoryDB) Get(k Key) (v []byte, err error) {
+	kBytes := k.Bytes()
+	db.RLock()
+	i := db.search(kBytes)
+	if i < len(db.entries) && bytes.Equal(db.entries[i].key, kBytes) {
+		v = make([]byte, len(db.entries[i].value))
+		copy(v, db.entries[i].value)
+	}
+	db.RUnlock()
+	StoreValueBytesRead <- len(v)
+	return
+}
+
+// GetRange returns a range of values spanning (kStart, kEnd) keys.  These key-value
+// pairs will be sorted in ascending key order.
+func (db *MemoryDB) GetRange(kStart, kEnd Key) (values []KeyValue, err error) {
+	values = []KeyValue{}
+	for _, e := range db.span(kStart, kEnd) {
+		StoreKeyBytesRead <- len(e.key)
+		StoreValueBytesRead <- len(e.value)
+
+		// Convert byte representation of key to storage.Key
+		var key Key
+		key, err = kStart.BytesToKey(e.key)
+		if err != nil {
+			return
+		}
+		values = append(values, KeyValue{key, e.value})
+	}
+	return
+}
+
+// KeysInRange returns a range of present keys spanning (kStart, kEnd).  Values
+// associated with the keys are not read.
+func (db *MemoryDB) KeysInRange(kStart, kEnd Key) (keys []Key, err error) {
+	db.RLock()
+	endBytes := kEnd.Bytes()
+	keyBytes := [][]byte{}
+	for i := db.search(kStart.Bytes()); i < len(db.entries); i++ {
+		if bytes.Compare(db.entries[i].key, endBytes) > 0 {
+			break
+		}
+		keyBytes = append(keyBytes, db.entries[i].key)
+	}
+	db.RUnlock()
+
+	keys = []Key{}
+	for _, kBytes := range keyBytes {
+		StoreKeyBytesRead <- len(kBytes)
+
+		// Convert byte representation of key to storage.Key
+		var key Key
+		key, err = kStart.BytesToKey(kBytes)
+		if err != nil {
+			return
+		}
+		keys = append(keys, key)
+	}
+	return
+}
+
+// ProcessRange sends a range of key-value pairs to chunk handlers.  The range is
+// copied before any handler is called so handlers may safely write to the store.
+func (db *MemoryDB) ProcessRange(kStart, kEnd Key, op *ChunkOp, f func(*Chunk)) error {
+	for _, e := range db.span(kStart, kEnd) {
+		StoreKeyBytesRead <- len(e.key)
+		StoreValueBytesRead <- len(e.value)
+
+		// Convert byte representation of key to storage.Key
+		key, err := kStart.BytesToKey(e.key)
+		if err != nil {
+			return err
+		}
+
+		if op != nil && op.Wg != nil {
+			op.Wg.Add(1)
+		}
+		chunk := &Chunk{
+			op,
+			KeyValue{key, e.value},
+		}
+		f(chunk)
+	}
+	return nil
+}
+
+// NewIterator returns an Iterator over key/value pairs spanning (kStart, kEnd).
+func (db *MemoryDB) NewIterator(kStart, kEnd Key, opts *IteratorOptions) (Iterator, error) {
+	return newRangeIterator(&memCursor{store: db.memStore}, kStart, kEnd, opts), nil
+}
+
+// memCursor traverses a memStore.  Since the store may be modified during iteration,
+// the cursor holds a copy of the current pair and repositions by key on each move.
+type memCursor struct {
+	store *memStore
+	valid bool
+	key   []byte
+	value []byte
+}
+
+// set positions the cursor at entry i.  The caller must hold at least a read lock.
+func (cur *memCursor) set(i int) {
+	if i < 0 || i >= len(cur.store.entries) {
+		cur.valid = false
+		cur.key = nil
+		cur.value = nil
+		return
+	}
+	e := cur.store.entries[i]
+	cur.valid = true
+	cur.key = e.key
+	cur.value = make([]byte, len(e.value))
+	copy(cur.value, e.value)
+}
+
+func (cur *memCursor) Valid() bool   { return cur.valid }
+func (cur *memCursor) Key() []byte   { return cur.key }
+func (cur *memCursor) Value() []byte { return cur.value }
+
+func (cur *memCursor) Seek(key []byte) {
+	cur.store.RLock()
+	cur.set(cur.store.search(key))
+	cur.store.RUnlock()
+}
+
+func (cur *memCursor) SeekToFirst() {
+	cur.store.RLock()
+	cur.set(0)
+	cur.store.RUnlock()
+}
+
+func (cur *memCursor) SeekToLast() {
+	cur.store.RLock()
+	cur.set(len(cur.store.entries) - 1)
+	cur.store.RUnlock()
+}
+
+func (cur *memCursor) Next() {
+	if !cur.valid {
+		return
+	}
+	cur.store.RLock()
+	i := cur.store.search(cur.key)
+	if i < len(cur.store.entries) && bytes.Equal(cur.store.entries[i].key, cur.key) {
+		i++
+	}
+	cur.set(i)
+	cur.store.RUnlock()
+}
+
+func (cur *memCursor) Prev() {
+	if !cur.valid {
+		return
+	}
+	cur.store.RLock()
+	cur.set(cur.store.search(cur.key) - 1)
+	cur.store.RUnlock()
+}
+
+func (cur *memCursor) GetError() error { return nil }
+func (cur *memCursor) Close()          {}
+
+// Put writes a value with given key.
+func (db *MemoryDB) Put(k Key, v []byte) error {
+	kBytes := k.Bytes()
+	db.Lock()
+	db.put(kBytes, v)
+	db.Unlock()
+	StoreKeyBytesWritten <- len(kBytes)
+	StoreValueBytesWritten <- len(v)
+	return nil
+}
+
+// PutRange puts key/value pairs that have been sorted in sequential key order.
+// All pairs are written while holding the lock so readers see all or none of them.
+func (db *MemoryDB) PutRange(values []KeyValue) error {
+	var keyBytesPut, valueBytesPut int
+	db.Lock()
+	for _, kv := range values {
+		kBytes := kv.K.Bytes()
+		db.put(kBytes, kv.V)
+		keyBytesPut += len(kBytes)
+		valueBytesPut += len(kv.V)
+	}
+	db.Unlock()
+	StoreKeyBytesWritten <- keyBytesPut
+	StoreValueBytesWritten <- valueBytesPut
+	return nil
+}
+
+// Delete removes a value with given key.
+func (db *MemoryDB) Delete(k Key) (err error) {
+	db.Lock()
+	db.del(k.Bytes())
+	db.Unlock()
+	return
+}
+
+// DeleteRange removes all key-value pairs spanning (kStart, kEnd).
+func (db *MemoryDB) DeleteRange(kStart, kEnd Key) error {
+	endBytes := kEnd.Bytes()
+	db.Lock()
+	i := db.search(kStart.Bytes())
+	j := i
+	for j < len(db.entries) && bytes.Compare(db.entries[j].key, endBytes) <= 0 {
+		j++
+	}
+	db.entries = append(db.entries[:i], db.entries[j:]...)
+	db.Unlock()
+	return nil
+}
+
+// --- Snapshotter interface ----
+
+// memSnapshot is a read-only MemoryDB holding a copy of the store's entries.  Since
+// stored keys and values are never modified in place, only the entry index is copied.
+type memSnapshot struct {
+	db *MemoryDB
+}
+
+// NewSnapshot returns a read-only view of the store at this point in time.
+func (db *MemoryDB) NewSnapshot() Snapshot {
+	db.RLock()
+	entries := make([]memEntry, len(db.entries))
+	copy(entries, db.entries)
+	db.RUnlock()
+	return &memSnapshot{&MemoryDB{db.directory, db.config, &memStore{entries: entries}}}
+}
+
+func (snapshot *memSnapshot) Get(k Key) ([]byte, error) {
+	return snapshot.db.Get(k)
+}
+
+func (snapshot *memSnapshot) GetRange(kStart, kEnd Key) ([]KeyValue, error) {
+	return snapshot.db.GetRange(kStart, kEnd)
+}
+
+func (snapshot *memSnapshot) KeysInRange(kStart, kEnd Key) ([]Key, error) {
+	return snapshot.db.KeysInRange(kStart, kEnd)
+}
+
+func (snapshot *memSnapshot) ProcessRange(kStart, kEnd Key, op *ChunkOp, f func(*Chunk)) error {
+	return snapshot.db.ProcessRange(kStart, kEnd, op, f)
+}
+
+func (snapshot *memSnapshot) NewIterator(kStart, kEnd Key, opts *IteratorOptions) (Iterator, error) {
+	return snapshot.db.NewIterator(kStart, kEnd, opts)
+}
+
+func (snapshot *memSnapshot) Close() {
+	snapshot.db = nil
+}
+
+// --- Batcher interface ----
+
+// memBatchOp is a single queued put or delete.
+type memBatchOp struct {
+	key    []byte
+	value  []byte
+	delete bool
+}
+
+type memBatch struct {
+	db  *MemoryDB
+	ops []memBatchOp
+}
+
+// NewBatch returns an implementation that allows batch writes
+func (db *MemoryDB) NewBatch() Batch {
+	return &memBatch{db: db, ops: []memBatchOp{}}
+}
+
+// --- Batch interface ---
+
+// Commit applies all queued operations atomically with respect to readers.
+func (batch *memBatch) Commit() error {
+	if batch.db == nil {
+		return fmt.Errorf("Cannot commit a closed batch")
+	}
+	batch.db.Lock()
+	for _, op := range batch.ops {
+		if op.delete {
+			batch.db.del(op.key)
+		} else {
+			batch.db.put(op.key, op.value)
+		}
+	}
+	batch.db.Unlock()
+	return nil
+}
+
+func (batch *memBatch) Delete(k Key) {
+	batch.ops = append(batch.ops, memBatchOp{key: k.Bytes(), delete: true})
+}
+
+func (batch *memBatch) Put(k Key, v []byte) {
+	kBytes := k.Bytes()
+	StoreKeyBytesWritten <- len(kBytes)
+	StoreValueBytesWritten <- len(v)
+	value := make([]byte, len(v))
+	copy(value, v)
+	batch.ops = append(batch.ops, memBatchOp{key: kBytes, value: value})
+}
+
+func (batch *memBatch) Clear() {
+	batch.ops = []memBatchOp{}
+}
+
+func (batch *memBatch) Close() {
+	batch.ops = nil
+	batch.db = nil
+}
+
+// --- BulkIniter and BulkWriter interfaces ----
+
+// Bytes of presorted key-value pairs held in a batch before a bulk load commits.
+const memBulkBatchBytes = 4 * dvid.Mega
+
+// NewBulkIniter returns a BulkLoader for a blank key range.
+func (db *MemoryDB) NewBulkIniter(kStart, kEnd Key) (BulkLoader, error) {
+	blank, err := IsBlankRange(db, kStart, kEnd)
+	if err != nil {
+		return nil, err
+	}
+	if !blank {
+		return nil, fmt.Errorf("Cannot bulk initialize key range (%s, %s) that holds data",
+			kStart, kEnd)
+	}
+	return newBatchedWriter(db, memBulkBatchBytes, kStart, kEnd), nil
+}
+
+// NewBulkWriter returns a BulkLoader that may overwrite existing data.
+func (db *MemoryDB) NewBulkWriter() (BulkLoader, error) {
+	return newBatchedWriter(db, memBulkBatchBytes, nil, nil), nil
+}
+
+// --- SizeEstimator interface ----
+
+// ApproximateSize returns the number of key and value bytes held for keys in the
+// range [kStart, kEnd], which is exact for an in-memory store.
+func (db *MemoryDB) ApproximateSize(kStart, kEnd Key) (uint64, error) {
+	db.RLock()
+	defer db.RUnlock()
+
+	endBytes := kEnd.Bytes()
+	var size uint64
+	for i := db.search(kStart.Bytes()); i < len(db.entries); i++ {
+		e := db.entries[i]
+		if bytes.Compare(e.key, endBytes) > 0 {
+			break
+		}
+		size += uint64(len(e.key) + len(e.value))
+	}
+	return size, nil
+}
+
+// --- Compacter and StatsReporter interfaces ----
+
+// CompactRange releases memory held by deleted key-value pairs.  Since entries are
+// kept in a single slice, the whole store is compacted whatever the range.
+func (db *MemoryDB) CompactRange(kStart, kEnd Key) error {
+	db.Lock()
+	defer db.Unlock()
+
+	entries := make([]memEntry, len(db.entries))
+	copy(entries, db.entries)
+	db.entries = entries
+	return nil
+}
+
+// Stats returns the number of key-value pairs and bytes held in memory.
+func (db *MemoryDB) Stats() map[string]string {
+	db.RLock()
+	defer db.RUnlock()
+
+	var keyBytes, valueBytes int
+	for _, e := range db.entries {
+		keyBytes += len(e.key)
+		valueBytes += len(e.value)
+	}
+	return map[string]string{
+		"memory.keys":        fmt.Sprintf("%d", len(db.entries)),
+		"memory.key-bytes":   fmt.Sprintf("%d", keyBytes),
+		"memory.value-bytes": fmt.Sprintf("%d", valueBytes),
+	}
+}
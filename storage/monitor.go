@@ -37,6 +37,12 @@ var (
 	// Number of key-value PUT calls in last second.
 	PutsPerSec int
 
+	// Number of cache hits in last second across all cached engines.
+	CacheHitsPerSec int
+
+	// Number of cache misses in last second across all cached engines.
+	CacheMissesPerSec int
+
 	// Channel to notify bytes read from a storage engine.
 	StoreKeyBytesRead chan int
 
@@ -64,6 +70,10 @@ var (
 	fileBytesWrittenPerSec       int
 	getsPerSec                   int
 	putsPerSec                   int
+
+	// Cache totals at the last second tick.
+	lastCacheHits   int64
+	lastCacheMisses int64
 )
 
 func init() {
@@ -120,6 +130,12 @@ func loadMonitor() {
 			getsPerSec = 0
 			putsPerSec = 0
 
+			hits, misses, _, _ := CacheStats()
+			CacheHitsPerSec = int(hits - lastCacheHits)
+			CacheMissesPerSec = int(misses - lastCacheMisses)
+			lastCacheHits = hits
+			lastCacheMisses = misses
+
 			access.Unlock()
 		}
 	}
@@ -0,0 +1,719 @@
+/*
+	This file supports routing key spaces to different storage engines, e.g., keeping
+	large immutable data on big spinning disks while indices and metadata stay on SSD.
+*/
+
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+// RawKey is a Key that is just its byte representation.  It is used to address
+// sub-ranges of routed engines or scan keys without knowledge of their Key type.
+type RawKey []byte
+
+func (k RawKey) KeyType() KeyType {
+	if len(k) == 0 {
+		return 0
+	}
+	return KeyType(k[0])
+}
+
+func (k RawKey) BytesToKey(b []byte) (Key, error) {
+	return RawKey(b), nil
+}
+
+func (k RawKey) Bytes() []byte {
+	return []byte(k)
+}
+
+func (k RawKey) BytesString() string {
+	return string(k)
+}
+
+func (k RawKey) String() string {
+	return fmt.Sprintf("%x", []byte(k))
+}
+
+// route sends keys starting with a prefix to an engine.
+type route struct {
+	prefix []byte
+	db     Engine
+}
+
+// Router is an Engine that sends each key to one of several engines using the longest
+// matching key prefix.  Keys that match no prefix go to a default engine.  Since each
+// engine only holds keys routed to it, a route should be added before any keys with its
+// prefix are stored.
+//
+// Batches spanning engines are committed per engine, so a Router only provides atomic
+// batches within a single engine.
+type Router struct {
+	sync.RWMutex
+	defaultDB Engine
+	routes    []route
+
+	// Serializes transaction commits.
+	txnMutex
+}
+
+// NewRouter returns a Router that sends keys to the given default engine until
+// routes are added.
+func NewRouter(defaultDB Engine) *Router {
+	return &Router{defaultDB: defaultDB, routes: []route{}}
+}
+
+// AddRoute sends all keys with the given prefix to an engine.
+func (r *Router) AddRoute(prefix []byte, db Engine) error {
+	if len(prefix) == 0 {
+		return fmt.Errorf("Cannot add route with empty key prefix")
+	}
+	r.Lock()
+	defer r.Unlock()
+	for _, rt := range r.routes {
+		if bytes.Equal(rt.prefix, prefix) {
+			if rt.db == db {
+				return nil
+			}
+			return fmt.Errorf("Key prefix %x is already routed to another engine", prefix)
+		}
+	}
+	p := make([]byte, len(prefix))
+	copy(p, prefix)
+	r.routes = append(r.routes, route{p, db})
+	return nil
+}
+
+// Engines returns the distinct engines used by the router, starting with the default.
+func (r *Router) Engines() []Engine {
+	r.RLock()
+	defer r.RUnlock()
+	engines := []Engine{r.defaultDB}
+	for _, rt := range r.routes {
+		found := false
+		for _, db := range engines {
+			if db == rt.db {
+				found = true
+				break
+			}
+		}
+		if !found {
+			engines = append(engines, rt.db)
+		}
+	}
+	return engines
+}
+
+// engine returns the engine for a key.  The caller must hold at least a read lock.
+func (r *Router) engine(kBytes []byte) Engine {
+	db := r.defaultDB
+	matched := 0
+	for _, rt := range r.routes {
+		if len(rt.prefix) > matched && bytes.HasPrefix(kBytes, rt.prefix) {
+			db = rt.db
+			matched = len(rt.prefix)
+		}
+	}
+	return db
+}
+
+// prefixEnd returns the first key after all keys with the given prefix or nil if
+// there is no such key.
+func prefixEnd(prefix []byte) []byte {
+	for i := len(prefix) - 1; i >= 0; i-- {
+		if prefix[i] != 0xFF {
+			end := make([]byte, i+1)
+			copy(end, prefix)
+			end[i]++
+			return end
+		}
+	}
+	return nil
+}
+
+// routeSegment is a key range whose keys are all held by one engine.
+type routeSegment struct {
+	start, end []byte
+	db         Engine
+}
+
+// segments partitions the inclusive range [start, end] into ordered segments, each
+// held by a single engine.  Since a key at the start of a segment is never held by the
+// engine of the previous segment, each segment's end is the next segment's start.
+func (r *Router) segments(start, end []byte) []routeSegment {
+	if bytes.Compare(start, end) > 0 {
+		return nil
+	}
+	r.RLock()
+	defer r.RUnlock()
+
+	cuts := [][]byte{}
+	addCut := func(b []byte) {
+		if b != nil && bytes.Compare(b, start) > 0 && bytes.Compare(b, end) <= 0 {
+			cuts = append(cuts, b)
+		}
+	}
+	for _, rt := range r.routes {
+		addCut(rt.prefix)
+		addCut(prefixEnd(rt.prefix))
+	}
+	sort.Sort(byteSlices(cuts))
+
+	segments := []routeSegment{{start, end, r.engine(start)}}
+	for _, cut := range cuts {
+		last := &segments[len(segments)-1]
+		if bytes.Equal(cut, last.start) {
+			continue
+		}
+		db := r.engine(cut)
+		if db == last.db {
+			continue
+		}
+		last.end = cut
+		segments = append(segments, routeSegment{cut, end, db})
+	}
+	return segments
+}
+
+type byteSlices [][]byte
+
+func (s byteSlices) Len() int           { return len(s) }
+func (s byteSlices) Less(i, j int) bool { return bytes.Compare(s[i], s[j]) < 0 }
+func (s byteSlices) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// ---- Engine interface ----
+
+// all returns true if every routed engine satisfies a test.
+func (r *Router) all(test func(Engine) bool) bool {
+	for _, db := range r.Engines() {
+		if !test(db) {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *Router) IsBatcher() bool {
+	return r.all(func(db Engine) bool { return db.IsBatcher() })
+}
+
+func (r *Router) IsSnapshotter() bool {
+	return r.all(func(db Engine) bool { return db.IsSnapshotter() })
+}
+
+func (r *Router) IsBulkIniter() bool {
+	return r.all(func(db Engine) bool { return db.IsBulkIniter() })
+}
+
+func (r *Router) IsBulkWriter() bool {
+	return r.all(func(db Engine) bool { return db.IsBulkWriter() })
+}
+
+func (r *Router) IsSizeEstimator() bool {
+	return r.all(func(db Engine) bool { return db.IsSizeEstimator() })
+}
+
+// GetConfig returns the configuration of the default engine.
+func (r *Router) GetConfig() dvid.Config {
+	return r.defaultDB.GetConfig()
+}
+
+// ---- KeyValueDB interface -----
+
+// Close closes all routed engines.
+func (r *Router) Close() {
+	for _, db := range r.Engines() {
+		db.Close()
+	}
+}
+
+// routeReader reads through a router using either the engines or views of them,
+// e.g., snapshots.
+type routeReader struct {
+	r     *Router
+	views map[Engine]KeyValueGetter
+}
+
+func (rr routeReader) getter(db Engine) KeyValueGetter {
+	if rr.views != nil {
+		if view, found := rr.views[db]; found {
+			return view
+		}
+	}
+	return db
+}
+
+func (rr routeReader) Get(k Key) ([]byte, error) {
+	rr.r.RLock()
+	db := rr.r.engine(k.Bytes())
+	rr.r.RUnlock()
+	return rr.getter(db).Get(k)
+}
+
+func (rr routeReader) GetRange(kStart, kEnd Key) (values []KeyValue, err error) {
+	values = []KeyValue{}
+	err = rr.scan(kStart, kEnd, func(key Key, value []byte) {
+		values = append(values, KeyValue{key, value})
+	})
+	return
+}
+
+func (rr routeReader) KeysInRange(kStart, kEnd Key) (keys []Key, err error) {
+	keys = []Key{}
+	it, err := rr.NewIterator(kStart, kEnd, nil)
+	if err != nil {
+		return
+	}
+	defer it.Close()
+	for ; it.Valid(); it.Next() {
+		var key Key
+		if key, err = it.Key(); err != nil {
+			return
+		}
+		keys = append(keys, key)
+	}
+	err = it.Error()
+	return
+}
+
+func (rr routeReader) ProcessRange(kStart, kEnd Key, op *ChunkOp, f func(*Chunk)) error {
+	return rr.scan(kStart, kEnd, func(key Key, value []byte) {
+		if op != nil && op.Wg != nil {
+			op.Wg.Add(1)
+		}
+		chunk := &Chunk{
+			op,
+			KeyValue{key, value},
+		}
+		f(chunk)
+	})
+}
+
+// scan calls f for each key-value pair in the range in ascending key order.
+func (rr routeReader) scan(kStart, kEnd Key, f func(Key, []byte)) error {
+	it, err := rr.NewIterator(kStart, kEnd, nil)
+	if err != nil {
+		return err
+	}
+	defer it.Close()
+	for ; it.Valid(); it.Next() {
+		key, err := it.Key()
+		if err != nil {
+			return err
+		}
+		f(key, it.Value())
+	}
+	return it.Error()
+}
+
+func (rr routeReader) NewIterator(kStart, kEnd Key, opts *IteratorOptions) (Iterator, error) {
+	segments := rr.r.segments(kStart.Bytes(), kEnd.Bytes())
+	cur := &routeCursor{getter: rr.getter, segments: segments}
+	it := newRangeIterator(cur, kStart, kEnd, opts)
+
+	// Bytes read are already counted by the routed engines' iterators.
+	it.unmonitored = true
+	return it, nil
+}
+
+// routeCursor traverses the segments of a routed key range in order, using an
+// iterator over the current segment.
+type routeCursor struct {
+	getter   func(Engine) KeyValueGetter
+	segments []routeSegment
+
+	i        int      // index of current segment
+	it       Iterator // iterator over current segment or nil
+	reversed bool     // true if it iterates in descending key order
+	err      error
+}
+
+// open sets the current segment and creates an iterator for it.
+func (cur *routeCursor) open(i int, reversed bool) {
+	if cur.it != nil {
+		cur.it.Close()
+		cur.it = nil
+	}
+	cur.i = i
+	cur.reversed = reversed
+	if i < 0 || i >= len(cur.segments) {
+		return
+	}
+	seg := cur.segments[i]
+	cur.it, cur.err = cur.getter(seg.db).NewIterator(RawKey(seg.start), RawKey(seg.end),
+		&IteratorOptions{Reverse: reversed})
+}
+
+// forward moves through later segments until a pair is found.
+func (cur *routeCursor) forward() {
+	for !cur.Valid() && cur.err == nil && cur.it != nil && cur.it.Error() == nil &&
+		cur.i+1 < len(cur.segments) {
+		cur.open(cur.i+1, false)
+	}
+}
+
+// backward moves through earlier segments until a pair is found.
+func (cur *routeCursor) backward() {
+	for !cur.Valid() && cur.err == nil && cur.it != nil && cur.it.Error() == nil && cur.i > 0 {
+		cur.open(cur.i-1, true)
+	}
+}
+
+func (cur *routeCursor) Valid() bool {
+	return cur.err == nil && cur.it != nil && cur.it.Valid()
+}
+
+func (cur *routeCursor) Key() []byte {
+	if !cur.Valid() {
+		return nil
+	}
+	key, err := cur.it.Key()
+	if err != nil {
+		cur.err = err
+		return nil
+	}
+	return key.Bytes()
+}
+
+func (cur *routeCursor) Value() []byte {
+	if !cur.Valid() {
+		return nil
+	}
+	return cur.it.Value()
+}
+
+func (cur *routeCursor) Seek(key []byte) {
+	i := sort.Search(len(cur.segments), func(i int) bool {
+		return bytes.Compare(cur.segments[i].end, key) >= 0
+	})
+	cur.open(i, false)
+	if cur.it != nil && bytes.Compare(key, cur.segments[i].start) > 0 {
+		cur.it.Seek(RawKey(key))
+	}
+	cur.forward()
+}
+
+func (cur *routeCursor) SeekToFirst() {
+	cur.open(0, false)
+	cur.forward()
+}
+
+func (cur *routeCursor) SeekToLast() {
+	cur.open(len(cur.segments)-1, true)
+	cur.backward()
+}
+
+func (cur *routeCursor) Next() {
+	if !cur.Valid() {
+		return
+	}
+	if cur.reversed {
+		cur.it.Prev()
+	} else {
+		cur.it.Next()
+	}
+	cur.forward()
+}
+
+func (cur *routeCursor) Prev() {
+	if !cur.Valid() {
+		return
+	}
+	if cur.reversed {
+		cur.it.Next()
+	} else {
+		cur.it.Prev()
+	}
+	cur.backward()
+}
+
+func (cur *routeCursor) GetError() error {
+	if cur.err != nil {
+		return cur.err
+	}
+	if cur.it != nil {
+		return cur.it.Error()
+	}
+	return nil
+}
+
+func (cur *routeCursor) Close() {
+	if cur.it != nil {
+		cur.it.Close()
+		cur.it = nil
+	}
+}
+
+// Get returns a value given a key.
+func (r *Router) Get(k Key) ([]byte, error) {
+	return routeReader{r: r}.Get(k)
+}
+
+// GetRange returns a range of values spanning (kStart, kEnd) keys.  These key-value
+// pairs will be sorted in ascending key order across all routed engines.
+func (r *Router) GetRange(kStart, kEnd Key) ([]KeyValue, error) {
+	return routeReader{r: r}.GetRange(kStart, kEnd)
+}
+
+// KeysInRange returns a range of present keys spanning (kStart, kEnd).
+func (r *Router) KeysInRange(kStart, kEnd Key) ([]Key, error) {
+	return routeReader{r: r}.KeysInRange(kStart, kEnd)
+}
+
+// ProcessRange sends a range of key-value pairs to chunk handlers.
+func (r *Router) ProcessRange(kStart, kEnd Key, op *ChunkOp, f func(*Chunk)) error {
+	return routeReader{r: r}.ProcessRange(kStart, kEnd, op, f)
+}
+
+// NewIterator returns an Iterator over key/value pairs spanning (kStart, kEnd).
+func (r *Router) NewIterator(kStart, kEnd Key, opts *IteratorOptions) (Iterator, error) {
+	return routeReader{r: r}.NewIterator(kStart, kEnd, opts)
+}
+
+// Put writes a value with given key.
+func (r *Router) Put(k Key, v []byte) error {
+	r.RLock()
+	db := r.engine(k.Bytes())
+	r.RUnlock()
+	return db.Put(k, v)
+}
+
+// PutRange puts key/value pairs that have been sorted in sequential key order.
+// Pairs are grouped by engine, keeping their order.
+func (r *Router) PutRange(values []KeyValue) error {
+	groups := make(map[Engine][]KeyValue)
+	r.RLock()
+	for _, kv := range values {
+		db := r.engine(kv.K.Bytes())
+		groups[db] = append(groups[db], kv)
+	}
+	r.RUnlock()
+	for db, group := range groups {
+		if err := db.PutRange(group); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Delete removes a value with given key.
+func (r *Router) Delete(k Key) error {
+	r.RLock()
+	db := r.engine(k.Bytes())
+	r.RUnlock()
+	return db.Delete(k)
+}
+
+// DeleteRange removes all key-value pairs spanning (kStart, kEnd) in all routed engines.
+func (r *Router) DeleteRange(kStart, kEnd Key) error {
+	for _, seg := range r.segments(kStart.Bytes(), kEnd.Bytes()) {
+		if err := seg.db.DeleteRange(RawKey(seg.start), RawKey(seg.end)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// --- Snapshotter interface ----
+
+// routeSnapshot holds a snapshot of each routed engine.  Each engine's snapshot is
+// consistent although snapshots of different engines may be taken at slightly
+// different times.
+type routeSnapshot struct {
+	routeReader
+}
+
+// NewSnapshot returns a read-only view of all routed engines.
+func (r *Router) NewSnapshot() Snapshot {
+	views := make(map[Engine]KeyValueGetter)
+	for _, db := range r.Engines() {
+		views[db] = ReadSnapshot(db)
+	}
+	return routeSnapshot{routeReader{r, views}}
+}
+
+func (snapshot routeSnapshot) Close() {
+	for _, view := range snapshot.views {
+		view.(Snapshot).Close()
+	}
+}
+
+// --- Batcher interface ----
+
+// routeBatch holds a batch for each engine written by the batch.
+type routeBatch struct {
+	r       *Router
+	batches map[Engine]Batch
+	engines []Engine // engines in order of first use
+}
+
+// NewBatch returns an implementation that allows batch writes
+func (r *Router) NewBatch() Batch {
+	return &routeBatch{r: r, batches: make(map[Engine]Batch)}
+}
+
+// --- Batch interface ---
+
+// batch returns the batch for the engine holding a key.
+func (batch *routeBatch) batch(k Key) Batch {
+	batch.r.RLock()
+	db := batch.r.engine(k.Bytes())
+	batch.r.RUnlock()
+	b, found := batch.batches[db]
+	if !found {
+		b = db.(Batcher).NewBatch()
+		batch.batches[db] = b
+		batch.engines = append(batch.engines, db)
+	}
+	return b
+}
+
+// Commit commits the batch of each engine.  Operations for a single engine are
+// committed atomically.
+func (batch *routeBatch) Commit() error {
+	for _, db := range batch.engines {
+		if err := batch.batches[db].Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (batch *routeBatch) Delete(k Key) {
+	batch.batch(k).Delete(k)
+}
+
+func (batch *routeBatch) Put(k Key, v []byte) {
+	batch.batch(k).Put(k, v)
+}
+
+func (batch *routeBatch) Clear() {
+	for _, b := range batch.batches {
+		b.Clear()
+	}
+}
+
+func (batch *routeBatch) Close() {
+	for _, b := range batch.batches {
+		b.Close()
+	}
+	batch.batches = nil
+	batch.engines = nil
+}
+
+// --- BulkIniter and BulkWriter interfaces ----
+
+// routeLoader holds a BulkLoader for each engine written by the loader.
+type routeLoader struct {
+	r         *Router
+	newLoader func(Engine) (BulkLoader, error)
+	loaders   map[Engine]BulkLoader
+	lastKey   []byte
+}
+
+// NewBulkIniter returns a BulkLoader for a blank key range.
+func (r *Router) NewBulkIniter(kStart, kEnd Key) (BulkLoader, error) {
+	blank, err := IsBlankRange(r, kStart, kEnd)
+	if err != nil {
+		return nil, err
+	}
+	if !blank {
+		return nil, fmt.Errorf("Cannot bulk initialize key range (%s, %s) that holds data",
+			kStart, kEnd)
+	}
+	newLoader := func(db Engine) (BulkLoader, error) {
+		return db.(BulkIniter).NewBulkIniter(kStart, kEnd)
+	}
+	return &routeLoader{r: r, newLoader: newLoader, loaders: make(map[Engine]BulkLoader)}, nil
+}
+
+// NewBulkWriter returns a BulkLoader that may overwrite existing data.
+func (r *Router) NewBulkWriter() (BulkLoader, error) {
+	newLoader := func(db Engine) (BulkLoader, error) {
+		return db.(BulkWriter).NewBulkWriter()
+	}
+	return &routeLoader{r: r, newLoader: newLoader, loaders: make(map[Engine]BulkLoader)}, nil
+}
+
+func (loader *routeLoader) Put(k Key, v []byte) error {
+	kBytes := k.Bytes()
+	if loader.lastKey != nil && bytes.Compare(kBytes, loader.lastKey) < 0 {
+		return fmt.Errorf("Bulk load keys must be presorted: key %x follows %x",
+			kBytes, loader.lastKey)
+	}
+	loader.lastKey = kBytes
+
+	loader.r.RLock()
+	db := loader.r.engine(kBytes)
+	loader.r.RUnlock()
+	l, found := loader.loaders[db]
+	if !found {
+		var err error
+		if l, err = loader.newLoader(db); err != nil {
+			return err
+		}
+		loader.loaders[db] = l
+	}
+	return l.Put(k, v)
+}
+
+func (loader *routeLoader) Commit() error {
+	for _, l := range loader.loaders {
+		if err := l.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (loader *routeLoader) Close() {
+	for _, l := range loader.loaders {
+		l.Close()
+	}
+	loader.loaders = nil
+}
+
+// --- SizeEstimator interface ----
+
+// ApproximateSize sums the approximate sizes reported by each engine holding part of
+// the range [kStart, kEnd].  All such engines must be SizeEstimators.
+func (r *Router) ApproximateSize(kStart, kEnd Key) (uint64, error) {
+	var size uint64
+	for _, segment := range r.segments(kStart.Bytes(), kEnd.Bytes()) {
+		estimator, ok := segment.db.(SizeEstimator)
+		if !ok {
+			return 0, fmt.Errorf("Storage engine for keys %x to %x cannot estimate sizes",
+				segment.start, segment.end)
+		}
+		segmentSize, err := estimator.ApproximateSize(RawKey(segment.start), RawKey(segment.end))
+		if err != nil {
+			return 0, err
+		}
+		size += segmentSize
+	}
+	return size, nil
+}
+
+// --- Compacter interface ----
+
+// CompactRange compacts the part of the range [kStart, kEnd] held by each engine.
+// All such engines must be Compacters.
+func (r *Router) CompactRange(kStart, kEnd Key) error {
+	for _, segment := range r.segments(kStart.Bytes(), kEnd.Bytes()) {
+		compacter, ok := segment.db.(Compacter)
+		if !ok {
+			return fmt.Errorf("Storage engine for keys %x to %x cannot be compacted",
+				segment.start, segment.end)
+		}
+		if err := compacter.CompactRange(RawKey(segment.start), RawKey(segment.end)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package storage
+
+import (
+	. "github.com/janelia-flyem/go/gocheck"
+
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+type RouterSuite struct {
+	defaultDB Engine
+	routedDB  Engine
+	router    *Router
+}
+
+var _ = Suite(&RouterSuite{})
+
+func (s *RouterSuite) SetUpTest(c *C) {
+	var err error
+	s.defaultDB, err = NewStore(c.MkDir(), true, dvid.Config{})
+	c.Assert(err, IsNil)
+	s.routedDB, err = NewStore(c.MkDir(), true, dvid.Config{})
+	c.Assert(err, IsNil)
+
+	s.router = NewRouter(s.defaultDB)
+	c.Assert(s.router.AddRoute([]byte("route b"), s.routedDB), IsNil)
+	c.Assert(s.router.AddRoute([]byte("route b"), s.defaultDB), NotNil)
+
+	for _, k := range []string{"route a", "route b1", "route b2", "route c"} {
+		c.Assert(s.router.Put(NewKey(k), []byte("value "+k)), IsNil)
+	}
+}
+
+func (s *RouterSuite) TearDownTest(c *C) {
+	s.router.Close()
+}
+
+func (s *RouterSuite) TestRouting(c *C) {
+	value, err := s.routedDB.Get(NewKey("route b1"))
+	c.Assert(err, IsNil)
+	c.Assert(string(value), Equals, "value route b1")
+	value, err = s.defaultDB.Get(NewKey("route b1"))
+	c.Assert(err, IsNil)
+	c.Assert(value, IsNil)
+
+	value, err = s.router.Get(NewKey("route c"))
+	c.Assert(err, IsNil)
+	c.Assert(string(value), Equals, "value route c")
+
+	values, err := s.router.GetRange(NewKey("route a"), NewKey("route z"))
+	c.Assert(err, IsNil)
+	c.Assert(values, HasLen, 4)
+	expected := []string{"route a", "route b1", "route b2", "route c"}
+	for i, kv := range values {
+		c.Assert(string(kv.K.(TestKey)), Equals, expected[i])
+	}
+
+	// Capabilities are only reported if every routed engine has them.
+	c.Assert(s.router.IsSizeEstimator(), Equals, s.defaultDB.IsSizeEstimator())
+}
+
+func (s *RouterSuite) TestIterator(c *C) {
+	it, err := s.router.NewIterator(NewKey("route a"), NewKey("route z"),
+		&IteratorOptions{Reverse: true})
+	c.Assert(err, IsNil)
+	keys := []string{}
+	for ; it.Valid(); it.Next() {
+		key, err := it.Key()
+		c.Assert(err, IsNil)
+		keys = append(keys, string(key.(TestKey)))
+	}
+	c.Assert(it.Error(), IsNil)
+	c.Assert(keys, DeepEquals, []string{"route c", "route b2", "route b1", "route a"})
+
+	// Move back across engines.
+	it.Seek(NewKey("route b1"))
+	c.Assert(it.Valid(), Equals, true)
+	it.Prev()
+	key, err := it.Key()
+	c.Assert(err, IsNil)
+	c.Assert(string(key.(TestKey)), Equals, "route b2")
+	it.Prev()
+	key, err = it.Key()
+	c.Assert(err, IsNil)
+	c.Assert(string(key.(TestKey)), Equals, "route c")
+	it.Close()
+}
+
+func (s *RouterSuite) TestDeleteAndBatch(c *C) {
+	c.Assert(s.router.DeleteRange(NewKey("route b"), NewKey("route b9")), IsNil)
+	keys, err := s.router.KeysInRange(NewKey("route a"), NewKey("route z"))
+	c.Assert(err, IsNil)
+	c.Assert(keys, HasLen, 2)
+
+	if !s.router.IsBatcher() {
+		c.Skip("storage engine does not support batches")
+	}
+	batch := s.router.NewBatch()
+	batch.Put(NewKey("route b3"), []byte("value route b3"))
+	batch.Put(NewKey("route d"), []byte("value route d"))
+	batch.Delete(NewKey("route a"))
+	c.Assert(batch.Commit(), IsNil)
+	batch.Close()
+
+	keys, err = s.routedDB.KeysInRange(NewKey("route a"), NewKey("route z"))
+	c.Assert(err, IsNil)
+	c.Assert(keys, HasLen, 1)
+	keys, err = s.router.KeysInRange(NewKey("route a"), NewKey("route z"))
+	c.Assert(err, IsNil)
+	c.Assert(keys, HasLen, 3)
+}
@@ -0,0 +1,699 @@
+/*
+	This file supports partitioning keys across several stores, e.g., one leveldb per
+	disk, so writes and compactions proceed in parallel across disks.  Each key is held
+	by exactly one shard chosen by a hash of the key, and range reads merge the shards
+	back into key order.
+*/
+
+package storage
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+// ShardFunc returns the shard in [0, n) that holds a key given its byte representation.
+// It must depend only on the key bytes so a key is found whatever Key type is used to
+// address it.
+type ShardFunc func(kBytes []byte, n int) int
+
+// hashShard is the default ShardFunc, which hashes the entire key.
+func hashShard(kBytes []byte, n int) int {
+	return dvid.IndexBytes(kBytes).Hash(n)
+}
+
+// ShardedEngine is an Engine that partitions keys among several engines.  Batches
+// spanning shards are committed per shard, so a ShardedEngine only provides atomic
+// batches within a single shard.
+type ShardedEngine struct {
+	shards []Engine
+	shard  ShardFunc
+
+	// Serializes transaction commits.
+	txnMutex
+}
+
+// NewShardedEngine returns an engine that partitions keys among the given engines
+// using a ShardFunc.  If the ShardFunc is nil, keys are partitioned by a hash of the
+// entire key.
+func NewShardedEngine(shards []Engine, shard ShardFunc) (*ShardedEngine, error) {
+	if len(shards) == 0 {
+		return nil, fmt.Errorf("Sharded engine requires at least one shard")
+	}
+	if shard == nil {
+		shard = hashShard
+	}
+	return &ShardedEngine{shards: shards, shard: shard}, nil
+}
+
+// NewShardedStore creates or opens a store in each directory and returns an engine
+// that partitions keys among them.  The directories must be given in the same order
+// each time the stores are opened.
+func NewShardedStore(directories []string, create bool, config dvid.Config, shard ShardFunc) (
+	*ShardedEngine, error) {
+
+	shards := make([]Engine, 0, len(directories))
+	for _, directory := range directories {
+		db, err := NewStore(directory, create, config)
+		if err != nil {
+			for _, opened := range shards {
+				opened.Close()
+			}
+			return nil, fmt.Errorf("Error opening shard %s: %s", directory, err.Error())
+		}
+		shards = append(shards, db)
+	}
+	return NewShardedEngine(shards, shard)
+}
+
+// Shards returns the engines holding the shards in shard order.
+func (s *ShardedEngine) Shards() []Engine {
+	return s.shards
+}
+
+// engine returns the shard holding a key.
+func (s *ShardedEngine) engine(k Key) Engine {
+	n := len(s.shards)
+	if n == 1 {
+		return s.shards[0]
+	}
+	return s.shards[s.shard(k.Bytes(), n)]
+}
+
+// ---- Engine interface ----
+
+// all returns true if every shard satisfies a test.
+func (s *ShardedEngine) all(test func(Engine) bool) bool {
+	for _, db := range s.shards {
+		if !test(db) {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *ShardedEngine) IsBatcher() bool {
+	return s.all(func(db Engine) bool { return db.IsBatcher() })
+}
+
+func (s *ShardedEngine) IsSnapshotter() bool {
+	return s.all(func(db Engine) bool { return db.IsSnapshotter() })
+}
+
+func (s *ShardedEngine) IsBulkIniter() bool {
+	return s.all(func(db Engine) bool { return db.IsBulkIniter() })
+}
+
+func (s *ShardedEngine) IsBulkWriter() bool {
+	return s.all(func(db Engine) bool { return db.IsBulkWriter() })
+}
+
+func (s *ShardedEngine) IsSizeEstimator() bool {
+	return s.all(func(db Engine) bool { return db.IsSizeEstimator() })
+}
+
+// GetConfig returns the configuration of the first shard.
+func (s *ShardedEngine) GetConfig() dvid.Config {
+	return s.shards[0].GetConfig()
+}
+
+// ---- KeyValueDB interface -----
+
+// Close closes all shards.
+func (s *ShardedEngine) Close() {
+	for _, db := range s.shards {
+		db.Close()
+	}
+}
+
+// shardReader reads through the shards using either the engines or views of them,
+// e.g., snapshots, given in shard order.
+type shardReader struct {
+	s     *ShardedEngine
+	views []KeyValueGetter
+}
+
+func (sr shardReader) getter(i int) KeyValueGetter {
+	if sr.views != nil {
+		return sr.views[i]
+	}
+	return sr.s.shards[i]
+}
+
+func (sr shardReader) Get(k Key) ([]byte, error) {
+	n := len(sr.s.shards)
+	if n == 1 {
+		return sr.getter(0).Get(k)
+	}
+	return sr.getter(sr.s.shard(k.Bytes(), n)).Get(k)
+}
+
+func (sr shardReader) GetRange(kStart, kEnd Key) (values []KeyValue, err error) {
+	values = []KeyValue{}
+	err = sr.scan(kStart, kEnd, func(key Key, value []byte) {
+		values = append(values, KeyValue{key, value})
+	})
+	return
+}
+
+func (sr shardReader) KeysInRange(kStart, kEnd Key) (keys []Key, err error) {
+	keys = []Key{}
+	it, err := sr.NewIterator(kStart, kEnd, nil)
+	if err != nil {
+		return
+	}
+	defer it.Close()
+	for ; it.Valid(); it.Next() {
+		var key Key
+		if key, err = it.Key(); err != nil {
+			return
+		}
+		keys = append(keys, key)
+	}
+	err = it.Error()
+	return
+}
+
+func (sr shardReader) ProcessRange(kStart, kEnd Key, op *ChunkOp, f func(*Chunk)) error {
+	return sr.scan(kStart, kEnd, func(key Key, value []byte) {
+		if op != nil && op.Wg != nil {
+			op.Wg.Add(1)
+		}
+		chunk := &Chunk{
+			op,
+			KeyValue{key, value},
+		}
+		f(chunk)
+	})
+}
+
+// scan calls f for each key-value pair in the range in ascending key order.
+func (sr shardReader) scan(kStart, kEnd Key, f func(Key, []byte)) error {
+	it, err := sr.NewIterator(kStart, kEnd, nil)
+	if err != nil {
+		return err
+	}
+	defer it.Close()
+	for ; it.Valid(); it.Next() {
+		key, err := it.Key()
+		if err != nil {
+			return err
+		}
+		f(key, it.Value())
+	}
+	return it.Error()
+}
+
+func (sr shardReader) NewIterator(kStart, kEnd Key, opts *IteratorOptions) (Iterator, error) {
+	cur := &mergeCursor{
+		getter: sr.getter,
+		n:      len(sr.s.shards),
+		start:  kStart.Bytes(),
+		end:    kEnd.Bytes(),
+	}
+	it := newRangeIterator(cur, kStart, kEnd, opts)
+
+	// Bytes read are already counted by the shards' iterators.
+	it.unmonitored = true
+	return it, nil
+}
+
+// mergeCursor traverses the union of the shards' key ranges in key order.  It holds an
+// iterator over each shard, all iterating in the current direction, and is positioned
+// at the shard iterator with the lowest key, or for reverse iteration, the highest key.
+// Since each key is held by a single shard, no two shard iterators share a key.
+type mergeCursor struct {
+	getter     func(int) KeyValueGetter
+	n          int
+	start, end []byte
+
+	its      []Iterator
+	keys     [][]byte // current key of each shard iterator or nil if not valid
+	cur      int      // index of current shard iterator or -1 if not valid
+	reversed bool     // true if shard iterators iterate in descending key order
+	err      error
+}
+
+// open creates an iterator over each shard in the given direction, positioned at the
+// first pair in that direction.
+func (cur *mergeCursor) open(reversed bool) {
+	cur.Close()
+	cur.reversed = reversed
+	cur.its = make([]Iterator, cur.n)
+	cur.keys = make([][]byte, cur.n)
+	cur.err = nil
+	for i := range cur.its {
+		it, err := cur.getter(i).NewIterator(RawKey(cur.start), RawKey(cur.end),
+			&IteratorOptions{Reverse: reversed})
+		if err != nil {
+			cur.err = err
+			cur.cur = -1
+			return
+		}
+		cur.its[i] = it
+		cur.readKey(i)
+	}
+	cur.pick()
+}
+
+// readKey caches the current key of a shard iterator.
+func (cur *mergeCursor) readKey(i int) {
+	cur.keys[i] = nil
+	it := cur.its[i]
+	if it == nil {
+		return
+	}
+	if !it.Valid() {
+		if err := it.Error(); err != nil && cur.err == nil {
+			cur.err = err
+		}
+		return
+	}
+	key, err := it.Key()
+	if err != nil {
+		if cur.err == nil {
+			cur.err = err
+		}
+		return
+	}
+	cur.keys[i] = key.Bytes()
+}
+
+// pick positions the cursor at the shard iterator with the next key in the current
+// direction.
+func (cur *mergeCursor) pick() {
+	cur.cur = -1
+	if cur.err != nil {
+		return
+	}
+	for i, key := range cur.keys {
+		if key == nil {
+			continue
+		}
+		if cur.cur < 0 {
+			cur.cur = i
+			continue
+		}
+		cmp := bytes.Compare(key, cur.keys[cur.cur])
+		if (!cur.reversed && cmp < 0) || (cur.reversed && cmp > 0) {
+			cur.cur = i
+		}
+	}
+}
+
+// seek positions each shard iterator at or beyond key in the current direction.
+func (cur *mergeCursor) seek(key []byte) {
+	for i, it := range cur.its {
+		if it == nil {
+			continue
+		}
+		it.Seek(RawKey(key))
+		cur.readKey(i)
+	}
+	cur.pick()
+}
+
+// advance moves the current shard iterator one pair in the current direction.
+func (cur *mergeCursor) advance() {
+	cur.its[cur.cur].Next()
+	cur.readKey(cur.cur)
+	cur.pick()
+}
+
+func (cur *mergeCursor) Valid() bool {
+	return cur.err == nil && cur.its != nil && cur.cur >= 0
+}
+
+func (cur *mergeCursor) Key() []byte {
+	if !cur.Valid() {
+		return nil
+	}
+	return cur.keys[cur.cur]
+}
+
+func (cur *mergeCursor) Value() []byte {
+	if !cur.Valid() {
+		return nil
+	}
+	return cur.its[cur.cur].Value()
+}
+
+func (cur *mergeCursor) Seek(key []byte) {
+	cur.open(false)
+	cur.seek(key)
+}
+
+func (cur *mergeCursor) SeekToFirst() {
+	cur.open(false)
+}
+
+func (cur *mergeCursor) SeekToLast() {
+	cur.open(true)
+}
+
+// Next moves to the next higher key, reopening the shard iterators in ascending order
+// at the current key if needed.
+func (cur *mergeCursor) Next() {
+	if !cur.Valid() {
+		return
+	}
+	if cur.reversed {
+		key := cur.Key()
+		cur.open(false)
+		cur.seek(key)
+		if !cur.Valid() {
+			return
+		}
+	}
+	cur.advance()
+}
+
+// Prev moves to the next lower key, reopening the shard iterators in descending order
+// at the current key if needed.
+func (cur *mergeCursor) Prev() {
+	if !cur.Valid() {
+		return
+	}
+	if !cur.reversed {
+		key := cur.Key()
+		cur.open(true)
+		cur.seek(key)
+		if !cur.Valid() {
+			return
+		}
+	}
+	cur.advance()
+}
+
+func (cur *mergeCursor) GetError() error {
+	if cur.err != nil {
+		return cur.err
+	}
+	for _, it := range cur.its {
+		if it != nil {
+			if err := it.Error(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (cur *mergeCursor) Close() {
+	for _, it := range cur.its {
+		if it != nil {
+			it.Close()
+		}
+	}
+	cur.its = nil
+	cur.keys = nil
+	cur.cur = -1
+}
+
+// Get returns a value given a key.
+func (s *ShardedEngine) Get(k Key) ([]byte, error) {
+	return shardReader{s: s}.Get(k)
+}
+
+// GetRange returns a range of values spanning (kStart, kEnd) keys.  These key-value
+// pairs will be sorted in ascending key order across all shards.
+func (s *ShardedEngine) GetRange(kStart, kEnd Key) ([]KeyValue, error) {
+	return shardReader{s: s}.GetRange(kStart, kEnd)
+}
+
+// KeysInRange returns a range of present keys spanning (kStart, kEnd).
+func (s *ShardedEngine) KeysInRange(kStart, kEnd Key) ([]Key, error) {
+	return shardReader{s: s}.KeysInRange(kStart, kEnd)
+}
+
+// ProcessRange sends a range of key-value pairs to chunk handlers.
+func (s *ShardedEngine) ProcessRange(kStart, kEnd Key, op *ChunkOp, f func(*Chunk)) error {
+	return shardReader{s: s}.ProcessRange(kStart, kEnd, op, f)
+}
+
+// NewIterator returns an Iterator over key/value pairs spanning (kStart, kEnd).
+func (s *ShardedEngine) NewIterator(kStart, kEnd Key, opts *IteratorOptions) (Iterator, error) {
+	return shardReader{s: s}.NewIterator(kStart, kEnd, opts)
+}
+
+// Put writes a value with given key.
+func (s *ShardedEngine) Put(k Key, v []byte) error {
+	return s.engine(k).Put(k, v)
+}
+
+// PutRange puts key/value pairs that have been sorted in sequential key order.
+// Pairs are grouped by shard, keeping their order.
+func (s *ShardedEngine) PutRange(values []KeyValue) error {
+	groups := make([][]KeyValue, len(s.shards))
+	for _, kv := range values {
+		i := 0
+		if len(s.shards) > 1 {
+			i = s.shard(kv.K.Bytes(), len(s.shards))
+		}
+		groups[i] = append(groups[i], kv)
+	}
+	for i, group := range groups {
+		if len(group) == 0 {
+			continue
+		}
+		if err := s.shards[i].PutRange(group); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Delete removes a value with given key.
+func (s *ShardedEngine) Delete(k Key) error {
+	return s.engine(k).Delete(k)
+}
+
+// DeleteRange removes all key-value pairs spanning (kStart, kEnd) in all shards.
+func (s *ShardedEngine) DeleteRange(kStart, kEnd Key) error {
+	for _, db := range s.shards {
+		if err := db.DeleteRange(kStart, kEnd); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// --- Snapshotter interface ----
+
+// shardSnapshot holds a snapshot of each shard.  Each shard's snapshot is consistent
+// although snapshots of different shards may be taken at slightly different times.
+type shardSnapshot struct {
+	shardReader
+}
+
+// NewSnapshot returns a read-only view of all shards.
+func (s *ShardedEngine) NewSnapshot() Snapshot {
+	views := make([]KeyValueGetter, len(s.shards))
+	for i, db := range s.shards {
+		views[i] = ReadSnapshot(db)
+	}
+	return shardSnapshot{shardReader{s, views}}
+}
+
+func (snapshot shardSnapshot) Close() {
+	for _, view := range snapshot.views {
+		view.(Snapshot).Close()
+	}
+}
+
+// --- Batcher interface ----
+
+// shardBatch holds a batch for each shard written by the batch.
+type shardBatch struct {
+	s       *ShardedEngine
+	batches []Batch
+}
+
+// NewBatch returns an implementation that allows batch writes
+func (s *ShardedEngine) NewBatch() Batch {
+	return &shardBatch{s: s, batches: make([]Batch, len(s.shards))}
+}
+
+// --- Batch interface ---
+
+// batch returns the batch for the shard holding a key.
+func (batch *shardBatch) batch(k Key) Batch {
+	i := 0
+	if len(batch.batches) > 1 {
+		i = batch.s.shard(k.Bytes(), len(batch.batches))
+	}
+	if batch.batches[i] == nil {
+		batch.batches[i] = batch.s.shards[i].(Batcher).NewBatch()
+	}
+	return batch.batches[i]
+}
+
+// Commit commits the batch of each shard.  Operations for a single shard are
+// committed atomically.
+func (batch *shardBatch) Commit() error {
+	for _, b := range batch.batches {
+		if b != nil {
+			if err := b.Commit(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (batch *shardBatch) Delete(k Key) {
+	batch.batch(k).Delete(k)
+}
+
+func (batch *shardBatch) Put(k Key, v []byte) {
+	batch.batch(k).Put(k, v)
+}
+
+func (batch *shardBatch) Clear() {
+	for _, b := range batch.batches {
+		if b != nil {
+			b.Clear()
+		}
+	}
+}
+
+func (batch *shardBatch) Close() {
+	for i, b := range batch.batches {
+		if b != nil {
+			b.Close()
+			batch.batches[i] = nil
+		}
+	}
+}
+
+// --- BulkIniter and BulkWriter interfaces ----
+
+// shardLoader holds a BulkLoader for each shard written by the loader.  Since keys
+// are presorted, the keys sent to each shard are also presorted.
+type shardLoader struct {
+	s         *ShardedEngine
+	newLoader func(Engine) (BulkLoader, error)
+	loaders   []BulkLoader
+	lastKey   []byte
+}
+
+// NewBulkIniter returns a BulkLoader for a blank key range.
+func (s *ShardedEngine) NewBulkIniter(kStart, kEnd Key) (BulkLoader, error) {
+	blank, err := IsBlankRange(s, kStart, kEnd)
+	if err != nil {
+		return nil, err
+	}
+	if !blank {
+		return nil, fmt.Errorf("Cannot bulk initialize key range (%s, %s) that holds data",
+			kStart, kEnd)
+	}
+	newLoader := func(db Engine) (BulkLoader, error) {
+		return db.(BulkIniter).NewBulkIniter(kStart, kEnd)
+	}
+	return &shardLoader{s: s, newLoader: newLoader, loaders: make([]BulkLoader, len(s.shards))}, nil
+}
+
+// NewBulkWriter returns a BulkLoader that may overwrite existing data.
+func (s *ShardedEngine) NewBulkWriter() (BulkLoader, error) {
+	newLoader := func(db Engine) (BulkLoader, error) {
+		return db.(BulkWriter).NewBulkWriter()
+	}
+	return &shardLoader{s: s, newLoader: newLoader, loaders: make([]BulkLoader, len(s.shards))}, nil
+}
+
+func (loader *shardLoader) Put(k Key, v []byte) error {
+	kBytes := k.Bytes()
+	if loader.lastKey != nil && bytes.Compare(kBytes, loader.lastKey) < 0 {
+		return fmt.Errorf("Bulk load keys must be presorted: key %x follows %x",
+			kBytes, loader.lastKey)
+	}
+	loader.lastKey = kBytes
+
+	i := 0
+	if len(loader.loaders) > 1 {
+		i = loader.s.shard(kBytes, len(loader.loaders))
+	}
+	if loader.loaders[i] == nil {
+		l, err := loader.newLoader(loader.s.shards[i])
+		if err != nil {
+			return err
+		}
+		loader.loaders[i] = l
+	}
+	return loader.loaders[i].Put(k, v)
+}
+
+func (loader *shardLoader) Commit() error {
+	for _, l := range loader.loaders {
+		if l != nil {
+			if err := l.Commit(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (loader *shardLoader) Close() {
+	for i, l := range loader.loaders {
+		if l != nil {
+			l.Close()
+			loader.loaders[i] = nil
+		}
+	}
+}
+
+// --- SizeEstimator interface ----
+
+// ApproximateSize sums the approximate sizes of the range [kStart, kEnd] reported by
+// each shard.  All shards must be SizeEstimators.
+func (s *ShardedEngine) ApproximateSize(kStart, kEnd Key) (uint64, error) {
+	var size uint64
+	for i, db := range s.shards {
+		estimator, ok := db.(SizeEstimator)
+		if !ok {
+			return 0, fmt.Errorf("Storage engine for shard %d cannot estimate sizes", i)
+		}
+		shardSize, err := estimator.ApproximateSize(kStart, kEnd)
+		if err != nil {
+			return 0, err
+		}
+		size += shardSize
+	}
+	return size, nil
+}
+
+// --- Compacter interface ----
+
+// CompactRange compacts the range [kStart, kEnd] in each shard.  All shards must be
+// Compacters.
+func (s *ShardedEngine) CompactRange(kStart, kEnd Key) error {
+	for i, db := range s.shards {
+		compacter, ok := db.(Compacter)
+		if !ok {
+			return fmt.Errorf("Storage engine for shard %d cannot be compacted", i)
+		}
+		if err := compacter.CompactRange(kStart, kEnd); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// --- StatsReporter interface ----
+
+// Stats returns the statistics of each shard with names prefixed by the shard, e.g.,
+// "shard0.leveldb.stats".
+func (s *ShardedEngine) Stats() map[string]string {
+	stats := make(map[string]string)
+	for i, db := range s.shards {
+		if reporter, ok := db.(StatsReporter); ok {
+			for name, value := range reporter.Stats() {
+				stats[fmt.Sprintf("shard%d.%s", i, name)] = value
+			}
+		}
+	}
+	return stats
+}
@@ -0,0 +1,122 @@
+package storage
+
+import (
+	"fmt"
+
+	. "github.com/janelia-flyem/go/gocheck"
+
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+type ShardSuite struct {
+	sharded *ShardedEngine
+	keys    []string
+}
+
+var _ = Suite(&ShardSuite{})
+
+func (s *ShardSuite) SetUpTest(c *C) {
+	var err error
+	dirs := []string{c.MkDir(), c.MkDir(), c.MkDir()}
+	s.sharded, err = NewShardedStore(dirs, true, dvid.Config{}, nil)
+	c.Assert(err, IsNil)
+
+	s.keys = []string{}
+	for i := 0; i < 20; i++ {
+		k := fmt.Sprintf("shard %02d", i)
+		c.Assert(s.sharded.Put(NewKey(k), []byte("value "+k)), IsNil)
+		s.keys = append(s.keys, k)
+	}
+}
+
+func (s *ShardSuite) TearDownTest(c *C) {
+	s.sharded.Close()
+}
+
+func (s *ShardSuite) TestSharding(c *C) {
+	// Each key is held by exactly one shard and every shard holds some keys.
+	total := 0
+	for _, db := range s.sharded.Shards() {
+		keys, err := db.KeysInRange(NewKey("shard"), NewKey("shard z"))
+		c.Assert(err, IsNil)
+		c.Assert(len(keys) > 0, Equals, true)
+		total += len(keys)
+	}
+	c.Assert(total, Equals, len(s.keys))
+
+	value, err := s.sharded.Get(NewKey("shard 07"))
+	c.Assert(err, IsNil)
+	c.Assert(string(value), Equals, "value shard 07")
+
+	// Keys are found whatever Key type is used.
+	value, err = s.sharded.Get(RawKey("shard 07"))
+	c.Assert(err, IsNil)
+	c.Assert(string(value), Equals, "value shard 07")
+
+	values, err := s.sharded.GetRange(NewKey("shard 05"), NewKey("shard 14"))
+	c.Assert(err, IsNil)
+	c.Assert(values, HasLen, 10)
+	for i, kv := range values {
+		c.Assert(string(kv.K.(TestKey)), Equals, s.keys[i+5])
+	}
+}
+
+func (s *ShardSuite) TestIterator(c *C) {
+	it, err := s.sharded.NewIterator(NewKey("shard"), NewKey("shard z"),
+		&IteratorOptions{Reverse: true})
+	c.Assert(err, IsNil)
+	keys := []string{}
+	for ; it.Valid(); it.Next() {
+		key, err := it.Key()
+		c.Assert(err, IsNil)
+		keys = append(keys, string(key.(TestKey)))
+	}
+	c.Assert(it.Error(), IsNil)
+	c.Assert(keys, HasLen, len(s.keys))
+	for i, k := range keys {
+		c.Assert(k, Equals, s.keys[len(s.keys)-1-i])
+	}
+	it.Close()
+
+	// Change direction in the middle of the merged shards.
+	it, err = s.sharded.NewIterator(NewKey("shard"), NewKey("shard z"), nil)
+	c.Assert(err, IsNil)
+	it.Seek(NewKey("shard 10"))
+	it.Next()
+	key, err := it.Key()
+	c.Assert(err, IsNil)
+	c.Assert(string(key.(TestKey)), Equals, "shard 11")
+	it.Prev()
+	it.Prev()
+	key, err = it.Key()
+	c.Assert(err, IsNil)
+	c.Assert(string(key.(TestKey)), Equals, "shard 09")
+	it.Next()
+	key, err = it.Key()
+	c.Assert(err, IsNil)
+	c.Assert(string(key.(TestKey)), Equals, "shard 10")
+	it.Close()
+}
+
+func (s *ShardSuite) TestDeleteAndBatch(c *C) {
+	c.Assert(s.sharded.DeleteRange(NewKey("shard 10"), NewKey("shard 19")), IsNil)
+	keys, err := s.sharded.KeysInRange(NewKey("shard"), NewKey("shard z"))
+	c.Assert(err, IsNil)
+	c.Assert(keys, HasLen, 10)
+
+	if !s.sharded.IsBatcher() {
+		c.Skip("storage engine does not support batches")
+	}
+	batch := s.sharded.NewBatch()
+	for i := 20; i < 30; i++ {
+		batch.Put(NewKey(fmt.Sprintf("shard %02d", i)), []byte("batched"))
+	}
+	batch.Delete(NewKey("shard 00"))
+	c.Assert(batch.Commit(), IsNil)
+	batch.Close()
+
+	keys, err = s.sharded.KeysInRange(NewKey("shard"), NewKey("shard z"))
+	c.Assert(err, IsNil)
+	c.Assert(keys, HasLen, 19)
+	c.Assert(string(keys[0].(TestKey)), Equals, "shard 01")
+}
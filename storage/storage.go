@@ -30,6 +30,30 @@ type ChunkOp struct {
 	Wg *sync.WaitGroup
 }
 
+// OpError holds the first error encountered by any chunk handler of an operation.
+// It is embedded in type-specific operations so handlers running concurrently can
+// report errors to the requestor.
+type OpError struct {
+	mu  sync.Mutex
+	err error
+}
+
+// SetError records an error from a chunk handler.  Only the first error is kept.
+func (e *OpError) SetError(err error) {
+	e.mu.Lock()
+	if e.err == nil {
+		e.err = err
+	}
+	e.mu.Unlock()
+}
+
+// Err returns the first error encountered by any chunk handler for this operation.
+func (e *OpError) Err() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.err
+}
+
 // Chunk is the unit passed down channels to chunk handlers.  Chunks can be passed
 // from lower-level database access functions to type-specific chunk processing.
 type Chunk struct {
@@ -52,8 +76,10 @@ type Engine interface {
 	KeyValueDB
 
 	IsBatcher() bool
+	IsSnapshotter() bool
 	IsBulkIniter() bool
 	IsBulkWriter() bool
+	IsSizeEstimator() bool
 
 	GetConfig() dvid.Config
 }
@@ -70,6 +96,9 @@ type KeyValueGetter interface {
 
 	// ProcessRange sends a range of key/value pairs to type-specific chunk handlers.
 	ProcessRange(kStart, kEnd Key, op *ChunkOp, f func(*Chunk)) (err error)
+
+	// NewIterator returns an Iterator over key/value pairs spanning (kStart, kEnd).
+	NewIterator(kStart, kEnd Key, opts *IteratorOptions) (Iterator, error)
 }
 
 type KeyValueSetter interface {
@@ -83,6 +112,9 @@ type KeyValueSetter interface {
 
 	// Delete removes an entry given key.
 	Delete(k Key) error
+
+	// DeleteRange removes all entries with keys spanning (kStart, kEnd).
+	DeleteRange(kStart, kEnd Key) error
 }
 
 // KeyValueDB provides an interface to the simplest storage API: a key/value store.
@@ -118,13 +150,183 @@ type Batch interface {
 	Close()
 }
 
-// BulkIniters can employ even more aggressive optimization in loading large
-// data since they can assume an uninitialized blank database.
+// Snapshotters provide consistent reads across many operations by pinning a read-only
+// view of the store to a point in time.  Writes made after the snapshot is taken are
+// not visible through it.
+type Snapshotter interface {
+	NewSnapshot() Snapshot
+}
+
+// Snapshot is a read-only view of a store at a point in time.
+type Snapshot interface {
+	KeyValueGetter
+
+	// Close releases the snapshot.
+	Close()
+}
+
+// Checkpointers can write a consistent copy of their store to a new directory more
+// efficiently than copying each key-value pair, e.g., by copying or linking files.
+// The copy can be opened with NewStore.
+type Checkpointer interface {
+	Checkpoint(directory string) error
+}
+
+// Compacters can reclaim the space held by deleted or overwritten key-value pairs
+// without waiting for background compaction.
+type Compacter interface {
+	// CompactRange rewrites the storage holding keys in the inclusive range
+	// [kStart, kEnd], dropping stale data.
+	CompactRange(kStart, kEnd Key) error
+}
+
+// StatsReporters can describe their internal state, e.g., level sizes and file counts.
+type StatsReporter interface {
+	// Stats returns engine-specific properties by name.
+	Stats() map[string]string
+}
+
+// SizeEstimators can report the storage used by a key range without reading the
+// key-value pairs, e.g., from the file offsets of an index.  Engines that only support
+// it for some of their stores, like a Router, report it through IsSizeEstimator().
+type SizeEstimator interface {
+	// ApproximateSize returns the approximate number of bytes used to store the keys
+	// in the inclusive range [kStart, kEnd].
+	ApproximateSize(kStart, kEnd Key) (uint64, error)
+}
+
+// Wrappers are engines that add behavior on top of another engine, e.g., a cache or
+// injected faults.  Optional interfaces of the wrapped engine, like Compacter, are
+// not visible through the wrapper.
+type Wrapper interface {
+	// Unwrap returns the wrapped engine.
+	Unwrap() Engine
+}
+
+// BaseEngine returns the engine beneath any Wrappers.
+func BaseEngine(db Engine) Engine {
+	for {
+		wrapper, ok := db.(Wrapper)
+		if !ok {
+			return db
+		}
+		db = wrapper.Unwrap()
+	}
+}
+
+// Cachers are engines that may serve point reads from memory, so reading keys one at
+// a time can be faster than reading a range of keys from the engine.
+type Cacher interface {
+	// IsCached returns true if point reads are cached.
+	IsCached() bool
+}
+
+// IsCached returns true if an engine, or any engine it wraps, caches point reads.
+func IsCached(db Engine) bool {
+	for {
+		if cacher, ok := db.(Cacher); ok && cacher.IsCached() {
+			return true
+		}
+		wrapper, ok := db.(Wrapper)
+		if !ok {
+			return false
+		}
+		db = wrapper.Unwrap()
+	}
+}
+
+// engineView is a Snapshot that reads an engine's current state for engines that
+// cannot provide point-in-time reads.
+type engineView struct {
+	KeyValueGetter
+}
+
+func (view engineView) Close() {}
+
+// engineBatch is a Batch for engines that cannot batch writes.  Operations are
+// written one at a time on commit, so commits are not atomic.
+type engineBatch struct {
+	db  KeyValueSetter
+	ops []engineBatchOp
+}
+
+type engineBatchOp struct {
+	k      Key
+	v      []byte
+	delete bool
+}
+
+func (batch *engineBatch) Commit() error {
+	for _, op := range batch.ops {
+		var err error
+		if op.delete {
+			err = batch.db.Delete(op.k)
+		} else {
+			err = batch.db.Put(op.k, op.v)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	batch.ops = nil
+	return nil
+}
+
+func (batch *engineBatch) Delete(k Key) {
+	batch.ops = append(batch.ops, engineBatchOp{k: k, delete: true})
+}
+
+func (batch *engineBatch) Put(k Key, v []byte) {
+	batch.ops = append(batch.ops, engineBatchOp{k: k, v: v})
+}
+
+func (batch *engineBatch) Clear() {
+	batch.ops = nil
+}
+
+func (batch *engineBatch) Close() {
+	batch.ops = nil
+}
+
+// ReadSnapshot returns a Snapshot of the engine if it is a Snapshotter, else reads
+// are passed through to the engine's current state.  This lets data types ask for
+// consistent reads over the length of a request whatever the engine.  The returned
+// Snapshot must be closed when done.
+func ReadSnapshot(db Engine) Snapshot {
+	if db.IsSnapshotter() {
+		if snapshotter, ok := db.(Snapshotter); ok {
+			return snapshotter.NewSnapshot()
+		}
+	}
+	return engineView{db}
+}
+
+// BulkLoader writes a stream of key-value pairs presorted in ascending key order.
+// Pairs may be written to the store before Commit() and are not written atomically.
+type BulkLoader interface {
+	// Put adds a key-value pair to the stream.  An error is returned if the key is
+	// less than the previous key.
+	Put(k Key, v []byte) error
+
+	// Commit writes any buffered key-value pairs to the store.
+	Commit() error
+
+	// Close releases the loader.  Pairs buffered since the last Commit are discarded.
+	Close()
+}
+
+// BulkIniters can load large data with fewer, larger batch writes since they can
+// assume an uninitialized blank key range.
 type BulkIniter interface {
+	// NewBulkIniter returns a BulkLoader for the key range (kStart, kEnd), which must
+	// not hold any data.  Keys put outside the range return an error.
+	NewBulkIniter(kStart, kEnd Key) (BulkLoader, error)
 }
 
 // BulkWriter employ some sort of optimization to efficiently write large
 // amount of data.  For some key-value databases, this requires keys to
 // be presorted.
 type BulkWriter interface {
+	// NewBulkWriter returns a BulkLoader that may overwrite existing data.
+	NewBulkWriter() (BulkLoader, error)
 }
@@ -2,6 +2,7 @@ package storage
 
 import (
 	"fmt"
+	"sync"
 	"testing"
 
 	. "github.com/janelia-flyem/go/gocheck"
@@ -132,3 +133,247 @@ func (s *DataSuite) TestMultipleItems(c *C) {
 		c.Assert(string(kv.V), Equals, string(items[i].V))
 	}
 }
+
+func (s *DataSuite) TestRanges(c *C) {
+	items := []KeyValue{
+		{K: NewKey("range b"), V: []byte("value B")},
+		{K: NewKey("range a"), V: []byte("value A")},
+		{K: NewKey("range d"), V: []byte("value D")},
+		{K: NewKey("range c"), V: []byte("value C")},
+	}
+	for _, kv := range items {
+		err := s.db.Put(kv.K, kv.V)
+		c.Assert(err, IsNil)
+	}
+
+	// End key is inclusive and results are sorted by key.
+	values, err := s.db.GetRange(NewKey("range b"), NewKey("range d"))
+	c.Assert(err, IsNil)
+	c.Assert(len(values), Equals, 3)
+	c.Assert(string(values[0].V), Equals, "value B")
+	c.Assert(string(values[1].V), Equals, "value C")
+	c.Assert(string(values[2].V), Equals, "value D")
+
+	keys, err := s.db.KeysInRange(NewKey("range a"), NewKey("range c"))
+	c.Assert(err, IsNil)
+	c.Assert(len(keys), Equals, 3)
+	c.Assert(keys[0].BytesString(), Equals, "range a")
+	c.Assert(keys[2].BytesString(), Equals, "range c")
+
+	keys, err = s.db.KeysInRange(NewKey("range e"), NewKey("range z"))
+	c.Assert(err, IsNil)
+	c.Assert(len(keys), Equals, 0)
+
+	var received []string
+	wg := new(sync.WaitGroup)
+	op := &ChunkOp{nil, wg}
+	err = s.db.ProcessRange(NewKey("range a"), NewKey("range z"), op, func(chunk *Chunk) {
+		received = append(received, string(chunk.V))
+		chunk.Wg.Done()
+	})
+	c.Assert(err, IsNil)
+	wg.Wait()
+	c.Assert(received, DeepEquals, []string{"value A", "value B", "value C", "value D"})
+
+	for _, kv := range items {
+		err := s.db.Delete(kv.K)
+		c.Assert(err, IsNil)
+	}
+	values, err = s.db.GetRange(NewKey("range a"), NewKey("range z"))
+	c.Assert(err, IsNil)
+	c.Assert(len(values), Equals, 0)
+}
+
+func (s *DataSuite) TestBatch(c *C) {
+	if !s.db.IsBatcher() {
+		c.Skip("storage engine does not support batches")
+	}
+	batcher, ok := s.db.(Batcher)
+	c.Assert(ok, Equals, true)
+
+	err := s.db.Put(NewKey("batch c"), []byte("value C"))
+	c.Assert(err, IsNil)
+
+	batch := batcher.NewBatch()
+	batch.Put(NewKey("batch a"), []byte("value A"))
+	batch.Put(NewKey("batch b"), []byte("value B"))
+	batch.Delete(NewKey("batch c"))
+
+	// Nothing is visible until commit.
+	value, err := s.db.Get(NewKey("batch a"))
+	c.Assert(err, IsNil)
+	c.Assert(value, IsNil)
+
+	err = batch.Commit()
+	c.Assert(err, IsNil)
+	batch.Close()
+
+	values, err := s.db.GetRange(NewKey("batch a"), NewKey("batch z"))
+	c.Assert(err, IsNil)
+	c.Assert(len(values), Equals, 2)
+	c.Assert(string(values[0].V), Equals, "value A")
+	c.Assert(string(values[1].V), Equals, "value B")
+
+	s.db.Delete(NewKey("batch a"))
+	s.db.Delete(NewKey("batch b"))
+}
+
+func (s *DataSuite) TestIterator(c *C) {
+	for _, k := range []string{"iter a", "iter b", "iter c", "iter d", "iter e"} {
+		err := s.db.Put(NewKey(k), []byte("value "+k[5:]))
+		c.Assert(err, IsNil)
+	}
+	keysOf := func(it Iterator) []string {
+		keys := []string{}
+		for ; it.Valid(); it.Next() {
+			key, err := it.Key()
+			c.Assert(err, IsNil)
+			keys = append(keys, key.BytesString())
+		}
+		c.Assert(it.Error(), IsNil)
+		it.Close()
+		return keys
+	}
+
+	it, err := s.db.NewIterator(NewKey("iter b"), NewKey("iter d"), nil)
+	c.Assert(err, IsNil)
+	c.Assert(string(it.Value()), Equals, "value b")
+	c.Assert(keysOf(it), DeepEquals, []string{"iter b", "iter c", "iter d"})
+
+	it, err = s.db.NewIterator(NewKey("iter a"), NewKey("iter z"), &IteratorOptions{Reverse: true})
+	c.Assert(err, IsNil)
+	c.Assert(keysOf(it), DeepEquals, []string{"iter e", "iter d", "iter c", "iter b", "iter a"})
+
+	it, err = s.db.NewIterator(NewKey("iter a"), NewKey("iter z"), &IteratorOptions{Limit: 2})
+	c.Assert(err, IsNil)
+	c.Assert(keysOf(it), DeepEquals, []string{"iter a", "iter b"})
+
+	// Seek within range, step forward and back.
+	it, err = s.db.NewIterator(NewKey("iter a"), NewKey("iter d"), nil)
+	c.Assert(err, IsNil)
+	it.Seek(NewKey("iter bb"))
+	c.Assert(it.Valid(), Equals, true)
+	c.Assert(string(it.Value()), Equals, "value c")
+	it.Prev()
+	c.Assert(string(it.Value()), Equals, "value b")
+	it.Seek(NewKey("iter cc"))
+	it.Next()
+	c.Assert(it.Valid(), Equals, false)
+	it.Close()
+
+	it, err = s.db.NewIterator(NewKey("iter a"), NewKey("iter z"), &IteratorOptions{Reverse: true})
+	c.Assert(err, IsNil)
+	it.Seek(NewKey("iter cc"))
+	c.Assert(string(it.Value()), Equals, "value c")
+	it.Next()
+	c.Assert(string(it.Value()), Equals, "value b")
+	it.Close()
+
+	for _, k := range []string{"iter a", "iter b", "iter c", "iter d", "iter e"} {
+		s.db.Delete(NewKey(k))
+	}
+}
+
+func (s *DataSuite) TestSnapshot(c *C) {
+	err := s.db.Put(NewKey("snap a"), []byte("old A"))
+	c.Assert(err, IsNil)
+
+	snapshot := ReadSnapshot(s.db)
+	defer snapshot.Close()
+
+	err = s.db.Put(NewKey("snap a"), []byte("new A"))
+	c.Assert(err, IsNil)
+	err = s.db.Put(NewKey("snap b"), []byte("new B"))
+	c.Assert(err, IsNil)
+
+	value, err := s.db.Get(NewKey("snap a"))
+	c.Assert(err, IsNil)
+	c.Assert(string(value), Equals, "new A")
+
+	if s.db.IsSnapshotter() {
+		value, err = snapshot.Get(NewKey("snap a"))
+		c.Assert(err, IsNil)
+		c.Assert(string(value), Equals, "old A")
+
+		values, err := snapshot.GetRange(NewKey("snap a"), NewKey("snap z"))
+		c.Assert(err, IsNil)
+		c.Assert(len(values), Equals, 1)
+
+		it, err := snapshot.NewIterator(NewKey("snap a"), NewKey("snap z"), nil)
+		c.Assert(err, IsNil)
+		c.Assert(it.Valid(), Equals, true)
+		c.Assert(string(it.Value()), Equals, "old A")
+		it.Next()
+		c.Assert(it.Valid(), Equals, false)
+		it.Close()
+	}
+
+	s.db.Delete(NewKey("snap a"))
+	s.db.Delete(NewKey("snap b"))
+}
+
+func (s *DataSuite) TestBulkLoad(c *C) {
+	if !s.db.IsBulkIniter() && !s.db.IsBulkWriter() {
+		c.Skip("storage engine does not support bulk loading")
+	}
+	blank, err := IsBlankRange(s.db, NewKey("bulk a"), NewKey("bulk z"))
+	c.Assert(err, IsNil)
+	c.Assert(blank, Equals, true)
+
+	loader, err := NewBulkLoader(s.db, NewKey("bulk a"), NewKey("bulk z"))
+	c.Assert(err, IsNil)
+	c.Assert(loader.Put(NewKey("bulk a"), []byte("value A")), IsNil)
+	c.Assert(loader.Put(NewKey("bulk c"), []byte("value C")), IsNil)
+	c.Assert(loader.Put(NewKey("bulk b"), []byte("value B")), NotNil)
+	c.Assert(loader.Commit(), IsNil)
+	loader.Close()
+
+	values, err := s.db.GetRange(NewKey("bulk a"), NewKey("bulk z"))
+	c.Assert(err, IsNil)
+	c.Assert(len(values), Equals, 2)
+	c.Assert(string(values[1].V), Equals, "value C")
+
+	// A key range with data can only be bulk written, not bulk initialized.
+	if initer, ok := s.db.(BulkIniter); ok && s.db.IsBulkIniter() {
+		_, err = initer.NewBulkIniter(NewKey("bulk a"), NewKey("bulk z"))
+		c.Assert(err, NotNil)
+	}
+	if s.db.IsBulkWriter() {
+		loader, err = NewBulkLoader(s.db, NewKey("bulk a"), NewKey("bulk z"))
+		c.Assert(err, IsNil)
+		c.Assert(loader.Put(NewKey("bulk b"), []byte("value B")), IsNil)
+		c.Assert(loader.Commit(), IsNil)
+		loader.Close()
+
+		values, err = s.db.GetRange(NewKey("bulk a"), NewKey("bulk z"))
+		c.Assert(err, IsNil)
+		c.Assert(len(values), Equals, 3)
+	}
+
+	for _, k := range []string{"bulk a", "bulk b", "bulk c"} {
+		s.db.Delete(NewKey(k))
+	}
+}
+
+func (s *DataSuite) TestDeleteRange(c *C) {
+	keys := []string{"delrange a", "delrange b", "delrange c", "delrange d"}
+	for _, k := range keys {
+		c.Assert(s.db.Put(NewKey(k), []byte("value "+k)), IsNil)
+	}
+
+	// Delete the inclusive middle range.
+	c.Assert(s.db.DeleteRange(NewKey("delrange b"), NewKey("delrange c")), IsNil)
+	values, err := s.db.GetRange(NewKey("delrange a"), NewKey("delrange z"))
+	c.Assert(err, IsNil)
+	c.Assert(len(values), Equals, 2)
+	c.Assert(string(values[0].V), Equals, "value delrange a")
+	c.Assert(string(values[1].V), Equals, "value delrange d")
+
+	// Deleting an empty range is not an error.
+	c.Assert(s.db.DeleteRange(NewKey("delrange b"), NewKey("delrange c")), IsNil)
+
+	c.Assert(s.db.DeleteRange(NewKey("delrange a"), NewKey("delrange z")), IsNil)
+	blank, err := IsBlankRange(s.db, NewKey("delrange a"), NewKey("delrange z"))
+	c.Assert(err, IsNil)
+	c.Assert(blank, Equals, true)
+}
@@ -0,0 +1,229 @@
+/*
+	This file implements optimistic transactions on top of any Engine that is also a
+	Batcher.  A transaction buffers its writes, so reads within it see its own writes,
+	and records the values it reads or expects.  On commit, those values are checked
+	against the store and, if any changed, the transaction is aborted with a conflict.
+	Otherwise the writes are committed in a single batch.
+
+	Commits of transactions on the same engine are serialized, so transactions never
+	overwrite each other's changes.  Writes made outside of transactions are only
+	detected if they change a value a transaction has read.
+*/
+
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+)
+
+// Number of times UpdateTxn tries a transaction that aborts due to conflicts.
+const maxTxnAttempts = 10
+
+// ConflictError is returned when a transaction is aborted because a key it read or
+// conditionally wrote no longer has the expected value.
+type ConflictError struct {
+	Key Key
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("Transaction aborted due to conflicting change of key %s", e.Key)
+}
+
+// IsConflict returns true if the error is due to a transaction conflict.
+func IsConflict(err error) bool {
+	_, conflict := err.(*ConflictError)
+	return conflict
+}
+
+// txnLocker is implemented by engines that serialize commits of transactions.
+type txnLocker interface {
+	txnLock() *sync.Mutex
+}
+
+// txnMutex is embedded in each Batcher engine to serialize its transaction commits.
+type txnMutex struct {
+	mu sync.Mutex
+}
+
+func (m *txnMutex) txnLock() *sync.Mutex {
+	return &m.mu
+}
+
+// wrappedTxnLock returns the transaction lock of an engine wrapped by another, so
+// commits through the wrapper and on the engine itself are serialized together.  If
+// the wrapped engine has no lock, the wrapper's own is used.
+func (m *txnMutex) wrappedTxnLock(db Engine) *sync.Mutex {
+	if locker, ok := db.(txnLocker); ok {
+		return locker.txnLock()
+	}
+	return &m.mu
+}
+
+// txnWrite is a buffered put or, if value is nil, delete.
+type txnWrite struct {
+	key   Key
+	value []byte
+}
+
+// txnExpect is a value that must be stored at commit, where nil means no key.
+type txnExpect struct {
+	key   Key
+	value []byte
+}
+
+// Txn is a set of reads and writes that are committed atomically or not at all.
+// A Txn is not safe for concurrent use.
+type Txn struct {
+	db      Engine
+	batcher Batcher
+	locker  txnLocker
+
+	writes  map[string]txnWrite
+	order   []string // keys of writes in order of first write
+	expects map[string]txnExpect
+
+	// Set when a conditional put fails against the transaction's own writes.
+	conflict error
+}
+
+// NewTxn returns a transaction on an engine, which must be a Batcher.
+func NewTxn(db Engine) (*Txn, error) {
+	batcher, ok := db.(Batcher)
+	if !ok || !db.IsBatcher() {
+		return nil, fmt.Errorf("Transactions require a storage engine that supports batches")
+	}
+	locker, ok := db.(txnLocker)
+	if !ok {
+		return nil, fmt.Errorf("Storage engine does not support transactions")
+	}
+	return &Txn{
+		db:      db,
+		batcher: batcher,
+		locker:  locker,
+		writes:  make(map[string]txnWrite),
+		expects: make(map[string]txnExpect),
+	}, nil
+}
+
+// Get returns the value of a key, including any write made earlier in the
+// transaction.  The value read from the store must be unchanged at commit.
+func (txn *Txn) Get(k Key) ([]byte, error) {
+	kStr := k.BytesString()
+	if w, found := txn.writes[kStr]; found {
+		return w.value, nil
+	}
+	if e, found := txn.expects[kStr]; found {
+		return e.value, nil
+	}
+	value, err := txn.db.Get(k)
+	if err != nil {
+		return nil, err
+	}
+	txn.expects[kStr] = txnExpect{k, value}
+	return value, nil
+}
+
+func (txn *Txn) write(k Key, v []byte) {
+	kStr := k.BytesString()
+	if _, found := txn.writes[kStr]; !found {
+		txn.order = append(txn.order, kStr)
+	}
+	txn.writes[kStr] = txnWrite{k, v}
+}
+
+// Put writes a value at commit.
+func (txn *Txn) Put(k Key, v []byte) {
+	if v == nil {
+		v = []byte{}
+	}
+	txn.write(k, v)
+}
+
+// Delete removes a key at commit.
+func (txn *Txn) Delete(k Key) {
+	txn.write(k, nil)
+}
+
+// PutIf writes a value at commit only if the key currently holds the expected value,
+// where a nil expected value requires that the key not exist.  If the expectation
+// fails, the transaction is aborted with a conflict.
+func (txn *Txn) PutIf(k Key, expected, v []byte) {
+	kStr := k.BytesString()
+	if w, found := txn.writes[kStr]; found {
+		if !sameValue(w.value, expected) {
+			txn.conflict = &ConflictError{k}
+		}
+	} else if e, found := txn.expects[kStr]; found {
+		if !sameValue(e.value, expected) {
+			txn.conflict = &ConflictError{k}
+		}
+	} else {
+		txn.expects[kStr] = txnExpect{k, expected}
+	}
+	txn.Put(k, v)
+}
+
+// sameValue compares values where nil means no key and differs from an empty value.
+func sameValue(a, b []byte) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return bytes.Equal(a, b)
+}
+
+// Commit checks that every value read or expected by the transaction is unchanged
+// and then atomically writes the transaction's puts and deletes.  A ConflictError is
+// returned if the transaction was aborted.
+func (txn *Txn) Commit() error {
+	if txn.conflict != nil {
+		return txn.conflict
+	}
+	mu := txn.locker.txnLock()
+	mu.Lock()
+	defer mu.Unlock()
+
+	for _, e := range txn.expects {
+		value, err := txn.db.Get(e.key)
+		if err != nil {
+			return err
+		}
+		if !sameValue(value, e.value) {
+			return &ConflictError{e.key}
+		}
+	}
+	if len(txn.writes) == 0 {
+		return nil
+	}
+	batch := txn.batcher.NewBatch()
+	defer batch.Close()
+	for _, kStr := range txn.order {
+		w := txn.writes[kStr]
+		if w.value == nil {
+			batch.Delete(w.key)
+		} else {
+			batch.Put(w.key, w.value)
+		}
+	}
+	return batch.Commit()
+}
+
+// UpdateTxn runs a function within a transaction and commits it, retrying the
+// function in a new transaction if the commit conflicts with other changes.
+func UpdateTxn(db Engine, f func(*Txn) error) error {
+	var err error
+	for attempt := 0; attempt < maxTxnAttempts; attempt++ {
+		var txn *Txn
+		if txn, err = NewTxn(db); err != nil {
+			return err
+		}
+		if err = f(txn); err != nil {
+			return err
+		}
+		if err = txn.Commit(); !IsConflict(err) {
+			return err
+		}
+	}
+	return err
+}
@@ -0,0 +1,153 @@
+package storage
+
+import (
+	"strconv"
+	"sync"
+
+	. "github.com/janelia-flyem/go/gocheck"
+
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+type TxnSuite struct {
+	db Engine
+}
+
+var _ = Suite(&TxnSuite{})
+
+func (s *TxnSuite) SetUpTest(c *C) {
+	var err error
+	s.db, err = NewStore(c.MkDir(), true, dvid.Config{})
+	c.Assert(err, IsNil)
+	c.Assert(s.db.Put(NewKey("txn a"), []byte("value a")), IsNil)
+}
+
+func (s *TxnSuite) TearDownTest(c *C) {
+	s.db.Close()
+}
+
+func (s *TxnSuite) TestReadYourWrites(c *C) {
+	txn, err := NewTxn(s.db)
+	c.Assert(err, IsNil)
+	txn.Put(NewKey("txn b"), []byte("value b"))
+	txn.Delete(NewKey("txn a"))
+
+	value, err := txn.Get(NewKey("txn b"))
+	c.Assert(err, IsNil)
+	c.Assert(string(value), Equals, "value b")
+	value, err = txn.Get(NewKey("txn a"))
+	c.Assert(err, IsNil)
+	c.Assert(value, IsNil)
+
+	// Nothing is written until commit.
+	value, err = s.db.Get(NewKey("txn b"))
+	c.Assert(err, IsNil)
+	c.Assert(value, IsNil)
+
+	c.Assert(txn.Commit(), IsNil)
+	value, err = s.db.Get(NewKey("txn b"))
+	c.Assert(err, IsNil)
+	c.Assert(string(value), Equals, "value b")
+	value, err = s.db.Get(NewKey("txn a"))
+	c.Assert(err, IsNil)
+	c.Assert(value, IsNil)
+}
+
+func (s *TxnSuite) TestConflict(c *C) {
+	txn1, err := NewTxn(s.db)
+	c.Assert(err, IsNil)
+	txn2, err := NewTxn(s.db)
+	c.Assert(err, IsNil)
+
+	for _, txn := range []*Txn{txn1, txn2} {
+		value, err := txn.Get(NewKey("txn a"))
+		c.Assert(err, IsNil)
+		txn.Put(NewKey("txn a"), append(value, '+'))
+		txn.Put(NewKey("txn b"), []byte("written"))
+	}
+	c.Assert(txn1.Commit(), IsNil)
+	err = txn2.Commit()
+	c.Assert(IsConflict(err), Equals, true)
+
+	value, err := s.db.Get(NewKey("txn a"))
+	c.Assert(err, IsNil)
+	c.Assert(string(value), Equals, "value a+")
+}
+
+func (s *TxnSuite) TestPutIf(c *C) {
+	txn, err := NewTxn(s.db)
+	c.Assert(err, IsNil)
+	txn.PutIf(NewKey("txn a"), []byte("value a"), []byte("swapped"))
+	txn.PutIf(NewKey("txn b"), nil, []byte("created"))
+	c.Assert(txn.Commit(), IsNil)
+
+	txn, err = NewTxn(s.db)
+	c.Assert(err, IsNil)
+	txn.PutIf(NewKey("txn a"), []byte("value a"), []byte("stale"))
+	c.Assert(IsConflict(txn.Commit()), Equals, true)
+
+	// A failed expectation aborts all writes of the transaction.
+	txn, err = NewTxn(s.db)
+	c.Assert(err, IsNil)
+	txn.Put(NewKey("txn c"), []byte("value c"))
+	txn.PutIf(NewKey("txn c"), []byte("other"), []byte("new c"))
+	c.Assert(IsConflict(txn.Commit()), Equals, true)
+	value, err := s.db.Get(NewKey("txn c"))
+	c.Assert(err, IsNil)
+	c.Assert(value, IsNil)
+
+	value, err = s.db.Get(NewKey("txn a"))
+	c.Assert(err, IsNil)
+	c.Assert(string(value), Equals, "swapped")
+}
+
+func (s *TxnSuite) TestUpdateTxn(c *C) {
+	counter := NewKey("txn counter")
+	increment := func(txn *Txn) error {
+		value, err := txn.Get(counter)
+		if err != nil {
+			return err
+		}
+		var n int
+		if value != nil {
+			if n, err = strconv.Atoi(string(value)); err != nil {
+				return err
+			}
+		}
+		txn.Put(counter, []byte(strconv.Itoa(n+1)))
+		return nil
+	}
+
+	// Increments that conflict are retried, so none are lost.
+	var wg sync.WaitGroup
+	errs := make(chan error, 4)
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 2; j++ {
+				if err := UpdateTxn(s.db, increment); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		c.Assert(err, IsNil)
+	}
+	value, err := s.db.Get(counter)
+	c.Assert(err, IsNil)
+	c.Assert(string(value), Equals, "8")
+}
+
+func (s *TxnSuite) TestWrappedLock(c *C) {
+	locker, ok := s.db.(txnLocker)
+	c.Assert(ok, Equals, true)
+	cache := NewCachedEngine(s.db, dvid.Mega)
+	c.Assert(cache.txnLock(), Equals, locker.txnLock())
+	faulty := NewFaultyEngine(cache, Faults{})
+	c.Assert(faulty.txnLock(), Equals, locker.txnLock())
+}